@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	// Base des fuseaux horaires embarquée : l'image de production n'en fournit pas
+	_ "time/tzdata"
+)
+
+// CalendrierConfig contient la configuration des flux iCalendar des plannings
+type CalendrierConfig struct {
+	Fuseau      *time.Location // Fuseau horaire des dates et heures des plannings
+	URLPublique string         // URL publique de l'API, pour les liens publics, déduite de la requête si vide (abonnements, devis en ligne)
+	JoursPasses int            // Nombre de jours passés publiés dans les flux
+}
+
+// GetCalendrierConfig retourne la configuration des flux iCalendar.
+// FUSEAU_HORAIRE (ex. "Europe/Paris") et API_PUBLIC_URL (ex. "https://app.exemple.fr/api") permettent de la surcharger.
+func GetCalendrierConfig() CalendrierConfig {
+	cfg := CalendrierConfig{
+		URLPublique: strings.TrimRight(os.Getenv("API_PUBLIC_URL"), "/"),
+		JoursPasses: 90,
+	}
+
+	fuseau := os.Getenv("FUSEAU_HORAIRE")
+	if fuseau == "" {
+		fuseau = "Europe/Paris"
+	}
+	location, err := time.LoadLocation(fuseau)
+	if err != nil {
+		location, _ = time.LoadLocation("Europe/Paris")
+	}
+	cfg.Fuseau = location
+
+	return cfg
+}
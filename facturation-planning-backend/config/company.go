@@ -1,59 +1,57 @@
 package config
 
-// CompanyInfo contient les informations de l'entreprise
-type CompanyInfo struct {
-	Name        string
-	Address     string
-	City        string
-	PostalCode  string
-	Phone       string
-	Fax         string
-	Email       string
-	Website     string
-	SIRET       string
-	APE         string
-	TVA         string
-	RCS         string
-	Capital     string
-	LogoPath    string
-}
+import (
+	"os"
+	"time"
+)
 
-// GetCompanyInfo retourne les informations de l'entreprise
-func GetCompanyInfo() CompanyInfo {
-	return CompanyInfo{
-		Name:       "ODI SERVICE PRO",
-		Address:    "23 RUE ERIC TABARLY",
-		City:       "DOMPIERRE SUR YON",
-		PostalCode: "85170",
-		Phone:      "02 51 99 36 91",
-		Fax:        "",
-		Email:      "aide.odiservicepro@gmail.com",
-		Website:    "",
-		SIRET:      "83377432600023",
-		APE:        "8121Z",
-		TVA:        "FR92833774326",
-		RCS:        "833774326",
-		Capital:    "500€",
-		LogoPath:   "assets/logo.jpg",
-	}
+// CompanyInfo contient les informations de l'entreprise émettrice affichées sur les documents
+type CompanyInfo struct {
+	Name            string
+	Address         string
+	City            string
+	PostalCode      string
+	Phone           string
+	Email           string
+	Website         string
+	SIRET           string
+	APE             string
+	TVA             string
+	RCS             string
+	Capital         string
+	LegalForm       string
+	LegalMentions   string
+	Logo            []byte
+	LogoContentType string
 }
 
 // DevisConfig contient la configuration pour les devis
 type DevisConfig struct {
-	DefaultCity        string
-	DefaultConditions  string
-	DefaultTVA         float64
-	ValidityDays       int
-	NumberingPrefix    string
+	DefaultCity       string
+	DefaultConditions string
+	DefaultTVA        float64
+	ValidityDays      int
+	NumberingPrefix   string
+
+	MotifExonerationTVA string // Exonération de TVA par défaut des documents sans TVA (vide : soumis à TVA)
+
+	ExpirationInterval time.Duration // Fréquence du passage des devis envoyés échus au statut expiré
 }
 
-// GetDevisConfig retourne la configuration des devis
+// GetDevisConfig retourne la configuration des devis appliquée tant que l'entreprise ne l'a pas personnalisée.
+// DEVIS_EXPIRATION_INTERVALLE (ex. "1h") permet de surcharger la fréquence d'expiration des devis.
 func GetDevisConfig() DevisConfig {
-	return DevisConfig{
-		DefaultCity:        "DOMPIERRE SUR YON",
-		DefaultConditions:  "Devis valable 30 jours. Paiement à réception de facture.",
+	cfg := DevisConfig{
+		DefaultConditions:  "Paiement à réception de facture.",
 		DefaultTVA:         20.0,
 		ValidityDays:       30,
 		NumberingPrefix:    "DEV",
+		ExpirationInterval: time.Hour,
+	}
+
+	if intervalle, err := time.ParseDuration(os.Getenv("DEVIS_EXPIRATION_INTERVALLE")); err == nil && intervalle > 0 {
+		cfg.ExpirationInterval = intervalle
 	}
+
+	return cfg
 }
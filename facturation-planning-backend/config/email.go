@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// EmailConfig contient la configuration SMTP par défaut du serveur et les modèles de message par défaut
+type EmailConfig struct {
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUser        string
+	SMTPPassword    string
+	SMTPChiffrement string
+	ExpediteurEmail string
+	ExpediteurNom   string
+	SujetFacture    string
+	CorpsFacture    string
+	SujetDevis      string
+	CorpsDevis      string
+}
+
+// GetEmailConfig retourne la configuration email par défaut, surchargée par les variables
+// SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASSWORD, SMTP_CHIFFREMENT et SMTP_FROM
+func GetEmailConfig() EmailConfig {
+	cfg := EmailConfig{
+		SMTPHost:        os.Getenv("SMTP_HOST"),
+		SMTPPort:        587,
+		SMTPUser:        os.Getenv("SMTP_USER"),
+		SMTPPassword:    os.Getenv("SMTP_PASSWORD"),
+		SMTPChiffrement: os.Getenv("SMTP_CHIFFREMENT"),
+		ExpediteurEmail: os.Getenv("SMTP_FROM"),
+		SujetFacture:    "Facture {{.Reference}} - {{.Entreprise}}",
+		CorpsFacture: `Bonjour,
+
+Veuillez trouver ci-joint la facture {{.Reference}} d'un montant de {{.Montant}}, à régler avant le {{.DateEcheance}}.
+
+Nous restons à votre disposition pour toute question.
+
+Cordialement,
+{{.Entreprise}}`,
+		SujetDevis: "Devis {{.Reference}} - {{.Entreprise}}",
+		CorpsDevis: `Bonjour,
+
+Veuillez trouver ci-joint notre devis {{.Reference}} d'un montant de {{.Montant}}, valable jusqu'au {{.DateExpiration}}.
+
+Nous restons à votre disposition pour toute question.
+
+Cordialement,
+{{.Entreprise}}`,
+	}
+
+	if port, err := strconv.Atoi(os.Getenv("SMTP_PORT")); err == nil && port > 0 {
+		cfg.SMTPPort = port
+	}
+	if cfg.SMTPPassword == "" {
+		cfg.SMTPPassword = os.Getenv("SMTP_PASS") // Nom utilisé dans .env.production
+	}
+	if cfg.SMTPChiffrement == "" {
+		cfg.SMTPChiffrement = "starttls"
+	}
+
+	return cfg
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+// FeuilleTempsConfig contient la configuration des feuilles de temps des salariés
+type FeuilleTempsConfig struct {
+	SeuilHebdomadaire float64 // Heures travaillées par semaine au-delà desquelles les heures sont supplémentaires
+	HeuresJournee     float64 // Heures comptées pour un planning sans horaires (congé, absence ou formation à la journée)
+}
+
+// GetFeuilleTempsConfig retourne la configuration des feuilles de temps appliquée tant que l'entreprise ne l'a pas personnalisée.
+// SEUIL_HEURES_SUPPLEMENTAIRES (ex. "39") et HEURES_JOURNEE (ex. "7.8") permettent de la surcharger.
+func GetFeuilleTempsConfig() FeuilleTempsConfig {
+	cfg := FeuilleTempsConfig{
+		SeuilHebdomadaire: 35,
+		HeuresJournee:     7,
+	}
+
+	if seuil, err := strconv.ParseFloat(os.Getenv("SEUIL_HEURES_SUPPLEMENTAIRES"), 64); err == nil && seuil > 0 {
+		cfg.SeuilHebdomadaire = seuil
+	}
+	if heures, err := strconv.ParseFloat(os.Getenv("HEURES_JOURNEE"), 64); err == nil && heures > 0 && heures <= 24 {
+		cfg.HeuresJournee = heures
+	}
+
+	return cfg
+}
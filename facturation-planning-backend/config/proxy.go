@@ -0,0 +1,51 @@
+package config
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// ProxyConfig contient la liste des reverse proxies dont les en-têtes X-Forwarded-For et X-Real-IP sont crus
+type ProxyConfig struct {
+	Confiance []*net.IPNet // Adresses ou réseaux des proxies de confiance ; vide, les en-têtes transmis sont ignorés
+}
+
+// GetProxyConfig retourne la configuration des proxies de confiance.
+// TRUSTED_PROXIES liste les adresses ou réseaux séparés par des virgules (ex. "127.0.0.1,172.16.0.0/12").
+func GetProxyConfig() ProxyConfig {
+	var cfg ProxyConfig
+	for _, valeur := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		valeur = strings.TrimSpace(valeur)
+		if valeur == "" {
+			continue
+		}
+		if !strings.Contains(valeur, "/") {
+			if ip := net.ParseIP(valeur); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				cfg.Confiance = append(cfg.Confiance, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, reseau, err := net.ParseCIDR(valeur); err == nil {
+			cfg.Confiance = append(cfg.Confiance, reseau)
+		}
+	}
+	return cfg
+}
+
+// EstDeConfiance indique si l'adresse est celle d'un proxy de confiance
+func (cfg ProxyConfig) EstDeConfiance(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, reseau := range cfg.Confiance {
+		if reseau.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
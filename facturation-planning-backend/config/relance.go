@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// RelanceConfig contient la configuration des relances de factures impayées
+type RelanceConfig struct {
+	Intervalle           time.Duration // Fréquence d'exécution du planificateur
+	DelaisJours          [3]int        // Retard minimum (jours après échéance) pour la 1re relance, la 2e relance et la mise en demeure
+	EcartMinimumJours    int           // Délai minimum entre deux relances d'une même facture
+	TauxPenalites        float64       // Taux annuel des pénalités de retard (taux directeur BCE + 10 points)
+	IndemniteForfaitaire float64       // Indemnité forfaitaire pour frais de recouvrement (art. D441-5 du Code de commerce)
+}
+
+// GetRelanceConfig retourne la configuration des relances.
+// RELANCES_INTERVALLE (ex. "24h") et TAUX_PENALITES_RETARD (ex. "12.15") permettent de la surcharger.
+func GetRelanceConfig() RelanceConfig {
+	cfg := RelanceConfig{
+		Intervalle:           24 * time.Hour,
+		DelaisJours:          [3]int{7, 21, 45},
+		EcartMinimumJours:    10,
+		TauxPenalites:        12.15,
+		IndemniteForfaitaire: 40.0,
+	}
+
+	if intervalle, err := time.ParseDuration(os.Getenv("RELANCES_INTERVALLE")); err == nil && intervalle > 0 {
+		cfg.Intervalle = intervalle
+	}
+	if taux, err := strconv.ParseFloat(os.Getenv("TAUX_PENALITES_RETARD"), 64); err == nil && taux >= 0 {
+		cfg.TauxPenalites = taux
+	}
+
+	return cfg
+}
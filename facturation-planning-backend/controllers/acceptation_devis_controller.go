@@ -0,0 +1,501 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Taille maximale de l'image de signature transmise par le client
+const tailleMaxSignatureDevis = 512 << 10
+
+var (
+	errLienDevisRevoque      = errors.New("Lien d'acceptation introuvable")
+	errDevisDejaDecide       = errors.New("Le devis n'est plus en attente de réponse")
+	errRevisionDevisObsolete = errors.New("Le devis a été modifié : consultez la dernière version avant de répondre")
+)
+
+// LienAcceptationDevisResponse représente le lien d'acceptation en ligne d'un devis
+type LienAcceptationDevisResponse struct {
+	Reference string    `json:"reference" example:"DEV0012-v2"`
+	URL       string    `json:"url" example:"https://app.exemple.fr/api/devis-en-ligne/Jf0x8mQ2..."`
+	ExpireLe  time.Time `json:"expire_le" example:"2025-07-10T00:00:00Z"` // Date d'expiration du devis : le lien est valable jusqu'à la fin de ce jour
+}
+
+// DevisEnLigneResponse représente le devis présenté au client sur la page d'acceptation
+type DevisEnLigneResponse struct {
+	Reference      string                      `json:"reference" example:"DEV0012-v2"`
+	Revision       int                         `json:"revision" example:"2"`
+	Statut         string                      `json:"statut" example:"envoyé"`
+	Emetteur       []string                    `json:"emetteur"` // Raison sociale puis coordonnées de l'entreprise
+	Client         string                      `json:"client" example:"Jean Dupont"`
+	Objet          string                      `json:"objet" example:"Développement application web"`
+	Conditions     string                      `json:"conditions" example:"Paiement sous 30 jours"`
+	DateDevis      time.Time                   `json:"date_devis" example:"2025-06-10T00:00:00Z"`
+	DateExpiration time.Time                   `json:"date_expiration" example:"2025-07-10T00:00:00Z"`
+	Lignes         []models.LigneRevisionDevis `json:"lignes"`
+	SousTotalHT    models.Montant              `json:"sous_total_ht" example:"1500"`
+	TotalTVA       models.Montant              `json:"total_tva" example:"300"`
+	TotalTTC       models.Montant              `json:"total_ttc" example:"1800"`
+	VentilationTVA []models.VentilationTVA     `json:"ventilation_tva"`
+	MentionTVA     string                      `json:"mention_tva,omitempty" example:"TVA non applicable, art. 293 B du CGI"`
+	Decidable      bool                        `json:"decidable" example:"true"` // Le client peut encore accepter ou refuser le devis
+	Decision       *models.AcceptationDevis    `json:"decision,omitempty"`       // Réponse déjà donnée sur cette révision
+}
+
+// DecisionDevisRequest représente la réponse du client à un devis en ligne
+type DecisionDevisRequest struct {
+	Revision  int    `json:"revision" example:"2"`                                        // Révision consultée par le client
+	Nom       string `json:"nom" example:"Jean Dupont"`                                   // Nom du signataire
+	Signature string `json:"signature,omitempty" example:"data:image/png;base64,iVBO..."` // Signature manuscrite PNG ou JPEG (data URL ou base64), obligatoire pour accepter
+	Lieu      string `json:"lieu,omitempty" example:"Paris"`                              // Lieu de signature (ville du client par défaut)
+	Motif     string `json:"motif,omitempty" example:"Budget dépassé"`                    // Motif du refus, obligatoire pour refuser
+}
+
+// CreateLienAcceptationDevis godoc
+// @Summary Créer le lien d'acceptation en ligne d'un devis
+// @Description Génère l'URL secrète permettant au client de consulter, accepter (avec signature) ou refuser le devis. Le lien précédent est révoqué. Un devis brouillon passe au statut envoyé. Le lien expire avec le devis.
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Success 201 {object} LienAcceptationDevisResponse
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Le devis n'est plus en attente de réponse"
+// @Failure 500 {string} string "Erreur lors de la création du lien"
+// @Router /devis/{id}/lien-acceptation [post]
+func CreateLienAcceptationDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).Preload("Entreprise").First(&devis, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+	if devis.Statut != models.StatutDevisBrouillon && devis.Statut != models.StatutDevisEnvoye {
+		http.Error(w, errDevisDejaDecide.Error(), http.StatusConflict)
+		return
+	}
+	if lienDevisExpire(devis, time.Now()) {
+		http.Error(w, "Le devis a expiré le "+devis.DateExpiration.Format("02/01/2006"), http.StatusConflict)
+		return
+	}
+
+	jeton, err := genererJetonAcces()
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du jeton", http.StatusInternalServerError)
+		return
+	}
+
+	acteur := acteurEntreprise(r, entrepriseID)
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := marquerRevisionEnvoyee(tx, devis); err != nil {
+			return err
+		}
+		champs := map[string]interface{}{"jeton_acceptation_hash": empreinteJetonAcces(jeton)}
+		if devis.Statut == models.StatutDevisEnvoye {
+			return tx.Model(&models.Devis{}).Where("id = ?", devis.ID).Updates(champs).Error
+		}
+		return changerStatutDevis(tx, devis, models.StatutDevisEnvoye, acteur, "Lien d'acceptation en ligne", champs)
+	})
+	if err != nil {
+		http.Error(w, "Erreur lors de la création du lien", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(LienAcceptationDevisResponse{
+		Reference: referenceRevisionDevis(devis),
+		URL:       urlPublique(r, "/devis-en-ligne/"+jeton),
+		ExpireLe:  devis.DateExpiration,
+	})
+}
+
+// DeleteLienAcceptationDevis godoc
+// @Summary Révoquer le lien d'acceptation en ligne d'un devis
+// @Description Le client ne peut plus consulter ni répondre au devis par le lien envoyé
+// @Tags Devis
+// @Param id path string true "ID du devis"
+// @Success 204 "Lien révoqué"
+// @Failure 404 {string} string "Devis introuvable"
+// @Router /devis/{id}/lien-acceptation [delete]
+func DeleteLienAcceptationDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	result := tenantDB(entrepriseID).Model(&models.Devis{}).Where("id = ?", chi.URLParam(r, "id")).Update("jeton_acceptation_hash", "")
+	if result.Error != nil {
+		http.Error(w, "Erreur lors de la révocation du lien", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAcceptationsDevis godoc
+// @Summary Réponses du client à un devis
+// @Description Retourne les acceptations et refus recueillis en ligne, avec leurs éléments de preuve (horodatage, adresse IP, navigateur, empreinte du PDF présenté)
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Success 200 {array} models.AcceptationDevis
+// @Failure 404 {string} string "Devis introuvable"
+// @Router /devis/{id}/acceptations [get]
+func GetAcceptationsDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).First(&devis, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	var acceptations []models.AcceptationDevis
+	if err := config.DB.Where("devis_id = ?", devis.ID).Order("decide_le DESC").Find(&acceptations).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des réponses", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(acceptations)
+}
+
+// GetDevisEnLigne godoc
+// @Summary Consulter un devis en ligne
+// @Description Endpoint public, protégé par le jeton du lien d'acceptation : retourne la dernière version du devis et indique si le client peut encore y répondre
+// @Tags Devis en ligne
+// @Produce json
+// @Param jeton path string true "Jeton du lien d'acceptation"
+// @Success 200 {object} DevisEnLigneResponse
+// @Failure 404 {string} string "Lien d'acceptation introuvable"
+// @Failure 410 {string} string "Le lien a expiré"
+// @Router /devis-en-ligne/{jeton} [get]
+func GetDevisEnLigne(w http.ResponseWriter, r *http.Request) {
+	devis, ok := chargerDevisEnLigne(w, r)
+	if !ok {
+		return
+	}
+
+	reponse, err := devisEnLigne(devis)
+	if err != nil {
+		http.Error(w, "Erreur lors de la récupération du devis", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(reponse)
+}
+
+// GetDevisEnLignePDF godoc
+// @Summary PDF d'un devis en ligne
+// @Description Endpoint public : PDF de la dernière version du devis, signé électroniquement une fois accepté
+// @Tags Devis en ligne
+// @Produce application/pdf
+// @Param jeton path string true "Jeton du lien d'acceptation"
+// @Success 200 {file} file "Fichier PDF du devis"
+// @Failure 404 {string} string "Lien d'acceptation introuvable"
+// @Failure 410 {string} string "Le lien a expiré"
+// @Router /devis-en-ligne/{jeton}/pdf [get]
+func GetDevisEnLignePDF(w http.ResponseWriter, r *http.Request) {
+	devis, ok := chargerDevisEnLigne(w, r)
+	if !ok {
+		return
+	}
+
+	pdf, err := genererDevisPDF(devis)
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=devis_%s.pdf", referenceRevisionDevis(devis)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pdf)
+}
+
+// AccepterDevisEnLigne godoc
+// @Summary Accepter un devis en ligne
+// @Description Endpoint public : le client accepte la révision consultée en la signant (nom et signature manuscrite). L'horodatage, l'adresse IP, le navigateur et l'empreinte du PDF sont conservés comme preuve, et la signature est reportée sur le PDF.
+// @Tags Devis en ligne
+// @Accept json
+// @Produce json
+// @Param jeton path string true "Jeton du lien d'acceptation"
+// @Param decision body DecisionDevisRequest true "Signataire et signature"
+// @Success 200 {object} DevisEnLigneResponse
+// @Failure 400 {string} string "Données invalides"
+// @Failure 404 {string} string "Lien d'acceptation introuvable"
+// @Failure 409 {string} string "Le devis n'est plus en attente de réponse ou a été modifié"
+// @Failure 410 {string} string "Le lien a expiré"
+// @Router /devis-en-ligne/{jeton}/accepter [post]
+func AccepterDevisEnLigne(w http.ResponseWriter, r *http.Request) {
+	deciderDevisEnLigne(w, r, models.DecisionDevisAccepte)
+}
+
+// RefuserDevisEnLigne godoc
+// @Summary Refuser un devis en ligne
+// @Description Endpoint public : le client refuse la révision consultée en indiquant un motif
+// @Tags Devis en ligne
+// @Accept json
+// @Produce json
+// @Param jeton path string true "Jeton du lien d'acceptation"
+// @Param decision body DecisionDevisRequest true "Motif du refus"
+// @Success 200 {object} DevisEnLigneResponse
+// @Failure 400 {string} string "Données invalides"
+// @Failure 404 {string} string "Lien d'acceptation introuvable"
+// @Failure 409 {string} string "Le devis n'est plus en attente de réponse ou a été modifié"
+// @Failure 410 {string} string "Le lien a expiré"
+// @Router /devis-en-ligne/{jeton}/refuser [post]
+func RefuserDevisEnLigne(w http.ResponseWriter, r *http.Request) {
+	deciderDevisEnLigne(w, r, models.DecisionDevisRefuse)
+}
+
+// deciderDevisEnLigne enregistre l'acceptation ou le refus du client et sa preuve
+func deciderDevisEnLigne(w http.ResponseWriter, r *http.Request, decision string) {
+	devis, ok := chargerDevisEnLigne(w, r)
+	if !ok {
+		return
+	}
+
+	var req DecisionDevisRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*tailleMaxSignatureDevis)).Decode(&req); err != nil {
+		http.Error(w, "Données invalides", http.StatusBadRequest)
+		return
+	}
+	req.Nom = strings.TrimSpace(req.Nom)
+	req.Lieu = strings.TrimSpace(req.Lieu)
+	req.Motif = strings.TrimSpace(req.Motif)
+	if req.Revision == 0 {
+		http.Error(w, "La révision consultée est obligatoire", http.StatusBadRequest)
+		return
+	}
+
+	acceptation := models.AcceptationDevis{
+		EntrepriseID:  devis.EntrepriseID,
+		DevisID:       devis.ID,
+		Revision:      req.Revision,
+		Reference:     referenceRevisionDevis(devis),
+		Decision:      decision,
+		NomSignataire: req.Nom,
+		AdresseIP:     adresseIPClient(r),
+		UserAgent:     r.UserAgent(),
+	}
+	if decision == models.DecisionDevisAccepte {
+		if req.Nom == "" {
+			http.Error(w, "Le nom du signataire est obligatoire", http.StatusBadRequest)
+			return
+		}
+		signature, typeSignature, err := decoderSignatureDevis(req.Signature)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		acceptation.Signature, acceptation.SignatureType = signature, typeSignature
+		acceptation.Lieu = req.Lieu
+		if acceptation.Lieu == "" {
+			acceptation.Lieu = devis.Client.Ville
+		}
+	} else {
+		if req.Motif == "" {
+			http.Error(w, "Le motif du refus est obligatoire", http.StatusBadRequest)
+			return
+		}
+		acceptation.Motif = req.Motif
+	}
+
+	// Empreinte du document présenté au client, avant report de sa signature
+	pdf, err := genererDevisPDF(devis)
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du PDF", http.StatusInternalServerError)
+		return
+	}
+	somme := sha256.Sum256(pdf)
+	acceptation.EmpreinteDocument = hex.EncodeToString(somme[:])
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// Verrou sur le devis : une seule réponse par révision, même en cas de requêtes simultanées
+		var verrou models.Devis
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&verrou, devis.ID).Error; err != nil {
+			return err
+		}
+		switch {
+		case verrou.JetonAcceptationHash != devis.JetonAcceptationHash:
+			return errLienDevisRevoque
+		case verrou.Statut != models.StatutDevisEnvoye:
+			return errDevisDejaDecide
+		case verrou.Revision != req.Revision:
+			return errRevisionDevisObsolete
+		}
+
+		acceptation.DecideLe = time.Now()
+		var champs map[string]interface{}
+		if decision == models.DecisionDevisAccepte {
+			champs = map[string]interface{}{
+				"lieu_signature": acceptation.Lieu,
+				"date_signature": acceptation.DecideLe.In(config.GetCalendrierConfig().Fuseau).Format("02/01/2006"),
+			}
+		}
+		if err := changerStatutDevis(tx, verrou, decision, acteurClient(r, req.Nom), acceptation.Motif, champs); err != nil {
+			return err
+		}
+		return tx.Create(&acceptation).Error
+	})
+	switch {
+	case err == errLienDevisRevoque:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errDevisDejaDecide || err == errRevisionDevisObsolete:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Erreur lors de l'enregistrement de la réponse", http.StatusInternalServerError)
+		return
+	}
+
+	devis = models.Devis{}
+	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, acceptation.DevisID).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération du devis", http.StatusInternalServerError)
+		return
+	}
+	reponse, err := devisEnLigne(devis)
+	if err != nil {
+		http.Error(w, "Erreur lors de la récupération du devis", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reponse)
+}
+
+// chargerDevisEnLigne charge le devis d'un lien d'acceptation (lignes, entreprise et client préchargés).
+// Répond 404 si le lien est inconnu ou révoqué, 410 s'il a expiré.
+func chargerDevisEnLigne(w http.ResponseWriter, r *http.Request) (models.Devis, bool) {
+	var devis models.Devis
+	jeton := chi.URLParam(r, "jeton")
+	if jeton != "" {
+		config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").
+			Where("jeton_acceptation_hash = ?", empreinteJetonAcces(jeton)).Limit(1).Find(&devis)
+	}
+	if devis.ID == 0 {
+		http.Error(w, errLienDevisRevoque.Error(), http.StatusNotFound)
+		return devis, false
+	}
+	if lienDevisExpire(devis, time.Now()) {
+		http.Error(w, "Le lien a expiré le "+devis.DateExpiration.Format("02/01/2006"), http.StatusGone)
+		return devis, false
+	}
+	return devis, true
+}
+
+// lienDevisExpire indique si le lien d'acceptation a expiré : il reste valable jusqu'à la fin du jour d'expiration du devis
+func lienDevisExpire(devis models.Devis, maintenant time.Time) bool {
+	if devis.DateExpiration.IsZero() {
+		return false
+	}
+	jour := devis.DateExpiration
+	fin := time.Date(jour.Year(), jour.Month(), jour.Day()+1, 0, 0, 0, 0, config.GetCalendrierConfig().Fuseau)
+	return !maintenant.Before(fin)
+}
+
+// devisEnLigne construit la vue client de la dernière révision du devis
+func devisEnLigne(devis models.Devis) (DevisEnLigneResponse, error) {
+	var revision models.RevisionDevis
+	if err := config.DB.Preload("Lignes", ordreLignesRevision).
+		Where("devis_id = ? AND numero = ?", devis.ID, devis.Revision).First(&revision).Error; err != nil {
+		return DevisEnLigneResponse{}, err
+	}
+
+	reponse := DevisEnLigneResponse{
+		Reference:      revision.Reference,
+		Revision:       revision.Numero,
+		Statut:         devis.Statut,
+		Emetteur:       emetteurPDF(companyInfo(entrepriseDocument(devis.EntrepriseID))),
+		Client:         devis.Client.GetDisplayName(),
+		Objet:          revision.Objet,
+		Conditions:     revision.Conditions,
+		DateDevis:      revision.DateDevis,
+		DateExpiration: revision.DateExpiration,
+		Lignes:         revision.Lignes,
+		SousTotalHT:    revision.SousTotalHT,
+		TotalTVA:       revision.TotalTVA,
+		TotalTTC:       revision.TotalTTC,
+		VentilationTVA: revision.Ventilation(),
+		MentionTVA:     models.MentionExonerationTVA(revision.MotifExonerationTVA),
+		Decidable:      devis.Statut == models.StatutDevisEnvoye,
+	}
+
+	var decision models.AcceptationDevis
+	config.DB.Where("devis_id = ? AND revision = ?", devis.ID, revision.Numero).Order("id DESC").Limit(1).Find(&decision)
+	if decision.ID != 0 {
+		reponse.Decision = &decision
+	}
+	return reponse, nil
+}
+
+// decoderSignatureDevis décode la signature manuscrite transmise en data URL ou en base64 et vérifie son format
+func decoderSignatureDevis(signature string) ([]byte, string, error) {
+	signature = strings.TrimSpace(signature)
+	if signature == "" {
+		return nil, "", errors.New("La signature est obligatoire")
+	}
+	if strings.HasPrefix(signature, "data:") {
+		if i := strings.Index(signature, ","); i >= 0 {
+			signature = signature[i+1:]
+		}
+	}
+
+	image, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, "", errors.New("Signature invalide : image encodée en base64 attendue")
+	}
+	if len(image) > tailleMaxSignatureDevis {
+		return nil, "", errors.New("Signature trop volumineuse (512 Ko maximum)")
+	}
+	typeImage := http.DetectContentType(image)
+	if typeImage != "image/png" && typeImage != "image/jpeg" {
+		return nil, "", errors.New("Signature invalide : image PNG ou JPEG attendue")
+	}
+	return image, typeImage, nil
+}
+
+// acceptationSigneeDevis charge l'acceptation signée d'une révision de devis
+func acceptationSigneeDevis(devisID uint, revision int) (models.AcceptationDevis, bool) {
+	var acceptation models.AcceptationDevis
+	config.DB.Where("devis_id = ? AND revision = ? AND decision = ?", devisID, revision, models.DecisionDevisAccepte).
+		Order("id DESC").Limit(1).Find(&acceptation)
+	return acceptation, acceptation.ID != 0
+}
+
+// legendeSignatureDevis retourne la légende de la signature électronique reportée sur le PDF
+func legendeSignatureDevis(acceptation models.AcceptationDevis) []string {
+	decideLe := acceptation.DecideLe.In(config.GetCalendrierConfig().Fuseau)
+	return []string{
+		"Bon pour accord - " + acceptation.NomSignataire,
+		"Signé électroniquement le " + decideLe.Format("02/01/2006 à 15:04"),
+	}
+}
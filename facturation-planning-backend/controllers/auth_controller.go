@@ -147,14 +147,13 @@ func LoginEntreprise(w http.ResponseWriter, r *http.Request) {
 // @Failure 401 {string} string "Non autorisé"
 // @Router /profile [get]
 func GetProfile(w http.ResponseWriter, r *http.Request) {
-	entrepriseID, ok := r.Context().Value("entrepriseID").(float64)
+	entrepriseID, ok := requireEntrepriseID(w, r)
 	if !ok {
-		http.Error(w, "Erreur d'authentification", http.StatusUnauthorized)
 		return
 	}
 
 	var entreprise models.Entreprise
-	result := config.DB.First(&entreprise, uint(entrepriseID))
+	result := config.DB.First(&entreprise, entrepriseID)
 	if result.Error != nil {
 		http.Error(w, "Entreprise non trouvée", http.StatusNotFound)
 		return
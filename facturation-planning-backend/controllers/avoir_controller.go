@@ -0,0 +1,500 @@
+package controllers
+
+import (
+	"encoding/json"
+	"facturation-planning/config"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateAvoirRequest représente la demande de création d'un avoir
+type CreateAvoirRequest struct {
+	TypeAvoir string              `json:"typeAvoir" example:"partiel"` // "total" ou "partiel"
+	Motif     string              `json:"motif" example:"Erreur de quantité"`
+	Lignes    []LigneAvoirRequest `json:"lignes"` // Obligatoire pour un avoir partiel
+}
+
+// LigneAvoirRequest représente une ligne de facture à créditer
+type LigneAvoirRequest struct {
+	LigneFactureID uint    `json:"ligneFactureID" example:"12"`
+	Quantite       float64 `json:"quantite" example:"1"`
+}
+
+// AvoirPDFData structure pour les données du template PDF d'un avoir
+type AvoirPDFData struct {
+	Reference        string
+	FactureReference string
+	DateEmission     string
+	DateFacture      string
+	ClientNom        string
+	ClientAdresse    string
+	TypeAvoir        string
+	Motif            string
+	Lignes           []LigneFacture
+	SousTotalHT      models.Montant
+	TotalTVA         models.Montant
+	TotalTTC         models.Montant
+	VentilationTVA   []models.VentilationTVA
+	MentionTVA       string
+	Company          config.CompanyInfo
+
+	RemisePourcentage float64
+}
+
+// CreateAvoir godoc
+// @Summary Créer un avoir sur une facture
+// @Description Crée un avoir total ou partiel (par ligne) sur une facture et met à jour son solde
+// @Tags Avoirs
+// @Accept json
+// @Produce json
+// @Param id path string true "ID de la facture à créditer"
+// @Param avoir body CreateAvoirRequest true "Lignes et quantités à créditer"
+// @Success 201 {object} models.Avoir "Avoir créé avec succès"
+// @Failure 400 {string} string "Erreur de validation des données"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "Facture brouillon ou déjà annulée"
+// @Failure 500 {string} string "Erreur interne du serveur"
+// @Router /factures/{id}/avoirs [post]
+func CreateAvoir(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var req CreateAvoirRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.TypeAvoir == "" {
+		req.TypeAvoir = models.TypeAvoirPartiel
+	}
+
+	var avoir models.Avoir
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var facture models.Facture
+		// Verrouiller la facture : deux avoirs concurrents ne doivent pas créditer deux fois le même reste
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("entreprise_id = ?", entrepriseID).Preload("Lignes").First(&facture, id).Error; err != nil {
+			return errFactureIntrouvable
+		}
+
+		switch facture.Statut {
+		case models.StatutFactureBrouillon:
+			return errFactureBrouillon
+		case models.StatutFactureAnnulee:
+			return errFactureAnnulee
+		}
+
+		lignes, err := buildLignesAvoir(tx, facture, req)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		reference, err := prochainNumero(tx, entrepriseID, models.TypeDocumentAvoir, now)
+		if err != nil {
+			return err
+		}
+
+		avoir = models.Avoir{
+			Reference:     reference,
+			EntrepriseID:  entrepriseID,
+			FactureID:     facture.ID,
+			ClientID:      facture.ClientID,
+			ClientNom:     facture.ClientNom,
+			ClientAdresse: facture.ClientAdresse,
+			DateEmission:  now,
+			TypeAvoir:     req.TypeAvoir,
+			Motif:         req.Motif,
+			Lignes:        lignes,
+		}
+		// La remise globale de la facture est créditée au prorata des lignes
+		avoir.RemiseMontant = facture.RemiseGlobale().Proportion(avoir.TotalLignesHT(), facture.TotalLignesHT())
+
+		// TVA calculée par taux, comme sur la facture ; un avoir total solde exactement le montant restant
+		avoir.VentilationTVA = avoir.Ventilation()
+		avoir.SousTotalHT, avoir.TotalTVA, avoir.TotalTTC = models.TotauxVentilation(avoir.VentilationTVA)
+		restant := facture.TotalTTC - facture.TotalAvoirs
+		if req.TypeAvoir == models.TypeAvoirTotal && (avoir.TotalTTC-restant).Abs() <= toleranceMontant*models.Montant(len(lignes)) {
+			avoir.TotalTTC = restant
+			avoir.TotalTVA = restant - avoir.SousTotalHT
+		}
+
+		if avoir.TotalTTC <= 0 {
+			return fmt.Errorf("le montant de l'avoir doit être positif")
+		}
+
+		// Un avoir ne peut pas créditer plus que le montant restant de la facture
+		if avoir.TotalTTC > restant+toleranceMontant {
+			return fmt.Errorf("le montant de l'avoir dépasse le montant restant de la facture")
+		}
+
+		if err := tx.Create(&avoir).Error; err != nil {
+			return err
+		}
+
+		if err := recalculerSoldeFacture(tx, facture.ID, acteurEntreprise(r, entrepriseID), "Avoir "+avoir.Reference); err != nil {
+			return err
+		}
+
+		// Les plannings dont la facturation est annulée redeviennent à facturer
+		return libererPlanningsCredites(tx, facture.ID)
+	})
+
+	switch {
+	case err == errFactureIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errFactureAnnulee || err == errFactureBrouillon:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(avoir)
+}
+
+// GetAllAvoirs godoc
+// @Summary Récupérer tous les avoirs
+// @Description Retourne la liste des avoirs de l'entreprise connectée
+// @Tags Avoirs
+// @Produce json
+// @Success 200 {array} models.Avoir "Liste des avoirs"
+// @Failure 500 {string} string "Erreur lors de la récupération des avoirs"
+// @Router /avoirs [get]
+func GetAllAvoirs(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var avoirs []models.Avoir
+	if err := tenantDB(entrepriseID).Preload("Lignes").Order("id DESC").Find(&avoirs).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des avoirs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(avoirs)
+}
+
+// GetAvoirByID godoc
+// @Summary Récupérer un avoir par ID
+// @Description Récupère un avoir avec ses lignes et la facture d'origine
+// @Tags Avoirs
+// @Produce json
+// @Param id path string true "ID de l'avoir"
+// @Success 200 {object} models.Avoir
+// @Failure 404 {string} string "Avoir introuvable"
+// @Router /avoirs/{id} [get]
+func GetAvoirByID(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var avoir models.Avoir
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Facture").First(&avoir, id).Error; err != nil {
+		http.Error(w, "Avoir introuvable", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(avoir)
+}
+
+// GetAvoirsByFacture godoc
+// @Summary Récupérer les avoirs d'une facture
+// @Description Récupère tous les avoirs émis sur une facture
+// @Tags Avoirs
+// @Produce json
+// @Param id path string true "ID de la facture"
+// @Success 200 {array} models.Avoir
+// @Failure 404 {string} string "Facture introuvable"
+// @Router /factures/{id}/avoirs [get]
+func GetAvoirsByFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	var avoirs []models.Avoir
+	if err := config.DB.Preload("Lignes").Where("facture_id = ?", facture.ID).Order("id").Find(&avoirs).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des avoirs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(avoirs)
+}
+
+// GenerateAvoirPDF godoc
+// @Summary Générer le PDF d'un avoir pour affichage
+// @Description Génère un avoir au format PDF pour affichage dans le navigateur
+// @Tags Avoirs
+// @Produce application/pdf
+// @Param id path string true "ID de l'avoir"
+// @Success 200 {file} file "Fichier PDF généré pour affichage"
+// @Failure 404 {string} string "Avoir introuvable"
+// @Failure 500 {string} string "Erreur lors de la génération du PDF"
+// @Router /avoirs/{id}/pdf [get]
+func GenerateAvoirPDF(w http.ResponseWriter, r *http.Request) {
+	serveAvoirPDF(w, r, "inline")
+}
+
+// DownloadAvoirPDF godoc
+// @Summary Télécharger un avoir en PDF
+// @Description Génère et force le téléchargement d'un avoir au format PDF
+// @Tags Avoirs
+// @Produce application/pdf
+// @Param id path string true "ID de l'avoir"
+// @Success 200 {file} file "Fichier PDF à télécharger"
+// @Failure 404 {string} string "Avoir introuvable"
+// @Failure 500 {string} string "Erreur lors de la génération du PDF"
+// @Router /avoirs/{id}/download [get]
+func DownloadAvoirPDF(w http.ResponseWriter, r *http.Request) {
+	serveAvoirPDF(w, r, "attachment")
+}
+
+// serveAvoirPDF génère le PDF d'un avoir et l'envoie avec la disposition demandée
+func serveAvoirPDF(w http.ResponseWriter, r *http.Request, disposition string) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var avoir models.Avoir
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Facture").First(&avoir, id).Error; err != nil {
+		http.Error(w, "Avoir introuvable", http.StatusNotFound)
+		return
+	}
+
+	pdf, err := genererAvoirPDF(avoir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("avoir_%s.pdf", avoir.Reference)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%s", disposition, filename))
+	w.Write(pdf)
+}
+
+// genererAvoirPDF produit le PDF d'un avoir (lignes et facture d'origine préchargées)
+func genererAvoirPDF(avoir models.Avoir) ([]byte, error) {
+	data := prepareAvoirPDFData(avoir)
+
+	origine := data.FactureReference
+	if data.DateFacture != "" {
+		origine += " du " + data.DateFacture
+	}
+
+	doc := utils.DocumentPDF{
+		Titre:     utils.TitrePDFAvoir,
+		Reference: data.Reference,
+		Mentions:  []string{"Sur facture : " + data.FactureReference},
+		Emetteur:  emetteurPDF(data.Company),
+		Logo:      data.Company.Logo,
+		Client:    blocClientPDF(data.ClientNom, data.ClientAdresse, "", ""),
+		Informations: utils.BlocPDF{Titre: "Informations avoir", Lignes: []string{
+			"Date d'émission : " + data.DateEmission,
+			"Facture d'origine : " + origine,
+			"Type : avoir " + data.TypeAvoir,
+		}},
+		Lignes:       lignesDocumentPDF(data.Lignes),
+		Totaux:       totauxPDF(data.SousTotalHT, data.VentilationTVA, data.TotalTVA, data.TotalTTC, data.RemisePourcentage),
+		TVA:          ventilationPDF(data.VentilationTVA),
+		MentionTVA:   data.MentionTVA,
+		PiedDePage:   piedDePagePDF(data.Company),
+		DateCreation: avoir.DateEmission,
+	}
+	if data.Motif != "" {
+		doc.Objet = utils.BlocPDF{Titre: "Motif de l'avoir", Lignes: []string{data.Motif}}
+	}
+	return utils.GenererDocumentPDF(doc)
+}
+
+// prepareAvoirPDFData prépare les données pour le template PDF d'un avoir
+func prepareAvoirPDFData(avoir models.Avoir) AvoirPDFData {
+	var lignes []LigneFacture
+	for _, ligne := range avoir.Lignes {
+		lignes = append(lignes, LigneFacture{
+			Designation:  ligne.Description,
+			Unite:        ligne.Unite,
+			Quantite:     ligne.Quantite,
+			PrixUnitaire: ligne.PrixUnitaire,
+			MontantHT:    ligne.MontantHT,
+			TVA:          ligne.TauxTVA,
+			MontantTTC:   ligne.MontantTTC,
+
+			RemisePourcentage: ligne.RemisePourcentage,
+			RemiseMontant:     ligne.RemiseMontant,
+		})
+	}
+
+	var factureReference, dateFacture, mentionTVA string
+	var remisePourcentage float64
+	if avoir.Facture != nil {
+		factureReference = avoir.Facture.Reference
+		remisePourcentage = avoir.Facture.RemisePourcentage
+		mentionTVA = models.MentionExonerationTVA(avoir.Facture.MotifExonerationTVA)
+		if !avoir.Facture.DateEmission.IsZero() {
+			dateFacture = avoir.Facture.DateEmission.Format("02/01/2006")
+		}
+	}
+
+	return AvoirPDFData{
+		Reference:        avoir.Reference,
+		FactureReference: factureReference,
+		DateEmission:     avoir.DateEmission.Format("02/01/2006"),
+		DateFacture:      dateFacture,
+		ClientNom:        avoir.ClientNom,
+		ClientAdresse:    avoir.ClientAdresse,
+		TypeAvoir:        avoir.TypeAvoir,
+		Motif:            avoir.Motif,
+		Lignes:           lignes,
+		SousTotalHT:      avoir.SousTotalHT,
+		TotalTVA:         avoir.TotalTVA,
+		TotalTTC:         avoir.TotalTTC,
+		VentilationTVA:   avoir.Ventilation(),
+		MentionTVA:       mentionTVA,
+		Company:          companyInfo(entrepriseDocument(avoir.EntrepriseID)),
+
+		RemisePourcentage: remisePourcentage,
+	}
+}
+
+// buildLignesAvoir construit les lignes d'un avoir à partir des lignes de la facture
+// en vérifiant qu'aucune ligne n'est créditée au-delà de sa quantité facturée
+func buildLignesAvoir(tx *gorm.DB, facture models.Facture, req CreateAvoirRequest) ([]models.LigneAvoir, error) {
+	if req.TypeAvoir != models.TypeAvoirTotal && req.TypeAvoir != models.TypeAvoirPartiel {
+		return nil, fmt.Errorf("type d'avoir invalide")
+	}
+
+	// Quantités déjà créditées par ligne de facture
+	dejaCredite := make(map[uint]float64)
+	var credits []struct {
+		LigneFactureID uint
+		Quantite       float64
+	}
+	tx.Model(&models.LigneAvoir{}).
+		Select("ligne_avoirs.ligne_facture_id, SUM(ligne_avoirs.quantite) AS quantite").
+		Joins("JOIN avoirs ON avoirs.id = ligne_avoirs.avoir_id").
+		Where("avoirs.facture_id = ? AND ligne_avoirs.ligne_facture_id IS NOT NULL", facture.ID).
+		Group("ligne_avoirs.ligne_facture_id").
+		Scan(&credits)
+	for _, c := range credits {
+		dejaCredite[c.LigneFactureID] = c.Quantite
+	}
+
+	lignesFacture := make(map[uint]models.LigneFacture)
+	for _, l := range facture.Lignes {
+		lignesFacture[l.ID] = l
+	}
+
+	var lignes []models.LigneAvoir
+
+	if req.TypeAvoir == models.TypeAvoirTotal {
+		// Facture sans lignes détaillées : créditer le montant restant en une seule ligne
+		if len(facture.Lignes) == 0 {
+			if facture.TotalTTC <= 0 {
+				return nil, fmt.Errorf("la facture n'a aucun montant à créditer")
+			}
+			resteHT := facture.SousTotalHT.Proportion(facture.TotalTTC-facture.TotalAvoirs, facture.TotalTTC)
+			return []models.LigneAvoir{{
+				Description:  fmt.Sprintf("Annulation de la facture %s", facture.Reference),
+				Unite:        models.UniteForfait,
+				Quantite:     1,
+				PrixUnitaire: resteHT,
+				TauxTVA:      facture.TauxTVA,
+				MontantHT:    resteHT,
+				MontantTTC:   facture.TotalTTC - facture.TotalAvoirs,
+			}}, nil
+		}
+
+		for _, l := range facture.Lignes {
+			reste := l.Quantite - dejaCredite[l.ID]
+			if reste <= 0 {
+				continue
+			}
+			lignes = append(lignes, ligneAvoirDepuisFacture(l, reste))
+		}
+		return lignes, nil
+	}
+
+	if len(req.Lignes) == 0 {
+		return nil, fmt.Errorf("un avoir partiel doit contenir au moins une ligne")
+	}
+
+	for _, demande := range req.Lignes {
+		l, ok := lignesFacture[demande.LigneFactureID]
+		if !ok {
+			return nil, fmt.Errorf("ligne de facture %d introuvable", demande.LigneFactureID)
+		}
+
+		if demande.Quantite <= 0 {
+			return nil, fmt.Errorf("la quantité créditée doit être positive")
+		}
+
+		if demande.Quantite > l.Quantite-dejaCredite[l.ID]+1e-9 {
+			return nil, fmt.Errorf("la quantité créditée dépasse la quantité restante de la ligne %d", l.ID)
+		}
+
+		dejaCredite[l.ID] += demande.Quantite
+		lignes = append(lignes, ligneAvoirDepuisFacture(l, demande.Quantite))
+	}
+
+	return lignes, nil
+}
+
+// ligneAvoirDepuisFacture crée une ligne d'avoir créditant une quantité d'une ligne de facture ; la remise
+// de la ligne est reprise en pourcentage, ou en montant au prorata de la quantité créditée
+func ligneAvoirDepuisFacture(l models.LigneFacture, quantite float64) models.LigneAvoir {
+	ligneFactureID := l.ID
+	remiseMontant := l.RemiseMontant
+	if remiseMontant != 0 && quantite != l.Quantite {
+		remiseMontant = l.RemiseMontant.Multiplier(quantite / l.Quantite)
+	}
+	montantHT := models.MontantHTRemise(l.PrixUnitaire, quantite, l.RemisePourcentage, remiseMontant)
+
+	return models.LigneAvoir{
+		LigneFactureID: &ligneFactureID,
+		Description:    l.Description,
+		Unite:          l.Unite,
+		Quantite:       quantite,
+		PrixUnitaire:   l.PrixUnitaire,
+		TauxTVA:        l.TauxTVA,
+		MontantHT:      montantHT,
+		MontantTTC:     montantHT + montantHT.Pourcentage(l.TauxTVA),
+
+		RemisePourcentage: l.RemisePourcentage,
+		RemiseMontant:     remiseMontant,
+	}
+}
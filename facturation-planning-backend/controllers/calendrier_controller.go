@@ -0,0 +1,384 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/go-chi/chi/v5"
+	"github.com/teambition/rrule-go"
+	"gorm.io/gorm"
+)
+
+// domaineUIDCalendrier complète les UID des événements publiés dans les flux iCalendar
+const domaineUIDCalendrier = "facturation-planning"
+
+// AbonnementCalendrierRequest représente la demande d'un lien d'abonnement au calendrier
+type AbonnementCalendrierRequest struct {
+	SalarieID *uint `json:"salarie_id,omitempty" example:"2"` // vide : tous les plannings de l'entreprise
+}
+
+// AbonnementCalendrierResponse représente un lien d'abonnement au calendrier.
+// Le lien contient le jeton d'accès : il n'est communiqué qu'une fois.
+type AbonnementCalendrierResponse struct {
+	ID        uint   `json:"id" example:"1"`
+	SalarieID *uint  `json:"salarie_id,omitempty" example:"2"`
+	URL       string `json:"url" example:"https://app.exemple.fr/api/calendrier/Jf0x8mQ2...ics"`
+}
+
+// CreateAbonnementCalendrier godoc
+// @Summary Créer un lien d'abonnement au calendrier
+// @Description Génère l'URL secrète du flux iCalendar des plannings de l'entreprise, ou d'un salarié. Le lien précédent de la même portée est révoqué.
+// @Tags Planning
+// @Accept json
+// @Produce json
+// @Param abonnement body AbonnementCalendrierRequest false "Salarié concerné (vide : toute l'entreprise)"
+// @Success 201 {object} AbonnementCalendrierResponse
+// @Failure 400 {string} string "Salarié introuvable"
+// @Failure 500 {string} string "Erreur lors de la création de l'abonnement"
+// @Router /plannings/calendrier [post]
+func CreateAbonnementCalendrier(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req AbonnementCalendrierRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.SalarieID != nil && !salarieAppartientA(entrepriseID, *req.SalarieID) {
+		http.Error(w, "Salarié introuvable", http.StatusBadRequest)
+		return
+	}
+
+	jeton, err := genererJetonAcces()
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du jeton", http.StatusInternalServerError)
+		return
+	}
+
+	abonnement := models.AbonnementCalendrier{
+		EntrepriseID: entrepriseID,
+		SalarieID:    req.SalarieID,
+		JetonHash:    empreinteJetonAcces(jeton),
+	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revoquerAbonnementsCalendrier(tx, entrepriseID, req.SalarieID); err != nil {
+			return err
+		}
+		return tx.Create(&abonnement).Error
+	})
+	if err != nil {
+		http.Error(w, "Erreur lors de la création de l'abonnement", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AbonnementCalendrierResponse{
+		ID:        abonnement.ID,
+		SalarieID: abonnement.SalarieID,
+		URL:       urlFluxCalendrier(r, jeton),
+	})
+}
+
+// DeleteAbonnementCalendrier godoc
+// @Summary Révoquer un lien d'abonnement au calendrier
+// @Description Révoque le lien du flux iCalendar de l'entreprise, ou d'un salarié
+// @Tags Planning
+// @Param salarie_id query int false "Salarié concerné (vide : lien de toute l'entreprise)"
+// @Success 204 "No Content"
+// @Failure 400 {string} string "Salarié invalide"
+// @Failure 500 {string} string "Erreur lors de la révocation"
+// @Router /plannings/calendrier [delete]
+func DeleteAbonnementCalendrier(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var salarieID *uint
+	if valeur := r.URL.Query().Get("salarie_id"); valeur != "" {
+		id, err := strconv.ParseUint(valeur, 10, 64)
+		if err != nil {
+			http.Error(w, "Salarié invalide", http.StatusBadRequest)
+			return
+		}
+		salarie := uint(id)
+		salarieID = &salarie
+	}
+
+	if err := revoquerAbonnementsCalendrier(config.DB, entrepriseID, salarieID); err != nil {
+		http.Error(w, "Erreur lors de la révocation de l'abonnement", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetFluxCalendrier godoc
+// @Summary Flux iCalendar des plannings
+// @Description Publie les plannings (récurrences comprises, adresse du client en lieu) au format iCalendar pour un abonnement depuis un agenda. L'accès est protégé par le jeton du lien, sans authentification.
+// @Tags Planning
+// @Produce text/calendar
+// @Param jeton path string true "Jeton du lien d'abonnement, suivi de .ics"
+// @Success 200 {string} string "Calendrier iCalendar"
+// @Failure 404 {string} string "Calendrier introuvable"
+// @Failure 500 {string} string "Erreur lors de la génération du calendrier"
+// @Router /calendrier/{jeton} [get]
+func GetFluxCalendrier(w http.ResponseWriter, r *http.Request) {
+	jeton := strings.TrimSuffix(chi.URLParam(r, "jeton"), ".ics")
+
+	var abonnement models.AbonnementCalendrier
+	config.DB.Where("jeton_hash = ?", empreinteJetonAcces(jeton)).Limit(1).Find(&abonnement)
+	if abonnement.ID == 0 {
+		http.Error(w, "Calendrier introuvable", http.StatusNotFound)
+		return
+	}
+
+	calendrier, err := construireCalendrier(config.DB, &abonnement, config.GetCalendrierConfig())
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du calendrier", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=planning.ics")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	calendrier.SerializeTo(w)
+}
+
+// revoquerAbonnementsCalendrier supprime les abonnements d'une entreprise pour un salarié, ou pour toute l'entreprise si nil
+func revoquerAbonnementsCalendrier(db *gorm.DB, entrepriseID uint, salarieID *uint) error {
+	query := db.Where("entreprise_id = ?", entrepriseID)
+	if salarieID != nil {
+		query = query.Where("salarie_id = ?", *salarieID)
+	} else {
+		query = query.Where("salarie_id IS NULL")
+	}
+	return query.Delete(&models.AbonnementCalendrier{}).Error
+}
+
+// urlFluxCalendrier construit l'URL d'abonnement d'un jeton
+func urlFluxCalendrier(r *http.Request, jeton string) string {
+	return urlPublique(r, "/calendrier/"+jeton+".ics")
+}
+
+// construireCalendrier construit le flux iCalendar d'un abonnement : les plannings enregistrés depuis
+// JoursPasses jours et les séries en cours. Une occurrence modifiée est publiée avec le RECURRENCE-ID
+// de sa série ; réattribuée à un autre salarié, elle est exclue de la série (EXDATE) dans le flux du salarié d'origine.
+func construireCalendrier(db *gorm.DB, abonnement *models.AbonnementCalendrier, cfg config.CalendrierConfig) (*ics.Calendar, error) {
+	borne := time.Now().In(cfg.Fuseau).AddDate(0, 0, -cfg.JoursPasses).Format("2006-01-02")
+
+	var entreprise models.Entreprise
+	if err := db.First(&entreprise, abonnement.EntrepriseID).Error; err != nil {
+		return nil, err
+	}
+
+	requeteSeries := db.Session(&gorm.Session{NewDB: true}).Preload("Exceptions").Preload("Salarie").Preload("Client").
+		Where("entreprise_id = ?", abonnement.EntrepriseID).
+		Where("date_fin = '' OR date_fin IS NULL OR date_fin >= ?", borne)
+	if abonnement.SalarieID != nil {
+		requeteSeries = requeteSeries.Where("salarie_id = ?", *abonnement.SalarieID)
+	}
+	var series []models.SeriePlanning
+	if err := requeteSeries.Order("id").Find(&series).Error; err != nil {
+		return nil, err
+	}
+	seriesParID := make(map[uint]*models.SeriePlanning, len(series))
+	serieIDs := make([]uint, 0, len(series))
+	for i := range series {
+		seriesParID[series[i].ID] = &series[i]
+		serieIDs = append(serieIDs, series[i].ID)
+	}
+
+	requetePlannings := db.Session(&gorm.Session{NewDB: true}).Preload("Salarie").Preload("Client").
+		Where("entreprise_id = ?", abonnement.EntrepriseID).
+		Where("date >= ? OR date_occurrence >= ?", borne, borne)
+	if abonnement.SalarieID != nil {
+		if len(serieIDs) > 0 {
+			requetePlannings = requetePlannings.Where("salarie_id = ? OR serie_id IN ?", *abonnement.SalarieID, serieIDs)
+		} else {
+			requetePlannings = requetePlannings.Where("salarie_id = ?", *abonnement.SalarieID)
+		}
+	}
+	var plannings []models.Planning
+	if err := requetePlannings.Order("date, heure_debut, id").Find(&plannings).Error; err != nil {
+		return nil, err
+	}
+
+	calendrier := ics.NewCalendarFor(domaineUIDCalendrier)
+	calendrier.SetMethod(ics.MethodPublish)
+	nom := entreprise.Nom
+	if abonnement.SalarieID != nil {
+		var salarie models.Salarie
+		if db.Session(&gorm.Session{NewDB: true}).Limit(1).Find(&salarie, *abonnement.SalarieID); salarie.ID != 0 {
+			nom = salarie.Nom + " - " + entreprise.Nom
+		}
+	}
+	calendrier.SetXWRCalName("Planning " + nom)
+	calendrier.SetXWRTimezone(cfg.Fuseau.String())
+	calendrier.SetRefreshInterval("PT1H")
+
+	avecSalarie := abonnement.SalarieID == nil
+	exclues := make(map[uint][]string)
+	for i := range plannings {
+		planning := &plannings[i]
+		publie := abonnement.SalarieID == nil || planning.SalarieID == *abonnement.SalarieID
+
+		var serie *models.SeriePlanning
+		if planning.SerieID != nil {
+			serie = seriesParID[*planning.SerieID]
+		}
+
+		switch {
+		case serie != nil && publie:
+			evenement := calendrier.AddEvent(uidSerieCalendrier(serie.ID))
+			remplirEvenementCalendrier(evenement, planning, avecSalarie, cfg)
+			valeur, parametres := dateICS(planning.DateOccurrence, serie.HeureDebut, serie.HeureFin, cfg)
+			evenement.SetProperty(ics.ComponentPropertyRecurrenceId, valeur, parametres...)
+		case serie != nil:
+			exclues[serie.ID] = append(exclues[serie.ID], planning.DateOccurrence)
+		case publie && planning.Date >= borne:
+			evenement := calendrier.AddEvent(fmt.Sprintf("planning-%d@%s", planning.ID, domaineUIDCalendrier))
+			remplirEvenementCalendrier(evenement, planning, avecSalarie, cfg)
+		}
+	}
+
+	for i := range series {
+		serie := &series[i]
+		regle, err := regleCalendrier(serie, cfg)
+		if err != nil {
+			continue
+		}
+
+		modele := serie.Occurrence(serie.DateDebut)
+		modele.CreatedAt, modele.UpdatedAt = serie.CreatedAt, serie.UpdatedAt
+		evenement := calendrier.AddEvent(uidSerieCalendrier(serie.ID))
+		remplirEvenementCalendrier(evenement, &modele, avecSalarie, cfg)
+		evenement.AddRrule(regle)
+
+		dates := exclues[serie.ID]
+		for _, exception := range serie.Exceptions {
+			dates = append(dates, exception.Date)
+		}
+		for _, date := range dates {
+			valeur, parametres := dateICS(date, serie.HeureDebut, serie.HeureFin, cfg)
+			evenement.AddExdate(valeur, parametres...)
+		}
+	}
+
+	return calendrier, nil
+}
+
+// uidSerieCalendrier retourne l'UID commun à une série et à ses occurrences modifiées
+func uidSerieCalendrier(serieID uint) string {
+	return fmt.Sprintf("serie-%d@%s", serieID, domaineUIDCalendrier)
+}
+
+// remplirEvenementCalendrier renseigne les dates, le résumé, le lieu (adresse du client) et la catégorie d'un événement
+func remplirEvenementCalendrier(evenement *ics.VEvent, planning *models.Planning, avecSalarie bool, cfg config.CalendrierConfig) {
+	modification := planning.UpdatedAt
+	if modification.IsZero() {
+		modification = time.Now()
+	}
+	evenement.SetDtStampTime(modification)
+	evenement.SetModifiedAt(modification)
+
+	debut, parametres := dateICS(planning.Date, planning.HeureDebut, planning.HeureFin, cfg)
+	evenement.SetProperty(ics.ComponentPropertyDtStart, debut, parametres...)
+	if planning.HeureDebut == "" && planning.HeureFin == "" {
+		jour, _ := time.Parse("2006-01-02", planning.Date)
+		evenement.SetAllDayEndAt(jour.AddDate(0, 0, 1))
+	} else if _, fin, err := planning.Intervalle(); err == nil {
+		evenement.SetProperty(ics.ComponentPropertyDtEnd, fin.Format("20060102T150405"), ics.WithTZID(cfg.Fuseau.String()))
+	}
+
+	resume := planning.TypeEvenement
+	if planning.ClientID != 0 {
+		resume += " - " + planning.Client.GetDisplayName()
+	}
+	if planning.Objet != nil && *planning.Objet != "" {
+		resume += " : " + *planning.Objet
+	}
+	evenement.SetSummary(resume)
+
+	var description []string
+	if planning.Prestation != nil && *planning.Prestation != "" {
+		description = append(description, "Prestation : "+*planning.Prestation)
+	}
+	if avecSalarie && planning.SalarieID != 0 {
+		description = append(description, "Salarié : "+planning.Salarie.Nom)
+	}
+	if planning.ClientID != 0 && planning.Client.Telephone != "" {
+		description = append(description, "Téléphone client : "+planning.Client.Telephone)
+	}
+	if len(description) > 0 {
+		evenement.SetDescription(strings.Join(description, "\n"))
+	}
+
+	if adresse := adresseClientCalendrier(&planning.Client); planning.ClientID != 0 && adresse != "" {
+		evenement.SetLocation(adresse)
+	}
+	evenement.AddCategory(planning.TypeEvenement)
+
+	if planning.TypeEvenement == models.TypeAnnulation {
+		evenement.SetStatus(ics.ObjectStatusCancelled)
+	} else {
+		evenement.SetStatus(ics.ObjectStatusConfirmed)
+	}
+	if models.EstAbsence(planning.TypeEvenement) {
+		evenement.SetTimeTransparency(ics.TransparencyOpaque)
+	}
+}
+
+// dateICS formate le début d'un planning : date seule (VALUE=DATE) sans horaires, heure locale du fuseau sinon
+func dateICS(date, heureDebut, heureFin string, cfg config.CalendrierConfig) (string, []ics.PropertyParameter) {
+	jour := strings.ReplaceAll(date, "-", "")
+	if heureDebut == "" && heureFin == "" {
+		return jour, []ics.PropertyParameter{ics.WithValue(string(ics.ValueDataTypeDate))}
+	}
+	return jour + "T" + strings.ReplaceAll(heureDebut, ":", "") + "00", []ics.PropertyParameter{ics.WithTZID(cfg.Fuseau.String())}
+}
+
+// regleCalendrier retourne la règle de récurrence publiée d'une série. La fin UNTIL, inclusive au jour près
+// dans l'application, est exprimée selon le type de DTSTART : date seule, ou fin de journée en UTC.
+func regleCalendrier(serie *models.SeriePlanning, cfg config.CalendrierConfig) (string, error) {
+	option, err := rrule.StrToROption(serie.RRule)
+	if err != nil {
+		return "", err
+	}
+	if option.Until.IsZero() {
+		return option.RRuleString(), nil
+	}
+
+	option.Until = time.Time{}
+	fin, err := time.ParseInLocation("2006-01-02", serie.DateFin, cfg.Fuseau)
+	if err != nil {
+		return "", err
+	}
+	if serie.HeureDebut == "" && serie.HeureFin == "" {
+		return option.RRuleString() + ";UNTIL=" + fin.Format("20060102"), nil
+	}
+	return option.RRuleString() + ";UNTIL=" + fin.AddDate(0, 0, 1).Add(-time.Second).UTC().Format("20060102T150405Z"), nil
+}
+
+// adresseClientCalendrier retourne l'adresse postale d'un client sur une ligne
+func adresseClientCalendrier(client *models.Client) string {
+	var parties []string
+	for _, partie := range []string{client.Adresse, client.ComplementAdresse, strings.TrimSpace(client.CodePostal + " " + client.Ville)} {
+		if strings.TrimSpace(partie) != "" {
+			parties = append(parties, strings.TrimSpace(partie))
+		}
+	}
+	return strings.Join(parties, ", ")
+}
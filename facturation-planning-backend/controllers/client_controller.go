@@ -17,8 +17,13 @@ import (
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/clients [get]
 func GetClients(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var clients []models.Client
-	result := config.DB.Find(&clients)
+	result := tenantDB(entrepriseID).Find(&clients)
 
 	if result.Error != nil {
 		http.Error(w, "Erreur serveur", http.StatusInternalServerError)
@@ -41,12 +46,21 @@ func GetClients(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/clients [post]
 func CreateClient(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var client models.Client
 	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
 		http.Error(w, "Requête invalide", http.StatusBadRequest)
 		return
 	}
 
+	// Le client est toujours rattaché à l'entreprise connectée
+	client.ID = 0
+	client.EntrepriseID = entrepriseID
+
 	if err := config.DB.Create(&client).Error; err != nil {
 		http.Error(w, "Erreur serveur", http.StatusInternalServerError)
 		return
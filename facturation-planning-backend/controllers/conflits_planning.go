@@ -0,0 +1,445 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"gorm.io/gorm"
+)
+
+// Types de conflit de planning
+const (
+	ConflitChevauchement = "chevauchement" // deux plannings du salarié se recouvrent
+	ConflitAbsence       = "absence"       // un planning tombe pendant une absence du salarié
+)
+
+// PlanningConflit résume un planning impliqué dans un conflit
+type PlanningConflit struct {
+	ID             uint   `json:"id" example:"12"`
+	SerieID        *uint  `json:"serie_id,omitempty" example:"3"`
+	DateOccurrence string `json:"date_occurrence,omitempty" example:"2025-06-16"`
+	Date           string `json:"date" example:"2025-06-16"`
+	HeureDebut     string `json:"heure_debut" example:"09:00"`
+	HeureFin       string `json:"heure_fin" example:"12:00"`
+	TypeEvenement  string `json:"type_evenement" example:"Intervention"`
+	ClientID       uint   `json:"client_id" example:"1"`
+}
+
+// ConflitPlanning représente un conflit entre deux plannings d'un même salarié.
+// Un conflit bloquant (intervention facturable pendant une absence) est toujours refusé ;
+// les autres sont refusés sauf si la requête force l'enregistrement (forcer=true).
+type ConflitPlanning struct {
+	Type          string          `json:"type" example:"absence" enums:"chevauchement,absence"`
+	Bloquant      bool            `json:"bloquant" example:"true"`
+	SalarieID     uint            `json:"salarie_id" example:"1"`
+	Date          string          `json:"date" example:"2025-06-16"`
+	Planning      PlanningConflit `json:"planning"`
+	EnConflitAvec PlanningConflit `json:"en_conflit_avec"`
+	Message       string          `json:"message" example:"Le salarié est en Congé le 2025-06-16"`
+}
+
+// ConflitsPlanningResponse représente la liste des conflits d'une période
+type ConflitsPlanningResponse struct {
+	DateDebut  string            `json:"date_debut" example:"2025-06-01"`
+	DateFin    string            `json:"date_fin" example:"2025-06-30"`
+	NbConflits int               `json:"nb_conflits" example:"2"`
+	Conflits   []ConflitPlanning `json:"conflits"`
+}
+
+// erreurConflits signale un enregistrement refusé à cause de conflits de planning
+type erreurConflits struct {
+	conflits []ConflitPlanning
+}
+
+func (e *erreurConflits) Error() string {
+	for _, conflit := range e.conflits {
+		if conflit.Bloquant {
+			return "Le planning est en conflit avec une absence du salarié"
+		}
+	}
+	return "Le planning est en conflit avec d'autres plannings du salarié : utilisez forcer=true pour l'enregistrer quand même"
+}
+
+// controleConflits vérifie les conflits des plannings enregistrés par une requête
+// et conserve les conflits acceptés pour les signaler dans la réponse
+type controleConflits struct {
+	forcer         bool
+	avertissements []ConflitPlanning
+}
+
+// nouveauControleConflits lit le paramètre forcer de la requête
+func nouveauControleConflits(r *http.Request) *controleConflits {
+	forcer, _ := strconv.ParseBool(r.URL.Query().Get("forcer"))
+	return &controleConflits{forcer: forcer}
+}
+
+// verifier détecte les conflits des plannings candidats avec les plannings existants de leurs salariés.
+// Les plannings existants retenus par ignorer (ceux que l'enregistrement remplace) ne sont pas comparés.
+func (c *controleConflits) verifier(db *gorm.DB, entrepriseID uint, candidats []models.Planning, ignorer func(*models.Planning) bool) error {
+	conflits, err := detecterConflits(db, entrepriseID, candidats, ignorer)
+	if err != nil {
+		return err
+	}
+	if len(conflits) == 0 {
+		return nil
+	}
+
+	for _, conflit := range conflits {
+		if conflit.Bloquant {
+			return &erreurConflits{conflits: conflits}
+		}
+	}
+	if !c.forcer {
+		return &erreurConflits{conflits: conflits}
+	}
+	c.avertissements = append(c.avertissements, conflits...)
+	return nil
+}
+
+// verifierSerie détecte les conflits des occurrences d'une série, sur deux ans au plus à partir de sa date de début
+func (c *controleConflits) verifierSerie(db *gorm.DB, serie *models.SeriePlanning, ignorer func(*models.Planning) bool) error {
+	debut, err := time.Parse("2006-01-02", serie.DateDebut)
+	if err != nil {
+		return err
+	}
+	fin := debut.AddDate(0, 0, maxJoursPeriodePlannings)
+	if finSerie, err := time.Parse("2006-01-02", serie.DateFin); err == nil && finSerie.Before(fin) {
+		fin = finSerie
+	}
+
+	dates, err := serie.Occurrences(debut, fin)
+	if err != nil {
+		return err
+	}
+	candidats := make([]models.Planning, 0, len(dates))
+	for _, date := range dates {
+		candidats = append(candidats, serie.Occurrence(date))
+	}
+	return c.verifier(db, serie.EntrepriseID, candidats, ignorer)
+}
+
+// signaler indique dans l'en-tête X-Conflits-Planning le nombre de conflits acceptés.
+// Elle doit être appelée avant l'écriture du statut de la réponse.
+func (c *controleConflits) signaler(w http.ResponseWriter) {
+	if len(c.avertissements) > 0 {
+		w.Header().Set("X-Conflits-Planning", strconv.Itoa(len(c.avertissements)))
+	}
+}
+
+// repondreConflits renvoie un 409 avec la liste des conflits, ou un 500 si la vérification a échoué
+func repondreConflits(w http.ResponseWriter, err error) {
+	var refus *erreurConflits
+	if !errors.As(err, &refus) {
+		http.Error(w, "Erreur lors de la vérification des conflits de planning", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  refus.Error(),
+		"conflits": refus.conflits,
+	})
+}
+
+// estConflit indique si une erreur est un refus pour conflits de planning
+func estConflit(err error) bool {
+	var refus *erreurConflits
+	return errors.As(err, &refus)
+}
+
+// GetConflitsPlannings godoc
+// @Summary Conflits de planning d'une période
+// @Description Liste les chevauchements entre plannings d'un même salarié et les plannings placés pendant ses absences (absence, congé, maladie, RTT), occurrences des plannings récurrents comprises. Un conflit est bloquant quand une intervention facturable tombe pendant une absence.
+// @Tags Planning
+// @Produce json
+// @Param date_debut query string true "Début de la période (AAAA-MM-JJ)"
+// @Param date_fin query string true "Fin de la période (AAAA-MM-JJ)"
+// @Param salarie_id query int false "Limiter la recherche à un salarié"
+// @Success 200 {object} ConflitsPlanningResponse
+// @Failure 400 {string} string "Période invalide"
+// @Failure 500 {string} string "Erreur base de données"
+// @Router /plannings/conflits [get]
+func GetConflitsPlannings(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("date_debut") == "" || r.URL.Query().Get("date_fin") == "" {
+		http.Error(w, "date_debut et date_fin sont obligatoires", http.StatusBadRequest)
+		return
+	}
+	debut, fin, err := lirePeriodePlannings(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var salarieIDs []uint
+	if salarieID := r.URL.Query().Get("salarie_id"); salarieID != "" {
+		id, err := strconv.ParseUint(salarieID, 10, 64)
+		if err != nil {
+			http.Error(w, "Salarié invalide", http.StatusBadRequest)
+			return
+		}
+		salarieIDs = []uint{uint(id)}
+	}
+
+	// La veille est chargée pour les interventions qui passent minuit
+	debutT, _ := time.Parse("2006-01-02", debut)
+	plannings, err := planningsSalaries(config.DB, entrepriseID, salarieIDs, debutT.AddDate(0, 0, -1).Format("2006-01-02"), fin)
+	if err != nil {
+		http.Error(w, "Erreur base de données", http.StatusInternalServerError)
+		return
+	}
+
+	parSalarie := make(map[uint][]models.Planning)
+	for _, planning := range plannings {
+		parSalarie[planning.SalarieID] = append(parSalarie[planning.SalarieID], planning)
+	}
+
+	response := ConflitsPlanningResponse{
+		DateDebut: debut,
+		DateFin:   fin,
+		Conflits:  []ConflitPlanning{},
+	}
+	for _, plannings := range parSalarie {
+		plages := plagesOccupees(plannings)
+		for i := range plages {
+			for j := i + 1; j < len(plages) && plages[j].debut.Before(plages[i].fin); j++ {
+				if plages[i].planning.Date < debut && plages[j].planning.Date < debut {
+					continue
+				}
+				if conflit, ok := conflitEntre(plages[i].planning, plages[j].planning); ok {
+					response.Conflits = append(response.Conflits, conflit)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(response.Conflits, func(i, j int) bool {
+		a, b := response.Conflits[i], response.Conflits[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		return a.SalarieID < b.SalarieID
+	})
+	response.NbConflits = len(response.Conflits)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// detecterConflits compare les plannings candidats aux plannings existants de leurs salariés
+func detecterConflits(db *gorm.DB, entrepriseID uint, candidats []models.Planning, ignorer func(*models.Planning) bool) ([]ConflitPlanning, error) {
+	var salarieIDs []uint
+	dejaVu := make(map[uint]bool)
+	debut, fin := "", ""
+	for _, candidat := range candidats {
+		if candidat.SalarieID == 0 || candidat.TypeEvenement == models.TypeAnnulation {
+			continue
+		}
+		if !dejaVu[candidat.SalarieID] {
+			dejaVu[candidat.SalarieID] = true
+			salarieIDs = append(salarieIDs, candidat.SalarieID)
+		}
+		if debut == "" || candidat.Date < debut {
+			debut = candidat.Date
+		}
+		if candidat.Date > fin {
+			fin = candidat.Date
+		}
+	}
+	if len(salarieIDs) == 0 {
+		return nil, nil
+	}
+
+	// La veille et le lendemain sont chargés pour les interventions qui passent minuit
+	debutT, err := time.Parse("2006-01-02", debut)
+	if err != nil {
+		return nil, err
+	}
+	finT, err := time.Parse("2006-01-02", fin)
+	if err != nil {
+		return nil, err
+	}
+	existants, err := planningsSalaries(db, entrepriseID, salarieIDs,
+		debutT.AddDate(0, 0, -1).Format("2006-01-02"), finT.AddDate(0, 0, 1).Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	parJour := make(map[string][]models.Planning)
+	for _, existant := range existants {
+		if ignorer != nil && ignorer(&existant) {
+			continue
+		}
+		cle := fmt.Sprintf("%d/%s", existant.SalarieID, existant.Date)
+		parJour[cle] = append(parJour[cle], existant)
+	}
+
+	var conflits []ConflitPlanning
+	for i := range candidats {
+		candidat := &candidats[i]
+		jour, err := time.Parse("2006-01-02", candidat.Date)
+		if err != nil {
+			continue
+		}
+		for _, decalage := range []int{-1, 0, 1} {
+			cle := fmt.Sprintf("%d/%s", candidat.SalarieID, jour.AddDate(0, 0, decalage).Format("2006-01-02"))
+			for j := range parJour[cle] {
+				existant := &parJour[cle][j]
+				if memePlanning(candidat, existant) {
+					continue
+				}
+				if conflit, ok := conflitEntre(candidat, existant); ok {
+					conflits = append(conflits, conflit)
+				}
+			}
+		}
+	}
+	return conflits, nil
+}
+
+// planningsSalaries charge les plannings enregistrés et les occurrences calculées des salariés entre debut et fin
+// (tous les salariés si salarieIDs est vide)
+func planningsSalaries(db *gorm.DB, entrepriseID uint, salarieIDs []uint, debut, fin string) ([]models.Planning, error) {
+	query := db.Session(&gorm.Session{NewDB: true}).
+		Where("entreprise_id = ? AND salarie_id <> 0 AND date >= ? AND date <= ?", entrepriseID, debut, fin).
+		Where("type_evenement <> ?", models.TypeAnnulation)
+	if len(salarieIDs) > 0 {
+		query = query.Where("salarie_id IN ?", salarieIDs)
+	}
+
+	var plannings []models.Planning
+	if err := query.Order("date, heure_debut, id").Find(&plannings).Error; err != nil {
+		return nil, err
+	}
+
+	occurrences, err := occurrencesVirtuelles(db.Session(&gorm.Session{NewDB: true}), entrepriseID, debut, fin)
+	if err != nil {
+		return nil, err
+	}
+	concernes := make(map[uint]bool, len(salarieIDs))
+	for _, id := range salarieIDs {
+		concernes[id] = true
+	}
+	for _, occurrence := range occurrences {
+		if occurrence.SalarieID == 0 || occurrence.TypeEvenement == models.TypeAnnulation {
+			continue
+		}
+		if len(salarieIDs) == 0 || concernes[occurrence.SalarieID] {
+			plannings = append(plannings, occurrence)
+		}
+	}
+	return plannings, nil
+}
+
+// plageOccupee associe un planning à la période pendant laquelle il occupe le salarié
+type plageOccupee struct {
+	planning   *models.Planning
+	debut, fin time.Time
+}
+
+// plagesOccupees retourne les périodes occupées par les plannings, triées par début
+func plagesOccupees(plannings []models.Planning) []plageOccupee {
+	plages := make([]plageOccupee, 0, len(plannings))
+	for i := range plannings {
+		if debut, fin, ok := periodeOccupee(&plannings[i]); ok {
+			plages = append(plages, plageOccupee{planning: &plannings[i], debut: debut, fin: fin})
+		}
+	}
+	sort.SliceStable(plages, func(i, j int) bool {
+		return plages[i].debut.Before(plages[j].debut)
+	})
+	return plages
+}
+
+// periodeOccupee retourne la période pendant laquelle un planning occupe le salarié :
+// une absence occupe toute la journée, quels que soient ses horaires
+func periodeOccupee(planning *models.Planning) (time.Time, time.Time, bool) {
+	if models.EstAbsence(planning.TypeEvenement) {
+		jour, err := time.Parse("2006-01-02", planning.Date)
+		return jour, jour.AddDate(0, 0, 1), err == nil
+	}
+	debut, fin, err := planning.Intervalle()
+	return debut, fin, err == nil
+}
+
+// conflitEntre indique si deux plannings d'un même salarié sont en conflit
+func conflitEntre(a, b *models.Planning) (ConflitPlanning, bool) {
+	if a.SalarieID == 0 || a.SalarieID != b.SalarieID {
+		return ConflitPlanning{}, false
+	}
+	if a.TypeEvenement == models.TypeAnnulation || b.TypeEvenement == models.TypeAnnulation {
+		return ConflitPlanning{}, false
+	}
+	absenceA, absenceB := models.EstAbsence(a.TypeEvenement), models.EstAbsence(b.TypeEvenement)
+	if absenceA && absenceB {
+		return ConflitPlanning{}, false
+	}
+
+	debutA, finA, okA := periodeOccupee(a)
+	debutB, finB, okB := periodeOccupee(b)
+	if !okA || !okB || !debutA.Before(finB) || !debutB.Before(finA) {
+		return ConflitPlanning{}, false
+	}
+
+	conflit := ConflitPlanning{
+		Type:          ConflitChevauchement,
+		SalarieID:     a.SalarieID,
+		Date:          a.Date,
+		Planning:      resumerPlanning(a),
+		EnConflitAvec: resumerPlanning(b),
+	}
+	switch {
+	case absenceA || absenceB:
+		absence, autre := a, b
+		if absenceB {
+			absence, autre = b, a
+		}
+		conflit.Type = ConflitAbsence
+		conflit.Bloquant = typeFacturable(autre.TypeEvenement)
+		conflit.Message = fmt.Sprintf("Le salarié est en %s le %s", absence.TypeEvenement, absence.Date)
+	default:
+		conflit.Message = fmt.Sprintf("Chevauchement avec %s le %s de %s à %s", b.TypeEvenement, b.Date, b.HeureDebut, b.HeureFin)
+	}
+	return conflit, true
+}
+
+// memePlanning indique si deux plannings désignent le même planning ou la même occurrence de série
+func memePlanning(a, b *models.Planning) bool {
+	if a.ID != 0 && a.ID == b.ID {
+		return true
+	}
+	return a.SerieID != nil && b.SerieID != nil && *a.SerieID != 0 &&
+		*a.SerieID == *b.SerieID && a.DateOccurrence == b.DateOccurrence
+}
+
+// resumerPlanning retourne le résumé d'un planning impliqué dans un conflit
+func resumerPlanning(planning *models.Planning) PlanningConflit {
+	resume := PlanningConflit{
+		ID:             planning.ID,
+		DateOccurrence: planning.DateOccurrence,
+		Date:           planning.Date,
+		HeureDebut:     planning.HeureDebut,
+		HeureFin:       planning.HeureFin,
+		TypeEvenement:  planning.TypeEvenement,
+		ClientID:       planning.ClientID,
+	}
+	// Les occurrences d'une série en cours de création n'ont pas encore de série
+	if planning.SerieID != nil && *planning.SerieID != 0 {
+		resume.SerieID = planning.SerieID
+	} else {
+		resume.DateOccurrence = ""
+	}
+	return resume
+}
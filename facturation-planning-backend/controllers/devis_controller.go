@@ -1,15 +1,19 @@
 package controllers
 
 import (
-	"bytes"
 	"encoding/json"
 	"facturation-planning/config"
 	"facturation-planning/models"
+	"facturation-planning/utils"
 	"fmt"
-	"html/template"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // CreateDevis godoc
@@ -21,9 +25,15 @@ import (
 // @Param devis body models.Devis true "Données du devis à créer"
 // @Success 201 {object} models.Devis "Devis créé avec succès"
 // @Failure 400 {string} string "Erreur de validation des données"
+// @Failure 409 {string} string "Un devis est créé au statut brouillon"
 // @Failure 500 {string} string "Erreur interne du serveur"
 // @Router devis [post]
 func CreateDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var devis models.Devis
 
 	if err := json.NewDecoder(r.Body).Decode(&devis); err != nil {
@@ -31,18 +41,48 @@ func CreateDevis(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Le devis est toujours rattaché à l'entreprise connectée
+	devis.ID = 0
+	devis.EntrepriseID = entrepriseID
+
 	// Validation des données du devis
 	if err := validateDevisData(&devis); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Définir le statut par défaut si non spécifié
-	if devis.Statut == "" {
-		devis.Statut = "brouillon"
+	// Un devis est toujours créé brouillon : il est ensuite envoyé par PATCH /devis/{id}/statut, par email ou par lien d'acceptation
+	if devis.Statut != "" && devis.Statut != models.StatutDevisBrouillon {
+		http.Error(w, "Un devis est créé au statut brouillon", http.StatusConflict)
+		return
 	}
+	devis.Statut = models.StatutDevisBrouillon
+
+	// Conditions et durée de validité par défaut du profil de l'entreprise
+	appliquerDefautsDevis(&devis, devisConfig(entrepriseDocument(entrepriseID)))
+	calculateTotals(&devis)
 
-	if err := config.DB.Create(&devis).Error; err != nil {
+	// La référence est attribuée par la séquence de numérotation de l'entreprise
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		reference, err := prochainNumero(tx, entrepriseID, models.TypeDocumentDevis, time.Now())
+		if err != nil {
+			return err
+		}
+		devis.Reference = reference
+		devis.Revision = 1
+		devis.RevisionAcceptee = 0
+		if err := tx.Create(&devis).Error; err != nil {
+			return err
+		}
+
+		// Première révision (DEV0012-v1), figée dès que le devis est proposé au client
+		revision := devis.NouvelleRevision(1, devis.Reference)
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		return appliquerStatutRevision(tx, devis, devis.Statut)
+	})
+	if err != nil {
 		http.Error(w, "Erreur lors de la création du devis", http.StatusInternalServerError)
 		return
 	}
@@ -92,9 +132,14 @@ func convertToResponseSlice(devis []models.Devis) []DevisResponse {
 }
 
 func GetAllDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var devis []models.Devis
 
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").Find(&devis).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").Find(&devis).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération des devis", http.StatusInternalServerError)
 		return
 	}
@@ -113,8 +158,13 @@ func GetAllDevis(w http.ResponseWriter, r *http.Request) {
 
 // TestDevisJSON - Endpoint de test pour débugger le JSON
 func TestDevisJSON(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var devis []models.Devis
-	config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").Find(&devis)
+	tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").Find(&devis)
 
 	// Créer un devis test simple avec ID explicite
 	testDevis := models.Devis{
@@ -137,10 +187,13 @@ type LigneDevis struct {
 	Designation  string
 	Unite        string
 	Quantite     float64
-	PrixUnitaire float64
-	MontantHT    float64
+	PrixUnitaire models.Montant
+	MontantHT    models.Montant
 	TVA          float64
-	MontantTTC   float64
+	MontantTTC   models.Montant
+
+	RemisePourcentage float64
+	RemiseMontant     models.Montant
 }
 
 type DevisPDFData struct {
@@ -156,11 +209,20 @@ type DevisPDFData struct {
 	LieuSignature   string
 	DateSignature   string
 	Lignes          []LigneDevis
-	SousTotalHT     float64
-	TotalTVA        float64
-	TotalTTC        float64
+	SousTotalHT     models.Montant
+	TotalTVA        models.Montant
+	TotalTTC        models.Montant
+	VentilationTVA  []models.VentilationTVA
+	MentionTVA      string
+	ClientNumeroTVA string
 	Objet           string
 	Company         config.CompanyInfo
+
+	RemisePourcentage float64
+
+	// Signature électronique du client recueillie par le lien d'acceptation en ligne
+	SignatureClient        []byte
+	LegendeSignatureClient []string
 }
 
 // GenerateDevisPDF godoc
@@ -174,6 +236,11 @@ type DevisPDFData struct {
 // @Failure 500 {string} string "Erreur lors de la génération du PDF"
 // @Router devis/{id}/pdf [get]
 func GenerateDevisPDF(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	// Validation de l'ID
@@ -183,97 +250,21 @@ func GenerateDevisPDF(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var devis models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
 		http.Error(w, "Devis introuvable", http.StatusNotFound)
 		return
 	}
 
-	// Transformation pour le template avec calculs
-	var lignes []LigneDevis
-	var sousTotalHT, totalTVA, totalTTC float64
-
-	for _, l := range devis.Lignes {
-		montantHT := float64(l.Quantite) * l.PrixUnitaire
-		montantTVA := montantHT * (l.TVA / 100)
-		montantTTC := montantHT + montantTVA
-
-		lignes = append(lignes, LigneDevis{
-			Designation:  l.Description,
-			Unite:        "U", // Unité par défaut, peut être modifiée
-			Quantite:     float64(l.Quantite),
-			PrixUnitaire: l.PrixUnitaire,
-			MontantHT:    montantHT,
-			TVA:          l.TVA,
-			MontantTTC:   montantTTC,
-		})
-
-		sousTotalHT += montantHT
-		totalTVA += montantTVA
-		totalTTC += montantTTC
-	}
-
-	// Formatage des dates
-	dateEdition := devis.DateDevis.Format("02 janvier 2006")
-	dateExpiration := devis.DateExpiration.Format("02 janvier 2006")
-
-	// Génération de l'objet du devis
-	objet := devis.Objet
-	if objet == "" {
-		objet = fmt.Sprintf("Devis pour %s", devis.Client.GetDisplayName())
-	}
-
-	// Informations de l'entreprise
-	company := config.GetCompanyInfo()
-	devisConfig := config.GetDevisConfig()
-
-	data := DevisPDFData{
-		Reference:       fmt.Sprintf("%s%04d", devisConfig.NumberingPrefix, devis.ID),
-		Ville:           devisConfig.DefaultCity,
-		DateEdition:     dateEdition,
-		DateExpiration:  dateExpiration,
-		ClientNom:       devis.Client.GetDisplayName(),
-		ClientAdresse:   devis.Client.Adresse,
-		ClientEmail:     devis.Client.Email,
-		ClientTelephone: devis.Client.Telephone,
-		Conditions:      devis.Conditions,
-		LieuSignature:   devis.LieuSignature,
-		DateSignature:   devis.DateSignature,
-		Lignes:          lignes,
-		SousTotalHT:     sousTotalHT,
-		TotalTVA:        totalTVA,
-		TotalTTC:        totalTTC,
-		Objet:           objet,
-		Company:         company,
-	}
-
-	// Template FuncMap avec fonctions utiles
-	funcMap := template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-		"formatPrice": func(price float64) string {
-			return fmt.Sprintf("%.2f €", price)
-		},
-		"formatPercent": func(percent float64) string {
-			return fmt.Sprintf("%.1f%%", percent)
-		},
-	}
-
-	tmpl, err := template.New("devis_improved.html").Funcs(funcMap).ParseFiles("templates/devis_improved.html")
+	pdf, err := genererDevisPDF(devis)
 	if err != nil {
-		http.Error(w, "Erreur chargement template", http.StatusInternalServerError)
-		return
-	}
-
-	var htmlBuffer bytes.Buffer
-	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-		http.Error(w, "Erreur exécution template", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// TEMPORAIRE: Retourner du HTML au lieu du PDF car wkhtmltopdf n'est pas disponible
-	filename := fmt.Sprintf("devis_%s.html", data.Reference)
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	filename := fmt.Sprintf("devis_%s.pdf", referenceRevisionDevis(devis))
+	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%s", filename))
-	w.Write(htmlBuffer.Bytes())
+	w.Write(pdf)
 }
 
 // DownloadDevisPDF godoc
@@ -287,6 +278,11 @@ func GenerateDevisPDF(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la génération du PDF"
 // @Router devis/{id}/download [get]
 func DownloadDevisPDF(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	// Validation de l'ID
@@ -296,94 +292,21 @@ func DownloadDevisPDF(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var devis models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
 		http.Error(w, "Devis introuvable", http.StatusNotFound)
 		return
 	}
 
-	// Réutiliser la même logique que GenerateDevisPDF
-	var lignes []LigneDevis
-	var sousTotalHT, totalTVA, totalTTC float64
-
-	for _, l := range devis.Lignes {
-		montantHT := float64(l.Quantite) * l.PrixUnitaire
-		montantTVA := montantHT * (l.TVA / 100)
-		montantTTC := montantHT + montantTVA
-
-		lignes = append(lignes, LigneDevis{
-			Designation:  l.Description,
-			Unite:        "U",
-			Quantite:     float64(l.Quantite),
-			PrixUnitaire: l.PrixUnitaire,
-			MontantHT:    montantHT,
-			TVA:          l.TVA,
-			MontantTTC:   montantTTC,
-		})
-
-		sousTotalHT += montantHT
-		totalTVA += montantTVA
-		totalTTC += montantTTC
-	}
-
-	dateEdition := devis.DateDevis.Format("02 janvier 2006")
-	dateExpiration := devis.DateExpiration.Format("02 janvier 2006")
-
-	objet := devis.Objet
-	if objet == "" {
-		objet = fmt.Sprintf("Devis pour %s", devis.Client.GetDisplayName())
-	}
-
-	// Informations de l'entreprise
-	company := config.GetCompanyInfo()
-	devisConfig := config.GetDevisConfig()
-
-	data := DevisPDFData{
-		Reference:       fmt.Sprintf("%s%04d", devisConfig.NumberingPrefix, devis.ID),
-		Ville:           devisConfig.DefaultCity,
-		DateEdition:     dateEdition,
-		DateExpiration:  dateExpiration,
-		ClientNom:       devis.Client.GetDisplayName(),
-		ClientAdresse:   devis.Client.Adresse,
-		ClientEmail:     devis.Client.Email,
-		ClientTelephone: devis.Client.Telephone,
-		Conditions:      devis.Conditions,
-		LieuSignature:   devis.LieuSignature,
-		DateSignature:   devis.DateSignature,
-		Lignes:          lignes,
-		SousTotalHT:     sousTotalHT,
-		TotalTVA:        totalTVA,
-		TotalTTC:        totalTTC,
-		Objet:           objet,
-		Company:         company,
-	}
-
-	funcMap := template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-		"formatPrice": func(price float64) string {
-			return fmt.Sprintf("%.2f €", price)
-		},
-		"formatPercent": func(percent float64) string {
-			return fmt.Sprintf("%.1f%%", percent)
-		},
-	}
-
-	tmpl, err := template.New("devis_improved.html").Funcs(funcMap).ParseFiles("templates/devis_improved.html")
+	pdf, err := genererDevisPDF(devis)
 	if err != nil {
-		http.Error(w, "Erreur chargement template", http.StatusInternalServerError)
-		return
-	}
-
-	var htmlBuffer bytes.Buffer
-	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-		http.Error(w, "Erreur exécution template", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// TEMPORAIRE: Retourner du HTML au lieu du PDF car wkhtmltopdf n'est pas disponible
-	filename := fmt.Sprintf("devis_%s.html", data.Reference)
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	filename := fmt.Sprintf("devis_%s.pdf", referenceRevisionDevis(devis))
+	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	w.Write(htmlBuffer.Bytes())
+	w.Write(pdf)
 }
 
 // GetDevis godoc
@@ -396,6 +319,11 @@ func DownloadDevisPDF(w http.ResponseWriter, r *http.Request) {
 // @Failure 404 {string} string "Devis introuvable"
 // @Router devis/{id} [get]
 func GetDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	// Validation de l'ID
@@ -405,7 +333,7 @@ func GetDevis(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var devis models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
 		http.Error(w, "Devis introuvable", http.StatusNotFound)
 		return
 	}
@@ -422,7 +350,7 @@ func GetDevis(w http.ResponseWriter, r *http.Request) {
 
 // UpdateDevis godoc
 // @Summary Mettre à jour un devis existant
-// @Description Met à jour un devis existant avec validation des données
+// @Description Met à jour un devis existant avec validation des données. Un devis déjà proposé au client (envoyé, refusé ou expiré) passe à la révision suivante (DEV0012-v2…) et redevient brouillon : les révisions précédentes, leurs lignes et leurs totaux restent consultables. Un devis accepté ne peut plus être modifié.
 // @Tags Devis
 // @Accept json
 // @Produce json
@@ -430,9 +358,16 @@ func GetDevis(w http.ResponseWriter, r *http.Request) {
 // @Param devis body models.Devis true "Nouvelles données du devis"
 // @Success 200 {object} models.Devis "Devis mis à jour avec succès"
 // @Failure 400 {string} string "Erreur de validation des données"
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Devis accepté"
 // @Failure 500 {string} string "Erreur lors de la mise à jour"
 // @Router devis/{id} [put]
 func UpdateDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	// Validation de l'ID
@@ -448,19 +383,38 @@ func UpdateDevis(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Vérifier que le devis appartient à l'entreprise connectée
+	var existing models.Devis
+	if err := tenantDB(entrepriseID).First(&existing, id).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	devis.ID = existing.ID
+	devis.EntrepriseID = entrepriseID
+	devis.Reference = existing.Reference
+
 	// Validation des données du devis
 	if err := validateDevisData(&devis); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := config.DB.Model(&devis).Where("id = ?", id).Updates(devis).Error; err != nil {
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return reviserDevis(tx, existing, devis, acteurEntreprise(r, entrepriseID))
+	})
+	switch {
+	case err == errDevisAccepte:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
 		http.Error(w, "Erreur lors de la mise à jour du devis", http.StatusInternalServerError)
 		return
 	}
 
 	// Récupérer le devis mis à jour avec les relations
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
+	devis = models.Devis{}
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération du devis", http.StatusInternalServerError)
 		return
 	}
@@ -481,6 +435,11 @@ func UpdateDevis(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la suppression"
 // @Router devis/{id} [delete]
 func DeleteDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	// Validation de l'ID
@@ -489,11 +448,17 @@ func DeleteDevis(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := config.DB.Delete(&models.Devis{}, id).Error; err != nil {
+	result := tenantDB(entrepriseID).Delete(&models.Devis{}, id)
+	if result.Error != nil {
 		http.Error(w, "Erreur lors de la suppression du devis", http.StatusInternalServerError)
 		return
 	}
 
+	if result.RowsAffected == 0 {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -508,17 +473,28 @@ func DeleteDevis(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la récupération"
 // @Router entreprises/{id}/devis [get]
 func GetDevisByEntreprise(w http.ResponseWriter, r *http.Request) {
-	entrepriseID := chi.URLParam(r, "id")
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	paramID := chi.URLParam(r, "id")
 
 	// Vérifier que l'ID n'est pas vide
-	if entrepriseID == "" {
+	if paramID == "" {
 		http.Error(w, "ID entreprise manquant", http.StatusBadRequest)
 		return
 	}
 
+	// Une entreprise ne peut consulter que ses propres devis
+	if paramID != fmt.Sprint(entrepriseID) {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
 	var devis []models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").
-		Where("entreprise_id = ?", entrepriseID).Find(&devis).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").
+		Find(&devis).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -540,9 +516,15 @@ func GetDevisByEntreprise(w http.ResponseWriter, r *http.Request) {
 // @Param id path string true "ID du client"
 // @Success 200 {array} models.Devis "Liste des devis du client"
 // @Failure 400 {string} string "ID client manquant"
+// @Failure 404 {string} string "Client introuvable"
 // @Failure 500 {string} string "Erreur lors de la récupération"
 // @Router clients/{id}/devis [get]
 func GetDevisByClient(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	clientID := chi.URLParam(r, "id")
 
 	// Vérifier que l'ID n'est pas vide
@@ -550,9 +532,13 @@ func GetDevisByClient(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "ID client manquant", http.StatusBadRequest)
 		return
 	}
+	if id, err := strconv.ParseUint(clientID, 10, 64); err != nil || !clientAppartientA(entrepriseID, uint(id)) {
+		http.Error(w, "Client introuvable", http.StatusNotFound)
+		return
+	}
 
 	var devis []models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").
 		Where("client_id = ?", clientID).Find(&devis).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -569,17 +555,24 @@ func GetDevisByClient(w http.ResponseWriter, r *http.Request) {
 
 // UpdateDevisStatut godoc
 // @Summary Mettre à jour le statut d'un devis
-// @Description Met à jour uniquement le statut d'un devis (brouillon, envoyé, accepté, refusé, expiré)
+// @Description Met à jour uniquement le statut d'un devis (brouillon, envoyé, accepté, refusé, expiré). Un statut autre que brouillon fige la révision courante ; seule la dernière révision peut être acceptée.
 // @Tags Devis
 // @Accept json
 // @Produce json
 // @Param id path string true "ID du devis"
-// @Param statut body object{statut=string} true "Nouveau statut du devis"
+// @Param statut body object{statut=string,revision=int,motif=string} true "Nouveau statut du devis (revision : numéro de la révision acceptée, facultatif ; motif : facultatif, conservé dans l'historique)"
 // @Success 200 {object} models.Devis "Devis avec statut mis à jour"
 // @Failure 400 {string} string "Statut invalide"
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Transition de statut interdite ou révision qui n'est pas la dernière"
 // @Failure 500 {string} string "Erreur lors de la mise à jour du statut"
 // @Router devis/{id}/statut [patch]
 func UpdateDevisStatut(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	// Validation de l'ID
@@ -589,7 +582,9 @@ func UpdateDevisStatut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var requestData struct {
-		Statut string `json:"statut"`
+		Statut   string `json:"statut"`
+		Revision int    `json:"revision"`
+		Motif    string `json:"motif"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
@@ -598,7 +593,7 @@ func UpdateDevisStatut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Valider le statut
-	validStatuts := []string{"brouillon", "envoyé", "accepté", "refusé", "expiré"}
+	validStatuts := []string{models.StatutDevisBrouillon, models.StatutDevisEnvoye, models.StatutDevisAccepte, models.StatutDevisRefuse, models.StatutDevisExpire}
 	statutValide := false
 	for _, s := range validStatuts {
 		if s == requestData.Statut {
@@ -612,15 +607,38 @@ func UpdateDevisStatut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Mettre à jour le statut
-	if err := config.DB.Model(&models.Devis{}).Where("id = ?", id).Update("statut", requestData.Statut).Error; err != nil {
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).First(&devis, id).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	// Seule la dernière révision peut être acceptée
+	if requestData.Statut == models.StatutDevisAccepte && requestData.Revision != 0 && requestData.Revision != devis.Revision {
+		http.Error(w, fmt.Sprintf("Seule la dernière révision (%s) peut être acceptée", referenceRevisionDevis(devis)), http.StatusConflict)
+		return
+	}
+
+	// Mettre à jour le statut selon les transitions autorisées
+	acteur := acteurEntreprise(r, entrepriseID)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&devis, devis.ID).Error; err != nil {
+			return err
+		}
+		return changerStatutDevis(tx, devis, requestData.Statut, acteur, strings.TrimSpace(requestData.Motif), nil)
+	})
+	switch {
+	case err == errTransitionStatutInterdite:
+		http.Error(w, messageTransitionInterdite(devis.Statut, requestData.Statut), http.StatusConflict)
+		return
+	case err != nil:
 		http.Error(w, "Erreur lors de la mise à jour du statut", http.StatusInternalServerError)
 		return
 	}
 
 	// Récupérer le devis mis à jour
-	var devis models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
+	devis = models.Devis{}
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération du devis", http.StatusInternalServerError)
 		return
 	}
@@ -632,24 +650,179 @@ func UpdateDevisStatut(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(devis)
 }
 
-// calculateTotals calcule les totaux pour un devis
-// Fonction utilitaire interne pour calculer automatiquement les montants HT, TVA et TTC
-func calculateTotals(devis *models.Devis) {
-	var sousTotalHT, totalTVA, totalTTC float64
+// prepareDevisPDFData prépare les données du template d'un devis (lignes, entreprise et client préchargés)
+func prepareDevisPDFData(devis models.Devis) DevisPDFData {
+	// Transformation pour le template avec calculs
+	var lignes []LigneDevis
+
+	for _, l := range devis.Lignes {
+		montantHT := l.MontantHT()
+		montantTTC := montantHT + montantHT.Pourcentage(l.TVA)
+
+		lignes = append(lignes, LigneDevis{
+			Designation:  l.Description,
+			Unite:        l.Unite,
+			Quantite:     l.Quantite,
+			PrixUnitaire: l.PrixUnitaire,
+			MontantHT:    montantHT,
+			TVA:          l.TVA,
+			MontantTTC:   montantTTC,
+
+			RemisePourcentage: l.RemisePourcentage,
+			RemiseMontant:     l.RemiseMontant,
+		})
+	}
+	calculateTotals(&devis)
 
-	for _, ligne := range devis.Lignes {
-		montantHT := float64(ligne.Quantite) * ligne.PrixUnitaire
-		montantTVA := montantHT * (ligne.TVA / 100)
-		montantTTC := montantHT + montantTVA
+	// Génération de l'objet du devis
+	objet := devis.Objet
+	if objet == "" {
+		objet = fmt.Sprintf("Devis pour %s", devis.Client.GetDisplayName())
+	}
 
-		sousTotalHT += montantHT
-		totalTVA += montantTVA
-		totalTTC += montantTTC
+	entreprise := entrepriseDocument(devis.EntrepriseID)
+
+	data := DevisPDFData{
+		Reference:       referenceRevisionDevis(devis),
+		Ville:           devisConfig(entreprise).DefaultCity,
+		DateEdition:     devis.DateDevis.Format("02 janvier 2006"),
+		DateExpiration:  devis.DateExpiration.Format("02 janvier 2006"),
+		ClientNom:       devis.Client.GetDisplayName(),
+		ClientAdresse:   devis.Client.Adresse,
+		ClientEmail:     devis.Client.Email,
+		ClientTelephone: devis.Client.Telephone,
+		Conditions:      devis.Conditions,
+		LieuSignature:   devis.LieuSignature,
+		DateSignature:   devis.DateSignature,
+		Lignes:          lignes,
+		SousTotalHT:     devis.SousTotalHT,
+		TotalTVA:        devis.TotalTVA,
+		TotalTTC:        devis.TotalTTC,
+		VentilationTVA:  devis.VentilationTVA,
+		MentionTVA:      devis.MentionTVA,
+		ClientNumeroTVA: devis.Client.NumeroTVA,
+		Objet:           objet,
+		Company:         companyInfo(entreprise),
+
+		RemisePourcentage: devis.RemisePourcentage,
+	}
+
+	// Signature électronique, reportée uniquement sur la révision acceptée
+	if devis.RevisionAcceptee != 0 && devis.RevisionAcceptee == devis.Revision {
+		if acceptation, ok := acceptationSigneeDevis(devis.ID, devis.Revision); ok {
+			data.SignatureClient = acceptation.Signature
+			data.LegendeSignatureClient = legendeSignatureDevis(acceptation)
+		}
 	}
+	return data
+}
 
-	devis.SousTotalHT = sousTotalHT
-	devis.TotalTVA = totalTVA
-	devis.TotalTTC = totalTTC
+// genererDevisPDF produit le PDF d'un devis (lignes, entreprise et client préchargés)
+func genererDevisPDF(devis models.Devis) ([]byte, error) {
+	doc := documentPDFDevis(prepareDevisPDFData(devis))
+	doc.DateCreation = devis.DateDevis
+	return utils.GenererDocumentPDF(doc)
+}
+
+// documentPDFDevis met en page un devis
+func documentPDFDevis(data DevisPDFData) utils.DocumentPDF {
+	mentions := []string{fmt.Sprintf("%s, le %s", data.Ville, data.DateEdition)}
+	infos := []string{"Date d'édition : " + data.DateEdition}
+	if data.DateExpiration != "" {
+		mentions = append(mentions, "Valide jusqu'au "+data.DateExpiration)
+		infos = append(infos, "Date d'expiration : "+data.DateExpiration)
+	}
+	infos = append(infos, "Référence : "+data.Reference)
+
+	var lignes []utils.LigneDocumentPDF
+	for _, l := range data.Lignes {
+		lignes = append(lignes, utils.LigneDocumentPDF{
+			Designation:  l.Designation,
+			Unite:        l.Unite,
+			Quantite:     l.Quantite,
+			PrixUnitaire: l.PrixUnitaire,
+			TauxTVA:      l.TVA,
+			MontantHT:    l.MontantHT,
+
+			RemisePourcentage: l.RemisePourcentage,
+			RemiseMontant:     l.RemiseMontant,
+		})
+	}
+
+	lieu, date := data.LieuSignature, data.DateSignature
+	if lieu == "" {
+		lieu = "_________________"
+	}
+	if date == "" {
+		date = "_________________"
+	}
+
+	doc := utils.DocumentPDF{
+		Titre:        utils.TitrePDFDevis,
+		Reference:    data.Reference,
+		Mentions:     mentions,
+		Emetteur:     emetteurPDF(data.Company),
+		Logo:         data.Company.Logo,
+		Client:       blocClientPDF(data.ClientNom, data.ClientAdresse, data.ClientEmail, data.ClientTelephone),
+		Informations: utils.BlocPDF{Titre: "Informations devis", Lignes: infos},
+		Lignes:       lignes,
+		Totaux:       totauxPDF(data.SousTotalHT, data.VentilationTVA, data.TotalTVA, data.TotalTTC, data.RemisePourcentage),
+		TVA:          ventilationPDF(data.VentilationTVA),
+		MentionTVA:   data.MentionTVA,
+		Signatures: &utils.SignaturesPDF{
+			Mention: fmt.Sprintf("Fait à %s, le %s", lieu, date),
+			Gauche:  "Signature de l'entreprise :",
+			Droite:  "Signature du client :",
+
+			ImageDroite:   data.SignatureClient,
+			LegendeDroite: data.LegendeSignatureClient,
+		},
+		PiedDePage: piedDePagePDF(data.Company),
+	}
+	if data.ClientNumeroTVA != "" {
+		doc.Client.Lignes = append(doc.Client.Lignes, "N° TVA : "+data.ClientNumeroTVA)
+	}
+	if data.Objet != "" {
+		doc.Objet = utils.BlocPDF{Titre: "Objet du devis", Lignes: []string{data.Objet}}
+	}
+	if data.Conditions != "" {
+		doc.Conditions = utils.BlocPDF{Titre: "Conditions particulières", Lignes: []string{data.Conditions}}
+	}
+	return doc
+}
+
+// referenceDevis retourne la référence du devis, ou l'ancienne référence dérivée de l'ID pour les devis non numérotés
+func referenceDevis(devis models.Devis) string {
+	if devis.Reference != "" {
+		return devis.Reference
+	}
+	entreprise := devis.Entreprise
+	if entreprise.ID == 0 {
+		entreprise = entrepriseDocument(devis.EntrepriseID)
+	}
+	return fmt.Sprintf("%s%04d", devisConfig(entreprise).NumberingPrefix, devis.ID)
+}
+
+// appliquerDefautsDevis complète un nouveau devis avec les valeurs par défaut de l'entreprise
+func appliquerDefautsDevis(devis *models.Devis, cfg config.DevisConfig) {
+	if devis.DateDevis.IsZero() {
+		devis.DateDevis = time.Now()
+	}
+	if devis.DateExpiration.IsZero() {
+		devis.DateExpiration = devis.DateDevis.AddDate(0, 0, cfg.ValidityDays)
+	}
+	if strings.TrimSpace(devis.Conditions) == "" {
+		devis.Conditions = cfg.DefaultConditions
+	}
+	if devis.MotifExonerationTVA == "" {
+		devis.MotifExonerationTVA = motifExonerationParDefaut(cfg, tauxLignesDevis(devis.Lignes))
+	}
+}
+
+// calculateTotals calcule les totaux pour un devis
+// Fonction utilitaire interne pour calculer automatiquement les montants HT, TVA (par taux) et TTC
+func calculateTotals(devis *models.Devis) {
+	devis.CalculerTotaux()
 }
 
 // validateDevisData valide les données d'un devis
@@ -671,5 +844,23 @@ func validateDevisData(devis *models.Devis) error {
 		return fmt.Errorf("le client n'appartient pas à cette entreprise")
 	}
 
-	return nil
+	if devis.MotifExonerationTVA == models.ExonerationTVAIntracommunautaire && client.NumeroTVA == "" {
+		return errNumeroTVAClientManquant
+	}
+
+	// Taux de TVA en vigueur et cohérence avec le motif d'exonération
+	if err := verifierTVA(tauxLignesDevis(devis.Lignes), devis.MotifExonerationTVA); err != nil {
+		return err
+	}
+
+	// Unités standard, quantités et remises
+	if err := verifierRemisesDevis(devis); err != nil {
+		return err
+	}
+
+	// Les totaux sont recalculés par le serveur : ceux transmis avec les lignes doivent y correspondre
+	if devis.Lignes == nil {
+		return nil
+	}
+	return verifierTotauxTransmis(devis.SousTotalHT, devis.TotalTVA, devis.TotalTTC, devis.Ventilation(), len(devis.Lignes))
 }
@@ -0,0 +1,562 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errDevisAccepte             = errors.New("Un devis accepté ne peut plus être modifié")
+	errDevisNonAccepte          = errors.New("Seul un devis accepté peut être facturé")
+	errRevisionDevisIntrouvable = errors.New("Révision introuvable")
+)
+
+// Statuts de ligne d'une comparaison de révisions
+const (
+	DiffLigneAjoutee   = "ajoutée"
+	DiffLigneSupprimee = "supprimée"
+	DiffLigneModifiee  = "modifiée"
+	DiffLigneInchangee = "inchangée"
+)
+
+// DiffChampDevis représente un champ du devis modifié entre deux révisions
+type DiffChampDevis struct {
+	Champ string `json:"champ" example:"objet"`
+	Avant string `json:"avant" example:"Site vitrine"`
+	Apres string `json:"apres" example:"Site vitrine et boutique"`
+}
+
+// DiffLigneDevis représente l'évolution d'une ligne entre deux révisions
+type DiffLigneDevis struct {
+	Statut string                     `json:"statut" example:"modifiée"` // ajoutée, supprimée, modifiée, inchangée
+	Champs []string                   `json:"champs,omitempty"`          // Champs modifiés (description, quantite, unite, prix_unitaire, tva, remise)
+	Avant  *models.LigneRevisionDevis `json:"avant,omitempty"`
+	Apres  *models.LigneRevisionDevis `json:"apres,omitempty"`
+}
+
+// DiffMontantDevis représente l'évolution d'un total entre deux révisions
+type DiffMontantDevis struct {
+	Avant models.Montant `json:"avant" example:"1500"`
+	Apres models.Montant `json:"apres" example:"1800"`
+	Ecart models.Montant `json:"ecart" example:"300"`
+}
+
+// DiffRevisionsDevisResponse représente les différences entre deux révisions d'un devis
+type DiffRevisionsDevisResponse struct {
+	DevisID     uint             `json:"devis_id" example:"12"`
+	De          string           `json:"de" example:"DEV0012-v1"`
+	A           string           `json:"a" example:"DEV0012-v2"`
+	Champs      []DiffChampDevis `json:"champs"`
+	Lignes      []DiffLigneDevis `json:"lignes"`
+	SousTotalHT DiffMontantDevis `json:"sous_total_ht"`
+	TotalTVA    DiffMontantDevis `json:"total_tva"`
+	TotalTTC    DiffMontantDevis `json:"total_ttc"`
+}
+
+// GetRevisionsDevis godoc
+// @Summary Révisions d'un devis
+// @Description Retourne les révisions du devis (DEV0012-v1, DEV0012-v2…) avec leurs lignes et totaux figés, de la plus ancienne à la plus récente
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Success 200 {array} models.RevisionDevis
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 500 {string} string "Erreur lors de la récupération des révisions"
+// @Router /devis/{id}/revisions [get]
+func GetRevisionsDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).First(&devis, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	var revisions []models.RevisionDevis
+	if err := config.DB.Preload("Lignes", ordreLignesRevision).
+		Where("devis_id = ?", devis.ID).Order("numero").Find(&revisions).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des révisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// GetRevisionDevis godoc
+// @Summary Révision d'un devis
+// @Description Retourne une révision du devis avec ses lignes et totaux figés
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Param numero path int true "Numéro de la révision"
+// @Success 200 {object} models.RevisionDevis
+// @Failure 404 {string} string "Révision introuvable"
+// @Router /devis/{id}/revisions/{numero} [get]
+func GetRevisionDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	_, revision, err := chargerRevisionDevis(entrepriseID, chi.URLParam(r, "id"), chi.URLParam(r, "numero"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revision)
+}
+
+// GenerateRevisionDevisPDF godoc
+// @Summary PDF d'une révision de devis
+// @Description Génère le PDF d'une révision telle qu'elle a été proposée au client
+// @Tags Devis
+// @Produce application/pdf
+// @Param id path string true "ID du devis"
+// @Param numero path int true "Numéro de la révision"
+// @Success 200 {file} file "Fichier PDF de la révision"
+// @Failure 404 {string} string "Révision introuvable"
+// @Failure 500 {string} string "Erreur lors de la génération du PDF"
+// @Router /devis/{id}/revisions/{numero}/pdf [get]
+func GenerateRevisionDevisPDF(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	devis, revision, err := chargerRevisionDevis(entrepriseID, chi.URLParam(r, "id"), chi.URLParam(r, "numero"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var client models.Client
+	tenantDB(entrepriseID).Limit(1).Find(&client, revision.ClientID)
+	devisRevision := devisDepuisRevision(devis, revision)
+	devisRevision.Client = client
+
+	pdf, err := genererDevisPDF(devisRevision)
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du PDF", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"devis_%s.pdf\"", revision.Reference))
+	w.Write(pdf)
+}
+
+// GetDiffRevisionsDevis godoc
+// @Summary Comparer deux révisions d'un devis
+// @Description Liste les champs, les lignes et les totaux qui diffèrent entre deux révisions. Par défaut, compare la dernière révision à la précédente.
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Param de query int false "Numéro de la révision de départ (révision précédant a par défaut)"
+// @Param a query int false "Numéro de la révision d'arrivée (dernière révision par défaut)"
+// @Success 200 {object} DiffRevisionsDevisResponse
+// @Failure 400 {string} string "Numéro de révision invalide"
+// @Failure 404 {string} string "Révision introuvable"
+// @Router /devis/{id}/revisions/diff [get]
+func GetDiffRevisionsDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).First(&devis, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	a := devis.Revision
+	if valeur := r.URL.Query().Get("a"); valeur != "" {
+		numero, err := strconv.Atoi(valeur)
+		if err != nil {
+			http.Error(w, "Numéro de révision invalide", http.StatusBadRequest)
+			return
+		}
+		a = numero
+	}
+	de := a - 1
+	if valeur := r.URL.Query().Get("de"); valeur != "" {
+		numero, err := strconv.Atoi(valeur)
+		if err != nil {
+			http.Error(w, "Numéro de révision invalide", http.StatusBadRequest)
+			return
+		}
+		de = numero
+	}
+
+	var revisions [2]models.RevisionDevis
+	for i, numero := range []int{de, a} {
+		err := config.DB.Preload("Lignes", ordreLignesRevision).
+			Where("devis_id = ? AND numero = ?", devis.ID, numero).First(&revisions[i]).Error
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Révision v%d introuvable", numero), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparerRevisionsDevis(revisions[0], revisions[1]))
+}
+
+// ordreLignesRevision trie les lignes d'une révision dans l'ordre du devis
+func ordreLignesRevision(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
+
+// chargerRevisionDevis charge un devis de l'entreprise et l'une de ses révisions
+func chargerRevisionDevis(entrepriseID uint, devisID, numero string) (models.Devis, models.RevisionDevis, error) {
+	var devis models.Devis
+	var revision models.RevisionDevis
+	if err := tenantDB(entrepriseID).Preload("Entreprise").First(&devis, devisID).Error; err != nil {
+		return devis, revision, errors.New("Devis introuvable")
+	}
+
+	n, err := strconv.Atoi(numero)
+	if err != nil {
+		return devis, revision, errRevisionDevisIntrouvable
+	}
+	if err := config.DB.Preload("Lignes", ordreLignesRevision).
+		Where("devis_id = ? AND numero = ?", devis.ID, n).First(&revision).Error; err != nil {
+		return devis, revision, errRevisionDevisIntrouvable
+	}
+	return devis, revision, nil
+}
+
+// devisDepuisRevision reconstitue le devis tel qu'il était à une révision, pour la génération du PDF.
+// La signature n'est reprise que sur la révision acceptée.
+func devisDepuisRevision(devis models.Devis, revision models.RevisionDevis) models.Devis {
+	devis.Revision = revision.Numero
+	devis.ClientID = revision.ClientID
+	devis.DateDevis = revision.DateDevis
+	devis.DateExpiration = revision.DateExpiration
+	devis.Objet = revision.Objet
+	devis.Conditions = revision.Conditions
+	devis.MotifExonerationTVA = revision.MotifExonerationTVA
+	devis.RemisePourcentage, devis.RemiseMontant = revision.RemisePourcentage, revision.RemiseMontant
+	if revision.Numero != devis.RevisionAcceptee {
+		devis.LieuSignature, devis.DateSignature = "", ""
+	}
+
+	devis.Lignes = make([]models.LigneDevis, 0, len(revision.Lignes))
+	for _, ligne := range revision.Lignes {
+		devis.Lignes = append(devis.Lignes, models.LigneDevis{
+			Description:  ligne.Description,
+			Quantite:     ligne.Quantite,
+			Unite:        ligne.Unite,
+			PrixUnitaire: ligne.PrixUnitaire,
+			TVA:          ligne.TVA,
+
+			RemisePourcentage: ligne.RemisePourcentage,
+			RemiseMontant:     ligne.RemiseMontant,
+		})
+	}
+	return devis
+}
+
+// referenceRevisionDevis retourne la référence de la révision courante du devis (DEV0012-v2)
+func referenceRevisionDevis(devis models.Devis) string {
+	numero := devis.Revision
+	if numero < 1 {
+		numero = 1
+	}
+	return models.ReferenceRevision(referenceDevis(devis), numero)
+}
+
+// revisionCouranteDevis retourne la révision correspondant au contenu actuel du devis
+func revisionCouranteDevis(tx *gorm.DB, devis models.Devis) (models.RevisionDevis, error) {
+	var revision models.RevisionDevis
+	err := tx.Where("devis_id = ? AND numero = ?", devis.ID, devis.Revision).First(&revision).Error
+	return revision, err
+}
+
+// reviserDevis enregistre les modifications d'un devis dans une transaction.
+// Tant que la révision courante d'un devis brouillon n'a pas été proposée au client, elle est modifiée ;
+// sinon le devis passe à la révision suivante et redevient brouillon, les révisions précédentes restant figées.
+func reviserDevis(tx *gorm.DB, existant models.Devis, modifie models.Devis, acteur acteurTransition) error {
+	if existant.Statut == models.StatutDevisAccepte {
+		return errDevisAccepte
+	}
+
+	courante, err := revisionCouranteDevis(tx, existant)
+	if err != nil {
+		return err
+	}
+	nouvelleRevision := courante.EnvoyeeLe != nil || existant.Statut != models.StatutDevisBrouillon
+
+	// Le statut, les révisions et les totaux ne sont pas modifiables par le corps de la requête
+	lignes := modifie.Lignes
+	modifie.Statut = ""
+	modifie.Revision = 0
+	modifie.RevisionAcceptee = 0
+	if err := tx.Model(&models.Devis{ID: existant.ID}).Omit(clause.Associations, "sous_total_ht", "total_tva", "total_ttc").Updates(modifie).Error; err != nil {
+		return err
+	}
+	// Updates ignore les valeurs vides : le retour à un devis soumis à TVA ou sans remise est enregistré explicitement
+	if err := tx.Model(&models.Devis{ID: existant.ID}).Updates(map[string]interface{}{
+		"motif_exoneration_tva": modifie.MotifExonerationTVA,
+		"remise_pourcentage":    modifie.RemisePourcentage,
+		"remise_montant":        modifie.RemiseMontant,
+	}).Error; err != nil {
+		return err
+	}
+
+	if lignes != nil {
+		if err := tx.Where("devis_id = ?", existant.ID).Delete(&models.LigneDevis{}).Error; err != nil {
+			return err
+		}
+		for i := range lignes {
+			lignes[i].ID = 0
+			lignes[i].DevisID = existant.ID
+		}
+		if len(lignes) > 0 {
+			if err := tx.Create(&lignes).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	numero := courante.Numero
+	if nouvelleRevision {
+		numero++
+		err := tx.Model(&models.Devis{}).Where("id = ?", existant.ID).Updates(map[string]interface{}{
+			"revision":          numero,
+			"revision_acceptee": 0,
+			"statut":            models.StatutDevisBrouillon,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if existant.Statut != models.StatutDevisBrouillon {
+			motif := fmt.Sprintf("Révision %s", models.ReferenceRevision(referenceDevis(existant), numero))
+			if err := enregistrerTransition(tx, models.TypeDocumentDevis, existant.EntrepriseID, existant.ID,
+				existant.Statut, models.StatutDevisBrouillon, acteur, motif); err != nil {
+				return err
+			}
+		}
+	}
+
+	var devis models.Devis
+	if err := tx.Preload("Lignes", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&devis, existant.ID).Error; err != nil {
+		return err
+	}
+
+	// Totaux recalculés à partir des lignes enregistrées
+	devis.CalculerTotaux()
+	if err := enregistrerTotauxDevis(tx, devis); err != nil {
+		return err
+	}
+	revision := devis.NouvelleRevision(numero, referenceDevis(devis))
+
+	if nouvelleRevision {
+		return tx.Create(&revision).Error
+	}
+
+	// Révision encore en préparation : son contenu est remplacé
+	if err := tx.Where("revision_devis_id = ?", courante.ID).Delete(&models.LigneRevisionDevis{}).Error; err != nil {
+		return err
+	}
+	revision.ID = courante.ID
+	revision.CreatedAt = courante.CreatedAt
+	return tx.Save(&revision).Error
+}
+
+// enregistrerTotauxDevis enregistre les totaux calculés du devis, y compris nuls
+func enregistrerTotauxDevis(tx *gorm.DB, devis models.Devis) error {
+	return tx.Model(&models.Devis{}).Where("id = ?", devis.ID).Updates(map[string]interface{}{
+		"sous_total_ht": devis.SousTotalHT,
+		"total_tva":     devis.TotalTVA,
+		"total_ttc":     devis.TotalTTC,
+	}).Error
+}
+
+// marquerRevisionEnvoyee fige la révision courante du devis, proposée au client
+func marquerRevisionEnvoyee(tx *gorm.DB, devis models.Devis) error {
+	return tx.Model(&models.RevisionDevis{}).
+		Where("devis_id = ? AND numero = ? AND envoyee_le IS NULL", devis.ID, devis.Revision).
+		Update("envoyee_le", time.Now()).Error
+}
+
+// appliquerStatutRevision reporte le nouveau statut du devis sur ses révisions : une révision qui n'est plus
+// brouillon est figée, et seule la révision courante peut être acceptée
+func appliquerStatutRevision(tx *gorm.DB, devis models.Devis, statut string) error {
+	if statut != models.StatutDevisBrouillon {
+		if err := marquerRevisionEnvoyee(tx, devis); err != nil {
+			return err
+		}
+	}
+
+	if statut == models.StatutDevisAccepte {
+		if err := tx.Model(&models.RevisionDevis{}).
+			Where("devis_id = ? AND numero = ?", devis.ID, devis.Revision).
+			Update("acceptee_le", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Devis{}).Where("id = ?", devis.ID).Update("revision_acceptee", devis.Revision).Error
+	}
+
+	if devis.RevisionAcceptee == 0 {
+		return nil
+	}
+	if err := tx.Model(&models.RevisionDevis{}).
+		Where("devis_id = ? AND numero = ?", devis.ID, devis.RevisionAcceptee).
+		Update("acceptee_le", nil).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Devis{}).Where("id = ?", devis.ID).Update("revision_acceptee", 0).Error
+}
+
+// revisionAccepteeDevis charge la révision acceptée d'un devis, à partir de laquelle il est facturé
+func revisionAccepteeDevis(tx *gorm.DB, devis models.Devis) (models.RevisionDevis, error) {
+	var revision models.RevisionDevis
+	if devis.Statut != models.StatutDevisAccepte || devis.RevisionAcceptee == 0 {
+		return revision, errDevisNonAccepte
+	}
+	err := tx.Preload("Lignes", ordreLignesRevision).
+		Where("devis_id = ? AND numero = ?", devis.ID, devis.RevisionAcceptee).First(&revision).Error
+	return revision, err
+}
+
+// comparerRevisionsDevis calcule les différences entre deux révisions d'un devis.
+// Les lignes sont rapprochées par désignation, puis par position pour les lignes dont la désignation a changé.
+func comparerRevisionsDevis(de, a models.RevisionDevis) DiffRevisionsDevisResponse {
+	diff := DiffRevisionsDevisResponse{
+		DevisID:     a.DevisID,
+		De:          de.Reference,
+		A:           a.Reference,
+		Champs:      []DiffChampDevis{},
+		Lignes:      []DiffLigneDevis{},
+		SousTotalHT: diffMontant(de.SousTotalHT, a.SousTotalHT),
+		TotalTVA:    diffMontant(de.TotalTVA, a.TotalTVA),
+		TotalTTC:    diffMontant(de.TotalTTC, a.TotalTTC),
+	}
+
+	formatDate := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+	champs := []DiffChampDevis{
+		{"client_id", strconv.FormatUint(uint64(de.ClientID), 10), strconv.FormatUint(uint64(a.ClientID), 10)},
+		{"date_devis", formatDate(de.DateDevis), formatDate(a.DateDevis)},
+		{"date_expiration", formatDate(de.DateExpiration), formatDate(a.DateExpiration)},
+		{"objet", de.Objet, a.Objet},
+		{"conditions", de.Conditions, a.Conditions},
+		{"motif_exoneration_tva", de.MotifExonerationTVA, a.MotifExonerationTVA},
+		{"remise_pourcentage", formatRemise(de.RemisePourcentage), formatRemise(a.RemisePourcentage)},
+		{"remise_montant", de.RemiseMontant.String(), a.RemiseMontant.String()},
+	}
+	for _, champ := range champs {
+		if champ.Avant != champ.Apres {
+			diff.Champs = append(diff.Champs, champ)
+		}
+	}
+
+	// Rapprochement des lignes : même désignation, puis même position
+	apparieeAvant := make([]int, len(a.Lignes))
+	utilisee := make([]bool, len(de.Lignes))
+	for i := range apparieeAvant {
+		apparieeAvant[i] = -1
+	}
+	for i, ligne := range a.Lignes {
+		cle := strings.ToLower(strings.TrimSpace(ligne.Description))
+		for j, avant := range de.Lignes {
+			if !utilisee[j] && strings.ToLower(strings.TrimSpace(avant.Description)) == cle {
+				apparieeAvant[i], utilisee[j] = j, true
+				break
+			}
+		}
+	}
+	for i, ligne := range a.Lignes {
+		if apparieeAvant[i] >= 0 {
+			continue
+		}
+		for j, avant := range de.Lignes {
+			if !utilisee[j] && avant.Position == ligne.Position {
+				apparieeAvant[i], utilisee[j] = j, true
+				break
+			}
+		}
+	}
+
+	for i := range a.Lignes {
+		apres := &a.Lignes[i]
+		if apparieeAvant[i] < 0 {
+			diff.Lignes = append(diff.Lignes, DiffLigneDevis{Statut: DiffLigneAjoutee, Apres: apres})
+			continue
+		}
+		avant := &de.Lignes[apparieeAvant[i]]
+		ligne := DiffLigneDevis{Statut: DiffLigneInchangee, Avant: avant, Apres: apres, Champs: champsLigneModifies(*avant, *apres)}
+		if len(ligne.Champs) > 0 {
+			ligne.Statut = DiffLigneModifiee
+		}
+		diff.Lignes = append(diff.Lignes, ligne)
+	}
+	for j := range de.Lignes {
+		if !utilisee[j] {
+			diff.Lignes = append(diff.Lignes, DiffLigneDevis{Statut: DiffLigneSupprimee, Avant: &de.Lignes[j]})
+		}
+	}
+
+	return diff
+}
+
+// champsLigneModifies retourne les champs qui diffèrent entre deux versions d'une ligne
+func champsLigneModifies(avant, apres models.LigneRevisionDevis) []string {
+	var champs []string
+	if avant.Description != apres.Description {
+		champs = append(champs, "description")
+	}
+	if avant.Quantite != apres.Quantite {
+		champs = append(champs, "quantite")
+	}
+	if avant.Unite != apres.Unite {
+		champs = append(champs, "unite")
+	}
+	if avant.PrixUnitaire != apres.PrixUnitaire {
+		champs = append(champs, "prix_unitaire")
+	}
+	if math.Abs(avant.TVA-apres.TVA) > 0.0001 {
+		champs = append(champs, "tva")
+	}
+	if avant.RemisePourcentage != apres.RemisePourcentage || avant.RemiseMontant != apres.RemiseMontant {
+		champs = append(champs, "remise")
+	}
+	return champs
+}
+
+// formatRemise affiche un pourcentage de remise pour le différentiel (vide sans remise)
+func formatRemise(pourcentage float64) string {
+	if pourcentage == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(pourcentage, 'f', -1, 64)
+}
+
+// diffMontant calcule l'écart entre deux totaux
+func diffMontant(avant, apres models.Montant) DiffMontantDevis {
+	return DiffMontantDevis{Avant: avant, Apres: apres, Ecart: apres - avant}
+}
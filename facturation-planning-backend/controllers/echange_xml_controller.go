@@ -0,0 +1,365 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"facturation-planning/config"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// Taille maximale d'un document XML importé
+const tailleMaxImportXML = 5 << 20
+
+var errFactureFournisseurDejaImportee = errors.New("Cette facture fournisseur a déjà été importée")
+
+// ExportFactureXML godoc
+// @Summary Exporter une facture en XML structuré
+// @Description Sérialise une facture émise au format UBL 2.1 (Invoice) ou UN/CEFACT CII, profil EN 16931
+// @Tags Factures
+// @Produce application/xml
+// @Param id path string true "ID de la facture"
+// @Param format query string false "Syntaxe XML : ubl (par défaut) ou cii"
+// @Success 200 {file} file "Document XML"
+// @Failure 400 {string} string "Format inconnu"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "La facture est un brouillon"
+// @Failure 422 {string} string "Informations obligatoires manquantes (SIRET, TVA...)"
+// @Router /factures/{id}/xml [get]
+func ExportFactureXML(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	format, ok := formatXMLDemande(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	if !facture.EstScellee() {
+		http.Error(w, errFactureBrouillon.Error(), http.StatusConflict)
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	contenu, err := utils.GenererXML(utils.DocumentDepuisFacture(facture, entreprise), format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	repondreXML(w, fmt.Sprintf("facture_%s_%s.xml", facture.Reference, format), contenu)
+}
+
+// ExportAvoirXML godoc
+// @Summary Exporter un avoir en XML structuré
+// @Description Sérialise un avoir au format UBL 2.1 (CreditNote) ou UN/CEFACT CII (type 381), avec la référence de la facture d'origine
+// @Tags Avoirs
+// @Produce application/xml
+// @Param id path string true "ID de l'avoir"
+// @Param format query string false "Syntaxe XML : ubl (par défaut) ou cii"
+// @Success 200 {file} file "Document XML"
+// @Failure 400 {string} string "Format inconnu"
+// @Failure 404 {string} string "Avoir introuvable"
+// @Failure 422 {string} string "Informations obligatoires manquantes (SIRET, TVA...)"
+// @Router /avoirs/{id}/xml [get]
+func ExportAvoirXML(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	format, ok := formatXMLDemande(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var avoir models.Avoir
+	if err := tenantDB(entrepriseID).Preload("Lignes").First(&avoir, id).Error; err != nil {
+		http.Error(w, "Avoir introuvable", http.StatusNotFound)
+		return
+	}
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Client").First(&facture, avoir.FactureID).Error; err != nil {
+		http.Error(w, "Facture d'origine introuvable", http.StatusNotFound)
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	contenu, err := utils.GenererXML(utils.DocumentDepuisAvoir(avoir, facture, entreprise), format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	repondreXML(w, fmt.Sprintf("avoir_%s_%s.xml", avoir.Reference, format), contenu)
+}
+
+// ImportFactureFournisseur godoc
+// @Summary Importer une facture fournisseur XML
+// @Description Crée une facture fournisseur à partir d'un document UBL 2.1 (Invoice/CreditNote) ou CII envoyé dans le corps de la requête. La syntaxe est détectée automatiquement et les totaux sont contrôlés.
+// @Tags Factures fournisseurs
+// @Accept application/xml
+// @Produce json
+// @Param document body string true "Document XML UBL ou CII"
+// @Success 201 {object} models.FactureFournisseur "Facture fournisseur importée"
+// @Failure 400 {string} string "Document illisible"
+// @Failure 409 {string} string "Facture déjà importée"
+// @Failure 422 {string} string "Document incohérent ou adressé à une autre entreprise"
+// @Router /factures-fournisseurs/import [post]
+func ImportFactureFournisseur(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	contenu, err := io.ReadAll(http.MaxBytesReader(w, r.Body, tailleMaxImportXML))
+	if err != nil {
+		http.Error(w, "Document trop volumineux ou illisible", http.StatusBadRequest)
+		return
+	}
+
+	importe, err := utils.LireDocumentXML(contenu)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	if err := verifierDestinataire(importe.Document.Acheteur, entreprise); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	facture := factureFournisseurDepuisImport(entrepriseID, importe, string(contenu))
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var existantes int64
+		if err := tx.Model(&models.FactureFournisseur{}).
+			Where("entreprise_id = ? AND fournisseur_nom = ? AND reference = ? AND type_document = ?",
+				entrepriseID, facture.FournisseurNom, facture.Reference, facture.TypeDocument).
+			Count(&existantes).Error; err != nil {
+			return err
+		}
+		if existantes > 0 {
+			return errFactureFournisseurDejaImportee
+		}
+
+		return tx.Create(&facture).Error
+	})
+
+	switch {
+	case err == errFactureFournisseurDejaImportee:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Erreur lors de l'enregistrement de la facture fournisseur : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(facture)
+}
+
+// GetFacturesFournisseurs godoc
+// @Summary Lister les factures fournisseurs
+// @Description Récupère les factures fournisseurs importées, de la plus récente à la plus ancienne
+// @Tags Factures fournisseurs
+// @Produce json
+// @Success 200 {array} models.FactureFournisseur
+// @Router /factures-fournisseurs [get]
+func GetFacturesFournisseurs(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var factures []models.FactureFournisseur
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("TVA").
+		Order("date_emission DESC, id DESC").Find(&factures).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des factures fournisseurs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(factures)
+}
+
+// GetFactureFournisseur godoc
+// @Summary Récupérer une facture fournisseur
+// @Tags Factures fournisseurs
+// @Produce json
+// @Param id path string true "ID de la facture fournisseur"
+// @Success 200 {object} models.FactureFournisseur
+// @Failure 404 {string} string "Facture fournisseur introuvable"
+// @Router /factures-fournisseurs/{id} [get]
+func GetFactureFournisseur(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.FactureFournisseur
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("TVA").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture fournisseur introuvable", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facture)
+}
+
+// GetFactureFournisseurXML godoc
+// @Summary Télécharger le XML d'origine d'une facture fournisseur
+// @Tags Factures fournisseurs
+// @Produce application/xml
+// @Param id path string true "ID de la facture fournisseur"
+// @Success 200 {file} file "Document XML tel que reçu"
+// @Failure 404 {string} string "Facture fournisseur introuvable"
+// @Router /factures-fournisseurs/{id}/xml [get]
+func GetFactureFournisseurXML(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.FactureFournisseur
+	if err := tenantDB(entrepriseID).First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture fournisseur introuvable", http.StatusNotFound)
+		return
+	}
+
+	repondreXML(w, fmt.Sprintf("fournisseur_%s.xml", facture.Reference), []byte(facture.XMLSource))
+}
+
+// formatXMLDemande lit le paramètre ?format= (ubl par défaut)
+func formatXMLDemande(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = utils.FormatUBL
+	}
+	if format != utils.FormatUBL && format != utils.FormatCII {
+		http.Error(w, "Format inconnu : ubl ou cii attendu", http.StatusBadRequest)
+		return "", false
+	}
+	return format, true
+}
+
+func repondreXML(w http.ResponseWriter, filename string, contenu []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	w.Write(contenu)
+}
+
+// verifierDestinataire refuse un document dont l'acheteur est identifié comme une autre entreprise
+func verifierDestinataire(acheteur utils.PartieFacturation, entreprise models.Entreprise) error {
+	normaliser := func(s string) string { return strings.ToUpper(strings.ReplaceAll(s, " ", "")) }
+
+	if acheteur.SIRET != "" && entreprise.SIRET != "" && normaliser(acheteur.SIRET) != normaliser(entreprise.SIRET) {
+		return fmt.Errorf("la facture est adressée au SIRET %s, qui n'est pas celui de votre entreprise", acheteur.SIRET)
+	}
+	if acheteur.NumeroTVA != "" && entreprise.TVA != "" && normaliser(acheteur.NumeroTVA) != normaliser(entreprise.TVA) {
+		return fmt.Errorf("la facture est adressée au numéro de TVA %s, qui n'est pas celui de votre entreprise", acheteur.NumeroTVA)
+	}
+	return nil
+}
+
+// factureFournisseurDepuisImport convertit un document importé en facture fournisseur
+func factureFournisseurDepuisImport(entrepriseID uint, importe utils.DocumentImporte, xmlSource string) models.FactureFournisseur {
+	doc := importe.Document
+	vendeur := doc.Vendeur
+
+	adresse := vendeur.Adresse
+	if vendeur.CodePostal != "" || vendeur.Ville != "" {
+		adresse = strings.TrimPrefix(fmt.Sprintf("%s, %s %s", adresse, vendeur.CodePostal, vendeur.Ville), ", ")
+	}
+
+	facture := models.FactureFournisseur{
+		EntrepriseID:       entrepriseID,
+		Reference:          doc.Numero,
+		TypeDocument:       doc.CodeType,
+		Format:             importe.Format,
+		FournisseurNom:     vendeur.Nom,
+		FournisseurSIRET:   vendeur.SIRET,
+		FournisseurTVA:     vendeur.NumeroTVA,
+		FournisseurAdresse: strings.TrimSpace(adresse),
+		FournisseurIBAN:    vendeur.IBAN,
+		DateEmission:       doc.DateEmission,
+		Devise:             doc.Devise,
+		Note:               doc.Note,
+		FactureOrigine:     doc.FactureOrigine,
+		SousTotalHT:        importe.TotalHT,
+		TotalTVA:           importe.TotalTVA,
+		TotalTTC:           importe.TotalTTC,
+		MontantPrepaye:     doc.MontantPrepaye,
+		NetAPayer:          importe.NetAPayer,
+		XMLSource:          xmlSource,
+	}
+	if !doc.DateEcheance.IsZero() {
+		echeance := doc.DateEcheance
+		facture.DateEcheance = &echeance
+	}
+
+	for _, l := range doc.Lignes {
+		facture.Lignes = append(facture.Lignes, models.LigneFactureFournisseur{
+			Description:      l.Description,
+			Unite:            l.CodeUnite,
+			Quantite:         l.Quantite,
+			PrixUnitaire:     l.PrixUnitaire,
+			MontantHT:        l.MontantHT,
+			TauxTVA:          l.TauxTVA,
+			CategorieTVA:     l.CategorieTVA,
+			MotifExoneration: l.MotifExoneration,
+		})
+	}
+	for _, v := range importe.Ventilation {
+		facture.TVA = append(facture.TVA, models.TVAFactureFournisseur{
+			CategorieTVA:     v.CategorieTVA,
+			TauxTVA:          v.TauxTVA,
+			BaseHT:           v.BaseHT,
+			MontantTVA:       v.MontantTVA,
+			MotifExoneration: v.MotifExoneration,
+		})
+	}
+
+	return facture
+}
@@ -0,0 +1,482 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"text/template"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// EnvoiDocumentRequest représente la demande d'envoi d'un document par email.
+// Tous les champs sont optionnels : par défaut, le document part à l'email du client
+// avec le sujet et le message des modèles de l'entreprise.
+type EnvoiDocumentRequest struct {
+	Destinataires []string `json:"destinataires" example:"client@example.com"`
+	Copies        []string `json:"copies" example:"compta@example.com"`
+	Sujet         string   `json:"sujet" example:"Votre facture"`
+	Message       string   `json:"message"`
+}
+
+// ModeleEmailData contient les variables disponibles dans les modèles de sujet et de corps
+type ModeleEmailData struct {
+	Reference      string
+	ClientNom      string
+	Montant        string
+	DateEcheance   string
+	DateExpiration string
+	Entreprise     string
+}
+
+// GetParametresEmail godoc
+// @Summary Paramètres d'envoi d'emails
+// @Description Retourne les paramètres SMTP, l'expéditeur et les modèles de message de l'entreprise (le mot de passe n'est jamais renvoyé)
+// @Tags Emails
+// @Produce json
+// @Success 200 {object} models.ParametresEmail
+// @Router /parametres/email [get]
+func GetParametresEmail(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	parametres := parametresEmailEffectifs(entrepriseID)
+	parametres.SMTPPassword = ""
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parametres)
+}
+
+// UpdateParametresEmail godoc
+// @Summary Modifier les paramètres d'envoi d'emails
+// @Description Enregistre le serveur SMTP, l'expéditeur et les modèles de message de l'entreprise. Un mot de passe vide conserve le mot de passe actuel.
+// @Tags Emails
+// @Accept json
+// @Produce json
+// @Param parametres body models.ParametresEmail true "Paramètres d'envoi"
+// @Success 200 {object} models.ParametresEmail
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 500 {string} string "Erreur lors de l'enregistrement"
+// @Router /parametres/email [put]
+func UpdateParametresEmail(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var parametres models.ParametresEmail
+	if err := json.NewDecoder(r.Body).Decode(&parametres); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateParametresEmail(&parametres); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var existing models.ParametresEmail
+	config.DB.Where("entreprise_id = ?", entrepriseID).Limit(1).Find(&existing)
+
+	parametres.ID = existing.ID
+	parametres.EntrepriseID = entrepriseID
+	if parametres.SMTPPassword == "" {
+		parametres.SMTPPassword = existing.SMTPPassword
+	}
+
+	if err := config.DB.Save(&parametres).Error; err != nil {
+		http.Error(w, "Erreur lors de l'enregistrement des paramètres email", http.StatusInternalServerError)
+		return
+	}
+
+	parametres.SMTPPassword = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(parametres)
+}
+
+// SendFacture godoc
+// @Summary Envoyer une facture par email
+// @Description Envoie la facture émise en pièce jointe PDF et trace l'envoi dans le journal
+// @Tags Emails
+// @Accept json
+// @Produce json
+// @Param id path string true "ID de la facture"
+// @Param envoi body EnvoiDocumentRequest false "Destinataires, sujet et message (optionnels)"
+// @Success 200 {object} models.EnvoiEmail "Envoi réussi"
+// @Failure 400 {string} string "Destinataire manquant ou invalide"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "Facture brouillon"
+// @Failure 502 {object} models.EnvoiEmail "Échec de l'envoi SMTP"
+// @Router /factures/{id}/send [post]
+func SendFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	req, err := decodeEnvoiDocumentRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	// Seule une facture émise (numérotée et scellée) peut être envoyée
+	if !facture.EstScellee() {
+		http.Error(w, errFactureBrouillon.Error(), http.StatusConflict)
+		return
+	}
+
+	if len(req.Destinataires) == 0 && facture.ClientEmail != "" {
+		req.Destinataires = []string{facture.ClientEmail}
+	}
+	if len(req.Destinataires) == 0 {
+		http.Error(w, "Aucun destinataire : le client n'a pas d'email", http.StatusBadRequest)
+		return
+	}
+
+	pdf, err := genererFacturePDF(facture)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	parametres := parametresEmailEffectifs(entrepriseID)
+	data := ModeleEmailData{
+		Reference:    facture.Reference,
+		ClientNom:    facture.ClientNom,
+		Montant:      fmt.Sprintf("%s €", facture.TotalTTC),
+		DateEcheance: facture.DateEcheance.Format("02/01/2006"),
+		Entreprise:   nomEntreprise(entrepriseID),
+	}
+
+	envoi, err := envoyerDocument(parametres, req, models.TypeDocumentFacture, facture.ID, facture.Reference,
+		parametres.SujetFacture, parametres.CorpsFacture, data,
+		utils.PieceJointe{Nom: fmt.Sprintf("facture_%s.pdf", facture.Reference), ContentType: "application/pdf", Contenu: pdf})
+
+	repondreEnvoi(w, envoi, err)
+}
+
+// SendDevis godoc
+// @Summary Envoyer un devis par email
+// @Description Envoie le devis en pièce jointe PDF, trace l'envoi dans le journal, passe le devis brouillon au statut "envoyé" et fige sa révision courante
+// @Tags Emails
+// @Accept json
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Param envoi body EnvoiDocumentRequest false "Destinataires, sujet et message (optionnels)"
+// @Success 200 {object} models.EnvoiEmail "Envoi réussi"
+// @Failure 400 {string} string "Destinataire manquant ou invalide"
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 502 {object} models.EnvoiEmail "Échec de l'envoi SMTP"
+// @Router /devis/{id}/send [post]
+func SendDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	req, err := decodeEnvoiDocumentRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).Preload("Lignes").Preload("Entreprise").Preload("Client").First(&devis, id).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	if len(req.Destinataires) == 0 && devis.Client.Email != "" {
+		req.Destinataires = []string{devis.Client.Email}
+	}
+	if len(req.Destinataires) == 0 {
+		http.Error(w, "Aucun destinataire : le client n'a pas d'email", http.StatusBadRequest)
+		return
+	}
+
+	pdf, err := genererDevisPDF(devis)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	calculateTotals(&devis)
+	reference := referenceRevisionDevis(devis)
+
+	parametres := parametresEmailEffectifs(entrepriseID)
+	data := ModeleEmailData{
+		Reference:      reference,
+		ClientNom:      devis.Client.GetDisplayName(),
+		Montant:        fmt.Sprintf("%s €", devis.TotalTTC),
+		DateExpiration: devis.DateExpiration.Format("02/01/2006"),
+		Entreprise:     nomEntreprise(entrepriseID),
+	}
+
+	envoi, err := envoyerDocument(parametres, req, models.TypeDocumentDevis, devis.ID, reference,
+		parametres.SujetDevis, parametres.CorpsDevis, data,
+		utils.PieceJointe{Nom: fmt.Sprintf("devis_%s.pdf", reference), ContentType: "application/pdf", Contenu: pdf})
+
+	// Un devis brouillon envoyé au client passe au statut "envoyé" ; la révision envoyée est figée
+	if err == nil && envoi.Statut == models.StatutEnvoiEnvoye {
+		acteur := acteurEntreprise(r, entrepriseID)
+		config.DB.Transaction(func(tx *gorm.DB) error {
+			if err := marquerRevisionEnvoyee(tx, devis); err != nil {
+				return err
+			}
+			if devis.Statut != models.StatutDevisBrouillon {
+				return nil
+			}
+			return changerStatutDevis(tx, devis, models.StatutDevisEnvoye, acteur, "Envoi par email à "+envoi.Destinataires, nil)
+		})
+	}
+
+	repondreEnvoi(w, envoi, err)
+}
+
+// GetEnvoisEmail godoc
+// @Summary Journal des envois d'emails
+// @Description Retourne les envois d'emails de l'entreprise, éventuellement filtrés par document
+// @Tags Emails
+// @Produce json
+// @Param type_document query string false "Type de document" Enums(facture, devis)
+// @Param document_id query int false "ID du document"
+// @Success 200 {array} models.EnvoiEmail
+// @Failure 500 {string} string "Erreur lors de la récupération du journal"
+// @Router /emails/envois [get]
+func GetEnvoisEmail(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	query := tenantDB(entrepriseID)
+	if typeDocument := r.URL.Query().Get("type_document"); typeDocument != "" {
+		query = query.Where("type_document = ?", typeDocument)
+	}
+	if documentID := r.URL.Query().Get("document_id"); documentID != "" {
+		query = query.Where("document_id = ?", documentID)
+	}
+
+	var envois []models.EnvoiEmail
+	if err := query.Order("created_at DESC").Find(&envois).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération du journal des envois", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envois)
+}
+
+// envoyerDocument construit et envoie l'email d'un document, puis trace l'envoi (réussi ou non) dans le journal
+func envoyerDocument(parametres models.ParametresEmail, req EnvoiDocumentRequest, typeDocument string, documentID uint,
+	reference, sujetModele, corpsModele string, data ModeleEmailData, pj utils.PieceJointe) (models.EnvoiEmail, error) {
+
+	sujet := req.Sujet
+	if sujet == "" {
+		s, err := appliquerModeleEmail(sujetModele, data)
+		if err != nil {
+			return models.EnvoiEmail{}, err
+		}
+		sujet = s
+	}
+
+	corps := req.Message
+	if corps == "" {
+		c, err := appliquerModeleEmail(corpsModele, data)
+		if err != nil {
+			return models.EnvoiEmail{}, err
+		}
+		corps = c
+	}
+
+	copies := req.Copies
+	if parametres.CopieA != "" {
+		copies = append(copies, parametres.CopieA)
+	}
+
+	envoi := models.EnvoiEmail{
+		EntrepriseID:  parametres.EntrepriseID,
+		TypeDocument:  typeDocument,
+		DocumentID:    documentID,
+		Reference:     reference,
+		Destinataires: strings.Join(req.Destinataires, ", "),
+		Copies:        strings.Join(copies, ", "),
+		Sujet:         sujet,
+		PieceJointe:   pj.Nom,
+		Statut:        models.StatutEnvoiEnvoye,
+	}
+
+	err := utils.EnvoyerEmail(utils.SMTPConfig{
+		Host:        parametres.SMTPHost,
+		Port:        parametres.SMTPPort,
+		Username:    parametres.SMTPUser,
+		Password:    parametres.SMTPPassword,
+		Chiffrement: parametres.SMTPChiffrement,
+	}, utils.Email{
+		ExpediteurEmail: parametres.ExpediteurEmail,
+		ExpediteurNom:   parametres.ExpediteurNom,
+		Destinataires:   req.Destinataires,
+		Copies:          copies,
+		Sujet:           sujet,
+		Corps:           corps,
+		PiecesJointes:   []utils.PieceJointe{pj},
+	})
+	if err != nil {
+		envoi.Statut = models.StatutEnvoiEchec
+		envoi.Erreur = err.Error()
+	}
+
+	config.DB.Create(&envoi)
+	return envoi, nil
+}
+
+// repondreEnvoi renvoie le résultat d'un envoi : 200 si envoyé, 502 si le serveur SMTP a échoué
+func repondreEnvoi(w http.ResponseWriter, envoi models.EnvoiEmail, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if envoi.Statut == models.StatutEnvoiEchec {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(envoi)
+}
+
+// decodeEnvoiDocumentRequest lit la demande d'envoi (corps optionnel) et valide les adresses
+func decodeEnvoiDocumentRequest(r *http.Request) (EnvoiDocumentRequest, error) {
+	var req EnvoiDocumentRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			return req, err
+		}
+	}
+
+	for _, adresse := range append(append([]string{}, req.Destinataires...), req.Copies...) {
+		if _, err := mail.ParseAddress(adresse); err != nil {
+			return req, fmt.Errorf("Adresse email invalide : %s", adresse)
+		}
+	}
+
+	return req, nil
+}
+
+// parametresEmailEffectifs retourne les paramètres email de l'entreprise complétés par la configuration du serveur
+func parametresEmailEffectifs(entrepriseID uint) models.ParametresEmail {
+	defaut := config.GetEmailConfig()
+
+	var parametres models.ParametresEmail
+	config.DB.Where("entreprise_id = ?", entrepriseID).Limit(1).Find(&parametres)
+	parametres.EntrepriseID = entrepriseID
+
+	if parametres.SMTPHost == "" {
+		parametres.SMTPHost = defaut.SMTPHost
+		parametres.SMTPPort = defaut.SMTPPort
+		parametres.SMTPUser = defaut.SMTPUser
+		parametres.SMTPPassword = defaut.SMTPPassword
+		parametres.SMTPChiffrement = defaut.SMTPChiffrement
+	}
+	if parametres.SMTPPort == 0 {
+		parametres.SMTPPort = defaut.SMTPPort
+	}
+	if parametres.SMTPChiffrement == "" {
+		parametres.SMTPChiffrement = defaut.SMTPChiffrement
+	}
+	if parametres.ExpediteurEmail == "" {
+		parametres.ExpediteurEmail = defaut.ExpediteurEmail
+	}
+	if parametres.ExpediteurNom == "" {
+		parametres.ExpediteurNom = nomEntreprise(entrepriseID)
+	}
+	if parametres.SujetFacture == "" {
+		parametres.SujetFacture = defaut.SujetFacture
+	}
+	if parametres.CorpsFacture == "" {
+		parametres.CorpsFacture = defaut.CorpsFacture
+	}
+	if parametres.SujetDevis == "" {
+		parametres.SujetDevis = defaut.SujetDevis
+	}
+	if parametres.CorpsDevis == "" {
+		parametres.CorpsDevis = defaut.CorpsDevis
+	}
+
+	return parametres
+}
+
+// validateParametresEmail valide les paramètres email saisis par l'entreprise
+func validateParametresEmail(parametres *models.ParametresEmail) error {
+	if parametres.SMTPPort < 0 || parametres.SMTPPort > 65535 {
+		return fmt.Errorf("Port SMTP invalide")
+	}
+
+	switch parametres.SMTPChiffrement {
+	case "", utils.SMTPChiffrementAucun, utils.SMTPChiffrementSTARTTLS, utils.SMTPChiffrementTLS:
+	default:
+		return fmt.Errorf("Chiffrement SMTP invalide (valeurs acceptées : aucun, starttls, tls)")
+	}
+
+	if parametres.ExpediteurEmail != "" {
+		if _, err := mail.ParseAddress(parametres.ExpediteurEmail); err != nil {
+			return fmt.Errorf("Email expéditeur invalide")
+		}
+	}
+	if parametres.CopieA != "" {
+		if _, err := mail.ParseAddress(parametres.CopieA); err != nil {
+			return fmt.Errorf("Email de copie invalide")
+		}
+	}
+
+	for _, modele := range []string{parametres.SujetFacture, parametres.CorpsFacture, parametres.SujetDevis, parametres.CorpsDevis} {
+		if _, err := appliquerModeleEmail(modele, ModeleEmailData{}); err != nil {
+			return fmt.Errorf("Modèle de message invalide : %v", err)
+		}
+	}
+
+	return nil
+}
+
+// appliquerModeleEmail remplace les variables d'un modèle de sujet ou de corps
+func appliquerModeleEmail(modele string, data ModeleEmailData) (string, error) {
+	tmpl, err := template.New("email").Option("missingkey=error").Parse(modele)
+	if err != nil {
+		return "", err
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// nomEntreprise retourne le nom de l'entreprise
+func nomEntreprise(entrepriseID uint) string {
+	var entreprise models.Entreprise
+	config.DB.Select("nom").Limit(1).Find(&entreprise, entrepriseID)
+	return entreprise.Nom
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"testing"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/database/basetest"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+	"facturation-planning/utils/smtptest"
+)
+
+// documentsEnvoi crée une entreprise dont les emails partent par le serveur de test, avec une facture émise
+// et un devis brouillon pour un client qui a une adresse email
+func documentsEnvoi(t *testing.T, serveur *smtptest.Serveur, suffixe string) (models.Entreprise, models.Facture, models.Devis) {
+	t.Helper()
+	db := config.DB
+	creer := func(valeur interface{}) {
+		t.Helper()
+		if err := db.Create(valeur).Error; err != nil {
+			t.Fatalf("création %T : %v", valeur, err)
+		}
+	}
+
+	entreprise := models.Entreprise{Nom: "Atelier " + suffixe, Email: "atelier-" + suffixe + "@exemple.test", Ville: "Lyon"}
+	creer(&entreprise)
+	creer(&models.ParametresEmail{
+		EntrepriseID: entreprise.ID, SMTPHost: serveur.Hote, SMTPPort: serveur.Port, SMTPChiffrement: utils.SMTPChiffrementAucun,
+		ExpediteurEmail: "factures@exemple.test", SujetFacture: "Facture {{.Reference}}", SujetDevis: "Devis {{.Reference}}",
+	})
+	nom := "Boulangerie " + suffixe
+	client := models.Client{TypeClient: "professionnel", NomOrganisme: &nom, Email: "client-" + suffixe + "@exemple.test", EntrepriseID: entreprise.ID}
+	creer(&client)
+
+	now := time.Now()
+	facture := models.Facture{
+		EntrepriseID: entreprise.ID, Reference: "FAC-" + suffixe, ClientID: client.ID, ClientNom: nom, ClientEmail: client.Email,
+		DateCreation: now, DateEmission: now, DateEcheance: now.AddDate(0, 0, 30), TypeFacture: models.TypeFactureClassique,
+		Statut: models.StatutFactureEmise, EmiseLe: &now,
+		SousTotalHT: models.MontantEuros(100), TotalTVA: models.MontantEuros(20), TotalTTC: models.MontantEuros(120), ResteAPayer: models.MontantEuros(120),
+		Lignes: []models.LigneFacture{{
+			Description: "Prestation", Unite: models.UniteForfait, Quantite: 1, PrixUnitaire: models.MontantEuros(100), TauxTVA: 20,
+			MontantHT: models.MontantEuros(100), TotalLigne: models.MontantEuros(100), MontantTTC: models.MontantEuros(120),
+		}},
+	}
+	creer(&facture)
+
+	devis := models.Devis{
+		EntrepriseID: entreprise.ID, Reference: "DEV-" + suffixe, ClientID: client.ID, Objet: "Travaux",
+		Statut: models.StatutDevisBrouillon, DateDevis: now, DateExpiration: now.AddDate(0, 1, 0),
+		Lignes: []models.LigneDevis{{Description: "Travaux", Quantite: 1, PrixUnitaire: models.MontantEuros(100), TVA: 20}},
+	}
+	creer(&devis)
+	return entreprise, facture, devis
+}
+
+// verifierEmailRecu vérifie le nombre de messages reçus par le serveur, puis le destinataire,
+// le sujet et la pièce jointe PDF du dernier
+func verifierEmailRecu(t *testing.T, serveur *smtptest.Serveur, nombre int, destinataire, sujet, pieceJointe string) {
+	t.Helper()
+	messages := serveur.Messages()
+	if len(messages) != nombre {
+		t.Fatalf("%d messages reçus, attendu %d", len(messages), nombre)
+	}
+	dernier := messages[nombre-1]
+	if len(dernier.Destinataires) != 1 || dernier.Destinataires[0] != destinataire {
+		t.Errorf("RCPT TO %v, attendu %s", dernier.Destinataires, destinataire)
+	}
+
+	message, err := mail.ReadMessage(bytes.NewReader(dernier.Donnees))
+	if err != nil {
+		t.Fatalf("message illisible : %v", err)
+	}
+	if recu, _ := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject")); recu != sujet {
+		t.Errorf("sujet %q, attendu %q", recu, sujet)
+	}
+
+	_, params, _ := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	parties := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		partie, err := parties.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("partie illisible : %v", err)
+		}
+		if partie.FileName() != pieceJointe {
+			continue
+		}
+		if contentType, _, _ := mime.ParseMediaType(partie.Header.Get("Content-Type")); contentType != "application/pdf" {
+			t.Errorf("pièce jointe de type %q", contentType)
+		}
+		contenu, _ := io.ReadAll(partie)
+		if !bytes.HasPrefix(contenu, []byte(base64PDF)) {
+			t.Errorf("la pièce jointe %s n'est pas un PDF", pieceJointe)
+		}
+		return
+	}
+	t.Errorf("pièce jointe %s absente", pieceJointe)
+}
+
+// base64PDF est le début de l'encodage base64 de « %PDF- », signature d'un fichier PDF
+const base64PDF = "JVBERi0"
+
+func envoyer(t *testing.T, handler http.HandlerFunc, entrepriseID, documentID uint) (int, models.EnvoiEmail) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, requeteEntreprise(entrepriseID, "POST", "", "id", fmt.Sprint(documentID)))
+	var envoi models.EnvoiEmail
+	if err := json.Unmarshal(rec.Body.Bytes(), &envoi); err != nil {
+		t.Fatalf("réponse illisible (%d) : %s", rec.Code, rec.Body.String())
+	}
+	return rec.Code, envoi
+}
+
+func TestEnvoiDocumentsParEmail(t *testing.T) {
+	basetest.Exiger(t)
+	serveur := smtptest.NouveauServeur(t)
+	entreprise, facture, devis := documentsEnvoi(t, serveur, "envoi")
+
+	code, envoi := envoyer(t, SendFacture, entreprise.ID, facture.ID)
+	if code != http.StatusOK || envoi.Statut != models.StatutEnvoiEnvoye {
+		t.Fatalf("envoi de la facture : statut %d, envoi %q (%s)", code, envoi.Statut, envoi.Erreur)
+	}
+	verifierEmailRecu(t, serveur, 1, facture.ClientEmail, "Facture "+facture.Reference, "facture_"+facture.Reference+".pdf")
+
+	code, envoi = envoyer(t, SendDevis, entreprise.ID, devis.ID)
+	if code != http.StatusOK || envoi.Statut != models.StatutEnvoiEnvoye {
+		t.Fatalf("envoi du devis : statut %d, envoi %q (%s)", code, envoi.Statut, envoi.Erreur)
+	}
+	verifierEmailRecu(t, serveur, 2, "client-envoi@exemple.test", envoi.Sujet, envoi.PieceJointe)
+	if envoi.Sujet != "Devis "+envoi.Reference || envoi.PieceJointe != "devis_"+envoi.Reference+".pdf" {
+		t.Errorf("envoi du devis : sujet %q, pièce jointe %q", envoi.Sujet, envoi.PieceJointe)
+	}
+
+	var relu models.Devis
+	config.DB.First(&relu, devis.ID)
+	if relu.Statut != models.StatutDevisEnvoye {
+		t.Errorf("devis envoyé au statut %q, attendu %q", relu.Statut, models.StatutDevisEnvoye)
+	}
+}
+
+func TestEnvoiDocumentsEchecSMTP(t *testing.T) {
+	basetest.Exiger(t)
+	serveur := smtptest.NouveauServeur(t)
+	serveur.RefuserDestinataires(true)
+	entreprise, facture, devis := documentsEnvoi(t, serveur, "echec")
+
+	for _, d := range []struct {
+		typeDocument string
+		handler      http.HandlerFunc
+		id           uint
+	}{
+		{models.TypeDocumentFacture, SendFacture, facture.ID},
+		{models.TypeDocumentDevis, SendDevis, devis.ID},
+	} {
+		code, envoi := envoyer(t, d.handler, entreprise.ID, d.id)
+		if code != http.StatusBadGateway || envoi.Statut != models.StatutEnvoiEchec || envoi.Erreur == "" {
+			t.Errorf("%s : statut %d, envoi %q (%s), attendu 502 et un échec tracé", d.typeDocument, code, envoi.Statut, envoi.Erreur)
+		}
+
+		// Le journal trace l'échec et aucun envoi réussi
+		var envois []models.EnvoiEmail
+		config.DB.Where("type_document = ? AND document_id = ?", d.typeDocument, d.id).Find(&envois)
+		if len(envois) != 1 || envois[0].Statut != models.StatutEnvoiEchec {
+			t.Errorf("%s : journal %+v, attendu un seul envoi en échec", d.typeDocument, envois)
+		}
+	}
+	if len(serveur.Messages()) != 0 {
+		t.Error("aucun message ne doit être transmis")
+	}
+
+	// Le devis n'est pas marqué envoyé
+	var relu models.Devis
+	config.DB.First(&relu, devis.ID)
+	if relu.Statut != models.StatutDevisBrouillon {
+		t.Errorf("devis au statut %q après un échec d'envoi, attendu %q", relu.Statut, models.StatutDevisBrouillon)
+	}
+	var transitions int64
+	config.DB.Model(&models.TransitionStatut{}).Where("type_document = ? AND document_id = ?", models.TypeDocumentDevis, devis.ID).Count(&transitions)
+	if transitions != 0 {
+		t.Errorf("%d transitions de statut enregistrées pour le devis, attendu aucune", transitions)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"facturation-planning/config"
 	"facturation-planning/models"
+	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"strings"
 )
 
-// GetEntreprises retourne toutes les entreprises
-// @Summary Récupérer toutes les entreprises
-// @Description Retourne la liste complète de toutes les entreprises
+// GetEntreprises retourne l'entreprise de l'utilisateur connecté
+// @Summary Récupérer les entreprises accessibles
+// @Description Retourne la liste des entreprises accessibles à l'utilisateur connecté (la sienne uniquement)
 // @Tags Entreprises
 // @Accept json
 // @Produce json
@@ -17,8 +21,13 @@ import (
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/entreprises [get]
 func GetEntreprises(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var entreprises []models.Entreprise
-	config.DB.Find(&entreprises)
+	config.DB.Where("id = ?", entrepriseID).Find(&entreprises)
 	json.NewEncoder(w).Encode(entreprises)
 }
 
@@ -40,3 +49,341 @@ func CreateEntreprise(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(entreprise)
 }
+
+// tailleMaxLogo limite la taille d'un logo téléversé (1 Mo)
+const tailleMaxLogo = 1 << 20
+
+// siretValide vérifie le format d'un numéro SIRET (14 chiffres)
+var siretValide = regexp.MustCompile(`^\d{14}$`)
+
+// ProfilEntrepriseRequest contient les champs du profil modifiables par l'entreprise
+type ProfilEntrepriseRequest struct {
+	Nom             string `json:"nom" example:"MaBoite"`
+	Adresse         string `json:"adresse" example:"123 rue du Code"`
+	CodePostal      string `json:"code_postal" example:"75001"`
+	Ville           string `json:"ville" example:"Paris"`
+	Telephone       string `json:"telephone" example:"+33123456789"`
+	SiteWeb         string `json:"site_web" example:"https://www.maboite.com"`
+	Responsable     string `json:"responsable" example:"Jean Dupont"`
+	SIRET           string `json:"siret" example:"12345678901234"`
+	TVA             string `json:"tva" example:"FR12345678901"`
+	IBAN            string `json:"iban" example:"FR7612345987650123456789014"`
+	BIC             string `json:"bic" example:"BIC12345"`
+	FormeJuridique  string `json:"forme_juridique" example:"SARL"`
+	Capital         string `json:"capital" example:"10 000 €"`
+	CodeAPE         string `json:"code_ape" example:"6201Z"`
+	RCS             string `json:"rcs" example:"RCS Paris 123 456 789"`
+	MentionsLegales string `json:"mentions_legales"`
+
+	ConditionsDevis    string   `json:"conditions_devis" example:"Paiement à réception de facture."`
+	ValiditeDevisJours int      `json:"validite_devis_jours" example:"30"`
+	TauxTVADefaut      *float64 `json:"taux_tva_defaut" example:"20"`
+	PrefixeDevis       string   `json:"prefixe_devis" example:"DEV"`
+
+	MotifExonerationTVA string `json:"motif_exoneration_tva" example:"franchise"` // "franchise", "autoliquidation", "intracommunautaire" ou vide
+
+	SeuilHeuresSupplementaires float64 `json:"seuil_heures_supplementaires" example:"35"`
+	HeuresJournee              float64 `json:"heures_journee" example:"7"`
+}
+
+// GetProfilEntreprise godoc
+// @Summary Profil de l'entreprise
+// @Description Retourne l'identité, les mentions légales et les valeurs par défaut des devis et des feuilles de temps de l'entreprise connectée
+// @Tags Entreprises
+// @Produce json
+// @Success 200 {object} models.Entreprise
+// @Failure 404 {string} string "Entreprise introuvable"
+// @Router /parametres/entreprise [get]
+func GetProfilEntreprise(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entreprise)
+}
+
+// UpdateProfilEntreprise godoc
+// @Summary Modifier le profil de l'entreprise
+// @Description Enregistre l'identité, les mentions légales et les valeurs par défaut des devis affichées sur les documents, ainsi que le seuil des heures supplémentaires et la durée d'une journée des feuilles de temps. L'email de connexion n'est pas modifiable ici.
+// @Tags Entreprises
+// @Accept json
+// @Produce json
+// @Param profil body ProfilEntrepriseRequest true "Profil de l'entreprise"
+// @Success 200 {object} models.Entreprise
+// @Failure 400 {string} string "Profil invalide"
+// @Failure 404 {string} string "Entreprise introuvable"
+// @Failure 500 {string} string "Erreur lors de l'enregistrement"
+// @Router /parametres/entreprise [put]
+func UpdateProfilEntreprise(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ProfilEntrepriseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateProfilEntreprise(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	profil := models.Entreprise{
+		Nom:                req.Nom,
+		Adresse:            req.Adresse,
+		CodePostal:         req.CodePostal,
+		Ville:              req.Ville,
+		Telephone:          req.Telephone,
+		SiteWeb:            req.SiteWeb,
+		Responsable:        req.Responsable,
+		SIRET:              req.SIRET,
+		TVA:                req.TVA,
+		IBAN:               req.IBAN,
+		BIC:                req.BIC,
+		FormeJuridique:     req.FormeJuridique,
+		Capital:            req.Capital,
+		CodeAPE:            req.CodeAPE,
+		RCS:                req.RCS,
+		MentionsLegales:    req.MentionsLegales,
+		ConditionsDevis:    req.ConditionsDevis,
+		ValiditeDevisJours: req.ValiditeDevisJours,
+		TauxTVADefaut:      req.TauxTVADefaut,
+		PrefixeDevis:       req.PrefixeDevis,
+
+		MotifExonerationTVA: req.MotifExonerationTVA,
+
+		SeuilHeuresSupplementaires: req.SeuilHeuresSupplementaires,
+		HeuresJournee:              req.HeuresJournee,
+	}
+
+	// Select : les valeurs vides ou nulles sont enregistrées (retour aux valeurs par défaut)
+	err := config.DB.Model(&entreprise).Select(
+		"nom", "adresse", "code_postal", "ville", "telephone", "site_web", "responsable",
+		"siret", "tva", "iban", "bic", "forme_juridique", "capital", "code_ape", "rcs", "mentions_legales",
+		"conditions_devis", "validite_devis_jours", "taux_tva_defaut", "prefixe_devis", "motif_exoneration_tva",
+		"seuil_heures_supplementaires", "heures_journee",
+	).Updates(profil).Error
+	if err != nil {
+		http.Error(w, "Erreur lors de l'enregistrement du profil", http.StatusInternalServerError)
+		return
+	}
+
+	config.DB.First(&entreprise, entrepriseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entreprise)
+}
+
+// UploadLogoEntreprise godoc
+// @Summary Téléverser le logo de l'entreprise
+// @Description Enregistre le logo (PNG ou JPEG, 1 Mo maximum) affiché en en-tête des devis, factures et avoirs
+// @Tags Entreprises
+// @Accept multipart/form-data
+// @Produce json
+// @Param logo formData file true "Image du logo"
+// @Success 200 {object} models.Entreprise
+// @Failure 400 {string} string "Fichier manquant"
+// @Failure 413 {string} string "Logo trop volumineux"
+// @Failure 415 {string} string "Format non supporté"
+// @Router /parametres/entreprise/logo [put]
+func UploadLogoEntreprise(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, tailleMaxLogo+64<<10)
+	fichier, _, err := r.FormFile("logo")
+	if err != nil {
+		http.Error(w, "Fichier 'logo' manquant", http.StatusBadRequest)
+		return
+	}
+	defer fichier.Close()
+
+	contenu, err := io.ReadAll(io.LimitReader(fichier, tailleMaxLogo+1))
+	if err != nil {
+		http.Error(w, "Erreur de lecture du logo", http.StatusBadRequest)
+		return
+	}
+	if len(contenu) > tailleMaxLogo {
+		http.Error(w, "Logo trop volumineux (1 Mo maximum)", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	typeContenu := http.DetectContentType(contenu)
+	if typeContenu != "image/png" && typeContenu != "image/jpeg" {
+		http.Error(w, "Format de logo non supporté (PNG ou JPEG)", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	if err := config.DB.Model(&entreprise).Updates(map[string]interface{}{"logo": contenu, "logo_type": typeContenu}).Error; err != nil {
+		http.Error(w, "Erreur lors de l'enregistrement du logo", http.StatusInternalServerError)
+		return
+	}
+	entreprise.LogoType = typeContenu
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entreprise)
+}
+
+// GetLogoEntreprise godoc
+// @Summary Logo de l'entreprise
+// @Description Retourne l'image du logo de l'entreprise connectée
+// @Tags Entreprises
+// @Produce image/png
+// @Produce image/jpeg
+// @Success 200 {file} file "Logo"
+// @Failure 404 {string} string "Aucun logo"
+// @Router /parametres/entreprise/logo [get]
+func GetLogoEntreprise(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var entreprise models.Entreprise
+	config.DB.Select("id", "logo", "logo_type").Limit(1).Find(&entreprise, entrepriseID)
+	if len(entreprise.Logo) == 0 {
+		http.Error(w, "Aucun logo", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", entreprise.LogoType)
+	w.Write(entreprise.Logo)
+}
+
+// DeleteLogoEntreprise godoc
+// @Summary Supprimer le logo de l'entreprise
+// @Description Retire le logo des documents de l'entreprise connectée
+// @Tags Entreprises
+// @Success 204 "Logo supprimé"
+// @Router /parametres/entreprise/logo [delete]
+func DeleteLogoEntreprise(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := config.DB.Model(&models.Entreprise{}).Where("id = ?", entrepriseID).
+		Updates(map[string]interface{}{"logo": nil, "logo_type": ""}).Error; err != nil {
+		http.Error(w, "Erreur lors de la suppression du logo", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateProfilEntreprise normalise et valide le profil saisi par l'entreprise
+func validateProfilEntreprise(req *ProfilEntrepriseRequest) error {
+	req.Nom = strings.TrimSpace(req.Nom)
+	if req.Nom == "" {
+		return fmt.Errorf("Le nom de l'entreprise est obligatoire")
+	}
+
+	req.SIRET = strings.ReplaceAll(req.SIRET, " ", "")
+	if req.SIRET != "" && !siretValide.MatchString(req.SIRET) {
+		return fmt.Errorf("SIRET invalide (14 chiffres)")
+	}
+
+	if req.ValiditeDevisJours < 0 || req.ValiditeDevisJours > 365 {
+		return fmt.Errorf("Durée de validité des devis invalide (0 à 365 jours)")
+	}
+	if req.TauxTVADefaut != nil && !models.TauxTVAApplicable(*req.TauxTVADefaut) {
+		return fmt.Errorf("Taux de TVA par défaut invalide (%s)", tauxTVAApplicables())
+	}
+	if !models.MotifExonerationTVAValide(req.MotifExonerationTVA) {
+		return fmt.Errorf("Motif d'exonération de TVA invalide (franchise, autoliquidation ou intracommunautaire)")
+	}
+	if req.MotifExonerationTVA != "" && req.TauxTVADefaut != nil && *req.TauxTVADefaut != 0 {
+		return fmt.Errorf("Une entreprise exonérée de TVA doit avoir un taux de TVA par défaut de 0 %%")
+	}
+
+	req.PrefixeDevis = strings.TrimSpace(req.PrefixeDevis)
+	if len(req.PrefixeDevis) > 20 || strings.ContainsAny(req.PrefixeDevis, "{}") {
+		return fmt.Errorf("Préfixe de numérotation des devis invalide")
+	}
+
+	if req.SeuilHeuresSupplementaires < 0 || req.SeuilHeuresSupplementaires > 168 {
+		return fmt.Errorf("Seuil hebdomadaire des heures supplémentaires invalide (0 à 168 heures)")
+	}
+	if req.HeuresJournee < 0 || req.HeuresJournee > 24 {
+		return fmt.Errorf("Durée d'une journée invalide (0 à 24 heures)")
+	}
+
+	return nil
+}
+
+// entrepriseDocument charge l'entreprise émettrice d'un document
+func entrepriseDocument(entrepriseID uint) models.Entreprise {
+	var entreprise models.Entreprise
+	config.DB.Limit(1).Find(&entreprise, entrepriseID)
+	return entreprise
+}
+
+// companyInfo retourne les informations affichées en en-tête et en pied des documents de l'entreprise
+func companyInfo(entreprise models.Entreprise) config.CompanyInfo {
+	return config.CompanyInfo{
+		Name:            entreprise.Nom,
+		Address:         entreprise.Adresse,
+		City:            entreprise.Ville,
+		PostalCode:      entreprise.CodePostal,
+		Phone:           entreprise.Telephone,
+		Email:           entreprise.Email,
+		Website:         entreprise.SiteWeb,
+		SIRET:           entreprise.SIRET,
+		APE:             entreprise.CodeAPE,
+		TVA:             entreprise.TVA,
+		RCS:             entreprise.RCS,
+		Capital:         entreprise.Capital,
+		LegalForm:       entreprise.FormeJuridique,
+		LegalMentions:   entreprise.MentionsLegales,
+		Logo:            entreprise.Logo,
+		LogoContentType: entreprise.LogoType,
+	}
+}
+
+// devisConfig retourne la configuration des devis de l'entreprise, complétée par les valeurs par défaut
+func devisConfig(entreprise models.Entreprise) config.DevisConfig {
+	cfg := config.GetDevisConfig()
+	cfg.DefaultCity = entreprise.Ville
+	if entreprise.ConditionsDevis != "" {
+		cfg.DefaultConditions = entreprise.ConditionsDevis
+	}
+	if entreprise.MotifExonerationTVA != "" {
+		cfg.DefaultTVA = 0
+		cfg.MotifExonerationTVA = entreprise.MotifExonerationTVA
+	}
+	if entreprise.TauxTVADefaut != nil {
+		cfg.DefaultTVA = *entreprise.TauxTVADefaut
+	}
+	if entreprise.ValiditeDevisJours > 0 {
+		cfg.ValidityDays = entreprise.ValiditeDevisJours
+	}
+	if entreprise.PrefixeDevis != "" {
+		cfg.NumberingPrefix = entreprise.PrefixeDevis
+	}
+	return cfg
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExpirerDevis godoc
+// @Summary Expirer les devis échus
+// @Description Fait passer au statut expiré les devis envoyés de l'entreprise dont la date d'expiration est dépassée, sans attendre le planificateur
+// @Tags Devis
+// @Produce json
+// @Success 200 {array} models.Devis "Devis expirés"
+// @Failure 500 {string} string "Erreur lors de l'expiration des devis"
+// @Router /devis/expirer [post]
+func ExpirerDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	devis, err := expirerDevisEchus(&entrepriseID, time.Now())
+	if err != nil {
+		http.Error(w, "Erreur lors de l'expiration des devis : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devis)
+}
+
+// LancerPlanificateurExpirationDevis démarre en arrière-plan l'expiration des devis échus :
+// une première exécution au démarrage, puis une à chaque intervalle
+func LancerPlanificateurExpirationDevis(intervalle time.Duration) {
+	go func() {
+		executerExpirationDevis()
+
+		ticker := time.NewTicker(intervalle)
+		defer ticker.Stop()
+		for range ticker.C {
+			executerExpirationDevis()
+		}
+	}()
+}
+
+// executerExpirationDevis expire les devis échus de toutes les entreprises
+func executerExpirationDevis() {
+	devis, err := expirerDevisEchus(nil, time.Now())
+	if err != nil {
+		log.Printf("❌ Expiration des devis : %v", err)
+	}
+	if len(devis) > 0 {
+		log.Printf("⌛ Expiration des devis : %d devis expiré(s)", len(devis))
+	}
+}
+
+// expirerDevisEchus fait passer au statut expiré les devis envoyés dont le jour d'expiration est terminé,
+// pour une entreprise ou pour toutes si nil. Chaque devis est traité dans sa propre transaction.
+func expirerDevisEchus(entrepriseID *uint, maintenant time.Time) ([]models.Devis, error) {
+	query := config.DB.Where("statut = ? AND date_expiration < ?", models.StatutDevisEnvoye, maintenant)
+	if entrepriseID != nil {
+		query = query.Where("entreprise_id = ?", *entrepriseID)
+	}
+
+	var candidats []models.Devis
+	if err := query.Order("id").Find(&candidats).Error; err != nil {
+		return nil, err
+	}
+
+	acteur := acteurSysteme("Expiration automatique")
+	expires := []models.Devis{}
+	for _, candidat := range candidats {
+		if !lienDevisExpire(candidat, maintenant) {
+			continue
+		}
+
+		var devis models.Devis
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			// Le devis a pu être accepté ou modifié depuis la lecture des candidats
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&devis, candidat.ID).Error; err != nil {
+				return err
+			}
+			if devis.Statut != models.StatutDevisEnvoye || !lienDevisExpire(devis, maintenant) {
+				devis.ID = 0
+				return nil
+			}
+			motif := "Date d'expiration dépassée (" + devis.DateExpiration.Format("02/01/2006") + ")"
+			if err := changerStatutDevis(tx, devis, models.StatutDevisExpire, acteur, motif, nil); err != nil {
+				return err
+			}
+			devis.Statut = models.StatutDevisExpire
+			return nil
+		})
+		if err != nil {
+			return expires, err
+		}
+		if devis.ID != 0 {
+			expires = append(expires, devis)
+		}
+	}
+	return expires, nil
+}
@@ -0,0 +1,666 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errDevisIntrouvable        = errors.New("Devis introuvable")
+	errFactureDevisBrouillon   = errors.New("Une facture du devis est encore en brouillon : émettez-la ou supprimez-la avant d'en créer une nouvelle")
+	errDevisEntierementFacture = errors.New("Le devis est entièrement facturé")
+)
+
+// AcompteDevisRequest représente la demande d'une facture d'acompte sur un devis, par pourcentage ou par montant TTC
+type AcompteDevisRequest struct {
+	Pourcentage float64        `json:"pourcentage,omitempty" example:"30"`
+	Montant     models.Montant `json:"montant,omitempty" example:"540"`
+}
+
+// AvancementLigneRequest représente l'avancement cumulé atteint sur une ligne du devis
+type AvancementLigneRequest struct {
+	Position   int     `json:"position" example:"1"`
+	Avancement float64 `json:"avancement" example:"60"`
+}
+
+// SituationDevisRequest représente la demande d'une facture de situation : avancement cumulé de toutes les lignes,
+// et/ou de certaines lignes (les autres lignes conservent l'avancement déjà facturé)
+type SituationDevisRequest struct {
+	Avancement *float64                 `json:"avancement,omitempty" example:"60"`
+	Lignes     []AvancementLigneRequest `json:"lignes,omitempty"`
+}
+
+// AvancementLigneDevis représente l'avancement facturé d'une ligne du devis
+type AvancementLigneDevis struct {
+	Position    int            `json:"position" example:"1"`
+	Description string         `json:"description" example:"Développement site web"`
+	MontantHT   models.Montant `json:"montant_ht" example:"1500"`
+	Avancement  float64        `json:"avancement" example:"60"`  // Avancement cumulé facturé, en %
+	FactureHT   models.Montant `json:"facture_ht" example:"900"` // Montant HT correspondant
+}
+
+// FactureDevisResume représente une facture rattachée à un devis
+type FactureDevisResume struct {
+	ID              uint           `json:"id" example:"4"`
+	Reference       string         `json:"reference" example:"AC-2025-0001"`
+	TypeFacture     string         `json:"type_facture" example:"acompte"`
+	Statut          string         `json:"statut" example:"payée"`
+	EmiseLe         *time.Time     `json:"emise_le,omitempty" example:"2025-06-16T09:00:00Z"`
+	TotalTTC        models.Montant `json:"total_ttc" example:"540"`
+	TotalAvoirs     models.Montant `json:"total_avoirs" example:"0"`
+	AcomptesDeduits models.Montant `json:"acomptes_deduits,omitempty" example:"270"` // Acomptes déduits par cette facture (TTC)
+}
+
+// FacturationDevisResponse représente l'état de facturation d'un devis accepté
+type FacturationDevisResponse struct {
+	DevisID         uint                   `json:"devis_id" example:"12"`
+	Reference       string                 `json:"reference" example:"DEV0012-v2"`
+	TotalHT         models.Montant         `json:"total_ht" example:"1500"`
+	TotalTTC        models.Montant         `json:"total_ttc" example:"1800"`
+	Avancement      float64                `json:"avancement" example:"60"`        // Part HT des lignes facturée par situations et solde, en %
+	Acomptes        models.Montant         `json:"acomptes" example:"540"`         // Acomptes émis, nets d'avoirs (TTC)
+	AcomptesDeduits models.Montant         `json:"acomptes_deduits" example:"324"` // Acomptes déjà déduits des situations et du solde (TTC)
+	Facture         models.Montant         `json:"facture" example:"1080"`         // Factures émises, nettes d'avoirs et de déductions (TTC)
+	EnBrouillon     models.Montant         `json:"en_brouillon" example:"0"`       // Factures en brouillon (TTC)
+	ResteAFacturer  models.Montant         `json:"reste_a_facturer" example:"720"` // Total du devis moins facturé et brouillons (TTC)
+	Lignes          []AvancementLigneDevis `json:"lignes"`
+	Factures        []FactureDevisResume   `json:"factures"`
+}
+
+// etatFacturationDevis rassemble la révision acceptée d'un devis et ses factures non annulées (lignes chargées)
+type etatFacturationDevis struct {
+	devis    models.Devis
+	revision models.RevisionDevis
+	factures []models.Facture
+}
+
+// CreateFactureAcompteDevis godoc
+// @Summary Créer une facture d'acompte sur un devis
+// @Description Génère une facture d'acompte brouillon sur la révision acceptée du devis, par pourcentage ou par montant TTC. L'acompte est ventilé par taux de TVA au prorata du devis et ne peut dépasser le reste à facturer.
+// @Tags Factures
+// @Accept json
+// @Produce json
+// @Param devisId path string true "ID du devis"
+// @Param acompte body AcompteDevisRequest true "Pourcentage ou montant TTC de l'acompte"
+// @Success 201 {object} models.Facture "Facture d'acompte créée"
+// @Failure 400 {string} string "Données invalides"
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Devis non accepté, facture en brouillon ou devis entièrement facturé"
+// @Router /api/factures/from-devis/{devisId}/acompte [post]
+func CreateFactureAcompteDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req AcompteDevisRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case (req.Pourcentage > 0) == (req.Montant > 0):
+		http.Error(w, "Indiquez soit le pourcentage, soit le montant de l'acompte", http.StatusBadRequest)
+		return
+	case req.Pourcentage < 0 || req.Montant < 0 || req.Pourcentage > 100:
+		http.Error(w, "Pourcentage ou montant d'acompte invalide", http.StatusBadRequest)
+		return
+	}
+
+	creerFactureDevis(w, r, entrepriseID, func(etat etatFacturationDevis) (models.Facture, error) {
+		return etat.factureAcompte(req)
+	})
+}
+
+// CreateFactureSituationDevis godoc
+// @Summary Créer une facture de situation sur un devis
+// @Description Génère une facture de situation brouillon : pour chaque ligne du devis, l'avancement cumulé atteint moins l'avancement déjà facturé. Les acomptes sont déduits au prorata du montant HT facturé.
+// @Tags Factures
+// @Accept json
+// @Produce json
+// @Param devisId path string true "ID du devis"
+// @Param situation body SituationDevisRequest true "Avancement cumulé global et/ou par ligne, en %"
+// @Success 201 {object} models.Facture "Facture de situation créée"
+// @Failure 400 {string} string "Avancement invalide"
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Devis non accepté, facture en brouillon ou devis entièrement facturé"
+// @Router /api/factures/from-devis/{devisId}/situation [post]
+func CreateFactureSituationDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var req SituationDevisRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Avancement == nil && len(req.Lignes) == 0 {
+		http.Error(w, "Indiquez l'avancement global ou l'avancement des lignes", http.StatusBadRequest)
+		return
+	}
+
+	creerFactureDevis(w, r, entrepriseID, func(etat etatFacturationDevis) (models.Facture, error) {
+		return etat.factureSituation(req)
+	})
+}
+
+// GetFacturationDevis godoc
+// @Summary État de facturation d'un devis
+// @Description Retourne, pour la révision acceptée du devis, les acomptes, l'avancement facturé par ligne, le montant facturé et le reste à facturer, avec les factures rattachées
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Success 200 {object} FacturationDevisResponse
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Seul un devis accepté peut être facturé"
+// @Router /devis/{id}/facturation [get]
+func GetFacturationDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).First(&devis, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, errDevisIntrouvable.Error(), http.StatusNotFound)
+		return
+	}
+
+	etat, err := chargerEtatFacturationDevis(config.DB, devis)
+	switch {
+	case err == errDevisNonAccepte:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Erreur lors du calcul de la facturation du devis", http.StatusInternalServerError)
+		return
+	}
+
+	var annulees []models.Facture
+	if err := config.DB.Where("devis_id = ? AND statut = ?", devis.ID, models.StatutFactureAnnulee).
+		Order("id").Find(&annulees).Error; err != nil {
+		http.Error(w, "Erreur lors du calcul de la facturation du devis", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(etat.synthese(annulees))
+}
+
+// creerFactureDevis crée dans une transaction la facture brouillon construite sur l'état de facturation du devis,
+// le devis étant verrouillé pour éviter une double facturation
+func creerFactureDevis(w http.ResponseWriter, r *http.Request, entrepriseID uint, construire func(etatFacturationDevis) (models.Facture, error)) {
+	var facture models.Facture
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var devis models.Devis
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Client").
+			Where("entreprise_id = ?", entrepriseID).First(&devis, chi.URLParam(r, "devisId")).Error; err != nil {
+			return errDevisIntrouvable
+		}
+
+		etat, err := chargerEtatFacturationDevis(tx, devis)
+		if err != nil {
+			return err
+		}
+		for _, existante := range etat.factures {
+			if existante.Statut == models.StatutFactureBrouillon {
+				return errFactureDevisBrouillon
+			}
+		}
+
+		facture, err = construire(etat)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&facture).Error
+	})
+
+	switch {
+	case err == errDevisIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errDevisNonAccepte || err == errFactureDevisBrouillon || err == errDevisEntierementFacture:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(facture)
+}
+
+// chargerEtatFacturationDevis charge la révision acceptée du devis et ses factures non annulées
+func chargerEtatFacturationDevis(tx *gorm.DB, devis models.Devis) (etatFacturationDevis, error) {
+	etat := etatFacturationDevis{devis: devis}
+
+	revision, err := revisionAccepteeDevis(tx, devis)
+	if err != nil {
+		return etat, err
+	}
+	etat.revision = revision
+
+	err = tx.Preload("Lignes", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("devis_id = ? AND statut <> ?", devis.ID, models.StatutFactureAnnulee).
+		Order("id").Find(&etat.factures).Error
+	return etat, err
+}
+
+// avancements retourne l'avancement cumulé déjà facturé (en %) pour chaque position de ligne du devis.
+// Une facture totale antérieure, sans avancement par ligne, facture l'ensemble du devis.
+func (e etatFacturationDevis) avancements() map[int]float64 {
+	avancements := map[int]float64{}
+	for _, facture := range e.factures {
+		parLigne := false
+		for _, ligne := range facture.Lignes {
+			if ligne.PositionDevis == 0 {
+				continue
+			}
+			parLigne = true
+			if ligne.AvancementCumule > avancements[ligne.PositionDevis] {
+				avancements[ligne.PositionDevis] = ligne.AvancementCumule
+			}
+		}
+		if !parLigne && (facture.TypeFacture == models.TypeFactureClassique || facture.TypeFacture == models.TypeFactureSolde) {
+			for _, ligne := range e.revision.Lignes {
+				avancements[ligne.Position] = 100
+			}
+		}
+	}
+	return avancements
+}
+
+// acomptes retourne les factures d'acompte émises sur le devis
+func (e etatFacturationDevis) acomptes() []models.Facture {
+	var acomptes []models.Facture
+	for _, facture := range e.factures {
+		if facture.TypeFacture == models.TypeFactureAcompte && facture.EstScellee() {
+			acomptes = append(acomptes, facture)
+		}
+	}
+	return acomptes
+}
+
+// acompteDeduit retourne le montant TTC d'un acompte déjà déduit par les factures du devis
+func (e etatFacturationDevis) acompteDeduit(acompteID uint) models.Montant {
+	var deduit models.Montant
+	for _, facture := range e.factures {
+		for _, ligne := range facture.Lignes {
+			if ligne.FactureAcompteID != nil && *ligne.FactureAcompteID == acompteID {
+				deduit -= ligne.MontantTTC
+			}
+		}
+	}
+	return deduit
+}
+
+// deductionsAcomptes retourne les lignes déduisant la part donnée (entre 0 et 1) de chaque acompte, net d'avoirs,
+// dans la limite de ce qui n'a pas encore été déduit. Une part de 1 déduit tout le restant (facture de solde).
+func (e etatFacturationDevis) deductionsAcomptes(part float64) []models.LigneFacture {
+	var lignes []models.LigneFacture
+	for _, acompte := range e.acomptes() {
+		net := acompte.TotalTTC - acompte.TotalAvoirs
+		if acompte.TotalTTC <= 0 || net <= 0 {
+			continue
+		}
+		restant := 1 - e.acompteDeduit(acompte.ID).Ratio(net)
+		if restant <= 0.0001 {
+			continue
+		}
+		fraction := math.Min(part, restant) * net.Ratio(acompte.TotalTTC)
+
+		acompteID := acompte.ID
+		for _, ligne := range acompte.Lignes {
+			montantHT := -ligne.MontantHT.Multiplier(fraction)
+			if montantHT == 0 {
+				continue
+			}
+			lignes = append(lignes, models.LigneFacture{
+				Description:      fmt.Sprintf("Déduction de l'acompte %s du %s", acompte.Reference, acompte.DateEmission.Format("02/01/2006")),
+				Unite:            models.UniteForfait,
+				Quantite:         1,
+				PrixUnitaire:     montantHT,
+				TotalLigne:       montantHT,
+				TauxTVA:          ligne.TauxTVA,
+				MontantHT:        montantHT,
+				MontantTTC:       -ligne.MontantTTC.Multiplier(fraction),
+				FactureAcompteID: &acompteID,
+			})
+		}
+	}
+	return lignes
+}
+
+// montantFacture retourne le montant TTC facturé sur le devis : factures émises nettes d'avoirs, et brouillons
+func (e etatFacturationDevis) montantFacture() (emis, brouillons models.Montant) {
+	for _, facture := range e.factures {
+		if facture.EstScellee() {
+			emis += facture.TotalTTC - facture.TotalAvoirs
+		} else {
+			brouillons += facture.TotalTTC
+		}
+	}
+	return emis, brouillons
+}
+
+// nouvelleFacture prépare une facture brouillon rattachée à la révision acceptée du devis
+func (e etatFacturationDevis) nouvelleFacture(typeFacture, description string) models.Facture {
+	devisID := e.devis.ID
+	return models.Facture{
+		ClientID:        e.devis.ClientID,
+		ClientNom:       e.devis.Client.GetDisplayName(),
+		ClientAdresse:   e.devis.Client.Adresse,
+		ClientEmail:     e.devis.Client.Email,
+		ClientTelephone: e.devis.Client.Telephone,
+		DateCreation:    time.Now(),
+		DateEcheance:    time.Now().AddDate(0, 0, 30),
+		Description:     description,
+		TypeFacture:     typeFacture,
+		Statut:          models.StatutFactureBrouillon,
+		LieuSignature:   e.devis.LieuSignature,
+		DateSignature:   e.devis.DateSignature,
+		EntrepriseID:    e.devis.EntrepriseID,
+		DevisID:         &devisID,
+		DevisReference:  e.revision.Reference,
+
+		MotifExonerationTVA: e.revision.MotifExonerationTVA,
+	}
+}
+
+// factureAcompte construit une facture d'acompte ventilée par taux de TVA au prorata des lignes du devis
+func (e etatFacturationDevis) factureAcompte(req AcompteDevisRequest) (models.Facture, error) {
+	if e.revision.TotalTTC <= 0 {
+		return models.Facture{}, errDevisEntierementFacture
+	}
+	part := req.Pourcentage / 100
+	if req.Montant > 0 {
+		part = req.Montant.Ratio(e.revision.TotalTTC)
+	}
+
+	emis, brouillons := e.montantFacture()
+	reste := e.revision.TotalTTC - emis - brouillons
+	if reste <= 0 {
+		return models.Facture{}, errDevisEntierementFacture
+	}
+	if acompte := e.revision.TotalTTC.Multiplier(part); acompte > reste+toleranceMontant {
+		return models.Facture{}, fmt.Errorf("l'acompte (%s €) dépasse le reste à facturer du devis (%s €)", acompte, reste)
+	}
+
+	pourcentage := arrondirPourcentage(part * 100)
+	facture := e.nouvelleFacture(models.TypeFactureAcompte,
+		fmt.Sprintf("Acompte de %s %% sur le devis %s", formatPourcentage(pourcentage), e.revision.Reference))
+	facture.PourcentageAcompte = pourcentage
+
+	// Base HT du devis par taux de TVA, remise globale déduite
+	bases := models.BasesTVA{}
+	var taux []float64
+	for _, v := range e.revision.Ventilation() {
+		taux = append(taux, v.Taux)
+		bases.Ajouter(v.Taux, v.Base)
+	}
+	sort.Float64s(taux)
+
+	for _, t := range taux {
+		montantHT := bases[t].Multiplier(part)
+		if montantHT == 0 {
+			continue
+		}
+		description := facture.Description
+		if len(taux) > 1 {
+			description += " - TVA " + formatPourcentage(t) + " %"
+		}
+		facture.Lignes = append(facture.Lignes, models.LigneFacture{
+			Description:  description,
+			Unite:        models.UniteForfait,
+			Quantite:     1,
+			PrixUnitaire: montantHT,
+			TotalLigne:   montantHT,
+			TauxTVA:      t,
+			MontantHT:    montantHT,
+			MontantTTC:   montantHT + montantHT.Pourcentage(t),
+		})
+	}
+	if len(facture.Lignes) == 0 {
+		return facture, fmt.Errorf("le montant de l'acompte doit être positif")
+	}
+	totaliserFacture(&facture)
+	return facture, nil
+}
+
+// factureSituation construit une facture de situation : avancement de chaque ligne depuis la dernière facture,
+// et déduction des acomptes au prorata du montant HT facturé
+func (e etatFacturationDevis) factureSituation(req SituationDevisRequest) (models.Facture, error) {
+	precedents := e.avancements()
+	cibles := map[int]float64{}
+	for _, ligne := range e.revision.Lignes {
+		cibles[ligne.Position] = precedents[ligne.Position]
+		if req.Avancement != nil {
+			cibles[ligne.Position] = *req.Avancement
+		}
+	}
+	for _, l := range req.Lignes {
+		if _, ok := cibles[l.Position]; !ok {
+			return models.Facture{}, fmt.Errorf("ligne %d introuvable dans le devis %s", l.Position, e.revision.Reference)
+		}
+		cibles[l.Position] = l.Avancement
+	}
+
+	facture := e.nouvelleFacture(models.TypeFactureSituation, "Situation de travaux sur le devis "+e.revision.Reference)
+	var factureHT models.Montant
+	for _, ligne := range e.revision.Lignes {
+		precedent, cible := precedents[ligne.Position], cibles[ligne.Position]
+		switch {
+		case cible < 0 || cible > 100:
+			return facture, fmt.Errorf("l'avancement de la ligne %d doit être compris entre 0 et 100 %%", ligne.Position)
+		case cible < precedent:
+			return facture, fmt.Errorf("l'avancement de la ligne %d ne peut pas diminuer (%s %% déjà facturés)", ligne.Position, formatPourcentage(precedent))
+		case cible == precedent:
+			continue
+		}
+		l := ligneAvancementDevis(ligne, precedent, cible)
+		factureHT += l.MontantHT
+		facture.Lignes = append(facture.Lignes, l)
+	}
+	if len(facture.Lignes) == 0 {
+		if avancementComplet(e.revision.Lignes, precedents) {
+			return facture, errDevisEntierementFacture
+		}
+		return facture, fmt.Errorf("aucun nouvel avancement à facturer")
+	}
+
+	facture.RemiseMontant = e.revision.RemiseGlobale().Proportion(factureHT, e.revision.TotalLignesHT())
+	if totalHT := e.revision.TotalLignesHT(); totalHT > 0 {
+		facture.Lignes = append(facture.Lignes, e.deductionsAcomptes(factureHT.Ratio(totalHT))...)
+	}
+	totaliserFacture(&facture)
+	return facture, nil
+}
+
+// remiseFacturee retourne la part de la remise globale du devis déjà portée par ses factures de situation et de solde
+func (e etatFacturationDevis) remiseFacturee() models.Montant {
+	var remise models.Montant
+	for _, facture := range e.factures {
+		if facture.TypeFacture != models.TypeFactureAcompte {
+			remise += facture.RemiseGlobale()
+		}
+	}
+	return remise
+}
+
+// factureSolde construit la facture qui termine le devis : reste de chaque ligne jusqu'à 100 % et déduction
+// de tout ce qui reste des acomptes. Sans facture antérieure, c'est une facture classique du devis entier.
+func (e etatFacturationDevis) factureSolde() (models.Facture, error) {
+	typeFacture, description := models.TypeFactureClassique, e.revision.Objet
+	if len(e.factures) > 0 {
+		typeFacture, description = models.TypeFactureSolde, "Solde du devis "+e.revision.Reference
+	}
+	facture := e.nouvelleFacture(typeFacture, description)
+
+	precedents := e.avancements()
+	for _, ligne := range e.revision.Lignes {
+		if precedents[ligne.Position] < 100 {
+			facture.Lignes = append(facture.Lignes, ligneAvancementDevis(ligne, precedents[ligne.Position], 100))
+		}
+	}
+	// Remise globale : celle du devis pour une facture unique, le reste non encore déduit pour une facture de solde
+	if typeFacture == models.TypeFactureClassique {
+		facture.RemisePourcentage, facture.RemiseMontant = e.revision.RemisePourcentage, e.revision.RemiseMontant
+	} else {
+		facture.RemiseMontant = e.revision.RemiseGlobale() - e.remiseFacturee()
+	}
+	facture.Lignes = append(facture.Lignes, e.deductionsAcomptes(1)...)
+	if len(facture.Lignes) == 0 {
+		return facture, errDevisEntierementFacture
+	}
+
+	totaliserFacture(&facture)
+	// La TVA arrondie facture par facture s'écarte de quelques centimes de celle du devis :
+	// le solde reprend l'écart pour que les factures totalisent exactement le devis
+	if typeFacture == models.TypeFactureSolde {
+		emis, brouillons := e.montantFacture()
+		restant := e.revision.TotalTTC - emis - brouillons
+		if (facture.TotalTTC - restant).Abs() <= toleranceMontant*models.Montant(len(e.factures)*len(facture.VentilationTVA)) {
+			facture.TotalTTC = restant
+			facture.TotalTVA = restant - facture.SousTotalHT
+			facture.MontantTVA = facture.TotalTVA
+			facture.ResteAPayer = facture.TotalTTC
+		}
+	}
+	if facture.TotalTTC < 0 {
+		return facture, fmt.Errorf("les acomptes (%s €) dépassent le solde du devis", -facture.TotalTTC)
+	}
+	return facture, nil
+}
+
+// synthese construit l'état de facturation du devis ; les factures annulées sont listées pour mémoire
+func (e etatFacturationDevis) synthese(annulees []models.Facture) FacturationDevisResponse {
+	emis, brouillons := e.montantFacture()
+	reponse := FacturationDevisResponse{
+		DevisID:        e.devis.ID,
+		Reference:      e.revision.Reference,
+		TotalHT:        e.revision.SousTotalHT,
+		TotalTTC:       e.revision.TotalTTC,
+		Facture:        emis,
+		EnBrouillon:    brouillons,
+		ResteAFacturer: e.revision.TotalTTC - emis - brouillons,
+		Lignes:         []AvancementLigneDevis{},
+		Factures:       []FactureDevisResume{},
+	}
+
+	avancements := e.avancements()
+	var factureHT models.Montant
+	for _, ligne := range e.revision.Lignes {
+		avancement := avancements[ligne.Position]
+		montant := ligne.MontantHT.Pourcentage(avancement)
+		factureHT += montant
+		reponse.Lignes = append(reponse.Lignes, AvancementLigneDevis{
+			Position:    ligne.Position,
+			Description: ligne.Description,
+			MontantHT:   ligne.MontantHT,
+			Avancement:  avancement,
+			FactureHT:   montant,
+		})
+	}
+	if totalHT := e.revision.TotalLignesHT(); totalHT > 0 {
+		reponse.Avancement = arrondirPourcentage(factureHT.Ratio(totalHT) * 100)
+	}
+
+	for _, acompte := range e.acomptes() {
+		reponse.Acomptes += acompte.TotalTTC - acompte.TotalAvoirs
+		reponse.AcomptesDeduits += e.acompteDeduit(acompte.ID)
+	}
+
+	factures := append(append([]models.Facture{}, e.factures...), annulees...)
+	sort.Slice(factures, func(i, j int) bool { return factures[i].ID < factures[j].ID })
+	for _, facture := range factures {
+		resume := FactureDevisResume{
+			ID:          facture.ID,
+			Reference:   facture.Reference,
+			TypeFacture: facture.TypeFacture,
+			Statut:      facture.Statut,
+			EmiseLe:     facture.EmiseLe,
+			TotalTTC:    facture.TotalTTC,
+			TotalAvoirs: facture.TotalAvoirs,
+		}
+		for _, ligne := range facture.Lignes {
+			if ligne.FactureAcompteID != nil {
+				resume.AcomptesDeduits -= ligne.MontantTTC
+			}
+		}
+		reponse.Factures = append(reponse.Factures, resume)
+	}
+	return reponse
+}
+
+// ligneAvancementDevis facture une ligne du devis de l'avancement précédent à l'avancement cible (en %).
+// Le montant est la différence des montants cumulés arrondis : les situations successives d'une ligne
+// totalisent exactement son montant HT à 100 %. La remise de la ligne est reprise en pourcentage si la ligne
+// est facturée en une fois, sinon en montant (écart entre le montant brut et le montant HT facturé).
+func ligneAvancementDevis(ligne models.LigneRevisionDevis, precedent, cible float64) models.LigneFacture {
+	part := (cible - precedent) / 100
+	description := ligne.Description
+	if precedent > 0 || cible < 100 {
+		description = fmt.Sprintf("%s - avancement %s %% (cumul %s %%)", ligne.Description,
+			formatPourcentage(cible-precedent), formatPourcentage(cible))
+	}
+
+	montantHT := ligne.MontantHT.Pourcentage(cible) - ligne.MontantHT.Pourcentage(precedent)
+	ligneFacture := models.LigneFacture{
+		Description:      description,
+		Unite:            ligne.Unite,
+		Quantite:         ligne.Quantite * part,
+		PrixUnitaire:     ligne.PrixUnitaire,
+		TotalLigne:       montantHT,
+		TauxTVA:          ligne.TVA,
+		MontantHT:        montantHT,
+		MontantTTC:       montantHT + montantHT.Pourcentage(ligne.TVA),
+		PositionDevis:    ligne.Position,
+		AvancementCumule: cible,
+	}
+	switch {
+	case ligne.RemisePourcentage == 0 && ligne.RemiseMontant == 0:
+	case ligne.RemisePourcentage != 0 && precedent == 0 && cible == 100:
+		ligneFacture.RemisePourcentage = ligne.RemisePourcentage
+	default:
+		ligneFacture.RemiseMontant = max(0, ligneFacture.PrixUnitaire.Multiplier(ligneFacture.Quantite)-montantHT)
+	}
+	return ligneFacture
+}
+
+// avancementComplet indique si toutes les lignes du devis sont facturées à 100 %
+func avancementComplet(lignes []models.LigneRevisionDevis, avancements map[int]float64) bool {
+	for _, ligne := range lignes {
+		if avancements[ligne.Position] < 100 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatPourcentage affiche un pourcentage sans décimales inutiles, avec une virgule décimale (12,5)
+func formatPourcentage(valeur float64) string {
+	return strings.Replace(strconv.FormatFloat(arrondirPourcentage(valeur), 'f', -1, 64), ".", ",", 1)
+}
+
+// arrondirPourcentage arrondit un pourcentage au centième
+func arrondirPourcentage(valeur float64) float64 {
+	return math.Round(valeur*100) / 100
+}
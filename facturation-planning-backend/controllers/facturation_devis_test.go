@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"facturation-planning/models"
+)
+
+// etatDevisTest construit, sans base, l'état de facturation d'un devis accepté de trois lignes à deux taux de TVA,
+// avec des remises de ligne et la remise globale donnée
+func etatDevisTest(remisePourcentage float64, remiseMontant models.Montant) etatFacturationDevis {
+	devis := models.Devis{
+		ID: 12, EntrepriseID: 5, ClientID: 3, Objet: "Rénovation",
+		RemisePourcentage: remisePourcentage, RemiseMontant: remiseMontant,
+		Lignes: []models.LigneDevis{
+			{Description: "Gros œuvre", Quantite: 3, PrixUnitaire: models.MontantEuros(1234.57), TVA: 20},
+			{Description: "Menuiseries", Quantite: 7, PrixUnitaire: models.MontantEuros(333.33), TVA: 10, RemisePourcentage: 5},
+			{Description: "Études", Quantite: 1, PrixUnitaire: models.MontantEuros(999.99), TVA: 20, RemiseMontant: models.MontantEuros(50)},
+		},
+	}
+	return etatFacturationDevis{devis: devis, revision: devis.NouvelleRevision(2, "DEV0012")}
+}
+
+// emetteurTest retourne une fonction qui enregistre la facture construite sur l'état comme émise,
+// comme le ferait EmettreFacture, et la retourne
+func emetteurTest(t *testing.T, etat *etatFacturationDevis) func(models.Facture, error) *models.Facture {
+	return func(facture models.Facture, err error) *models.Facture {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("facture %s : %v", facture.TypeFacture, err)
+		}
+		maintenant := time.Date(2025, time.June, 16, 9, 0, 0, 0, time.UTC)
+		facture.ID = uint(len(etat.factures) + 1)
+		facture.Reference = fmt.Sprintf("FAC-2025-%04d", facture.ID)
+		facture.Statut = models.StatutFactureEmise
+		facture.DateEmission = maintenant
+		facture.EmiseLe = &maintenant
+		etat.factures = append(etat.factures, facture)
+		return &etat.factures[len(etat.factures)-1]
+	}
+}
+
+// deductions retourne le total TTC déduit de l'acompte par les lignes données (positif)
+func deductions(lignes []models.LigneFacture, acompteID uint) models.Montant {
+	var total models.Montant
+	for _, ligne := range lignes {
+		if ligne.FactureAcompteID != nil && *ligne.FactureAcompteID == acompteID {
+			total -= ligne.MontantTTC
+		}
+	}
+	return total
+}
+
+func TestFacturationDevisAcompteSituationSolde(t *testing.T) {
+	cas := []struct {
+		nom               string
+		remisePourcentage float64
+		remiseMontant     models.Montant
+		avoirAcompte      models.Montant
+	}{
+		{"remise en pourcentage, sans avoir", 3, 0, 0},
+		{"remise en pourcentage, avoir partiel sur l'acompte", 3, 0, models.MontantEuros(333.33)},
+		{"remise en montant, avoir partiel sur l'acompte", 0, models.MontantEuros(150), models.MontantEuros(100.01)},
+		{"remise en montant, sans avoir", 0, models.MontantEuros(150), 0},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			etat := etatDevisTest(c.remisePourcentage, c.remiseMontant)
+			emettre := emetteurTest(t, &etat)
+			revision := etat.revision
+
+			// Acompte de 30 %, puis avoir partiel sur l'acompte
+			acompte := emettre(etat.factureAcompte(AcompteDevisRequest{Pourcentage: 30}))
+			if attendu := revision.TotalTTC.Pourcentage(30); (acompte.TotalTTC - attendu).Abs() > toleranceMontant {
+				t.Errorf("acompte de %s, attendu 30 %% de %s = %s", acompte.TotalTTC, revision.TotalTTC, attendu)
+			}
+			acompte.TotalAvoirs = c.avoirAcompte
+			acompteID, netAcompte := acompte.ID, acompte.TotalTTC-c.avoirAcompte
+
+			// Situation à 60 % : 60 % des lignes, la part correspondante de la remise globale et de l'acompte net
+			avancement := 60.0
+			situation := emettre(etat.factureSituation(SituationDevisRequest{Avancement: &avancement}))
+			var situationHT models.Montant
+			for _, ligne := range situation.Lignes {
+				if ligne.PositionDevis != 0 {
+					situationHT += ligne.MontantHT
+				}
+			}
+			if attendu := revision.RemiseGlobale().Proportion(situationHT, revision.TotalLignesHT()); situation.RemiseMontant != attendu {
+				t.Errorf("remise de la situation %s, attendu %s", situation.RemiseMontant, attendu)
+			}
+			if etat.remiseFacturee() != situation.RemiseMontant {
+				t.Errorf("remise facturée %s après la situation, attendu %s", etat.remiseFacturee(), situation.RemiseMontant)
+			}
+			deduitSituation := deductions(situation.Lignes, acompteID)
+			if attendu := netAcompte.Multiplier(situationHT.Ratio(revision.TotalLignesHT())); (deduitSituation - attendu).Abs() > toleranceMontant {
+				t.Errorf("acompte déduit par la situation %s, attendu %s", deduitSituation, attendu)
+			}
+
+			// Solde : reste des lignes et de la remise, reste de l'acompte
+			solde := emettre(etat.factureSolde())
+			if solde.TypeFacture != models.TypeFactureSolde {
+				t.Errorf("facture de type %q, attendu %q", solde.TypeFacture, models.TypeFactureSolde)
+			}
+			if remises := situation.RemiseMontant + solde.RemiseMontant; remises != revision.RemiseGlobale() {
+				t.Errorf("remises facturées %s, attendu la remise globale du devis %s", remises, revision.RemiseGlobale())
+			}
+
+			// Le client paie exactement le devis, au centime près
+			var totalFacture models.Montant
+			for _, facture := range etat.factures {
+				totalFacture += facture.TotalTTC - facture.TotalAvoirs
+			}
+			if totalFacture != revision.TotalTTC {
+				t.Errorf("total facturé %s, attendu le total du devis %s", totalFacture, revision.TotalTTC)
+			}
+
+			// L'acompte net est déduit une fois et une seule
+			if deduit := deduitSituation + deductions(solde.Lignes, acompteID); deduit != netAcompte {
+				t.Errorf("acompte déduit %s, attendu %s", deduit, netAcompte)
+			}
+			if etat.acompteDeduit(acompteID) != netAcompte {
+				t.Errorf("acompteDeduit = %s, attendu %s", etat.acompteDeduit(acompteID), netAcompte)
+			}
+			if lignes := etat.deductionsAcomptes(1); len(lignes) != 0 {
+				t.Errorf("%d lignes de déduction après le solde, attendu aucune", len(lignes))
+			}
+			if _, err := etat.factureSolde(); err != errDevisEntierementFacture {
+				t.Errorf("nouveau solde : %v, attendu %v", err, errDevisEntierementFacture)
+			}
+			if synthese := etat.synthese(nil); synthese.ResteAFacturer != 0 || synthese.Avancement != 100 || synthese.AcomptesDeduits != netAcompte {
+				t.Errorf("synthèse : reste %s, avancement %v, acomptes déduits %s", synthese.ResteAFacturer, synthese.Avancement, synthese.AcomptesDeduits)
+			}
+		})
+	}
+}
+
+func TestDeductionsAcomptesRestant(t *testing.T) {
+	etat := etatDevisTest(3, 0)
+	emettre := emetteurTest(t, &etat)
+	acompte := emettre(etat.factureAcompte(AcompteDevisRequest{Pourcentage: 30}))
+	acompte.TotalAvoirs = models.MontantEuros(200)
+	acompteID, net := acompte.ID, acompte.TotalTTC-acompte.TotalAvoirs
+
+	// Aucune déduction : la part demandée de l'acompte net
+	if deduit := deductions(etat.deductionsAcomptes(0.25), acompteID); (deduit - net.Multiplier(0.25)).Abs() > toleranceMontant {
+		t.Errorf("25 %% de l'acompte : %s déduits, attendu %s", deduit, net.Multiplier(0.25))
+	}
+
+	// 60 % déjà déduits : une part plus grande que le restant est ramenée au restant
+	avancement := 60.0
+	situation := emettre(etat.factureSituation(SituationDevisRequest{Avancement: &avancement}))
+	restant := net - deductions(situation.Lignes, acompteID)
+	for _, part := range []float64{0.9, 1} {
+		if deduit := deductions(etat.deductionsAcomptes(part), acompteID); deduit != restant {
+			t.Errorf("part %v : %s déduits, attendu le restant %s", part, deduit, restant)
+		}
+	}
+
+	// Acompte entièrement crédité par avoir : plus rien à déduire
+	acompte = &etat.factures[0]
+	acompte.TotalAvoirs = acompte.TotalTTC
+	if lignes := etat.deductionsAcomptes(1); len(lignes) != 0 {
+		t.Errorf("%d lignes de déduction pour un acompte entièrement crédité", len(lignes))
+	}
+}
+
+func TestFactureSoldeNegativeRefusee(t *testing.T) {
+	etat := etatDevisTest(0, 0)
+	emettre := emetteurTest(t, &etat)
+
+	// Situation à 80 % presque entièrement créditée par avoir : le reste à facturer permet un acompte de 50 %,
+	// qui dépasse ensuite les 20 % restant à facturer sur les lignes
+	avancement := 80.0
+	situation := emettre(etat.factureSituation(SituationDevisRequest{Avancement: &avancement}))
+	situation.TotalAvoirs = situation.TotalTTC.Pourcentage(90)
+	emettre(etat.factureAcompte(AcompteDevisRequest{Pourcentage: 50}))
+
+	_, err := etat.factureSolde()
+	if err == nil || !strings.Contains(err.Error(), "dépassent le solde du devis") {
+		t.Fatalf("solde : %v, attendu le refus d'un solde négatif", err)
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"sort"
 	"time"
 
 	"facturation-planning/config"
 	"facturation-planning/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // FacturationMensuelleRequest représente la demande de facturation mensuelle
@@ -20,18 +25,19 @@ type FacturationMensuelleRequest struct {
 
 // PrestationFacturable représente une prestation qui peut être facturée
 type PrestationFacturable struct {
-	Date            string  `json:"date"`
-	ClientID        uint    `json:"client_id"`
-	ClientNom       string  `json:"client_nom"`
-	Prestation      string  `json:"prestation"`
-	Objet           string  `json:"objet"`
-	Duree           float64 `json:"duree"`
-	TauxHoraire     float64 `json:"taux_horaire"`
-	ForfaitHT       float64 `json:"forfait_ht"`
-	TypeFacturation string  `json:"type_facturation"` // "horaire" ou "forfait"
-	MontantHT       float64 `json:"montant_ht"`
-	MontantTTC      float64 `json:"montant_ttc"`
-	TauxTVA         float64 `json:"taux_tva"`
+	PlanningID      uint           `json:"planning_id"`
+	Date            string         `json:"date"`
+	ClientID        uint           `json:"client_id"`
+	ClientNom       string         `json:"client_nom"`
+	Prestation      string         `json:"prestation"`
+	Objet           string         `json:"objet"`
+	Duree           float64        `json:"duree"`
+	TauxHoraire     models.Montant `json:"taux_horaire"`
+	ForfaitHT       models.Montant `json:"forfait_ht"`
+	TypeFacturation string         `json:"type_facturation"` // "horaire" ou "forfait"
+	MontantHT       models.Montant `json:"montant_ht"`
+	MontantTTC      models.Montant `json:"montant_ttc"`
+	TauxTVA         float64        `json:"taux_tva"`
 }
 
 // ClientFacturation représente la facturation d'un client
@@ -40,8 +46,8 @@ type ClientFacturation struct {
 	ClientNom   string                 `json:"client_nom"`
 	NbHeures    float64                `json:"nb_heures"`
 	NbForfaits  int                    `json:"nb_forfaits"`
-	TotalHT     float64                `json:"total_ht"`
-	TotalTTC    float64                `json:"total_ttc"`
+	TotalHT     models.Montant         `json:"total_ht"`
+	TotalTTC    models.Montant         `json:"total_ttc"`
 	Prestations []PrestationFacturable `json:"prestations"`
 }
 
@@ -52,8 +58,8 @@ type FacturationMensuelleResponse struct {
 	Annee              int                 `json:"annee"`
 	NbClients          int                 `json:"nb_clients"`
 	NbPrestations      int                 `json:"nb_prestations"`
-	TotalGeneralHT     float64             `json:"total_general_ht"`
-	TotalGeneralTTC    float64             `json:"total_general_ttc"`
+	TotalGeneralHT     models.Montant      `json:"total_general_ht"`
+	TotalGeneralTTC    models.Montant      `json:"total_general_ttc"`
 	ClientsFacturation []ClientFacturation `json:"clients_facturation"`
 }
 
@@ -61,17 +67,17 @@ type FacturationMensuelleResponse struct {
 type FacturationMensuelleCreateResponse struct {
 	FacturesCreees []FactureCreee `json:"factures_creees"`
 	NbFactures     int            `json:"nb_factures"`
-	TotalHT        float64        `json:"total_ht"`
-	TotalTTC       float64        `json:"total_ttc"`
+	TotalHT        models.Montant `json:"total_ht"`
+	TotalTTC       models.Montant `json:"total_ttc"`
 }
 
 // FactureCreee représente une facture créée
 type FactureCreee struct {
-	ID        uint    `json:"id"`
-	Reference string  `json:"reference"`
-	ClientNom string  `json:"client_nom"`
-	TotalHT   float64 `json:"total_ht"`
-	TotalTTC  float64 `json:"total_ttc"`
+	ID        uint           `json:"id"`
+	Reference string         `json:"reference"`
+	ClientNom string         `json:"client_nom"`
+	TotalHT   models.Montant `json:"total_ht"`
+	TotalTTC  models.Montant `json:"total_ttc"`
 }
 
 var nomsMois = []string{
@@ -79,434 +85,378 @@ var nomsMois = []string{
 	"Juillet", "Août", "Septembre", "Octobre", "Novembre", "Décembre",
 }
 
-// GetFacturationMensuellePreview génère un aperçu de la facturation mensuelle
+// typesEvenementsFacturables liste les types d'événements qui donnent lieu à facturation
+var typesEvenementsFacturables = []string{models.TypeIntervention, models.TypeFormation, models.TypeDivers}
+
+// GetFacturationMensuellePreview godoc
+// @Summary Aperçu de la facturation mensuelle
+// @Description Calcule, par client, les prestations du mois à l'état "À facturer" (durée issue des heures de début et de fin, ou forfait) sans rien enregistrer
+// @Tags Facturation mensuelle
+// @Accept json
+// @Produce json
+// @Param demande body FacturationMensuelleRequest true "Mois, année et clients éventuels"
+// @Success 200 {object} FacturationMensuelleResponse
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 500 {string} string "Erreur base de données"
+// @Router /facturation-mensuelle/preview [post]
 func GetFacturationMensuellePreview(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
 
-	var req FacturationMensuelleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("Erreur de parsing JSON: %v", err), http.StatusBadRequest)
+	req, ok := decodeFacturationMensuelle(w, r)
+	if !ok {
 		return
 	}
 
 	log.Printf("🔍 Preview facturation mensuelle: mois=%d, année=%d, clients=%v", req.Mois, req.Annee, req.ClientIDs)
 
-	// Valider les paramètres
-	if req.Mois < 1 || req.Mois > 12 {
-		http.Error(w, "Le mois doit être entre 1 et 12", http.StatusBadRequest)
-		return
-	}
-	if req.Annee < 2020 || req.Annee > 2030 {
-		http.Error(w, "L'année doit être entre 2020 et 2030", http.StatusBadRequest)
+	entreprise := entrepriseDocument(entrepriseID)
+	preview, err := chargerFacturationMensuelle(config.DB, entrepriseID, req, devisConfig(entreprise).DefaultTVA)
+	if err != nil {
+		log.Printf("❌ Erreur requête plannings: %v", err)
+		http.Error(w, "Erreur base de données", http.StatusInternalServerError)
 		return
 	}
 
-	// Calculer les dates de début et fin du mois
-	dateDebut := time.Date(req.Annee, time.Month(req.Mois), 1, 0, 0, 0, 0, time.UTC)
-	dateFin := dateDebut.AddDate(0, 1, -1).Add(23*time.Hour + 59*time.Minute + 59*time.Second)
-
-	log.Printf("📅 Période: %v à %v", dateDebut.Format("2006-01-02"), dateFin.Format("2006-01-02"))
-
-	// Requête pour récupérer les plannings facturables
-	query := config.DB.Table("plannings").
-		Select(`
-			plannings.date,
-			plannings.client_id,
-			clients.nom as client_nom,
-			clients.prenom as client_prenom,
-			clients.raison_sociale,
-			clients.type_client,
-			plannings.prestation,
-			plannings.objet,
-			plannings.duree,
-			plannings.taux_horaire,
-			plannings.forfait_ht,
-			plannings.type_evenement
-		`).
-		Joins("JOIN clients ON plannings.client_id = clients.id").
-		Where("plannings.date >= ? AND plannings.date <= ?", dateDebut, dateFin).
-		Where("plannings.type_evenement IN (?)", []string{"Intervention", "Formation", "Divers"}).
-		Where("(plannings.taux_horaire IS NOT NULL AND plannings.taux_horaire > 0) OR (plannings.forfait_ht IS NOT NULL AND plannings.forfait_ht > 0)")
+	log.Printf("✅ Preview généré: %d clients, %d prestations, %s€ TTC",
+		preview.NbClients, preview.NbPrestations, preview.TotalGeneralTTC)
 
-	// Filtrer par clients si spécifié
-	if len(req.ClientIDs) > 0 {
-		query = query.Where("plannings.client_id IN (?)", req.ClientIDs)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// CreateFacturationMensuelle godoc
+// @Summary Lancer la facturation mensuelle
+// @Description Émet une facture par client pour les prestations "À facturer" du mois. Chaque planning passe à l'état "Facturé", rattaché à sa ligne de facture. Le traitement est atomique : en cas d'erreur, aucune facture n'est créée.
+// @Tags Facturation mensuelle
+// @Accept json
+// @Produce json
+// @Param demande body FacturationMensuelleRequest true "Mois, année et clients éventuels"
+// @Success 201 {object} FacturationMensuelleCreateResponse
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 409 {string} string "Planning déjà facturé"
+// @Failure 500 {string} string "Erreur lors de la facturation"
+// @Router /facturation-mensuelle [post]
+func CreateFacturationMensuelle(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
 	}
 
-	// Exécuter la requête
-	rows, err := query.Rows()
-	if err != nil {
-		log.Printf("❌ Erreur requête plannings: %v", err)
-		http.Error(w, fmt.Sprintf("Erreur base de données: %v", err), http.StatusInternalServerError)
+	req, ok := decodeFacturationMensuelle(w, r)
+	if !ok {
 		return
 	}
-	defer rows.Close()
 
-	clientsMap := make(map[uint]*ClientFacturation)
-	var totalGeneralHT, totalGeneralTTC float64
-	var nbPrestationsTotal int
-
-	// Traiter chaque ligne
-	for rows.Next() {
-		var date time.Time
-		var clientID uint
-		var clientNom, clientPrenom, raisonSociale, typeClient, prestation, objet, typeEvenement string
-		var duree, tauxHoraire, forfaitHT *float64
-
-		err := rows.Scan(&date, &clientID, &clientNom, &clientPrenom, &raisonSociale, &typeClient,
-			&prestation, &objet, &duree, &tauxHoraire, &forfaitHT, &typeEvenement)
-		if err != nil {
-			log.Printf("❌ Erreur scan ligne: %v", err)
-			continue
-		}
+	log.Printf("💰 Création facturation mensuelle: mois=%d, année=%d, clients=%v", req.Mois, req.Annee, req.ClientIDs)
 
-		// Calculer le nom du client
-		var nomComplet string
-		if typeClient == "professionnel" && raisonSociale != "" {
-			nomComplet = raisonSociale
-		} else {
-			nomComplet = fmt.Sprintf("%s %s", clientPrenom, clientNom)
-		}
+	entreprise := entrepriseDocument(entrepriseID)
+	tauxTVA := devisConfig(entreprise).DefaultTVA
 
-		// Déterminer le type de facturation et calculer le montant
-		var typeFacturation string
-		var montantHT float64
+	response := FacturationMensuelleCreateResponse{FacturesCreees: []FactureCreee{}}
+	acteur := acteurEntreprise(r, entrepriseID)
 
-		if tauxHoraire != nil && *tauxHoraire > 0 && duree != nil && *duree > 0 {
-			typeFacturation = "horaire"
-			montantHT = *tauxHoraire * *duree
-		} else if forfaitHT != nil && *forfaitHT > 0 {
-			typeFacturation = "forfait"
-			montantHT = *forfaitHT
-		} else {
-			log.Printf("⚠️ Prestation non facturable: clientID=%d, date=%v", clientID, date)
-			continue
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// Les occurrences des plannings récurrents sont enregistrées pour être rattachées à leur ligne de facture
+		debut, fin := periodeMois(req)
+		if err := materialiserOccurrences(tx, entrepriseID, debut, fin, occurrenceAFacturer(req)); err != nil {
+			return err
 		}
 
-		tauxTVA := 20.0 // TVA par défaut
-		montantTTC := montantHT * (1 + tauxTVA/100)
-
-		// Créer la prestation
-		prestationFacturable := PrestationFacturable{
-			Date:            date.Format("2006-01-02"),
-			ClientID:        clientID,
-			ClientNom:       nomComplet,
-			Prestation:      prestation,
-			Objet:           objet,
-			Duree:           getFloat64Value(duree),
-			TauxHoraire:     getFloat64Value(tauxHoraire),
-			ForfaitHT:       getFloat64Value(forfaitHT),
-			TypeFacturation: typeFacturation,
-			MontantHT:       montantHT,
-			MontantTTC:      montantTTC,
-			TauxTVA:         tauxTVA,
+		// Les plannings sont verrouillés jusqu'au commit : un traitement concurrent attend puis les voit facturés
+		preview, err := chargerFacturationMensuelle(tx.Clauses(clause.Locking{Strength: "UPDATE"}), entrepriseID, req, tauxTVA)
+		if err != nil {
+			return err
 		}
 
-		// Ajouter au client
-		if clientsMap[clientID] == nil {
-			clientsMap[clientID] = &ClientFacturation{
-				ClientID:    clientID,
-				ClientNom:   nomComplet,
-				Prestations: []PrestationFacturable{},
+		for _, clientFacturation := range preview.ClientsFacturation {
+			facture, err := creerFactureMensuelle(tx, entreprise, req, clientFacturation, acteur)
+			if err != nil {
+				return err
 			}
-		}
-
-		client := clientsMap[clientID]
-		client.Prestations = append(client.Prestations, prestationFacturable)
-		client.TotalHT += montantHT
-		client.TotalTTC += montantTTC
 
-		if typeFacturation == "horaire" {
-			client.NbHeures += getFloat64Value(duree)
-		} else {
-			client.NbForfaits++
+			response.FacturesCreees = append(response.FacturesCreees, FactureCreee{
+				ID:        facture.ID,
+				Reference: facture.Reference,
+				ClientNom: facture.ClientNom,
+				TotalHT:   facture.SousTotalHT,
+				TotalTTC:  facture.TotalTTC,
+			})
+			response.TotalHT += facture.SousTotalHT
+			response.TotalTTC += facture.TotalTTC
+
+			log.Printf("✅ Facture créée: %s pour %s (%s€ TTC)", facture.Reference, facture.ClientNom, facture.TotalTTC)
 		}
+		return nil
+	})
 
-		totalGeneralHT += montantHT
-		totalGeneralTTC += montantTTC
-		nbPrestationsTotal++
-	}
-
-	// Convertir la map en slice
-	var clientsFacturation []ClientFacturation
-	for _, client := range clientsMap {
-		clientsFacturation = append(clientsFacturation, *client)
+	switch {
+	case err == nil:
+	case err == errPlanningDejaFacture:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		log.Printf("❌ Erreur facturation mensuelle: %v", err)
+		http.Error(w, "Erreur lors de la facturation mensuelle, aucune facture n'a été créée", http.StatusInternalServerError)
+		return
 	}
 
-	response := FacturationMensuelleResponse{
-		Mois:               req.Mois,
-		NomMois:            nomsMois[req.Mois],
-		Annee:              req.Annee,
-		NbClients:          len(clientsFacturation),
-		NbPrestations:      nbPrestationsTotal,
-		TotalGeneralHT:     totalGeneralHT,
-		TotalGeneralTTC:    totalGeneralTTC,
-		ClientsFacturation: clientsFacturation,
-	}
+	response.NbFactures = len(response.FacturesCreees)
 
-	log.Printf("✅ Preview généré: %d clients, %d prestations, %.2f€ TTC",
-		response.NbClients, response.NbPrestations, response.TotalGeneralTTC)
+	log.Printf("🎉 Facturation mensuelle terminée: %d factures créées, %s€ TTC total",
+		response.NbFactures, response.TotalTTC)
 
+	w.Header().Set("Content-Type", "application/json")
+	if response.NbFactures > 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
-// CreateFacturationMensuelle crée les factures pour le mois sélectionné
-func CreateFacturationMensuelle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
+// decodeFacturationMensuelle lit et valide la demande de facturation mensuelle
+func decodeFacturationMensuelle(w http.ResponseWriter, r *http.Request) (FacturationMensuelleRequest, bool) {
 	var req FacturationMensuelleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("Erreur de parsing JSON: %v", err), http.StatusBadRequest)
-		return
+		return req, false
 	}
 
-	log.Printf("💰 Création facturation mensuelle: mois=%d, année=%d, clients=%v", req.Mois, req.Annee, req.ClientIDs)
+	if req.Mois < 1 || req.Mois > 12 {
+		http.Error(w, "Le mois doit être entre 1 et 12", http.StatusBadRequest)
+		return req, false
+	}
+	if req.Annee < 2020 || req.Annee > 2100 {
+		http.Error(w, "L'année doit être entre 2020 et 2100", http.StatusBadRequest)
+		return req, false
+	}
 
-	// Récupérer d'abord l'aperçu pour avoir les données
-	preview, err := getFacturationMensuelleData(req)
-	if err != nil {
-		log.Printf("❌ Erreur récupération données: %v", err)
-		http.Error(w, fmt.Sprintf("Erreur récupération données: %v", err), http.StatusInternalServerError)
-		return
+	return req, true
+}
+
+// chargerFacturationMensuelle regroupe par client les plannings facturables du mois à l'état "À facturer".
+// Les clients sont triés par nom et leurs prestations par date, pour un résultat stable entre l'aperçu et la création.
+func chargerFacturationMensuelle(db *gorm.DB, entrepriseID uint, req FacturationMensuelleRequest, tauxTVA float64) (*FacturationMensuelleResponse, error) {
+	debut, fin := periodeMois(req)
+
+	query := db.Preload("Client").
+		Where("entreprise_id = ?", entrepriseID).
+		Where("date >= ? AND date <= ?", debut, fin).
+		Where("type_evenement IN ?", typesEvenementsFacturables).
+		Where("facturation = ? AND ligne_facture_id IS NULL", models.FacturationAFacturer).
+		Where("(taux_horaire IS NOT NULL AND taux_horaire > 0) OR (forfait_ht IS NOT NULL AND forfait_ht > 0)")
+
+	// Filtrer par clients si spécifié
+	if len(req.ClientIDs) > 0 {
+		query = query.Where("client_id IN ?", req.ClientIDs)
+	}
+
+	var plannings []models.Planning
+	if err := query.Order("date, heure_debut, id").Find(&plannings).Error; err != nil {
+		return nil, err
 	}
 
-	if preview.NbClients == 0 {
-		response := FacturationMensuelleCreateResponse{
-			FacturesCreees: []FactureCreee{},
-			NbFactures:     0,
-			TotalHT:        0,
-			TotalTTC:       0,
+	// Occurrences des plannings récurrents pas encore enregistrées (aperçu uniquement : la création les enregistre avant),
+	// calculées sur la même connexion que la requête mais sans son verrou
+	occurrences, err := occurrencesVirtuelles(db.Session(&gorm.Session{NewDB: true}), entrepriseID, debut, fin)
+	if err != nil {
+		return nil, err
+	}
+	garder := occurrenceAFacturer(req)
+	for i := range occurrences {
+		if garder(&occurrences[i]) {
+			plannings = append(plannings, occurrences[i])
 		}
-		json.NewEncoder(w).Encode(response)
-		return
 	}
+	trierPlannings(plannings)
 
-	var facturesCreees []FactureCreee
-	var totalHT, totalTTC float64
+	response := &FacturationMensuelleResponse{
+		Mois:               req.Mois,
+		NomMois:            nomsMois[req.Mois],
+		Annee:              req.Annee,
+		ClientsFacturation: []ClientFacturation{},
+	}
 
-	// Créer une facture pour chaque client
-	for _, clientFacturation := range preview.ClientsFacturation {
-		// Récupérer les informations complètes du client
-		var client models.Client
-		if err := config.DB.First(&client, clientFacturation.ClientID).Error; err != nil {
-			log.Printf("❌ Erreur récupération client %d: %v", clientFacturation.ClientID, err)
-			continue
-		}
+	clientsMap := make(map[uint]*ClientFacturation)
+	var ordreClients []uint
 
-		// Générer une référence unique
-		reference := fmt.Sprintf("FAC-%d-%02d-%03d", req.Annee, req.Mois, clientFacturation.ClientID)
-
-		// Créer la facture avec les bons champs
-		facture := models.Facture{
-			Reference:       reference,
-			ClientID:        client.ID,
-			ClientNom:       clientFacturation.ClientNom,
-			ClientAdresse:   fmt.Sprintf("%s, %s %s", client.Adresse, client.CodePostal, client.Ville),
-			ClientEmail:     client.Email,
-			ClientTelephone: client.Telephone,
-			DateCreation:    time.Now(),
-			DateEmission:    time.Now(),
-			DateEcheance:    time.Now().AddDate(0, 0, 30), // 30 jours
-			Description:     fmt.Sprintf("Facturation %s %d", nomsMois[req.Mois], req.Annee),
-			TypeFacture:     "classique",
-			SousTotalHT:     clientFacturation.TotalHT,
-			TauxTVA:         20.0,
-			TotalTVA:        clientFacturation.TotalHT * 0.2,
-			TotalTTC:        clientFacturation.TotalTTC,
-			MontantTVA:      clientFacturation.TotalHT * 0.2,
-			Statut:          "en_attente",
-			LieuSignature:   "Paris",
-			DateSignature:   time.Now().Format("02/01/2006"),
-		}
+	for i := range plannings {
+		planning := &plannings[i]
 
-		// Sauvegarder la facture
-		if err := config.DB.Create(&facture).Error; err != nil {
-			log.Printf("❌ Erreur création facture pour client %d: %v", client.ID, err)
+		prestation, ok := prestationFacturable(planning, tauxTVA)
+		if !ok {
+			log.Printf("⚠️ Prestation non facturable: planning=%d, date=%s, %s-%s", planning.ID, planning.Date, planning.HeureDebut, planning.HeureFin)
 			continue
 		}
 
-		// Créer les lignes de facture
-		for _, prestation := range clientFacturation.Prestations {
-			ligne := models.LigneFacture{
-				FactureID:    facture.ID,
-				Description:  fmt.Sprintf("%s - %s (%s)", prestation.Prestation, prestation.Objet, prestation.Date),
-				Unite:        "prestation",
-				Quantite:     1,
-				PrixUnitaire: prestation.MontantHT,
-				TotalLigne:   prestation.MontantHT,
-				TauxTVA:      prestation.TauxTVA,
-				MontantHT:    prestation.MontantHT,
-				MontantTTC:   prestation.MontantTTC,
-			}
-
-			if err := config.DB.Create(&ligne).Error; err != nil {
-				log.Printf("❌ Erreur création ligne facture: %v", err)
+		client := clientsMap[planning.ClientID]
+		if client == nil {
+			client = &ClientFacturation{
+				ClientID:    planning.ClientID,
+				ClientNom:   prestation.ClientNom,
+				Prestations: []PrestationFacturable{},
 			}
+			clientsMap[planning.ClientID] = client
+			ordreClients = append(ordreClients, planning.ClientID)
 		}
 
-		factureCreee := FactureCreee{
-			ID:        facture.ID,
-			Reference: facture.Reference,
-			ClientNom: facture.ClientNom,
-			TotalHT:   facture.SousTotalHT,
-			TotalTTC:  facture.TotalTTC,
+		client.Prestations = append(client.Prestations, prestation)
+		client.TotalHT += prestation.MontantHT
+		if prestation.TypeFacturation == "horaire" {
+			client.NbHeures += prestation.Duree
+		} else {
+			client.NbForfaits++
 		}
 
-		facturesCreees = append(facturesCreees, factureCreee)
-		totalHT += facture.SousTotalHT
-		totalTTC += facture.TotalTTC
-
-		log.Printf("✅ Facture créée: %s pour %s (%.2f€ TTC)", facture.Reference, facture.ClientNom, facture.TotalTTC)
+		response.TotalGeneralHT += prestation.MontantHT
+		response.NbPrestations++
 	}
 
-	response := FacturationMensuelleCreateResponse{
-		FacturesCreees: facturesCreees,
-		NbFactures:     len(facturesCreees),
-		TotalHT:        totalHT,
-		TotalTTC:       totalTTC,
+	// La TVA est calculée sur le total de chaque facture, comme à sa création
+	for _, clientID := range ordreClients {
+		client := clientsMap[clientID]
+		client.TotalTTC = client.TotalHT + client.TotalHT.Pourcentage(tauxTVA)
+		response.TotalGeneralTTC += client.TotalTTC
+		response.ClientsFacturation = append(response.ClientsFacturation, *client)
 	}
+	sort.SliceStable(response.ClientsFacturation, func(i, j int) bool {
+		return response.ClientsFacturation[i].ClientNom < response.ClientsFacturation[j].ClientNom
+	})
+	response.NbClients = len(response.ClientsFacturation)
 
-	log.Printf("🎉 Facturation mensuelle terminée: %d factures créées, %.2f€ TTC total",
-		response.NbFactures, response.TotalTTC)
+	return response, nil
+}
 
-	json.NewEncoder(w).Encode(response)
+// periodeMois retourne le premier et le dernier jour (AAAA-MM-JJ) du mois facturé.
+// Les dates des plannings sont stockées dans ce format : la comparaison de chaînes suit l'ordre chronologique.
+func periodeMois(req FacturationMensuelleRequest) (string, string) {
+	premierJour := time.Date(req.Annee, time.Month(req.Mois), 1, 0, 0, 0, 0, time.UTC)
+	return premierJour.Format("2006-01-02"), premierJour.AddDate(0, 1, -1).Format("2006-01-02")
 }
 
-// getFacturationMensuelleData récupère les données de facturation (fonction helper)
-func getFacturationMensuelleData(req FacturationMensuelleRequest) (*FacturationMensuelleResponse, error) {
-	// Calculer les dates de début et fin du mois
-	dateDebut := time.Date(req.Annee, time.Month(req.Mois), 1, 0, 0, 0, 0, time.UTC)
-	dateFin := dateDebut.AddDate(0, 1, -1).Add(23*time.Hour + 59*time.Minute + 59*time.Second)
-
-	// Requête pour récupérer les plannings facturables
-	query := config.DB.Table("plannings").
-		Select(`
-			plannings.date,
-			plannings.client_id,
-			clients.nom as client_nom,
-			clients.prenom as client_prenom,
-			clients.raison_sociale,
-			clients.type_client,
-			plannings.prestation,
-			plannings.objet,
-			plannings.duree,
-			plannings.taux_horaire,
-			plannings.forfait_ht
-		`).
-		Joins("JOIN clients ON plannings.client_id = clients.id").
-		Where("plannings.date >= ? AND plannings.date <= ?", dateDebut, dateFin).
-		Where("plannings.type_evenement IN (?)", []string{"Intervention", "Formation", "Divers"}).
-		Where("(plannings.taux_horaire IS NOT NULL AND plannings.taux_horaire > 0) OR (plannings.forfait_ht IS NOT NULL AND plannings.forfait_ht > 0)")
+// occurrenceAFacturer retient les occurrences calculées qui répondent aux critères de la facturation mensuelle
+func occurrenceAFacturer(req FacturationMensuelleRequest) func(*models.Planning) bool {
+	return func(planning *models.Planning) bool {
+		if models.MontantOuZero(planning.TauxHoraire) <= 0 && models.MontantOuZero(planning.ForfaitHT) <= 0 {
+			return false
+		}
+		return estAFacturer(planning, req.ClientIDs)
+	}
+}
 
-	if len(req.ClientIDs) > 0 {
-		query = query.Where("plannings.client_id IN (?)", req.ClientIDs)
+// prestationFacturable calcule le montant d'un planning : taux horaire × durée si la durée est connue, sinon forfait
+func prestationFacturable(planning *models.Planning, tauxTVA float64) (PrestationFacturable, bool) {
+	prestation := PrestationFacturable{
+		PlanningID:  planning.ID,
+		Date:        planning.Date,
+		ClientID:    planning.ClientID,
+		ClientNom:   planning.Client.GetDisplayName(),
+		Prestation:  getStringValue(planning.Prestation),
+		Objet:       getStringValue(planning.Objet),
+		TauxHoraire: models.MontantOuZero(planning.TauxHoraire),
+		ForfaitHT:   models.MontantOuZero(planning.ForfaitHT),
+		TauxTVA:     tauxTVA,
 	}
 
-	rows, err := query.Rows()
-	if err != nil {
-		return nil, err
+	duree, err := planning.Duree()
+	switch {
+	case err == nil && duree > 0 && prestation.TauxHoraire > 0:
+		prestation.TypeFacturation = "horaire"
+		prestation.Duree = math.Round(duree*100) / 100
+		prestation.MontantHT = prestation.TauxHoraire.Multiplier(prestation.Duree)
+	case prestation.ForfaitHT > 0:
+		prestation.TypeFacturation = "forfait"
+		prestation.MontantHT = prestation.ForfaitHT
+	default:
+		return prestation, false
 	}
-	defer rows.Close()
 
-	clientsMap := make(map[uint]*ClientFacturation)
-	var totalGeneralHT, totalGeneralTTC float64
-	var nbPrestationsTotal int
+	prestation.MontantTTC = prestation.MontantHT + prestation.MontantHT.Pourcentage(tauxTVA)
+	return prestation, true
+}
 
-	for rows.Next() {
-		var date time.Time
-		var clientID uint
-		var clientNom, clientPrenom, raisonSociale, typeClient, prestation, objet string
-		var duree, tauxHoraire, forfaitHT *float64
+// creerFactureMensuelle crée et émet la facture d'un client, puis rattache chaque planning à sa ligne de facture.
+// Elle doit être appelée dans la transaction qui a chargé (et verrouillé) les plannings.
+func creerFactureMensuelle(tx *gorm.DB, entreprise models.Entreprise, req FacturationMensuelleRequest, clientFacturation ClientFacturation, acteur acteurTransition) (*models.Facture, error) {
+	now := time.Now()
+
+	facture := models.Facture{
+		ClientID:      clientFacturation.ClientID,
+		DateCreation:  now,
+		DateEcheance:  now.AddDate(0, 0, 30), // 30 jours
+		Description:   fmt.Sprintf("Facturation %s %d", nomsMois[req.Mois], req.Annee),
+		TypeFacture:   "classique",
+		Statut:        models.StatutFactureBrouillon,
+		LieuSignature: entreprise.Ville,
+		DateSignature: now.Format("02/01/2006"),
+		EntrepriseID:  entreprise.ID,
+	}
 
-		err := rows.Scan(&date, &clientID, &clientNom, &clientPrenom, &raisonSociale, &typeClient,
-			&prestation, &objet, &duree, &tauxHoraire, &forfaitHT)
-		if err != nil {
-			continue
+	for _, prestation := range clientFacturation.Prestations {
+		ligne := models.LigneFacture{
+			Description: descriptionPrestation(prestation),
+			TauxTVA:     prestation.TauxTVA,
+			MontantHT:   prestation.MontantHT,
+			TotalLigne:  prestation.MontantHT,
+			MontantTTC:  prestation.MontantTTC,
 		}
-
-		var nomComplet string
-		if typeClient == "professionnel" && raisonSociale != "" {
-			nomComplet = raisonSociale
+		if prestation.TypeFacturation == "horaire" {
+			ligne.Unite = models.UniteHeure
+			ligne.Quantite = prestation.Duree
+			ligne.PrixUnitaire = prestation.TauxHoraire
 		} else {
-			nomComplet = fmt.Sprintf("%s %s", clientPrenom, clientNom)
+			ligne.Unite = models.UniteForfait
+			ligne.Quantite = 1
+			ligne.PrixUnitaire = prestation.MontantHT
 		}
+		facture.Lignes = append(facture.Lignes, ligne)
+	}
 
-		var typeFacturation string
-		var montantHT float64
+	// Sans TVA, la facture porte l'exonération de l'entreprise et sa mention légale
+	facture.MotifExonerationTVA = motifExonerationParDefaut(devisConfig(entreprise), tauxLignesFacture(facture.Lignes))
+	totaliserFacture(&facture)
 
-		if tauxHoraire != nil && *tauxHoraire > 0 && duree != nil && *duree > 0 {
-			typeFacturation = "horaire"
-			montantHT = *tauxHoraire * *duree
-		} else if forfaitHT != nil && *forfaitHT > 0 {
-			typeFacturation = "forfait"
-			montantHT = *forfaitHT
-		} else {
-			continue
-		}
+	if err := tx.Create(&facture).Error; err != nil {
+		return nil, err
+	}
 
-		tauxTVA := 20.0
-		montantTTC := montantHT * (1 + tauxTVA/100)
-
-		prestationFacturable := PrestationFacturable{
-			Date:            date.Format("2006-01-02"),
-			ClientID:        clientID,
-			ClientNom:       nomComplet,
-			Prestation:      prestation,
-			Objet:           objet,
-			Duree:           getFloat64Value(duree),
-			TauxHoraire:     getFloat64Value(tauxHoraire),
-			ForfaitHT:       getFloat64Value(forfaitHT),
-			TypeFacturation: typeFacturation,
-			MontantHT:       montantHT,
-			MontantTTC:      montantTTC,
-			TauxTVA:         tauxTVA,
-		}
-
-		if clientsMap[clientID] == nil {
-			clientsMap[clientID] = &ClientFacturation{
-				ClientID:    clientID,
-				ClientNom:   nomComplet,
-				Prestations: []PrestationFacturable{},
-			}
+	// Les lignes sont créées dans l'ordre des prestations : chaque planning pointe vers la sienne
+	for i, prestation := range clientFacturation.Prestations {
+		if err := marquerPlanningFacture(tx, entreprise.ID, prestation.PlanningID, facture.Lignes[i].ID); err != nil {
+			return nil, err
 		}
+	}
 
-		client := clientsMap[clientID]
-		client.Prestations = append(client.Prestations, prestationFacturable)
-		client.TotalHT += montantHT
-		client.TotalTTC += montantTTC
+	if err := emettreFacture(tx, &facture, acteur); err != nil {
+		return nil, err
+	}
+	return &facture, nil
+}
 
-		if typeFacturation == "horaire" {
-			client.NbHeures += getFloat64Value(duree)
-		} else {
-			client.NbForfaits++
+// descriptionPrestation retourne le libellé d'une ligne de facture mensuelle, ex. « Ménage - Bureaux (15/06/2025) »
+func descriptionPrestation(prestation PrestationFacturable) string {
+	libelle := prestation.Prestation
+	if prestation.Objet != "" {
+		if libelle != "" {
+			libelle += " - "
 		}
-
-		totalGeneralHT += montantHT
-		totalGeneralTTC += montantTTC
-		nbPrestationsTotal++
+		libelle += prestation.Objet
 	}
-
-	var clientsFacturation []ClientFacturation
-	for _, client := range clientsMap {
-		clientsFacturation = append(clientsFacturation, *client)
+	if libelle == "" {
+		libelle = "Prestation"
 	}
 
-	return &FacturationMensuelleResponse{
-		Mois:               req.Mois,
-		NomMois:            nomsMois[req.Mois],
-		Annee:              req.Annee,
-		NbClients:          len(clientsFacturation),
-		NbPrestations:      nbPrestationsTotal,
-		TotalGeneralHT:     totalGeneralHT,
-		TotalGeneralTTC:    totalGeneralTTC,
-		ClientsFacturation: clientsFacturation,
-	}, nil
+	if date, err := time.Parse("2006-01-02", prestation.Date); err == nil {
+		return fmt.Sprintf("%s (%s)", libelle, date.Format("02/01/2006"))
+	}
+	return fmt.Sprintf("%s (%s)", libelle, prestation.Date)
 }
 
-// getFloat64Value retourne la valeur d'un pointeur float64 ou 0 si nil
-func getFloat64Value(ptr *float64) float64 {
+// getStringValue retourne la valeur d'un pointeur string ou une chaîne vide si nil
+func getStringValue(ptr *string) string {
 	if ptr == nil {
-		return 0
+		return ""
 	}
 	return *ptr
 }
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"facturation-planning/config"
+	"facturation-planning/database/basetest"
+	"facturation-planning/models"
+)
+
+func TestFacturationMensuelleUneFoisParMois(t *testing.T) {
+	basetest.Exiger(t)
+	db := config.DB
+
+	entreprise := models.Entreprise{Nom: "Services Mensuels", Email: "mensuel@exemple.test", Ville: "Lyon"}
+	if err := db.Create(&entreprise).Error; err != nil {
+		t.Fatal(err)
+	}
+	salarie := models.Salarie{Nom: "Paul Martin", EntrepriseID: entreprise.ID}
+	if err := db.Create(&salarie).Error; err != nil {
+		t.Fatal(err)
+	}
+	nomA, nomB := "Bureaux Alpha", "Cabinet Bêta"
+	clientA := models.Client{TypeClient: "professionnel", NomOrganisme: &nomA, EntrepriseID: entreprise.ID}
+	clientB := models.Client{TypeClient: "professionnel", NomOrganisme: &nomB, EntrepriseID: entreprise.ID}
+	for _, client := range []*models.Client{&clientA, &clientB} {
+		if err := db.Create(client).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	taux, forfait := models.MontantEuros(40), models.MontantEuros(150)
+	planning := func(client models.Client, date, debut, fin string, facturation string) *models.Planning {
+		p := &models.Planning{
+			Date: date, HeureDebut: debut, HeureFin: fin, TypeEvenement: models.TypeIntervention,
+			SalarieID: salarie.ID, ClientID: client.ID, Facturation: facturation, EntrepriseID: entreprise.ID,
+		}
+		if debut != "" {
+			p.TauxHoraire = &taux
+		} else {
+			p.ForfaitHT = &forfait
+		}
+		return p
+	}
+	juinA1 := planning(clientA, "2025-06-03", "09:00", "12:00", models.FacturationAFacturer) // 3 h × 40 €
+	juinA2 := planning(clientA, "2025-06-17", "", "", models.FacturationAFacturer)           // forfait 150 €
+	juinB := planning(clientB, "2025-06-30", "14:00", "16:30", models.FacturationAFacturer)  // 2,5 h × 40 €
+	offert := planning(clientB, "2025-06-10", "09:00", "10:00", models.FacturationOffert)
+	juillet := planning(clientA, "2025-07-01", "09:00", "12:00", models.FacturationAFacturer)
+	for _, p := range []*models.Planning{juinA1, juinA2, juinB, offert, juillet} {
+		if err := db.Create(p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	facturer := func() (int, FacturationMensuelleCreateResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		CreateFacturationMensuelle(rec, requeteEntreprise(entreprise.ID, "POST", `{"mois":6,"annee":2025}`))
+		var response FacturationMensuelleCreateResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("réponse illisible (%d) : %s", rec.Code, rec.Body.String())
+		}
+		return rec.Code, response
+	}
+
+	// Premier passage : une facture émise par client, avec ses prestations de juin
+	code, response := facturer()
+	if code != http.StatusCreated || response.NbFactures != 2 {
+		t.Fatalf("premier passage : statut %d, %d factures, attendu 201 et 2", code, response.NbFactures)
+	}
+	attendus := map[uint]struct {
+		lignes  int
+		totalHT models.Montant
+	}{
+		clientA.ID: {2, models.MontantEuros(270)},
+		clientB.ID: {1, models.MontantEuros(100)},
+	}
+	for _, creee := range response.FacturesCreees {
+		var facture models.Facture
+		if err := db.Preload("Lignes").First(&facture, creee.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		attendu, ok := attendus[facture.ClientID]
+		if !ok {
+			t.Fatalf("facture %s pour un client inattendu (%d)", facture.Reference, facture.ClientID)
+		}
+		delete(attendus, facture.ClientID)
+		if facture.Statut != models.StatutFactureEmise || facture.Reference == "" {
+			t.Errorf("facture %d : statut %q, référence %q, attendu émise et numérotée", facture.ID, facture.Statut, facture.Reference)
+		}
+		if len(facture.Lignes) != attendu.lignes || facture.SousTotalHT != attendu.totalHT {
+			t.Errorf("facture %s : %d lignes, %s HT, attendu %d lignes, %s HT",
+				facture.Reference, len(facture.Lignes), facture.SousTotalHT, attendu.lignes, attendu.totalHT)
+		}
+	}
+
+	// Les plannings facturés sont rattachés à une ligne ; les autres ne sont pas touchés
+	etats := map[*models.Planning]string{
+		juinA1:  models.FacturationFacture,
+		juinA2:  models.FacturationFacture,
+		juinB:   models.FacturationFacture,
+		offert:  models.FacturationOffert,
+		juillet: models.FacturationAFacturer,
+	}
+	for p, attendu := range etats {
+		var relu models.Planning
+		if err := db.First(&relu, p.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if relu.Facturation != attendu || (relu.LigneFactureID != nil) != (attendu == models.FacturationFacture) {
+			t.Errorf("planning du %s : %q (ligne %v), attendu %q", relu.Date, relu.Facturation, relu.LigneFactureID, attendu)
+		}
+	}
+
+	// Second passage sur le même mois : rien n'est facturé une seconde fois
+	code, response = facturer()
+	if code != http.StatusOK || response.NbFactures != 0 || len(response.FacturesCreees) != 0 {
+		t.Fatalf("second passage : statut %d, %d factures, attendu 200 et aucune", code, response.NbFactures)
+	}
+	var nbFactures int64
+	db.Model(&models.Facture{}).Where("entreprise_id = ?", entreprise.ID).Count(&nbFactures)
+	if nbFactures != 2 {
+		t.Fatalf("%d factures en base après deux passages, attendu 2", nbFactures)
+	}
+}
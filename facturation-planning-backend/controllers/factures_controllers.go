@@ -1,19 +1,29 @@
 package controllers
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
 	"facturation-planning/config"
 	"facturation-planning/models"
+	"facturation-planning/utils"
 	"fmt"
-	"html/template"
-	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/SebastiaanKlippert/go-wkhtmltopdf"
 	"github.com/go-chi/chi/v5"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Erreurs métier partagées par les contrôleurs de facturation
+var (
+	errFactureIntrouvable = errors.New("Facture introuvable")
+	errFactureAnnulee     = errors.New("La facture est déjà annulée")
+	errFactureScellee     = errors.New("La facture a été émise et ne peut plus être modifiée")
+	errFactureBrouillon   = errors.New("La facture est un brouillon : elle doit d'abord être émise")
+	errLignesFactureDevis = errors.New("Les lignes d'une facture issue d'un devis sont fixées par le devis : supprimez le brouillon et facturez à nouveau le devis")
 )
 
 // LigneFacture représente une ligne de facturation
@@ -21,10 +31,13 @@ type LigneFacture struct {
 	Designation  string
 	Unite        string
 	Quantite     float64
-	PrixUnitaire float64
-	MontantHT    float64
+	PrixUnitaire models.Montant
+	MontantHT    models.Montant
 	TVA          float64
-	MontantTTC   float64
+	MontantTTC   models.Montant
+
+	RemisePourcentage float64
+	RemiseMontant     models.Montant
 }
 
 // FacturePDFData structure pour les données du template PDF
@@ -40,19 +53,25 @@ type FacturePDFData struct {
 	ClientTelephone string
 	Description     string
 	Lignes          []LigneFacture
-	SousTotalHT     float64
-	TotalTVA        float64
-	TotalTTC        float64
+	SousTotalHT     models.Montant
+	TotalTVA        models.Montant
+	TotalTTC        models.Montant
+	VentilationTVA  []models.VentilationTVA
+	MentionTVA      string
+	ClientNumeroTVA string
 	TypeFacture     string
 	Statut          string
 	Company         config.CompanyInfo
 	LieuSignature   string
 	DateSignature   string
+	DevisReference  string
+
+	RemisePourcentage float64
 }
 
 // CreateFacture godoc
 // @Summary Créer une nouvelle facture
-// @Description Crée une nouvelle facture (standard ou d'avancement) avec validation
+// @Description Crée une nouvelle facture brouillon. Les montants des lignes et les totaux sont calculés par le serveur ; des montants transmis qui ne correspondent pas au calcul sont refusés.
 // @Tags Factures
 // @Accept json
 // @Produce json
@@ -62,6 +81,11 @@ type FacturePDFData struct {
 // @Failure 500 {string} string "Erreur interne du serveur"
 // @Router /api/factures [post]
 func CreateFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var facture models.Facture
 
 	if err := json.NewDecoder(r.Body).Decode(&facture); err != nil {
@@ -69,21 +93,31 @@ func CreateFacture(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// La facture est toujours rattachée à l'entreprise connectée
+	facture.ID = 0
+	facture.EntrepriseID = entrepriseID
+	reinitialiserSuiviDevis(facture.Lignes)
+	if facture.MotifExonerationTVA == "" {
+		facture.MotifExonerationTVA = motifExonerationParDefaut(devisConfig(entrepriseDocument(entrepriseID)), tauxLignesFacture(facture.Lignes))
+	}
+
 	// Validation des données de la facture
 	if err := validateFactureData(&facture); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Définir le statut par défaut si non spécifié
-	if facture.Statut == "" {
-		facture.Statut = "en attente"
+	// Une nouvelle facture est toujours un brouillon : la référence est attribuée à l'émission
+	facture.Statut = models.StatutFactureBrouillon
+	facture.Reference = ""
+	facture.HashContenu = ""
+	facture.EmiseLe = nil
+	facture.DevisID = nil // Seules les factures générées depuis un devis lui sont rattachées
+	if facture.DateCreation.IsZero() {
+		facture.DateCreation = time.Now()
 	}
 
-	// Générer un numéro de facture si non fourni
-	if facture.Reference == "" {
-		facture.Reference = generateFactureNumber()
-	}
+	facture.TotalAvoirs = 0
 
 	if err := config.DB.Create(&facture).Error; err != nil {
 		// log supprimé
@@ -92,6 +126,7 @@ func CreateFacture(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// log supprimé
+	facture.CompleterTVA()
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(facture)
@@ -99,67 +134,26 @@ func CreateFacture(w http.ResponseWriter, r *http.Request) {
 
 // CreateFactureFromDevis godoc
 // @Summary Créer une facture à partir d'un devis
-// @Description Génère une facture en copiant les infos d'un devis existant
+// @Description Génère une facture brouillon à partir de la révision acceptée du devis (lignes et totaux tels qu'acceptés par le client). Après des acomptes ou des situations, c'est la facture de solde : elle facture le reste de chaque ligne et déduit tous les acomptes.
 // @Tags Factures
 // @Accept json
 // @Produce json
 // @Param devisId path string true "ID du devis à convertir en facture"
 // @Success 201 {object} models.Facture "Facture créée depuis devis"
 // @Failure 404 {string} string "Devis introuvable"
+// @Failure 409 {string} string "Devis non accepté, facture en brouillon ou devis entièrement facturé"
 // @Failure 500 {string} string "Erreur interne du serveur"
 // @Router /api/factures/from-devis/{devisId} [post]
 func CreateFactureFromDevis(w http.ResponseWriter, r *http.Request) {
-	devisId := chi.URLParam(r, "devisId")
-	var devis models.Devis
-	if err := config.DB.Preload("Lignes").Preload("Client").Preload("Entreprise").First(&devis, devisId).Error; err != nil {
-		http.Error(w, "Devis introuvable", http.StatusNotFound)
-		return
-	}
-
-	facture := models.Facture{
-		Reference:       generateFactureNumber(),
-		ClientID:        devis.ClientID,
-		Client:          devis.Client,
-		ClientNom:       devis.Client.GetDisplayName(),
-		ClientAdresse:   devis.Client.Adresse,
-		ClientEmail:     devis.Client.Email,
-		ClientTelephone: devis.Client.Telephone,
-		DateCreation:    time.Now(),
-		DateEmission:    time.Now(),
-		DateEcheance:    time.Now().AddDate(0, 0, 30),
-		Description:     devis.Objet,
-		TypeFacture:     "classique",
-		SousTotalHT:     devis.SousTotalHT,
-		TotalTVA:        devis.TotalTVA,
-		TotalTTC:        devis.TotalTTC,
-		Statut:          "en attente",
-		LieuSignature:   devis.LieuSignature,
-		DateSignature:   devis.DateSignature,
-		EntrepriseID:    devis.EntrepriseID,
-		DevisReference:  fmt.Sprintf("DEV-%04d", devis.ID),
-	}
-
-	// Copier les lignes du devis dans la facture
-	for _, ligne := range devis.Lignes {
-		facture.Lignes = append(facture.Lignes, models.LigneFacture{
-			Description:  ligne.Description,
-			Unite:        "unité",
-			Quantite:     float64(ligne.Quantite),
-			PrixUnitaire: ligne.PrixUnitaire,
-			TotalLigne:   ligne.PrixUnitaire * float64(ligne.Quantite),
-			TauxTVA:      ligne.TVA,
-			MontantHT:    ligne.PrixUnitaire * float64(ligne.Quantite),
-			MontantTTC:   ligne.PrixUnitaire * float64(ligne.Quantite) * (1 + ligne.TVA/100),
-		})
-	}
-
-	if err := config.DB.Create(&facture).Error; err != nil {
-		http.Error(w, "Erreur lors de la création de la facture", http.StatusInternalServerError)
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(facture)
+	// La facture reprend la révision acceptée, jamais le contenu courant du devis
+	creerFactureDevis(w, r, entrepriseID, func(etat etatFacturationDevis) (models.Facture, error) {
+		return etat.factureSolde()
+	})
 }
 
 // GetAllFactures godoc
@@ -171,9 +165,14 @@ func CreateFactureFromDevis(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la récupération des factures"
 // @Router /api/factures [get]
 func GetAllFactures(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var factures []models.Facture
 
-	if err := config.DB.Find(&factures).Error; err != nil {
+	if err := tenantDB(entrepriseID).Find(&factures).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération des factures", http.StatusInternalServerError)
 		return
 	}
@@ -192,10 +191,15 @@ func GetAllFactures(w http.ResponseWriter, r *http.Request) {
 // @Failure 404 {string} string "Facture introuvable"
 // @Router /api/factures/{id} [get]
 func GetFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	var facture models.Facture
-	if err := config.DB.First(&facture, id).Error; err != nil {
+	if err := tenantDB(entrepriseID).First(&facture, id).Error; err != nil {
 		http.Error(w, "Facture introuvable", http.StatusNotFound)
 		return
 	}
@@ -205,8 +209,8 @@ func GetFacture(w http.ResponseWriter, r *http.Request) {
 }
 
 // UpdateFacture godoc
-// @Summary Mettre à jour une facture existante
-// @Description Met à jour une facture existante avec validation des données
+// @Summary Mettre à jour une facture brouillon
+// @Description Met à jour une facture encore au statut brouillon (les factures émises sont scellées)
 // @Tags Factures
 // @Accept json
 // @Produce json
@@ -214,9 +218,16 @@ func GetFacture(w http.ResponseWriter, r *http.Request) {
 // @Param facture body models.Facture true "Nouvelles données de la facture"
 // @Success 200 {object} models.Facture "Facture mise à jour avec succès"
 // @Failure 400 {string} string "Erreur de validation des données"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "Facture émise ou issue d'un devis, modification interdite"
 // @Failure 500 {string} string "Erreur lors de la mise à jour"
 // @Router /api/factures/{id} [put]
 func UpdateFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 	var facture models.Facture
 
@@ -225,19 +236,100 @@ func UpdateFacture(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Vérifier que la facture appartient à l'entreprise connectée
+	var existing models.Facture
+	if err := tenantDB(entrepriseID).First(&existing, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	// Une facture émise est scellée : lignes, montants, client et dates ne changent plus
+	if existing.EstScellee() {
+		http.Error(w, errFactureScellee.Error(), http.StatusConflict)
+		return
+	}
+
+	facture.ID = existing.ID
+	facture.EntrepriseID = entrepriseID
+	facture.Statut = models.StatutFactureBrouillon
+	facture.Reference = ""
+	facture.HashContenu = ""
+	facture.EmiseLe = nil
+
+	// Le solde est calculé par le serveur à partir des avoirs
+	facture.TotalAvoirs = existing.TotalAvoirs
+	facture.DevisID = existing.DevisID // Le rattachement au devis est fixé à la création depuis le devis
+	facture.ResteAPayer = 0
+
+	// Sans lignes transmises, celles du brouillon sont conservées et les totaux recalculés à partir d'elles
+	lignesTransmises := facture.Lignes != nil
+	if lignesTransmises && existing.DevisID != nil {
+		// Remplacer les lignes fausserait l'avancement et les acomptes suivis sur le devis
+		http.Error(w, errLignesFactureDevis.Error(), http.StatusConflict)
+		return
+	}
+	if lignesTransmises {
+		reinitialiserSuiviDevis(facture.Lignes)
+	} else {
+		if err := config.DB.Where("facture_id = ?", existing.ID).Order("id").Find(&facture.Lignes).Error; err != nil {
+			http.Error(w, "Erreur lors de la récupération des lignes de la facture", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Validation des données de la facture
 	if err := validateFactureData(&facture); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := config.DB.Model(&facture).Where("id = ?", id).Updates(facture).Error; err != nil {
+	lignes := facture.Lignes
+	facture.Lignes = nil
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&facture).Where("id = ?", existing.ID).Updates(facture).Error; err != nil {
+			return err
+		}
+		// Updates ignore les valeurs vides : le retour à une facture soumise à TVA ou sans remise et les totaux
+		// recalculés, même nuls, sont enregistrés explicitement
+		if err := tx.Model(&models.Facture{}).Where("id = ?", existing.ID).
+			Select("motif_exoneration_tva", "remise_pourcentage", "remise_montant", "taux_tva", "sous_total_ht", "total_tva", "total_ttc", "montant_tva").
+			Updates(facture).Error; err != nil {
+			return err
+		}
+
+		// Les lignes envoyées remplacent celles du brouillon
+		if lignesTransmises {
+			ids, err := lignesFactureIDs(tx, existing.ID)
+			if err != nil {
+				return err
+			}
+			if err := libererPlanningsLignes(tx, ids); err != nil {
+				return err
+			}
+			if err := tx.Where("facture_id = ?", existing.ID).Delete(&models.LigneFacture{}).Error; err != nil {
+				return err
+			}
+			for i := range lignes {
+				lignes[i].ID = 0
+				lignes[i].FactureID = existing.ID
+			}
+			if len(lignes) > 0 {
+				if err := tx.Create(&lignes).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return recalculerSoldeFacture(tx, existing.ID, acteurEntreprise(r, entrepriseID), "Modification du brouillon")
+	})
+	if err != nil {
 		http.Error(w, "Erreur lors de la mise à jour de la facture", http.StatusInternalServerError)
 		return
 	}
 
 	// Récupérer la facture mise à jour
-	if err := config.DB.First(&facture, id).Error; err != nil {
+	if err := config.DB.Preload("Lignes").First(&facture, existing.ID).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération de la facture", http.StatusInternalServerError)
 		return
 	}
@@ -247,17 +339,49 @@ func UpdateFacture(w http.ResponseWriter, r *http.Request) {
 }
 
 // DeleteFacture godoc
-// @Summary Supprimer une facture
-// @Description Supprime définitivement une facture du système
+// @Summary Supprimer une facture brouillon
+// @Description Supprime une facture encore au statut brouillon. Une facture émise se corrige par un avoir.
 // @Tags Factures
 // @Param id path string true "ID de la facture à supprimer"
 // @Success 204 "Facture supprimée avec succès"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "Facture émise, suppression interdite"
 // @Failure 500 {string} string "Erreur lors de la suppression"
 // @Router /api/factures/{id} [delete]
 func DeleteFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
-	if err := config.DB.Delete(&models.Facture{}, id).Error; err != nil {
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	if facture.EstScellee() {
+		http.Error(w, errFactureScellee.Error(), http.StatusConflict)
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// Les plannings rattachés aux lignes supprimées redeviennent à facturer
+		ids, err := lignesFactureIDs(tx, facture.ID)
+		if err != nil {
+			return err
+		}
+		if err := libererPlanningsLignes(tx, ids); err != nil {
+			return err
+		}
+		if err := tx.Where("facture_id = ?", facture.ID).Delete(&models.LigneFacture{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Facture{}, facture.ID).Error
+	})
+	if err != nil {
 		http.Error(w, "Erreur lors de la suppression de la facture", http.StatusInternalServerError)
 		return
 	}
@@ -265,76 +389,131 @@ func DeleteFacture(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// GenerateFacturePDF godoc
-// @Summary Générer un PDF de facture pour affichage
-// @Description Génère une facture au format PDF pour affichage dans le navigateur
+// EmettreFacture godoc
+// @Summary Émettre une facture
+// @Description Attribue la référence définitive, fige le client, les dates et les montants, et scelle la facture par une empreinte
 // @Tags Factures
-// @Produce application/pdf
-// @Param id path string true "ID de la facture à convertir en PDF"
-// @Success 200 {file} file "Fichier PDF généré pour affichage"
+// @Produce json
+// @Param id path string true "ID de la facture à émettre"
+// @Success 200 {object} models.Facture "Facture émise"
 // @Failure 404 {string} string "Facture introuvable"
-// @Failure 500 {string} string "Erreur lors de la génération du PDF"
-// @Router /api/factures/{id}/pdf [get]
-func GenerateFacturePDF(w http.ResponseWriter, r *http.Request) {
+// @Failure 409 {string} string "Facture déjà émise"
+// @Failure 500 {string} string "Erreur lors de l'émission"
+// @Router /factures/{id}/emettre [post]
+func EmettreFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	var facture models.Facture
-	if err := config.DB.Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
-		http.Error(w, "Facture introuvable", http.StatusNotFound)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("entreprise_id = ?", entrepriseID).First(&facture, id).Error; err != nil {
+			return errFactureIntrouvable
+		}
+
+		if err := tx.Where("facture_id = ?", facture.ID).Order("id").Find(&facture.Lignes).Error; err != nil {
+			return err
+		}
+
+		return emettreFacture(tx, &facture, acteurEntreprise(r, entrepriseID))
+	})
+
+	switch {
+	case err == errFactureIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errFactureScellee || err == errPlanningDejaFacture:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Erreur lors de l'émission de la facture : "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Préparer les données pour le template
-	data := prepareFacturePDFData(facture)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facture)
+}
 
-	// Template FuncMap avec fonctions utiles
-	funcMap := template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-		"formatPrice": func(price float64) string {
-			return fmt.Sprintf("%.2f €", price)
-		},
-		"formatPercent": func(percent float64) string {
-			return fmt.Sprintf("%.1f%%", percent)
-		},
-	}
+// VerificationFactureResponse représente le résultat du contrôle d'intégrité d'une facture
+type VerificationFactureResponse struct {
+	FactureID   uint   `json:"factureID" example:"1"`
+	Reference   string `json:"reference" example:"FAC-2025-0001"`
+	Scellee     bool   `json:"scellee" example:"true"`
+	HashStocke  string `json:"hashStocke"`
+	HashCalcule string `json:"hashCalcule"`
+	Integre     bool   `json:"integre" example:"true"`
+}
 
-	// Choisir le template selon le type de facture
-	templateName := "facture_final.html"
-	if facture.TypeFacture == "acompte" {
-		templateName = "facture_final.html" // même template avec mention du type
+// VerifierFacture godoc
+// @Summary Vérifier l'intégrité d'une facture émise
+// @Description Recalcule l'empreinte du contenu et la compare à celle enregistrée à l'émission
+// @Tags Factures
+// @Produce json
+// @Param id path string true "ID de la facture"
+// @Success 200 {object} VerificationFactureResponse
+// @Failure 404 {string} string "Facture introuvable"
+// @Router /factures/{id}/verification [get]
+func VerifierFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
 	}
 
-	tmpl, err := template.New(templateName).Funcs(funcMap).ParseFiles("templates/" + templateName)
-	if err != nil {
-		http.Error(w, "Erreur chargement template", http.StatusInternalServerError)
+	id := chi.URLParam(r, "id")
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Lignes").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
 		return
 	}
 
-	var htmlBuffer bytes.Buffer
-	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-		http.Error(w, "Erreur exécution template", http.StatusInternalServerError)
-		return
+	response := VerificationFactureResponse{
+		FactureID:  facture.ID,
+		Reference:  facture.Reference,
+		Scellee:    facture.EstScellee(),
+		HashStocke: facture.HashContenu,
 	}
 
-	pdfg, err := wkhtmltopdf.NewPDFGenerator()
-	if err != nil {
-		http.Error(w, "Erreur PDF", http.StatusInternalServerError)
+	if response.Scellee {
+		response.HashCalcule = facture.CalculerHashContenu()
+		response.Integre = facture.HashContenu != "" && facture.HashContenu == response.HashCalcule
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GenerateFacturePDF godoc
+// @Summary Générer un PDF de facture pour affichage
+// @Description Génère une facture au format PDF pour affichage dans le navigateur
+// @Tags Factures
+// @Produce application/pdf
+// @Param id path string true "ID de la facture à convertir en PDF"
+// @Success 200 {file} file "Fichier PDF généré pour affichage"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 500 {string} string "Erreur lors de la génération du PDF"
+// @Router /api/factures/{id}/pdf [get]
+func GenerateFacturePDF(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
 		return
 	}
 
-	// Configuration du PDF
-	pdfg.Dpi.Set(300)
-	pdfg.Orientation.Set(wkhtmltopdf.OrientationPortrait)
-	pdfg.Grayscale.Set(false)
-	pdfg.PageSize.Set(wkhtmltopdf.PageSizeA4)
+	id := chi.URLParam(r, "id")
 
-	page := wkhtmltopdf.NewPageReader(bytes.NewReader(htmlBuffer.Bytes()))
-	page.DisableSmartShrinking.Set(true)
-	page.EnableLocalFileAccess.Set(true)
-	pdfg.AddPage(page)
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
 
-	if err := pdfg.Create(); err != nil {
-		http.Error(w, "Erreur création PDF", http.StatusInternalServerError)
+	pdf, err := genererFacturePDF(facture)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -342,7 +521,7 @@ func GenerateFacturePDF(w http.ResponseWriter, r *http.Request) {
 	filename := fmt.Sprintf("facture_%s.pdf", facture.Reference)
 	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%s", filename))
-	io.Copy(w, bytes.NewReader(pdfg.Bytes()))
+	w.Write(pdf)
 }
 
 // DownloadFacturePDF godoc
@@ -356,70 +535,29 @@ func GenerateFacturePDF(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la génération du PDF"
 // @Router /api/factures/{id}/download [get]
 func DownloadFacturePDF(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	var facture models.Facture
-	if err := config.DB.Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
-		http.Error(w, "Facture introuvable", http.StatusNotFound)
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
 		return
 	}
 
-	// Préparer les données pour le template
-	data := prepareFacturePDFData(facture)
-
-	funcMap := template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-		"formatPrice": func(price float64) string {
-			return fmt.Sprintf("%.2f €", price)
-		},
-		"formatPercent": func(percent float64) string {
-			return fmt.Sprintf("%.1f%%", percent)
-		},
-	}
-
-	// Choisir le template selon le type de facture
-	templateName := "facture.html"
-	if facture.TypeFacture == "acompte" {
-		templateName = "facture_acompte.html"
-	}
-
-	tmpl, err := template.New(templateName).Funcs(funcMap).ParseFiles("templates/" + templateName)
-	if err != nil {
-		http.Error(w, "Erreur chargement template", http.StatusInternalServerError)
-		return
-	}
+	id := chi.URLParam(r, "id")
 
-	var htmlBuffer bytes.Buffer
-	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-		http.Error(w, "Erreur exécution template", http.StatusInternalServerError)
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
 		return
 	}
 
-	pdfg, err := wkhtmltopdf.NewPDFGenerator()
+	pdf, err := genererFacturePDF(facture)
 	if err != nil {
-		http.Error(w, "Erreur PDF", http.StatusInternalServerError)
-		return
-	}
-
-	pdfg.Dpi.Set(300)
-	pdfg.Orientation.Set(wkhtmltopdf.OrientationPortrait)
-	pdfg.Grayscale.Set(false)
-	pdfg.PageSize.Set(wkhtmltopdf.PageSizeA4)
-
-	page := wkhtmltopdf.NewPageReader(bytes.NewReader(htmlBuffer.Bytes()))
-	page.DisableSmartShrinking.Set(true)
-	page.EnableLocalFileAccess.Set(true)
-	pdfg.AddPage(page)
-
-	if err := pdfg.Create(); err != nil {
-		http.Error(w, "Erreur création PDF", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	filename := fmt.Sprintf("facture_%s.pdf", facture.Reference)
 	w.Header().Set("Content-Type", "application/pdf")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	io.Copy(w, bytes.NewReader(pdfg.Bytes()))
+	w.Write(pdf)
 }
 
 // GetFacturesByEntreprise godoc
@@ -433,15 +571,26 @@ func DownloadFacturePDF(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la récupération"
 // @Router /api/entreprises/{id}/factures [get]
 func GetFacturesByEntreprise(w http.ResponseWriter, r *http.Request) {
-	entrepriseID := chi.URLParam(r, "id")
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	paramID := chi.URLParam(r, "id")
 
-	if entrepriseID == "" {
+	if paramID == "" {
 		http.Error(w, "ID entreprise manquant", http.StatusBadRequest)
 		return
 	}
 
+	// Une entreprise ne peut consulter que ses propres factures
+	if paramID != fmt.Sprint(entrepriseID) {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
 	var factures []models.Facture
-	if err := config.DB.Where("entreprise_id = ?", entrepriseID).Find(&factures).Error; err != nil {
+	if err := tenantDB(entrepriseID).Find(&factures).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -461,6 +610,11 @@ func GetFacturesByEntreprise(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur lors de la récupération"
 // @Router /api/plannings/{id}/factures [get]
 func GetFacturesByPlanning(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	planningID := chi.URLParam(r, "id")
 
 	if planningID == "" {
@@ -469,7 +623,7 @@ func GetFacturesByPlanning(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var factures []models.Facture
-	if err := config.DB.Where("planning_id = ?", planningID).Find(&factures).Error; err != nil {
+	if err := tenantDB(entrepriseID).Where("planning_id = ?", planningID).Find(&factures).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -480,7 +634,7 @@ func GetFacturesByPlanning(w http.ResponseWriter, r *http.Request) {
 
 // UpdateFactureStatut godoc
 // @Summary Mettre à jour le statut d'une facture
-// @Description Met à jour uniquement le statut d'une facture (payée, en attente, rejetée)
+// @Description Émet une facture brouillon. Les statuts payée et partiellement payée découlent des paiements enregistrés, l'annulation passe par un avoir.
 // @Tags Factures
 // @Accept json
 // @Produce json
@@ -488,9 +642,16 @@ func GetFacturesByPlanning(w http.ResponseWriter, r *http.Request) {
 // @Param statut body object{statut=string} true "Nouveau statut de la facture"
 // @Success 200 {object} models.Facture "Facture avec statut mis à jour"
 // @Failure 400 {string} string "Statut invalide"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "Transition de statut interdite"
 // @Failure 500 {string} string "Erreur lors de la mise à jour du statut"
 // @Router /api/factures/{id}/statut [patch]
 func UpdateFactureStatut(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	var requestData struct {
@@ -503,30 +664,45 @@ func UpdateFactureStatut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Valider le statut
-	validStatuts := []string{"payée", "en attente", "rejetée"}
-	statutValide := false
-	for _, s := range validStatuts {
-		if s == requestData.Statut {
-			statutValide = true
-			break
-		}
-	}
-
-	if !statutValide {
-		http.Error(w, "Statut invalide", http.StatusBadRequest)
+	switch requestData.Statut {
+	case models.StatutFactureEmise:
+	case models.StatutFacturePartiellementPayee, models.StatutFacturePayee:
+		http.Error(w, "Le statut de paiement est calculé à partir des paiements : enregistrez un paiement sur la facture", http.StatusConflict)
 		return
-	}
-
-	// Mettre à jour le statut
-	if err := config.DB.Model(&models.Facture{}).Where("id = ?", id).Update("statut", requestData.Statut).Error; err != nil {
-		http.Error(w, "Erreur lors de la mise à jour du statut", http.StatusInternalServerError)
+	case models.StatutFactureAnnulee:
+		http.Error(w, "Une facture émise est annulée par un avoir total : créez un avoir sur la facture", http.StatusConflict)
+		return
+	case models.StatutFactureBrouillon:
+		http.Error(w, "Une facture émise ne redevient pas brouillon", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Statut invalide", http.StatusBadRequest)
 		return
 	}
 
-	// Récupérer la facture mise à jour
 	var facture models.Facture
-	if err := config.DB.First(&facture, id).Error; err != nil {
-		http.Error(w, "Erreur lors de la récupération de la facture", http.StatusInternalServerError)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("entreprise_id = ?", entrepriseID).First(&facture, id).Error; err != nil {
+			return errFactureIntrouvable
+		}
+
+		// Passer au statut "émise" revient à émettre la facture
+		if err := tx.Where("facture_id = ?", facture.ID).Order("id").Find(&facture.Lignes).Error; err != nil {
+			return err
+		}
+		return emettreFacture(tx, &facture, acteurEntreprise(r, entrepriseID))
+	})
+
+	switch {
+	case err == errFactureIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errFactureScellee || err == errPlanningDejaFacture:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Erreur lors de la mise à jour du statut", http.StatusInternalServerError)
 		return
 	}
 
@@ -546,10 +722,15 @@ func UpdateFactureStatut(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {object} map[string]string
 // @Router /factures/{id} [get]
 func GetFactureByID(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 
 	var facture models.Facture
-	if err := config.DB.Preload("Client").First(&facture, id).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Client").First(&facture, id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			http.Error(w, "Facture non trouvée", http.StatusNotFound)
 			return
@@ -573,6 +754,11 @@ func GetFactureByID(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {object} map[string]string
 // @Router /factures/search [get]
 func SearchFactures(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	searchTerm := r.URL.Query().Get("q")
 	if searchTerm == "" {
 		http.Error(w, "Terme de recherche requis", http.StatusBadRequest)
@@ -580,7 +766,7 @@ func SearchFactures(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var factures []models.Facture
-	query := config.DB.Preload("Client").Where(
+	query := tenantDB(entrepriseID).Preload("Client").Where(
 		"reference ILIKE ? OR client_nom ILIKE ? OR description ILIKE ?",
 		"%"+searchTerm+"%", "%"+searchTerm+"%", "%"+searchTerm+"%",
 	)
@@ -602,13 +788,23 @@ func SearchFactures(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param clientId path int true "ID du client"
 // @Success 200 {array} models.Facture
+// @Failure 404 {string} string "Client introuvable"
 // @Failure 500 {object} map[string]string
 // @Router /factures/client/{clientId} [get]
 func GetFacturesByClient(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	clientID := chi.URLParam(r, "clientId")
+	if id, err := strconv.ParseUint(clientID, 10, 64); err != nil || !clientAppartientA(entrepriseID, uint(id)) {
+		http.Error(w, "Client introuvable", http.StatusNotFound)
+		return
+	}
 
 	var factures []models.Facture
-	if err := config.DB.Preload("Client").Where("client_id = ?", clientID).Find(&factures).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Client").Where("client_id = ?", clientID).Find(&factures).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération des factures", http.StatusInternalServerError)
 		return
 	}
@@ -623,27 +819,39 @@ func GetFacturesByClient(w http.ResponseWriter, r *http.Request) {
 // @Tags Factures
 // @Accept json
 // @Produce json
-// @Param statut path string true "Statut des factures" Enums(en_attente, payee, rejetee)
+// @Param statut path string true "Statut des factures" Enums(brouillon, emise, partiellement_payee, payee, annulee)
 // @Success 200 {array} models.Facture
 // @Failure 500 {object} map[string]string
 // @Router /factures/statut/{statut} [get]
 func GetFacturesByStatut(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	statut := chi.URLParam(r, "statut")
 
-	// Valider le statut
-	validStatuts := map[string]bool{
-		"en_attente": true,
-		"payee":      true,
-		"rejetee":    true,
+	// Valider le statut (forme accentuée ou forme utilisable dans une URL)
+	validStatuts := map[string]string{
+		models.StatutFactureBrouillon:          models.StatutFactureBrouillon,
+		models.StatutFactureEmise:              models.StatutFactureEmise,
+		"emise":                                models.StatutFactureEmise,
+		models.StatutFacturePartiellementPayee: models.StatutFacturePartiellementPayee,
+		"partiellement_payee":                  models.StatutFacturePartiellementPayee,
+		models.StatutFacturePayee:              models.StatutFacturePayee,
+		"payee":                                models.StatutFacturePayee,
+		models.StatutFactureAnnulee:            models.StatutFactureAnnulee,
+		"annulee":                              models.StatutFactureAnnulee,
 	}
 
-	if !validStatuts[statut] {
+	statut, statutValide := validStatuts[statut]
+	if !statutValide {
 		http.Error(w, "Statut invalide", http.StatusBadRequest)
 		return
 	}
 
 	var factures []models.Facture
-	if err := config.DB.Preload("Client").Where("statut = ?", statut).Find(&factures).Error; err != nil {
+	if err := tenantDB(entrepriseID).Preload("Client").Where("statut = ?", statut).Find(&factures).Error; err != nil {
 		http.Error(w, "Erreur lors de la récupération des factures", http.StatusInternalServerError)
 		return
 	}
@@ -652,6 +860,180 @@ func GetFacturesByStatut(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(factures)
 }
 
+// genererFacturePDF produit le PDF d'une facture (client et lignes préchargés)
+func genererFacturePDF(facture models.Facture) ([]byte, error) {
+	doc := documentPDFFacture(prepareFacturePDFData(facture))
+	doc.DateCreation = facture.DateEmission
+	return utils.GenererDocumentPDF(doc)
+}
+
+// documentPDFFacture met en page une facture ; la même présentation sert aux factures classiques, d'acompte, de situation et de solde
+func documentPDFFacture(data FacturePDFData) utils.DocumentPDF {
+	titre := utils.TitrePDFFacture
+	switch data.TypeFacture {
+	case models.TypeFactureAcompte:
+		titre = utils.TitrePDFAcompte
+	case models.TypeFactureSituation:
+		titre = utils.TitrePDFSituation
+	case models.TypeFactureSolde:
+		titre = utils.TitrePDFSolde
+	}
+
+	doc := utils.DocumentPDF{
+		Titre:      titre,
+		Reference:  data.Reference,
+		Emetteur:   emetteurPDF(data.Company),
+		Logo:       data.Company.Logo,
+		Client:     blocClientPDF(data.ClientNom, data.ClientAdresse, data.ClientEmail, data.ClientTelephone),
+		Lignes:     lignesDocumentPDF(data.Lignes),
+		Totaux:     totauxPDF(data.SousTotalHT, data.VentilationTVA, data.TotalTVA, data.TotalTTC, data.RemisePourcentage),
+		TVA:        ventilationPDF(data.VentilationTVA),
+		MentionTVA: data.MentionTVA,
+		PiedDePage: piedDePagePDF(data.Company),
+	}
+	if data.ClientNumeroTVA != "" {
+		doc.Client.Lignes = append(doc.Client.Lignes, "N° TVA : "+data.ClientNumeroTVA)
+	}
+	if data.LieuSignature != "" {
+		doc.Mentions = []string{fmt.Sprintf("%s, le %s", data.LieuSignature, data.DateSignature)}
+		doc.Signatures = &utils.SignaturesPDF{Gauche: "Signature de l'entreprise", Droite: "Signature du client"}
+	}
+
+	infos := []string{"Date d'émission : " + data.DateEmission}
+	if data.DateEcheance != "" {
+		infos = append(infos, "Date d'échéance : "+data.DateEcheance)
+	}
+	if data.DevisReference != "" {
+		infos = append(infos, "Devis : "+data.DevisReference)
+	}
+	infos = append(infos, "Statut : "+data.Statut, "Type : "+data.TypeFacture)
+	doc.Informations = utils.BlocPDF{Titre: "Informations facture", Lignes: infos}
+
+	if data.Description != "" {
+		doc.Objet = utils.BlocPDF{Titre: "Objet de la facture", Lignes: []string{data.Description}}
+	}
+	return doc
+}
+
+// emetteurPDF retourne la raison sociale et les coordonnées affichées en en-tête des documents
+func emetteurPDF(company config.CompanyInfo) []string {
+	lignes := []string{company.Name, company.Address, strings.TrimSpace(company.PostalCode + " " + company.City)}
+	if company.Website != "" {
+		lignes = append(lignes, company.Website)
+	}
+	if company.Phone != "" {
+		lignes = append(lignes, "Tél : "+company.Phone)
+	}
+	if company.Email != "" {
+		lignes = append(lignes, "Email : "+company.Email)
+	}
+	return lignes
+}
+
+// piedDePagePDF retourne les mentions légales répétées en pied de chaque page
+func piedDePagePDF(company config.CompanyInfo) []string {
+	identite := company.Name
+	if company.LegalForm != "" {
+		identite += " " + company.LegalForm
+	}
+	if company.Capital != "" {
+		identite += " au capital de " + company.Capital
+	}
+	mentions := []string{fmt.Sprintf("%s - %s - %s %s", identite, company.Address, company.PostalCode, company.City)}
+
+	var immatriculation []string
+	if company.SIRET != "" {
+		immatriculation = append(immatriculation, "SIRET : "+company.SIRET)
+	}
+	if company.RCS != "" {
+		immatriculation = append(immatriculation, company.RCS)
+	}
+	if company.APE != "" {
+		immatriculation = append(immatriculation, "APE : "+company.APE)
+	}
+	if company.TVA != "" {
+		immatriculation = append(immatriculation, "N° TVA : "+company.TVA)
+	}
+	if len(immatriculation) > 0 {
+		mentions = append(mentions, strings.Join(immatriculation, " - "))
+	}
+	if company.LegalMentions != "" {
+		mentions = append(mentions, company.LegalMentions)
+	}
+	return mentions
+}
+
+// blocClientPDF construit le bloc destinataire d'un document
+func blocClientPDF(nom, adresse, email, telephone string) utils.BlocPDF {
+	bloc := utils.BlocPDF{Titre: "Client", Lignes: []string{nom}}
+	if adresse != "" {
+		bloc.Lignes = append(bloc.Lignes, adresse)
+	}
+	if email != "" {
+		bloc.Lignes = append(bloc.Lignes, "Email : "+email)
+	}
+	if telephone != "" {
+		bloc.Lignes = append(bloc.Lignes, "Tél : "+telephone)
+	}
+	return bloc
+}
+
+// lignesDocumentPDF convertit les lignes d'une facture ou d'un avoir pour le tableau de détail
+func lignesDocumentPDF(lignes []LigneFacture) []utils.LigneDocumentPDF {
+	var resultat []utils.LigneDocumentPDF
+	for _, l := range lignes {
+		resultat = append(resultat, utils.LigneDocumentPDF{
+			Designation:  l.Designation,
+			Unite:        l.Unite,
+			Quantite:     l.Quantite,
+			PrixUnitaire: l.PrixUnitaire,
+			TauxTVA:      l.TVA,
+			MontantHT:    l.MontantHT,
+
+			RemisePourcentage: l.RemisePourcentage,
+			RemiseMontant:     l.RemiseMontant,
+		})
+	}
+	return resultat
+}
+
+// totauxPDF retourne le tableau des totaux : total des lignes et remise globale s'il y en a une,
+// sous-total HT, TVA de chaque taux et total TTC
+func totauxPDF(sousTotalHT models.Montant, ventilation []models.VentilationTVA, totalTVA, totalTTC models.Montant, remisePourcentage float64) []utils.TotalPDF {
+	var totaux []utils.TotalPDF
+	if remise := models.TotalRemises(ventilation); remise != 0 {
+		libelle := "REMISE"
+		if remisePourcentage != 0 {
+			libelle += " " + utils.FormatTauxPDF(remisePourcentage)
+		}
+		totaux = append(totaux,
+			utils.TotalPDF{Libelle: "TOTAL LIGNES HT", Montant: sousTotalHT + remise},
+			utils.TotalPDF{Libelle: libelle, Montant: -remise})
+	}
+	totaux = append(totaux, utils.TotalPDF{Libelle: "SOUS-TOTAL HT", Montant: sousTotalHT})
+	if len(ventilation) <= 1 {
+		libelle := "TVA"
+		if len(ventilation) == 1 {
+			libelle += " " + utils.FormatTauxPDF(ventilation[0].Taux)
+		}
+		totaux = append(totaux, utils.TotalPDF{Libelle: libelle, Montant: totalTVA})
+	} else {
+		for _, v := range ventilation {
+			totaux = append(totaux, utils.TotalPDF{Libelle: "TVA " + utils.FormatTauxPDF(v.Taux), Montant: v.TVA})
+		}
+	}
+	return append(totaux, utils.TotalPDF{Libelle: "TOTAL TTC", Montant: totalTTC, Principal: true})
+}
+
+// ventilationPDF convertit le récapitulatif de TVA pour le tableau des documents
+func ventilationPDF(ventilation []models.VentilationTVA) []utils.TVAPDF {
+	var resultat []utils.TVAPDF
+	for _, v := range ventilation {
+		resultat = append(resultat, utils.TVAPDF{Taux: v.Taux, BaseHT: v.Base, MontantTVA: v.TVA})
+	}
+	return resultat
+}
+
 // prepareFacturePDFData prépare les données pour le template PDF
 func prepareFacturePDFData(facture models.Facture) FacturePDFData {
 	// Formatage des dates
@@ -676,17 +1058,17 @@ func prepareFacturePDFData(facture models.Facture) FacturePDFData {
 		montantTVA = facture.TotalTTC - facture.SousTotalHT
 	}
 
-	// Informations de l'entreprise
-	company := config.GetCompanyInfo()
-	devisConfig := config.GetDevisConfig()
+	// Profil de l'entreprise émettrice
+	entreprise := entrepriseDocument(facture.EntrepriseID)
 
 	// Informations client
-	var clientNom, clientAdresse, clientEmail, clientTelephone string
+	var clientNom, clientAdresse, clientEmail, clientTelephone, clientNumeroTVA string
 	if facture.Client.ID != 0 {
 		clientNom = facture.Client.GetDisplayName()
 		clientAdresse = facture.Client.Adresse
 		clientEmail = facture.Client.Email
 		clientTelephone = facture.Client.Telephone
+		clientNumeroTVA = facture.Client.NumeroTVA
 	}
 
 	// Préparer les lignes de facture
@@ -700,13 +1082,16 @@ func prepareFacturePDFData(facture models.Facture) FacturePDFData {
 			MontantHT:    ligne.MontantHT,
 			TVA:          ligne.TauxTVA,
 			MontantTTC:   ligne.MontantTTC,
+
+			RemisePourcentage: ligne.RemisePourcentage,
+			RemiseMontant:     ligne.RemiseMontant,
 		})
 	}
 
 	return FacturePDFData{
 		Reference:       facture.Reference,
 		Numero:          facture.Reference, // Alias pour compatibilité
-		Ville:           devisConfig.DefaultCity,
+		Ville:           entreprise.Ville,
 		DateEmission:    dateEmission,
 		DateEcheance:    dateEcheance,
 		ClientNom:       clientNom,
@@ -718,11 +1103,27 @@ func prepareFacturePDFData(facture models.Facture) FacturePDFData {
 		SousTotalHT:     facture.SousTotalHT,
 		TotalTVA:        montantTVA,
 		TotalTTC:        facture.TotalTTC,
+		VentilationTVA:  facture.Ventilation(),
+		MentionTVA:      models.MentionExonerationTVA(facture.MotifExonerationTVA),
+		ClientNumeroTVA: clientNumeroTVA,
 		TypeFacture:     facture.TypeFacture,
 		Statut:          facture.Statut,
-		Company:         company,
+		Company:         companyInfo(entreprise),
 		LieuSignature:   facture.LieuSignature,
 		DateSignature:   facture.DateSignature,
+		DevisReference:  facture.DevisReference,
+
+		RemisePourcentage: facture.RemisePourcentage,
+	}
+}
+
+// reinitialiserSuiviDevis efface des lignes transmises par le client les champs de suivi du devis
+// (position, avancement cumulé, acompte déduit) : seule la facturation d'un devis les renseigne
+func reinitialiserSuiviDevis(lignes []models.LigneFacture) {
+	for i := range lignes {
+		lignes[i].PositionDevis = 0
+		lignes[i].AvancementCumule = 0
+		lignes[i].FactureAcompteID = nil
 	}
 }
 
@@ -736,8 +1137,13 @@ func validateFactureData(facture *models.Facture) error {
 		}
 	}
 
+	// Vérifier que le client appartient à l'entreprise
+	if facture.EntrepriseID != 0 && !clientAppartientA(facture.EntrepriseID, facture.ClientID) {
+		return fmt.Errorf("client introuvable")
+	}
+
 	// Vérifier le type de facture
-	validTypes := []string{"classique", "acompte", "avancement", "standard"}
+	validTypes := []string{models.TypeFactureClassique, models.TypeFactureAcompte, models.TypeFactureSituation, models.TypeFactureSolde, "standard"}
 	typeValide := false
 	for _, t := range validTypes {
 		if t == facture.TypeFacture {
@@ -750,34 +1156,184 @@ func validateFactureData(facture *models.Facture) error {
 		return fmt.Errorf("type de facture invalide")
 	}
 
-	// Vérifier les montants
+	if facture.MotifExonerationTVA == models.ExonerationTVAIntracommunautaire {
+		var client models.Client
+		config.DB.Limit(1).Find(&client, facture.ClientID)
+		if client.NumeroTVA == "" {
+			return errNumeroTVAClientManquant
+		}
+	}
+
+	// Taux de TVA en vigueur et cohérence avec le motif d'exonération
+	if err := verifierTVA(tauxLignesFacture(facture.Lignes), facture.MotifExonerationTVA); err != nil {
+		return err
+	}
+
+	// Montants des lignes et totaux calculés par le serveur ; ceux transmis doivent y correspondre
+	if err := calculerTotauxFacture(facture); err != nil {
+		return err
+	}
 	if facture.SousTotalHT < 0 || facture.TotalTTC < 0 {
 		return fmt.Errorf("les montants ne peuvent pas être négatifs")
 	}
+	return nil
+}
+
+// emettreFacture fait passer un brouillon au statut émise : attribution de la référence,
+// copie figée du client, dates d'émission et d'échéance, puis calcul de l'empreinte de scellement.
+// Les lignes de la facture doivent être chargées.
+func emettreFacture(tx *gorm.DB, facture *models.Facture, acteur acteurTransition) error {
+	if facture.EstScellee() {
+		return errFactureScellee
+	}
+	statutInitial := facture.Statut
 
+	var client models.Client
+	if err := tx.Where("entreprise_id = ?", facture.EntrepriseID).First(&client, facture.ClientID).Error; err != nil {
+		return fmt.Errorf("client introuvable")
+	}
+
+	now := time.Now()
+
+	// Les factures d'acompte ont leur propre série de numérotation
+	typeDocument := models.TypeDocumentFacture
+	if facture.TypeFacture == "acompte" {
+		typeDocument = models.TypeDocumentAcompte
+	}
+
+	reference, err := prochainNumero(tx, facture.EntrepriseID, typeDocument, now)
+	if err != nil {
+		return err
+	}
+
+	facture.Reference = reference
+	facture.ClientNom = client.GetDisplayName()
+	facture.ClientAdresse = formatAdresseClient(client)
+	facture.ClientEmail = client.Email
+	facture.ClientTelephone = client.Telephone
+	facture.DateEmission = now
+	if facture.DateEcheance.Before(now) {
+		facture.DateEcheance = now.AddDate(0, 0, 30)
+	}
+	facture.Statut = models.StatutFactureEmise
+	facture.EmiseLe = &now
+	facture.HashContenu = facture.CalculerHashContenu()
+
+	err = tx.Model(&models.Facture{}).Where("id = ?", facture.ID).Updates(map[string]interface{}{
+		"reference":        facture.Reference,
+		"client_nom":       facture.ClientNom,
+		"client_adresse":   facture.ClientAdresse,
+		"client_email":     facture.ClientEmail,
+		"client_telephone": facture.ClientTelephone,
+		"date_emission":    facture.DateEmission,
+		"date_echeance":    facture.DateEcheance,
+		"statut":           facture.Statut,
+		"emise_le":         facture.EmiseLe,
+		"hash_contenu":     facture.HashContenu,
+	}).Error
+	if err != nil {
+		return err
+	}
+	if err := enregistrerTransition(tx, models.TypeDocumentFacture, facture.EntrepriseID, facture.ID,
+		statutInitial, facture.Statut, acteur, "Émission "+facture.Reference); err != nil {
+		return err
+	}
+
+	if err := recalculerSoldeFacture(tx, facture.ID, acteur, "Émission "+facture.Reference); err != nil {
+		return err
+	}
+
+	if err := tx.Preload("Lignes", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(facture, facture.ID).Error; err != nil {
+		return err
+	}
+
+	// Le planning d'origine de la facture est facturé par sa première ligne
+	if facture.PlanningID != nil && len(facture.Lignes) > 0 {
+		var planning models.Planning
+		tx.Where("entreprise_id = ?", facture.EntrepriseID).Limit(1).Find(&planning, *facture.PlanningID)
+		switch planning.Facturation {
+		case models.FacturationAFacturer:
+			return marquerPlanningFacture(tx, facture.EntrepriseID, planning.ID, facture.Lignes[0].ID)
+		case models.FacturationFacture:
+			return errPlanningDejaFacture
+		}
+	}
 	return nil
 }
 
-// generateFactureNumber génère un numéro de facture unique
-func generateFactureNumber() string {
-	year := time.Now().Year()
+// formatAdresseClient retourne l'adresse postale complète d'un client sur une ligne
+func formatAdresseClient(client models.Client) string {
+	adresse := client.Adresse
+	if client.ComplementAdresse != "" {
+		adresse += ", " + client.ComplementAdresse
+	}
+	return fmt.Sprintf("%s, %s %s", adresse, client.CodePostal, client.Ville)
+}
 
-	// Rechercher la dernière référence de l'année pour éviter les doublons
-	var maxNumber int = 0
+// recalculerSoldeFacture met à jour le total des avoirs, le total payé, le reste à payer et le statut d'une facture.
+// Un changement de statut est tracé au nom de l'acteur et avec le motif du recalcul (paiement, avoir).
+func recalculerSoldeFacture(tx *gorm.DB, factureID uint, acteur acteurTransition, motif string) error {
+	var facture models.Facture
+	if err := tx.First(&facture, factureID).Error; err != nil {
+		return err
+	}
 
-	// Chercher toutes les factures de l'année et extraire le plus grand numéro
-	var factures []models.Facture
-	config.DB.Where("reference LIKE ?", fmt.Sprintf("FAC-%d-%%", year)).Find(&factures)
-
-	for _, facture := range factures {
-		// Extraire le numéro de la référence (format: FAC-2025-XXXX)
-		var num int
-		if _, err := fmt.Sscanf(facture.Reference, "FAC-%d-%d", &year, &num); err == nil {
-			if num > maxNumber {
-				maxNumber = num
+	var totalAvoirs models.Montant
+	if err := tx.Model(&models.Avoir{}).Where("facture_id = ?", factureID).
+		Select("COALESCE(SUM(total_ttc), 0)").Scan(&totalAvoirs).Error; err != nil {
+		return err
+	}
+
+	var totalPaye models.Montant
+	if err := tx.Model(&models.Paiement{}).Where("facture_id = ? AND annule = ?", factureID, false).
+		Select("COALESCE(SUM(montant), 0)").Scan(&totalPaye).Error; err != nil {
+		return err
+	}
+
+	var nbAvoirsTotaux int64
+	tx.Model(&models.Avoir{}).Where("facture_id = ? AND type_avoir = ?", factureID, models.TypeAvoirTotal).Count(&nbAvoirsTotaux)
+
+	// Montant dû après avoirs
+	montantDu := facture.TotalTTC - totalAvoirs
+	if montantDu < 0 || nbAvoirsTotaux > 0 {
+		montantDu = 0
+	}
+
+	resteAPayer := montantDu - totalPaye
+	if resteAPayer < 0 {
+		resteAPayer = 0
+	}
+
+	updates := map[string]interface{}{
+		"total_avoirs":  totalAvoirs,
+		"total_paye":    totalPaye,
+		"reste_a_payer": resteAPayer,
+	}
+
+	// Le statut d'une facture émise découle de ses avoirs et de ses paiements
+	if facture.EstScellee() {
+		switch {
+		case totalAvoirs > 0 && montantDu == 0:
+			// Une facture entièrement créditée est annulée
+			updates["statut"] = models.StatutFactureAnnulee
+		case totalPaye > 0 && resteAPayer == 0:
+			updates["statut"] = models.StatutFacturePayee
+		case totalPaye > 0:
+			updates["statut"] = models.StatutFacturePartiellementPayee
+		default:
+			updates["statut"] = models.StatutFactureEmise
+		}
+
+		if statut := updates["statut"].(string); statut != facture.Statut {
+			if !models.TransitionFactureAutorisee(facture.Statut, statut) {
+				return errTransitionStatutInterdite
+			}
+			if err := enregistrerTransition(tx, models.TypeDocumentFacture, facture.EntrepriseID, facture.ID,
+				facture.Statut, statut, acteur, motif); err != nil {
+				return err
 			}
 		}
 	}
 
-	return fmt.Sprintf("FAC-%d-%04d", year, maxNumber+1)
+	return tx.Model(&models.Facture{}).Where("id = ?", factureID).Updates(updates).Error
 }
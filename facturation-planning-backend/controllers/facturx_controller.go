@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"facturation-planning/config"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ExportFacturX godoc
+// @Summary Exporter une facture au format Factur-X
+// @Description Génère le PDF/A-3 d'une facture émise avec le XML CII (profil EN 16931) embarqué : ventilation de la TVA par taux, SIRET et TVA intracommunautaire de l'entreprise
+// @Tags Factures
+// @Produce application/pdf
+// @Param id path string true "ID de la facture"
+// @Success 200 {file} file "PDF Factur-X"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "La facture est un brouillon"
+// @Failure 422 {string} string "Informations obligatoires manquantes (SIRET, TVA...)"
+// @Failure 500 {string} string "Erreur lors de la génération"
+// @Router /factures/{id}/facturx [get]
+func ExportFacturX(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Preload("Client").Preload("Lignes").First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	// Seule une facture émise (référence et contenu définitifs) peut être échangée
+	if !facture.EstScellee() {
+		http.Error(w, errFactureBrouillon.Error(), http.StatusConflict)
+		return
+	}
+
+	var entreprise models.Entreprise
+	if err := config.DB.First(&entreprise, entrepriseID).Error; err != nil {
+		http.Error(w, "Entreprise introuvable", http.StatusNotFound)
+		return
+	}
+
+	document := utils.DocumentDepuisFacture(facture, entreprise)
+	if err := document.Valider(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	pdf, err := genererFacturX(facture, entreprise, document)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("facturx_%s.pdf", facture.Reference)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	w.Write(pdf)
+}
+
+// genererFacturX produit le PDF de la facture et y embarque le XML CII du document normalisé
+func genererFacturX(facture models.Facture, entreprise models.Entreprise, document utils.DocumentFacturation) ([]byte, error) {
+	xmlCII, err := utils.GenererCII(document)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf, err := genererFacturePDF(facture)
+	if err != nil {
+		return nil, err
+	}
+
+	date := facture.DateEmission
+	if facture.EmiseLe != nil {
+		date = *facture.EmiseLe
+	}
+
+	return utils.IntegrerFacturX(pdf, xmlCII, utils.MetadonneesFacturX{
+		Titre:  "Facture " + facture.Reference,
+		Auteur: entreprise.Nom,
+		Sujet:  fmt.Sprintf("Facture %s - %s", facture.Reference, facture.ClientNom),
+		Date:   date,
+	})
+}
@@ -0,0 +1,595 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+)
+
+// SemaineFeuilleTemps représente les heures d'un salarié sur une semaine (du lundi au dimanche).
+// Les heures supplémentaires d'une semaine à cheval sur deux périodes sont rattachées à la période où elle se termine.
+type SemaineFeuilleTemps struct {
+	Semaine               string             `json:"semaine" example:"2025-W23"`
+	DateDebut             string             `json:"date_debut" example:"2025-06-02"`
+	DateFin               string             `json:"date_fin" example:"2025-06-08"`
+	HeuresParType         map[string]float64 `json:"heures_par_type"`
+	HeuresTravaillees     float64            `json:"heures_travaillees" example:"38.5"`
+	HeuresAbsence         float64            `json:"heures_absence" example:"0"`
+	HeuresSupplementaires float64            `json:"heures_supplementaires" example:"3.5"`
+	Rattachee             bool               `json:"rattachee" example:"true"` // Faux si la semaine se termine après la période : ses heures supplémentaires sont comptées sur la période suivante
+}
+
+// LigneFeuilleTemps représente un planning compté dans la feuille de temps
+type LigneFeuilleTemps struct {
+	PlanningID    uint    `json:"planning_id,omitempty" example:"12"`
+	SerieID       *uint   `json:"serie_id,omitempty" example:"3"`
+	Date          string  `json:"date" example:"2025-06-02"`
+	HeureDebut    string  `json:"heure_debut" example:"08:00"`
+	HeureFin      string  `json:"heure_fin" example:"12:30"`
+	TypeEvenement string  `json:"type_evenement" example:"Intervention"`
+	ClientNom     string  `json:"client_nom,omitempty" example:"Dupont Jean"`
+	Objet         string  `json:"objet,omitempty" example:"Entretien chaudière"`
+	Heures        float64 `json:"heures" example:"4.5"`
+}
+
+// FeuilleTempsSalarie représente la feuille de temps d'un salarié sur la période
+type FeuilleTempsSalarie struct {
+	SalarieID             uint                  `json:"salarie_id" example:"2"`
+	SalarieNom            string                `json:"salarie_nom" example:"Jean Dupont"`
+	HeuresParType         map[string]float64    `json:"heures_par_type"`
+	HeuresTravaillees     float64               `json:"heures_travaillees" example:"151.5"`
+	HeuresAbsence         float64               `json:"heures_absence" example:"14"`
+	HeuresSupplementaires float64               `json:"heures_supplementaires" example:"6.5"`
+	Semaines              []SemaineFeuilleTemps `json:"semaines"`
+	Lignes                []LigneFeuilleTemps   `json:"lignes"`
+}
+
+// FeuillesTempsResponse représente les feuilles de temps des salariés sur une période
+type FeuillesTempsResponse struct {
+	DateDebut         string                `json:"date_debut" example:"2025-06-01"`
+	DateFin           string                `json:"date_fin" example:"2025-06-30"`
+	SeuilHebdomadaire float64               `json:"seuil_hebdomadaire" example:"35"`
+	HeuresJournee     float64               `json:"heures_journee" example:"7"`
+	Salaries          []FeuilleTempsSalarie `json:"salaries"`
+}
+
+// GetFeuillesTemps godoc
+// @Summary Feuilles de temps des salariés
+// @Description Heures de chaque salarié sur la période (le mois en cours par défaut), plannings récurrents compris : répartition par type d'événement, totaux par semaine et heures supplémentaires au-delà du seuil hebdomadaire de l'entreprise. Les absences, congés, maladies et RTT ne sont pas des heures travaillées ; un planning sans horaires compte pour une journée.
+// @Tags Planning
+// @Produce json
+// @Param mois query string false "Mois (AAAA-MM), à la place de date_debut et date_fin"
+// @Param date_debut query string false "Début de la période (AAAA-MM-JJ)"
+// @Param date_fin query string false "Fin de la période (AAAA-MM-JJ)"
+// @Param salarie_id query int false "Limiter le rapport à un salarié"
+// @Success 200 {object} FeuillesTempsResponse
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 404 {string} string "Salarié introuvable"
+// @Failure 500 {string} string "Erreur base de données"
+// @Router /plannings/feuilles-temps [get]
+func GetFeuillesTemps(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	feuilles, ok := chargerFeuillesTemps(w, r, entrepriseID, entrepriseDocument(entrepriseID))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(feuilles)
+}
+
+// ExportFeuillesTempsCSV godoc
+// @Summary Exporter les feuilles de temps en CSV
+// @Description Export pour la paie (séparateur point-virgule, virgule décimale, UTF-8) : une ligne par salarié et par semaine, puis une ligne "Total période" par salarié. Mêmes paramètres que /plannings/feuilles-temps.
+// @Tags Planning
+// @Produce text/csv
+// @Param mois query string false "Mois (AAAA-MM), à la place de date_debut et date_fin"
+// @Param date_debut query string false "Début de la période (AAAA-MM-JJ)"
+// @Param date_fin query string false "Fin de la période (AAAA-MM-JJ)"
+// @Param salarie_id query int false "Limiter l'export à un salarié"
+// @Success 200 {file} file "Fichier CSV"
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 404 {string} string "Salarié introuvable"
+// @Failure 500 {string} string "Erreur base de données"
+// @Router /plannings/feuilles-temps/csv [get]
+func ExportFeuillesTempsCSV(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	feuilles, ok := chargerFeuillesTemps(w, r, entrepriseID, entrepriseDocument(entrepriseID))
+	if !ok {
+		return
+	}
+
+	contenu, err := feuillesTempsCSV(feuilles)
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du CSV", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("feuilles_temps_%s_%s.csv", feuilles.DateDebut, feuilles.DateFin)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Write(contenu)
+}
+
+// ExportFeuillesTempsPDF godoc
+// @Summary Exporter les feuilles de temps en PDF
+// @Description Une feuille de temps par salarié : récapitulatif par type d'événement, semaines et détail des plannings. Mêmes paramètres que /plannings/feuilles-temps.
+// @Tags Planning
+// @Produce application/pdf
+// @Param mois query string false "Mois (AAAA-MM), à la place de date_debut et date_fin"
+// @Param date_debut query string false "Début de la période (AAAA-MM-JJ)"
+// @Param date_fin query string false "Fin de la période (AAAA-MM-JJ)"
+// @Param salarie_id query int false "Limiter l'export à un salarié"
+// @Success 200 {file} file "Fichier PDF"
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 404 {string} string "Salarié introuvable"
+// @Failure 500 {string} string "Erreur lors de la génération du PDF"
+// @Router /plannings/feuilles-temps/pdf [get]
+func ExportFeuillesTempsPDF(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	entreprise := entrepriseDocument(entrepriseID)
+	feuilles, ok := chargerFeuillesTemps(w, r, entrepriseID, entreprise)
+	if !ok {
+		return
+	}
+
+	pdf, err := utils.GenererRapportPDF(feuillesTempsPDF(feuilles, companyInfo(entreprise)))
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération du PDF", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("feuilles_temps_%s_%s.pdf", feuilles.DateDebut, feuilles.DateFin)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Write(pdf)
+}
+
+// feuilleTempsConfig retourne la configuration des feuilles de temps de l'entreprise, complétée par les valeurs par défaut
+func feuilleTempsConfig(entreprise models.Entreprise) config.FeuilleTempsConfig {
+	cfg := config.GetFeuilleTempsConfig()
+	if entreprise.SeuilHeuresSupplementaires > 0 {
+		cfg.SeuilHebdomadaire = entreprise.SeuilHeuresSupplementaires
+	}
+	if entreprise.HeuresJournee > 0 {
+		cfg.HeuresJournee = entreprise.HeuresJournee
+	}
+	return cfg
+}
+
+// chargerFeuillesTemps lit les paramètres de la requête et calcule les feuilles de temps.
+// En cas d'erreur, la réponse est écrite et ok vaut false.
+func chargerFeuillesTemps(w http.ResponseWriter, r *http.Request, entrepriseID uint, entreprise models.Entreprise) (FeuillesTempsResponse, bool) {
+	debut, fin, err := lirePeriodeFeuilleTemps(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return FeuillesTempsResponse{}, false
+	}
+
+	query := tenantDB(entrepriseID).Order("nom, id")
+	if salarieID := r.URL.Query().Get("salarie_id"); salarieID != "" {
+		id, err := strconv.ParseUint(salarieID, 10, 64)
+		if err != nil {
+			http.Error(w, "Salarié invalide", http.StatusBadRequest)
+			return FeuillesTempsResponse{}, false
+		}
+		query = query.Where("id = ?", id)
+	}
+	var salaries []models.Salarie
+	if err := query.Find(&salaries).Error; err != nil {
+		http.Error(w, "Erreur base de données", http.StatusInternalServerError)
+		return FeuillesTempsResponse{}, false
+	}
+	if len(salaries) == 0 && r.URL.Query().Get("salarie_id") != "" {
+		http.Error(w, "Salarié introuvable", http.StatusNotFound)
+		return FeuillesTempsResponse{}, false
+	}
+
+	feuilles, err := calculerFeuillesTemps(entrepriseID, salaries, debut, fin, feuilleTempsConfig(entreprise))
+	if err != nil {
+		http.Error(w, "Erreur base de données", http.StatusInternalServerError)
+		return FeuillesTempsResponse{}, false
+	}
+	return feuilles, true
+}
+
+// lirePeriodeFeuilleTemps lit la période du rapport : le mois demandé, les dates données ou le mois en cours
+func lirePeriodeFeuilleTemps(r *http.Request) (string, string, error) {
+	mois := r.URL.Query().Get("mois")
+	if mois == "" && r.URL.Query().Get("date_debut") == "" && r.URL.Query().Get("date_fin") == "" {
+		mois = time.Now().Format("2006-01")
+	}
+	if mois == "" {
+		return lirePeriodePlannings(r)
+	}
+
+	premier, err := time.Parse("2006-01", mois)
+	if err != nil {
+		return "", "", fmt.Errorf("mois invalide (format attendu : AAAA-MM)")
+	}
+	return premier.Format("2006-01-02"), premier.AddDate(0, 1, -1).Format("2006-01-02"), nil
+}
+
+// calculerFeuillesTemps calcule la feuille de temps de chaque salarié entre debut et fin (AAAA-MM-JJ inclus).
+// Les semaines sont calculées en entier, y compris les jours hors période, pour que le seuil hebdomadaire
+// s'applique aux semaines à cheval sur deux mois.
+func calculerFeuillesTemps(entrepriseID uint, salaries []models.Salarie, debut, fin string, cfg config.FeuilleTempsConfig) (FeuillesTempsResponse, error) {
+	response := FeuillesTempsResponse{
+		DateDebut:         debut,
+		DateFin:           fin,
+		SeuilHebdomadaire: cfg.SeuilHebdomadaire,
+		HeuresJournee:     cfg.HeuresJournee,
+		Salaries:          []FeuilleTempsSalarie{},
+	}
+	if len(salaries) == 0 {
+		return response, nil
+	}
+
+	debutT, _ := time.Parse("2006-01-02", debut)
+	finT, _ := time.Parse("2006-01-02", fin)
+	premierLundi := lundiSemaine(debutT)
+	dernierDimanche := lundiSemaine(finT).AddDate(0, 0, 6)
+
+	salarieIDs := make([]uint, 0, len(salaries))
+	for _, salarie := range salaries {
+		salarieIDs = append(salarieIDs, salarie.ID)
+	}
+	plannings, err := planningsSalaries(config.DB, entrepriseID, salarieIDs,
+		premierLundi.Format("2006-01-02"), dernierDimanche.Format("2006-01-02"))
+	if err != nil {
+		return response, err
+	}
+	trierPlannings(plannings)
+
+	clients, err := nomsClientsPlannings(entrepriseID, plannings)
+	if err != nil {
+		return response, err
+	}
+
+	parSalarie := make(map[uint][]*models.Planning, len(salaries))
+	for i := range plannings {
+		parSalarie[plannings[i].SalarieID] = append(parSalarie[plannings[i].SalarieID], &plannings[i])
+	}
+
+	for _, salarie := range salaries {
+		feuille := FeuilleTempsSalarie{
+			SalarieID:     salarie.ID,
+			SalarieNom:    salarie.Nom,
+			HeuresParType: map[string]float64{},
+			Semaines:      []SemaineFeuilleTemps{},
+			Lignes:        []LigneFeuilleTemps{},
+		}
+
+		semaines := make(map[string]*SemaineFeuilleTemps)
+		for lundi := premierLundi; !lundi.After(finT); lundi = lundi.AddDate(0, 0, 7) {
+			dimanche := lundi.AddDate(0, 0, 6)
+			annee, numero := lundi.ISOWeek()
+			semaine := SemaineFeuilleTemps{
+				Semaine:       fmt.Sprintf("%d-W%02d", annee, numero),
+				DateDebut:     lundi.Format("2006-01-02"),
+				DateFin:       dimanche.Format("2006-01-02"),
+				HeuresParType: map[string]float64{},
+				Rattachee:     !dimanche.After(finT),
+			}
+			feuille.Semaines = append(feuille.Semaines, semaine)
+		}
+		for i := range feuille.Semaines {
+			semaines[feuille.Semaines[i].DateDebut] = &feuille.Semaines[i]
+		}
+
+		for _, planning := range parSalarie[salarie.ID] {
+			jour, err := time.Parse("2006-01-02", planning.Date)
+			if err != nil {
+				continue
+			}
+			heures := heuresFeuilleTemps(planning, cfg.HeuresJournee)
+			travail, absence := repartitionHeures(planning.TypeEvenement, heures)
+
+			if semaine := semaines[lundiSemaine(jour).Format("2006-01-02")]; semaine != nil {
+				semaine.HeuresParType[planning.TypeEvenement] += heures
+				semaine.HeuresTravaillees += travail
+				semaine.HeuresAbsence += absence
+			}
+
+			if planning.Date < debut || planning.Date > fin {
+				continue
+			}
+			objet := ""
+			if planning.Objet != nil {
+				objet = *planning.Objet
+			}
+			feuille.HeuresParType[planning.TypeEvenement] += heures
+			feuille.HeuresTravaillees += travail
+			feuille.HeuresAbsence += absence
+			feuille.Lignes = append(feuille.Lignes, LigneFeuilleTemps{
+				PlanningID:    planning.ID,
+				SerieID:       planning.SerieID,
+				Date:          planning.Date,
+				HeureDebut:    planning.HeureDebut,
+				HeureFin:      planning.HeureFin,
+				TypeEvenement: planning.TypeEvenement,
+				ClientNom:     clients[planning.ClientID],
+				Objet:         objet,
+				Heures:        arrondirHeures(heures),
+			})
+		}
+
+		for i := range feuille.Semaines {
+			semaine := &feuille.Semaines[i]
+			semaine.HeuresTravaillees = arrondirHeures(semaine.HeuresTravaillees)
+			semaine.HeuresAbsence = arrondirHeures(semaine.HeuresAbsence)
+			semaine.HeuresSupplementaires = arrondirHeures(math.Max(0, semaine.HeuresTravaillees-cfg.SeuilHebdomadaire))
+			arrondirHeuresParType(semaine.HeuresParType)
+			if semaine.Rattachee {
+				feuille.HeuresSupplementaires += semaine.HeuresSupplementaires
+			}
+		}
+		feuille.HeuresTravaillees = arrondirHeures(feuille.HeuresTravaillees)
+		feuille.HeuresAbsence = arrondirHeures(feuille.HeuresAbsence)
+		feuille.HeuresSupplementaires = arrondirHeures(feuille.HeuresSupplementaires)
+		arrondirHeuresParType(feuille.HeuresParType)
+
+		response.Salaries = append(response.Salaries, feuille)
+	}
+
+	return response, nil
+}
+
+// heuresFeuilleTemps retourne les heures comptées pour un planning : sa durée, ou une journée s'il n'a pas d'horaires
+func heuresFeuilleTemps(planning *models.Planning, heuresJournee float64) float64 {
+	if planning.HeureDebut == "" && planning.HeureFin == "" {
+		return heuresJournee
+	}
+	duree, err := planning.Duree()
+	if err != nil {
+		return 0
+	}
+	return duree
+}
+
+// repartitionHeures répartit les heures d'un planning entre temps de travail et absence.
+// Un rendez-vous privé n'est ni l'un ni l'autre.
+func repartitionHeures(typeEvenement string, heures float64) (travail, absence float64) {
+	switch {
+	case models.EstAbsence(typeEvenement):
+		return 0, heures
+	case typeEvenement == models.TypeRDVPrive:
+		return 0, 0
+	}
+	return heures, 0
+}
+
+// nomsClientsPlannings retourne le nom d'affichage des clients des plannings
+func nomsClientsPlannings(entrepriseID uint, plannings []models.Planning) (map[uint]string, error) {
+	noms := make(map[uint]string)
+	var ids []uint
+	for _, planning := range plannings {
+		if planning.ClientID == 0 {
+			continue
+		}
+		if _, ok := noms[planning.ClientID]; !ok {
+			noms[planning.ClientID] = ""
+			ids = append(ids, planning.ClientID)
+		}
+	}
+	if len(ids) == 0 {
+		return noms, nil
+	}
+
+	var clients []models.Client
+	if err := tenantDB(entrepriseID).Where("id IN ?", ids).Find(&clients).Error; err != nil {
+		return nil, err
+	}
+	for i := range clients {
+		noms[clients[i].ID] = clients[i].GetDisplayName()
+	}
+	return noms, nil
+}
+
+// lundiSemaine retourne le lundi de la semaine d'une date
+func lundiSemaine(jour time.Time) time.Time {
+	decalage := (int(jour.Weekday()) + 6) % 7
+	return jour.AddDate(0, 0, -decalage)
+}
+
+// arrondirHeures arrondit un nombre d'heures au centième
+func arrondirHeures(heures float64) float64 {
+	return math.Round(heures*100) / 100
+}
+
+// arrondirHeuresParType arrondit au centième les heures de chaque type d'événement
+func arrondirHeuresParType(heures map[string]float64) {
+	for typeEvenement, h := range heures {
+		heures[typeEvenement] = arrondirHeures(h)
+	}
+}
+
+// typesFeuilleTemps retourne les types d'événements comptés dans les feuilles de temps, dans l'ordre des colonnes
+func typesFeuilleTemps() []string {
+	var types []string
+	for _, t := range models.GetTypesEvenements() {
+		if t != models.TypeAnnulation {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// formatHeures formate un nombre d'heures avec une virgule décimale (7,5), comme attendu par les tableurs français
+func formatHeures(heures float64) string {
+	return strings.Replace(strconv.FormatFloat(arrondirHeures(heures), 'f', -1, 64), ".", ",", 1)
+}
+
+// formatDateFR formate une date AAAA-MM-JJ en JJ/MM/AAAA
+func formatDateFR(date string) string {
+	jour, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return jour.Format("02/01/2006")
+}
+
+// feuillesTempsCSV produit l'export CSV des feuilles de temps (UTF-8 avec BOM pour l'ouverture dans un tableur)
+func feuillesTempsCSV(feuilles FeuillesTempsResponse) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+
+	writer := csv.NewWriter(&buf)
+	writer.Comma = ';'
+
+	types := typesFeuilleTemps()
+	entete := []string{"Salarié", "Semaine", "Du", "Au"}
+	entete = append(entete, types...)
+	entete = append(entete, "Heures travaillées", "Heures d'absence", "Heures supplémentaires")
+	if err := writer.Write(entete); err != nil {
+		return nil, err
+	}
+
+	ligne := func(salarie, semaine, du, au string, parType map[string]float64, travail, absence string, supplementaires string) error {
+		valeurs := []string{salarie, semaine, du, au}
+		for _, t := range types {
+			valeurs = append(valeurs, formatHeures(parType[t]))
+		}
+		valeurs = append(valeurs, travail, absence, supplementaires)
+		return writer.Write(valeurs)
+	}
+
+	for _, feuille := range feuilles.Salaries {
+		for _, semaine := range feuille.Semaines {
+			// Une semaine non rattachée est reportée sur la période suivante : ses heures supplémentaires n'y sont pas comptées
+			supplementaires := formatHeures(semaine.HeuresSupplementaires)
+			if !semaine.Rattachee {
+				supplementaires = ""
+			}
+			err := ligne(feuille.SalarieNom, semaine.Semaine, formatDateFR(semaine.DateDebut), formatDateFR(semaine.DateFin),
+				semaine.HeuresParType, formatHeures(semaine.HeuresTravaillees), formatHeures(semaine.HeuresAbsence), supplementaires)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := ligne(feuille.SalarieNom, "Total période", formatDateFR(feuilles.DateDebut), formatDateFR(feuilles.DateFin),
+			feuille.HeuresParType, formatHeures(feuille.HeuresTravaillees), formatHeures(feuille.HeuresAbsence), formatHeures(feuille.HeuresSupplementaires))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// feuillesTempsPDF prépare le rapport PDF des feuilles de temps : une section par salarié
+func feuillesTempsPDF(feuilles FeuillesTempsResponse, company config.CompanyInfo) utils.RapportPDF {
+	rapport := utils.RapportPDF{
+		Titre:        utils.TitrePDFFeuilleTemps,
+		Emetteur:     emetteurPDF(company),
+		PiedDePage:   piedDePagePDF(company),
+		DateCreation: time.Now(),
+	}
+
+	periode := fmt.Sprintf("Du %s au %s", formatDateFR(feuilles.DateDebut), formatDateFR(feuilles.DateFin))
+	parametres := fmt.Sprintf("Seuil hebdomadaire : %s h - Journée : %s h", formatHeures(feuilles.SeuilHebdomadaire), formatHeures(feuilles.HeuresJournee))
+
+	for _, feuille := range feuilles.Salaries {
+		recapitulatif := utils.TableauPDF{
+			Titre: "Récapitulatif",
+			Colonnes: []utils.ColonneTableauPDF{
+				{Titre: "TYPE D'ÉVÉNEMENT", Largeur: 140, Align: "L"},
+				{Titre: "HEURES", Largeur: 40, Align: "R"},
+			},
+		}
+		types := make([]string, 0, len(feuille.HeuresParType))
+		for t := range feuille.HeuresParType {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			recapitulatif.Lignes = append(recapitulatif.Lignes, []string{t, formatHeures(feuille.HeuresParType[t])})
+		}
+		recapitulatif.Lignes = append(recapitulatif.Lignes,
+			[]string{"Heures travaillées", formatHeures(feuille.HeuresTravaillees)},
+			[]string{"Heures d'absence (absence, congé, maladie, RTT)", formatHeures(feuille.HeuresAbsence)},
+		)
+		recapitulatif.Total = []string{"Heures supplémentaires", formatHeures(feuille.HeuresSupplementaires)}
+
+		semaines := utils.TableauPDF{
+			Titre: "Semaines",
+			Colonnes: []utils.ColonneTableauPDF{
+				{Titre: "SEMAINE", Largeur: 30, Align: "L"},
+				{Titre: "DU", Largeur: 30, Align: "C"},
+				{Titre: "AU", Largeur: 30, Align: "C"},
+				{Titre: "TRAVAILLÉES", Largeur: 30, Align: "R"},
+				{Titre: "ABSENCES", Largeur: 30, Align: "R"},
+				{Titre: "SUPPL.", Largeur: 30, Align: "R"},
+			},
+		}
+		for _, semaine := range feuille.Semaines {
+			supplementaires := formatHeures(semaine.HeuresSupplementaires)
+			if !semaine.Rattachee {
+				supplementaires = "période suivante"
+			}
+			semaines.Lignes = append(semaines.Lignes, []string{
+				semaine.Semaine, formatDateFR(semaine.DateDebut), formatDateFR(semaine.DateFin),
+				formatHeures(semaine.HeuresTravaillees), formatHeures(semaine.HeuresAbsence), supplementaires,
+			})
+		}
+
+		detail := utils.TableauPDF{
+			Titre: "Détail des plannings",
+			Colonnes: []utils.ColonneTableauPDF{
+				{Titre: "DATE", Largeur: 22, Align: "C"},
+				{Titre: "HORAIRES", Largeur: 26, Align: "C"},
+				{Titre: "TYPE", Largeur: 32, Align: "L"},
+				{Titre: "CLIENT / OBJET", Largeur: 80, Align: "L"},
+				{Titre: "HEURES", Largeur: 20, Align: "R"},
+			},
+		}
+		for _, ligne := range feuille.Lignes {
+			horaires := "Journée"
+			if ligne.HeureDebut != "" || ligne.HeureFin != "" {
+				horaires = ligne.HeureDebut + " - " + ligne.HeureFin
+			}
+			libelle := ligne.ClientNom
+			if ligne.Objet != "" {
+				libelle = strings.TrimPrefix(libelle+" - "+ligne.Objet, " - ")
+			}
+			detail.Lignes = append(detail.Lignes, []string{
+				formatDateFR(ligne.Date), horaires, ligne.TypeEvenement, libelle, formatHeures(ligne.Heures),
+			})
+		}
+
+		rapport.Sections = append(rapport.Sections, utils.SectionRapportPDF{
+			Titre:    feuille.SalarieNom,
+			Mentions: []string{periode, parametres},
+			Tableaux: []utils.TableauPDF{recapitulatif, semaines, detail},
+		})
+	}
+
+	return rapport
+}
@@ -0,0 +1,459 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	ics "github.com/arran4/golang-ical"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tailleMaxImportICS limite la taille d'un fichier iCalendar importé
+const tailleMaxImportICS = 2 << 20
+
+var errEvenementDejaImporte = errors.New("Événement déjà importé")
+
+// ErreurImportICS décrit un événement iCalendar qui n'a pas pu être importé
+type ErreurImportICS struct {
+	UID     string `json:"uid" example:"040000008200E00074C5B7101A82E008@outlook.com"`
+	Resume  string `json:"resume" example:"Entretien des bureaux"`
+	Message string `json:"message" example:"DTEND manquant"`
+}
+
+// ResultatImportICS représente le résultat de l'import d'un fichier iCalendar
+type ResultatImportICS struct {
+	NbEvenements int               `json:"nb_evenements" example:"12"`
+	NbPlannings  int               `json:"nb_plannings" example:"9"`
+	NbSeries     int               `json:"nb_series" example:"1"`
+	NbIgnores    int               `json:"nb_ignores" example:"2"`
+	Erreurs      []ErreurImportICS `json:"erreurs"`
+}
+
+// categoriesTypesEvenements associe aux types d'événements les catégories usuelles des agendas (sans accents, en minuscules)
+var categoriesTypesEvenements = map[string]string{
+	"conges":            models.TypeConge,
+	"vacances":          models.TypeConge,
+	"vacation":          models.TypeConge,
+	"holiday":           models.TypeConge,
+	"arret maladie":     models.TypeMaladie,
+	"sick":              models.TypeMaladie,
+	"sick leave":        models.TypeMaladie,
+	"training":          models.TypeFormation,
+	"chantier":          models.TypeIntervention,
+	"meeting":           models.TypeReunion,
+	"rendez-vous prive": models.TypeRDVPrive,
+	"prive":             models.TypeRDVPrive,
+	"private":           models.TypeRDVPrive,
+	"personal":          models.TypeRDVPrive,
+	"rappel":            models.TypeRappelTelephonique,
+	"appel":             models.TypeRappelTelephonique,
+	"phone call":        models.TypeRappelTelephonique,
+	"call":              models.TypeRappelTelephonique,
+	"out of office":     models.TypeAbsence,
+	"medical":           models.TypeVisiteMedicale,
+}
+
+var sansAccents = strings.NewReplacer("à", "a", "â", "a", "ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e", "î", "i", "ï", "i", "ô", "o", "ù", "u", "û", "u", "ü", "u")
+
+var formatDateICS = regexp.MustCompile(`^(\d{8})(T(\d{6})(Z)?)?$`)
+
+// ImportPlanningsICS godoc
+// @Summary Importer des plannings depuis un fichier iCalendar
+// @Description Crée un planning par événement du fichier .ics (un par jour pour un événement sur plusieurs journées) et une série pour un événement récurrent (RRULE, EXDATE, occurrences modifiées par RECURRENCE-ID). La catégorie (CATEGORIES) donne le type d'événement ; à défaut, type_evenement est utilisé. Un événement déjà importé (même UID) est ignoré. Les conflits avec les plannings du salarié sont contrôlés comme à la création.
+// @Tags Planning
+// @Accept multipart/form-data
+// @Produce json
+// @Param fichier formData file true "Fichier iCalendar (.ics)"
+// @Param salarie_id formData int false "Salarié des plannings importés"
+// @Param client_id formData int false "Client des plannings importés"
+// @Param type_evenement formData string false "Type des événements sans catégorie reconnue (Divers par défaut)"
+// @Param forcer query bool false "Importer malgré les chevauchements"
+// @Success 200 {object} ResultatImportICS
+// @Failure 400 {string} string "Fichier ou paramètres invalides"
+// @Failure 413 {string} string "Fichier trop volumineux"
+// @Router /plannings/import-ics [post]
+func ImportPlanningsICS(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, tailleMaxImportICS+64<<10)
+	fichier, _, err := r.FormFile("fichier")
+	if err != nil {
+		http.Error(w, "Fichier 'fichier' manquant", http.StatusBadRequest)
+		return
+	}
+	defer fichier.Close()
+
+	contenu, err := io.ReadAll(io.LimitReader(fichier, tailleMaxImportICS+1))
+	if err != nil {
+		http.Error(w, "Erreur de lecture du fichier", http.StatusBadRequest)
+		return
+	}
+	if len(contenu) > tailleMaxImportICS {
+		http.Error(w, "Fichier trop volumineux (2 Mo maximum)", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	modele := models.Planning{EntrepriseID: entrepriseID, TypeEvenement: models.TypeDivers}
+	if valeur := r.FormValue("salarie_id"); valeur != "" {
+		id, err := strconv.ParseUint(valeur, 10, 64)
+		if err != nil {
+			http.Error(w, "Salarié invalide", http.StatusBadRequest)
+			return
+		}
+		modele.SalarieID = uint(id)
+	}
+	if valeur := r.FormValue("client_id"); valeur != "" {
+		id, err := strconv.ParseUint(valeur, 10, 64)
+		if err != nil {
+			http.Error(w, "Client invalide", http.StatusBadRequest)
+			return
+		}
+		modele.ClientID = uint(id)
+	}
+	if err := validatePlanningRelations(&modele); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if valeur := r.FormValue("type_evenement"); valeur != "" {
+		typeEvenement, ok := typeEvenementCategorie(valeur)
+		if !ok {
+			http.Error(w, "Type d'événement invalide", http.StatusBadRequest)
+			return
+		}
+		modele.TypeEvenement = typeEvenement
+	}
+
+	calendrier, err := ics.ParseCalendar(strings.NewReader(string(contenu)))
+	if err != nil {
+		http.Error(w, "Fichier iCalendar invalide : "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := config.GetCalendrierConfig()
+	conflits := nouveauControleConflits(r)
+	resultat := ResultatImportICS{Erreurs: []ErreurImportICS{}}
+	series := make(map[string]*models.SeriePlanning)
+
+	// Les événements principaux sont importés avant les occurrences modifiées (RECURRENCE-ID) qui s'y rattachent
+	evenements := calendrier.Events()
+	var modifications []*ics.VEvent
+	for _, evenement := range evenements {
+		resultat.NbEvenements++
+		if evenement.GetProperty(ics.ComponentPropertyRecurrenceId) != nil {
+			modifications = append(modifications, evenement)
+			continue
+		}
+		nbPlannings, serie, err := importerEvenementICS(evenement, modele, conflits, cfg)
+		noterImportICS(&resultat, evenement, err)
+		resultat.NbPlannings += nbPlannings
+		if serie != nil {
+			resultat.NbSeries++
+			series[serie.UIDImport] = serie
+		}
+	}
+	for _, evenement := range modifications {
+		err := importerOccurrenceModifieeICS(evenement, modele, series, conflits, cfg)
+		noterImportICS(&resultat, evenement, err)
+		if err == nil {
+			resultat.NbPlannings++
+		}
+	}
+
+	conflits.signaler(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultat)
+}
+
+// noterImportICS enregistre dans le résultat l'échec ou l'abandon de l'import d'un événement
+func noterImportICS(resultat *ResultatImportICS, evenement *ics.VEvent, err error) {
+	switch {
+	case err == nil:
+	case err == errEvenementDejaImporte:
+		resultat.NbIgnores++
+	default:
+		resume := ""
+		if propriete := evenement.GetProperty(ics.ComponentPropertySummary); propriete != nil {
+			resume = ics.FromText(propriete.Value)
+		}
+		message := err.Error()
+		var refus *erreurConflits
+		if errors.As(err, &refus) && len(refus.conflits) > 0 {
+			message += " (" + refus.conflits[0].Message + ")"
+		}
+		resultat.Erreurs = append(resultat.Erreurs, ErreurImportICS{UID: evenement.Id(), Resume: resume, Message: message})
+	}
+}
+
+// importerEvenementICS crée les plannings d'un événement, ou sa série s'il est récurrent
+func importerEvenementICS(evenement *ics.VEvent, modele models.Planning, conflits *controleConflits, cfg config.CalendrierConfig) (int, *models.SeriePlanning, error) {
+	uid := evenement.Id()
+	if uid != "" && evenementDejaImporte(modele.EntrepriseID, uid) {
+		return 0, nil, errEvenementDejaImporte
+	}
+
+	debut, fin, journee, err := periodeEvenementICS(evenement, cfg)
+	if err != nil {
+		return 0, nil, err
+	}
+	planning := planningEvenementICS(evenement, modele)
+	planning.UIDImport = uid
+	planning.Date = debut.Format("2006-01-02")
+	if !journee {
+		if fin.Sub(debut) > 24*time.Hour {
+			return 0, nil, fmt.Errorf("Événement de plus de 24 heures non pris en charge")
+		}
+		planning.HeureDebut = debut.Format("15:04")
+		planning.HeureFin = fin.Format("15:04")
+	}
+
+	if regle := evenement.GetProperty(ics.ComponentPropertyRrule); regle != nil {
+		if fin.Sub(debut) > 24*time.Hour {
+			return 0, nil, fmt.Errorf("Événement récurrent sur plusieurs jours non pris en charge")
+		}
+		serie, err := creerSerieEvenementICS(evenement, regle.Value, &planning, conflits, cfg)
+		return 0, serie, err
+	}
+
+	// Un événement sur plusieurs journées (congés d'une semaine) donne un planning par jour
+	var plannings []models.Planning
+	for jour := debut; jour.Before(fin) || len(plannings) == 0; jour = jour.AddDate(0, 0, 1) {
+		if len(plannings) >= maxJoursPeriodePlannings {
+			return 0, nil, fmt.Errorf("Événement trop long (%d jours maximum)", maxJoursPeriodePlannings)
+		}
+		planningJour := planning
+		planningJour.Date = jour.Format("2006-01-02")
+		if err := validerPlanning(&planningJour); err != nil {
+			return 0, nil, err
+		}
+		plannings = append(plannings, planningJour)
+	}
+
+	if err := conflits.verifier(config.DB, modele.EntrepriseID, plannings, nil); err != nil {
+		return 0, nil, err
+	}
+	if err := config.DB.Omit(clause.Associations).Create(&plannings).Error; err != nil {
+		return 0, nil, err
+	}
+	return len(plannings), nil, nil
+}
+
+// creerSerieEvenementICS crée la série d'un événement récurrent à partir du modèle de sa première occurrence
+func creerSerieEvenementICS(evenement *ics.VEvent, regle string, planning *models.Planning, conflits *controleConflits, cfg config.CalendrierConfig) (*models.SeriePlanning, error) {
+	serie := models.SeriePlanning{
+		EntrepriseID:  planning.EntrepriseID,
+		RRule:         regleImportee(regle, cfg),
+		DateDebut:     planning.Date,
+		HeureDebut:    planning.HeureDebut,
+		HeureFin:      planning.HeureFin,
+		TypeEvenement: planning.TypeEvenement,
+		SalarieID:     planning.SalarieID,
+		ClientID:      planning.ClientID,
+		Objet:         planning.Objet,
+		Facturation:   planning.Facturation,
+		UIDImport:     planning.UIDImport,
+	}
+	for _, propriete := range evenement.GetProperties(ics.ComponentPropertyExdate) {
+		for _, valeur := range strings.Split(propriete.Value, ",") {
+			date, _, err := lireDateICS(valeur, propriete.ICalParameters, cfg)
+			if err != nil {
+				return nil, fmt.Errorf("EXDATE invalide : %s", valeur)
+			}
+			serie.Exceptions = append(serie.Exceptions, models.ExceptionSeriePlanning{Date: date.Format("2006-01-02")})
+		}
+	}
+
+	if err := validerSerie(&serie); err != nil {
+		return nil, err
+	}
+	if err := conflits.verifierSerie(config.DB, &serie, nil); err != nil {
+		return nil, err
+	}
+	if err := config.DB.Omit("Salarie", "Client").Create(&serie).Error; err != nil {
+		return nil, err
+	}
+	return &serie, nil
+}
+
+// importerOccurrenceModifieeICS enregistre une occurrence modifiée (RECURRENCE-ID) d'une série importée
+func importerOccurrenceModifieeICS(evenement *ics.VEvent, modele models.Planning, series map[string]*models.SeriePlanning, conflits *controleConflits, cfg config.CalendrierConfig) error {
+	serie := series[evenement.Id()]
+	if serie == nil {
+		if evenementDejaImporte(modele.EntrepriseID, evenement.Id()) {
+			return errEvenementDejaImporte
+		}
+		return fmt.Errorf("Occurrence modifiée d'un événement récurrent absent du fichier")
+	}
+
+	propriete := evenement.GetProperty(ics.ComponentPropertyRecurrenceId)
+	origine, _, err := lireDateICS(propriete.Value, propriete.ICalParameters, cfg)
+	if err != nil {
+		return fmt.Errorf("RECURRENCE-ID invalide : %s", propriete.Value)
+	}
+	date := origine.Format("2006-01-02")
+	if !serie.EstOccurrence(date) {
+		return errOccurrenceIntrouvable
+	}
+
+	debut, fin, journee, err := periodeEvenementICS(evenement, cfg)
+	if err != nil {
+		return err
+	}
+	planning := planningEvenementICS(evenement, modele)
+	planning.Date = debut.Format("2006-01-02")
+	if !journee {
+		planning.HeureDebut = debut.Format("15:04")
+		planning.HeureFin = fin.Format("15:04")
+	}
+	planning.SerieID = &serie.ID
+	planning.DateOccurrence = date
+	if err := validerPlanning(&planning); err != nil {
+		return err
+	}
+
+	return config.DB.Transaction(func(tx *gorm.DB) error {
+		if propriete := evenement.GetProperty(ics.ComponentPropertyStatus); propriete != nil && strings.EqualFold(propriete.Value, string(ics.ObjectStatusCancelled)) {
+			return ajouterExceptionSerie(tx, serie.ID, date)
+		}
+		if err := conflits.verifier(tx, modele.EntrepriseID, []models.Planning{planning}, nil); err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Create(&planning).Error
+	})
+}
+
+// evenementDejaImporte indique si un planning ou une série de l'entreprise provient déjà de l'événement uid
+func evenementDejaImporte(entrepriseID uint, uid string) bool {
+	var nb int64
+	config.DB.Model(&models.Planning{}).Where("entreprise_id = ? AND uid_import = ?", entrepriseID, uid).Count(&nb)
+	if nb > 0 {
+		return true
+	}
+	config.DB.Model(&models.SeriePlanning{}).Where("entreprise_id = ? AND uid_import = ?", entrepriseID, uid).Count(&nb)
+	return nb > 0
+}
+
+// planningEvenementICS construit le planning d'un événement à partir du modèle de l'import : type d'après la catégorie,
+// objet d'après le résumé. Un événement annulé (STATUS:CANCELLED) devient un planning de type Annulation.
+func planningEvenementICS(evenement *ics.VEvent, modele models.Planning) models.Planning {
+	planning := modele
+	planning.NbRepetitions = 1
+	planning.Facturation = models.FacturationAFacturer
+
+	for _, propriete := range evenement.GetProperties(ics.ComponentPropertyCategories) {
+		if typeEvenement, ok := typeEvenementCategorie(propriete.Value); ok {
+			planning.TypeEvenement = typeEvenement
+			break
+		}
+	}
+	if propriete := evenement.GetProperty(ics.ComponentPropertyStatus); propriete != nil && strings.EqualFold(propriete.Value, string(ics.ObjectStatusCancelled)) {
+		planning.TypeEvenement = models.TypeAnnulation
+	}
+	if !typeFacturable(planning.TypeEvenement) {
+		planning.Facturation = models.FacturationNonFacturable
+	}
+
+	if propriete := evenement.GetProperty(ics.ComponentPropertySummary); propriete != nil {
+		if resume := strings.TrimSpace(ics.FromText(propriete.Value)); resume != "" {
+			planning.Objet = &resume
+		}
+	}
+	return planning
+}
+
+// typeEvenementCategorie retrouve le type d'événement d'une ou plusieurs catégories iCalendar séparées par des virgules
+func typeEvenementCategorie(categories string) (string, bool) {
+	for _, categorie := range strings.Split(ics.FromText(categories), ",") {
+		cle := sansAccents.Replace(strings.ToLower(strings.TrimSpace(categorie)))
+		if cle == "" {
+			continue
+		}
+		for _, typeEvenement := range models.GetTypesEvenements() {
+			if cle == sansAccents.Replace(strings.ToLower(typeEvenement)) {
+				return typeEvenement, true
+			}
+		}
+		if typeEvenement, ok := categoriesTypesEvenements[cle]; ok {
+			return typeEvenement, true
+		}
+	}
+	return "", false
+}
+
+// periodeEvenementICS retourne le début et la fin d'un événement dans le fuseau des plannings.
+// Un événement à la journée (VALUE=DATE) sans fin dure un jour.
+func periodeEvenementICS(evenement *ics.VEvent, cfg config.CalendrierConfig) (time.Time, time.Time, bool, error) {
+	proprieteDebut := evenement.GetProperty(ics.ComponentPropertyDtStart)
+	if proprieteDebut == nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("DTSTART manquant")
+	}
+	debut, journee, err := lireDateICS(proprieteDebut.Value, proprieteDebut.ICalParameters, cfg)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("DTSTART invalide : %s", proprieteDebut.Value)
+	}
+
+	proprieteFin := evenement.GetProperty(ics.ComponentPropertyDtEnd)
+	if proprieteFin == nil {
+		if journee {
+			return debut, debut.AddDate(0, 0, 1), true, nil
+		}
+		return time.Time{}, time.Time{}, false, fmt.Errorf("DTEND manquant")
+	}
+	fin, _, err := lireDateICS(proprieteFin.Value, proprieteFin.ICalParameters, cfg)
+	if err != nil || fin.Before(debut) {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("DTEND invalide : %s", proprieteFin.Value)
+	}
+	return debut, fin, journee, nil
+}
+
+// lireDateICS lit une date iCalendar (AAAAMMJJ, AAAAMMJJTHHMMSS avec ou sans TZID, ou en UTC avec Z)
+// et la retourne dans le fuseau des plannings
+func lireDateICS(valeur string, parametres map[string][]string, cfg config.CalendrierConfig) (time.Time, bool, error) {
+	morceaux := formatDateICS.FindStringSubmatch(strings.TrimSpace(valeur))
+	if morceaux == nil {
+		return time.Time{}, false, fmt.Errorf("date iCalendar invalide : %s", valeur)
+	}
+	if morceaux[2] == "" {
+		jour, err := time.ParseInLocation("20060102", morceaux[1], cfg.Fuseau)
+		return jour, true, err
+	}
+
+	location := cfg.Fuseau
+	if morceaux[4] == "Z" {
+		location = time.UTC
+	} else if tzid := parametres[string(ics.ParameterTzid)]; len(tzid) == 1 {
+		if fuseau, err := time.LoadLocation(strings.Trim(tzid[0], `"`)); err == nil {
+			location = fuseau
+		}
+	}
+	instant, err := time.ParseInLocation("20060102150405", morceaux[1]+morceaux[3], location)
+	return instant.In(cfg.Fuseau), false, err
+}
+
+// regleImportee adapte une règle importée : la fin UNTIL, en UTC ou en heure locale, est ramenée au jour
+// de la dernière occurrence dans le fuseau des plannings, comme les règles saisies dans l'application
+func regleImportee(regle string, cfg config.CalendrierConfig) string {
+	var parties []string
+	for _, partie := range strings.Split(strings.TrimPrefix(strings.TrimSpace(regle), "RRULE:"), ";") {
+		if strings.HasPrefix(strings.ToUpper(partie), "UNTIL=") {
+			if fin, _, err := lireDateICS(partie[len("UNTIL="):], nil, cfg); err == nil {
+				partie = "UNTIL=" + fin.Format("20060102")
+			}
+		}
+		parties = append(parties, partie)
+	}
+	return strings.Join(parties, ";")
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"facturation-planning/config"
+)
+
+// genererJetonAcces génère un jeton aléatoire de 256 bits pour un lien public (abonnement, devis en ligne)
+func genererJetonAcces() (string, error) {
+	aleatoire := make([]byte, 32)
+	if _, err := rand.Read(aleatoire); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aleatoire), nil
+}
+
+// empreinteJetonAcces retourne l'empreinte enregistrée d'un jeton : le jeton lui-même n'est jamais stocké
+func empreinteJetonAcces(jeton string) string {
+	somme := sha256.Sum256([]byte(jeton))
+	return hex.EncodeToString(somme[:])
+}
+
+// urlPublique construit l'URL publique d'un chemin de l'API, à partir de API_PUBLIC_URL ou de la requête
+func urlPublique(r *http.Request, chemin string) string {
+	base := config.GetCalendrierConfig().URLPublique
+	if base == "" {
+		schema := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			schema = "https"
+		}
+		base = schema + "://" + r.Host
+	}
+	return base + chemin
+}
+
+// adresseIPClient retourne l'adresse IP du client. Les en-têtes X-Forwarded-For et X-Real-IP ne sont crus que si la requête
+// provient d'un proxy de confiance (TRUSTED_PROXIES) : sinon, n'importe quel client pourrait y inscrire l'adresse de son choix.
+func adresseIPClient(r *http.Request) string {
+	hote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		hote = r.RemoteAddr
+	}
+
+	proxies := config.GetProxyConfig()
+	if !proxies.EstDeConfiance(net.ParseIP(hote)) {
+		return hote
+	}
+
+	// Chaque proxy ajoute l'adresse qu'il a reçue à droite : la première adresse qui n'est pas un proxy de confiance,
+	// en partant de la droite, est celle du client
+	if transmise := r.Header.Get("X-Forwarded-For"); transmise != "" {
+		adresses := strings.Split(transmise, ",")
+		for i := len(adresses) - 1; i >= 0; i-- {
+			adresse := strings.TrimSpace(adresses[i])
+			if i == 0 || !proxies.EstDeConfiance(net.ParseIP(adresse)) {
+				return adresse
+			}
+		}
+	}
+	if reelle := r.Header.Get("X-Real-IP"); reelle != "" {
+		return strings.TrimSpace(reelle)
+	}
+	return hote
+}
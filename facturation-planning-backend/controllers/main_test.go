@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"facturation-planning/database/basetest"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(basetest.Executer(m))
+}
+
+// requeteEntreprise construit une requête authentifiée pour l'entreprise, comme après JWTMiddleware,
+// avec les paramètres d'URL attendus par le handler (paires nom, valeur)
+func requeteEntreprise(entrepriseID uint, methode, corps string, parametres ...string) *http.Request {
+	req := httptest.NewRequest(methode, "/", strings.NewReader(corps))
+	req.Header.Set("Content-Type", "application/json")
+
+	rctx := chi.NewRouteContext()
+	for i := 0; i+1 < len(parametres); i += 2 {
+		rctx.URLParams.Add(parametres[i], parametres[i+1])
+	}
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, "entrepriseID", entrepriseID)
+	return req.WithContext(ctx)
+}
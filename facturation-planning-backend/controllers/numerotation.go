@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// jetonCompteur repère le compteur d'un format de numérotation ({NNNN})
+var jetonCompteur = regexp.MustCompile(`\{N+\}`)
+
+// jetonsNumerotation repère tous les jetons d'un format de numérotation
+var jetonsNumerotation = regexp.MustCompile(`\{[^{}]*\}`)
+
+// FormatNumerotationResponse représente le format de numérotation d'un type de document
+type FormatNumerotationResponse struct {
+	TypeDocument  string `json:"type_document" example:"facture"`
+	Pattern       string `json:"pattern" example:"FAC-{YYYY}-{NNNN}"`
+	ParDefaut     bool   `json:"par_defaut" example:"true"`
+	DernierNumero int    `json:"dernier_numero" example:"41"`
+	Prochain      string `json:"prochain" example:"FAC-2025-0042"`
+}
+
+// UpdateFormatNumerotationRequest représente la modification d'un format de numérotation
+type UpdateFormatNumerotationRequest struct {
+	Pattern string `json:"pattern" example:"FAC-{YYYY}-{NNNN}"`
+}
+
+// GetFormatsNumerotation godoc
+// @Summary Lister les formats de numérotation
+// @Description Retourne, pour chaque type de document (facture, acompte, avoir, devis), le format utilisé et le prochain numéro
+// @Tags Numérotation
+// @Produce json
+// @Success 200 {array} FormatNumerotationResponse
+// @Failure 401 {string} string "Erreur d'authentification"
+// @Router /numerotation [get]
+func GetFormatsNumerotation(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	types := []string{models.TypeDocumentFacture, models.TypeDocumentAcompte, models.TypeDocumentAvoir, models.TypeDocumentDevis}
+
+	formats := make([]FormatNumerotationResponse, 0, len(types))
+	for _, typeDocument := range types {
+		pattern, parDefaut := patternNumerotation(config.DB, entrepriseID, typeDocument)
+
+		var sequence models.SequenceNumerotation
+		config.DB.Where("entreprise_id = ? AND type_document = ? AND annee = ?",
+			entrepriseID, typeDocument, anneeSequence(pattern, now)).Find(&sequence)
+
+		formats = append(formats, FormatNumerotationResponse{
+			TypeDocument:  typeDocument,
+			Pattern:       pattern,
+			ParDefaut:     parDefaut,
+			DernierNumero: sequence.DernierNumero,
+			Prochain:      formaterNumero(pattern, now, sequence.DernierNumero+1),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(formats)
+}
+
+// UpdateFormatNumerotation godoc
+// @Summary Modifier un format de numérotation
+// @Description Définit le format de numérotation d'un type de document. Jetons : {YYYY}, {YY}, {MM} et un compteur {N…} obligatoire.
+// @Tags Numérotation
+// @Accept json
+// @Produce json
+// @Param type path string true "Type de document" Enums(facture, acompte, avoir, devis)
+// @Param format body UpdateFormatNumerotationRequest true "Nouveau format"
+// @Success 200 {object} FormatNumerotationResponse
+// @Failure 400 {string} string "Format invalide"
+// @Failure 404 {string} string "Type de document inconnu"
+// @Failure 500 {string} string "Erreur lors de l'enregistrement"
+// @Router /numerotation/{type} [put]
+func UpdateFormatNumerotation(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	typeDocument := chi.URLParam(r, "type")
+	if _, connu := models.PatternsNumerotationParDefaut[typeDocument]; !connu {
+		http.Error(w, "Type de document inconnu", http.StatusNotFound)
+		return
+	}
+
+	var req UpdateFormatNumerotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	if err := validerPatternNumerotation(req.Pattern); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := models.FormatNumerotation{
+		EntrepriseID: entrepriseID,
+		TypeDocument: typeDocument,
+		Pattern:      req.Pattern,
+	}
+	err := config.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "entreprise_id"}, {Name: "type_document"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pattern", "updated_at"}),
+	}).Create(&format).Error
+	if err != nil {
+		http.Error(w, "Erreur lors de l'enregistrement du format de numérotation", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	var sequence models.SequenceNumerotation
+	config.DB.Where("entreprise_id = ? AND type_document = ? AND annee = ?",
+		entrepriseID, typeDocument, anneeSequence(req.Pattern, now)).Find(&sequence)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FormatNumerotationResponse{
+		TypeDocument:  typeDocument,
+		Pattern:       req.Pattern,
+		DernierNumero: sequence.DernierNumero,
+		Prochain:      formaterNumero(req.Pattern, now, sequence.DernierNumero+1),
+	})
+}
+
+// prochainNumero attribue le numéro suivant d'un type de document pour l'entreprise.
+// Il doit être appelé dans la transaction qui enregistre le document : la ligne de séquence
+// reste verrouillée jusqu'au commit, et un rollback libère le numéro (numérotation sans trou).
+func prochainNumero(tx *gorm.DB, entrepriseID uint, typeDocument string, date time.Time) (string, error) {
+	pattern, _ := patternNumerotation(tx, entrepriseID, typeDocument)
+
+	sequence := models.SequenceNumerotation{
+		EntrepriseID: entrepriseID,
+		TypeDocument: typeDocument,
+		Annee:        anneeSequence(pattern, date),
+	}
+
+	// Créer la séquence si elle n'existe pas encore, sans erreur en cas de création concurrente
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&sequence).Error; err != nil {
+		return "", fmt.Errorf("création de la séquence de numérotation : %v", err)
+	}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("entreprise_id = ? AND type_document = ? AND annee = ?", entrepriseID, typeDocument, sequence.Annee).
+		First(&sequence).Error
+	if err != nil {
+		return "", fmt.Errorf("verrouillage de la séquence de numérotation : %v", err)
+	}
+
+	sequence.DernierNumero++
+	if err := tx.Model(&models.SequenceNumerotation{}).Where("id = ?", sequence.ID).
+		Update("dernier_numero", sequence.DernierNumero).Error; err != nil {
+		return "", fmt.Errorf("incrémentation de la séquence de numérotation : %v", err)
+	}
+
+	return formaterNumero(pattern, date, sequence.DernierNumero), nil
+}
+
+// patternNumerotation retourne le format de l'entreprise pour un type de document, ou le format par défaut
+func patternNumerotation(db *gorm.DB, entrepriseID uint, typeDocument string) (string, bool) {
+	var format models.FormatNumerotation
+	db.Where("entreprise_id = ? AND type_document = ?", entrepriseID, typeDocument).Limit(1).Find(&format)
+	if format.Pattern != "" {
+		return format.Pattern, false
+	}
+	if typeDocument == models.TypeDocumentDevis {
+		// Le format par défaut des devis reprend le préfixe choisi dans le profil de l'entreprise
+		var entreprise models.Entreprise
+		db.Select("id", "prefixe_devis").Limit(1).Find(&entreprise, entrepriseID)
+		return devisConfig(entreprise).NumberingPrefix + "{NNNN}", true
+	}
+	return models.PatternsNumerotationParDefaut[typeDocument], true
+}
+
+// anneeSequence retourne l'année de la séquence : le compteur repart à 1 chaque année
+// si le format contient l'année, sinon il est continu (année 0)
+func anneeSequence(pattern string, date time.Time) int {
+	if strings.Contains(pattern, "{YYYY}") || strings.Contains(pattern, "{YY}") {
+		return date.Year()
+	}
+	return 0
+}
+
+// formaterNumero remplace les jetons d'un format de numérotation
+func formaterNumero(pattern string, date time.Time, numero int) string {
+	return jetonsNumerotation.ReplaceAllStringFunc(pattern, func(jeton string) string {
+		switch jeton {
+		case "{YYYY}":
+			return fmt.Sprintf("%04d", date.Year())
+		case "{YY}":
+			return fmt.Sprintf("%02d", date.Year()%100)
+		case "{MM}":
+			return fmt.Sprintf("%02d", int(date.Month()))
+		}
+		if jetonCompteur.MatchString(jeton) {
+			return fmt.Sprintf("%0*d", len(jeton)-2, numero)
+		}
+		return jeton
+	})
+}
+
+// validerPatternNumerotation vérifie qu'un format de numérotation produit des numéros uniques
+func validerPatternNumerotation(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("Le format de numérotation est obligatoire")
+	}
+	if len(pattern) > 50 {
+		return fmt.Errorf("Le format de numérotation ne doit pas dépasser 50 caractères")
+	}
+	if len(jetonCompteur.FindAllString(pattern, -1)) != 1 {
+		return fmt.Errorf("Le format doit contenir exactement un compteur {N…} (ex. {NNNN})")
+	}
+	for _, jeton := range jetonsNumerotation.FindAllString(pattern, -1) {
+		if jeton != "{YYYY}" && jeton != "{YY}" && jeton != "{MM}" && !jetonCompteur.MatchString(jeton) {
+			return fmt.Errorf("Jeton inconnu dans le format de numérotation : %s", jeton)
+		}
+	}
+	if strings.Contains(pattern, "{MM}") && anneeSequence(pattern, time.Now()) == 0 {
+		return fmt.Errorf("Le jeton {MM} nécessite l'année ({YYYY} ou {YY}) dans le format")
+	}
+	return nil
+}
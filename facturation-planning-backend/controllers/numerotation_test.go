@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/database/basetest"
+	"facturation-planning/models"
+
+	"gorm.io/gorm"
+)
+
+// connexionsSimultanees borne le nombre de transactions ouvertes en même temps, sous le max_connections de PostgreSQL
+const connexionsSimultanees = 32
+
+// enParallele exécute f(0)…f(n-1) dans des goroutines libérées en même temps,
+// au plus connexionsSimultanees à la fois
+func enParallele(n int, f func(i int)) {
+	var (
+		wg     sync.WaitGroup
+		depart = make(chan struct{})
+		jetons = make(chan struct{}, connexionsSimultanees)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-depart
+			jetons <- struct{}{}
+			defer func() { <-jetons }()
+			f(i)
+		}(i)
+	}
+	close(depart)
+	wg.Wait()
+}
+
+// errAnnulation fait échouer volontairement une transaction après l'attribution du numéro
+var errAnnulation = errors.New("annulation du test")
+
+// attribuerNumeros attribue un numéro par goroutine, chacune dans sa propre transaction.
+// Les transactions dont l'indice est dans annulees sont annulées après l'attribution.
+func attribuerNumeros(t *testing.T, entrepriseID uint, dates []time.Time, annulees map[int]bool) map[int][]string {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		numeros = map[int][]string{}
+		erreurs []error
+	)
+	enParallele(len(dates), func(i int) {
+		date := dates[i]
+		var numero string
+		err := config.DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if numero, err = prochainNumero(tx, entrepriseID, models.TypeDocumentFacture, date); err != nil {
+				return err
+			}
+			// Garder le verrou le temps que les autres transactions se mettent en attente
+			time.Sleep(5 * time.Millisecond)
+			if annulees[i] {
+				return errAnnulation
+			}
+			return nil
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err == nil:
+			numeros[date.Year()] = append(numeros[date.Year()], numero)
+		case !errors.Is(err, errAnnulation):
+			erreurs = append(erreurs, err)
+		}
+	})
+	for _, err := range erreurs {
+		t.Errorf("attribution : %v", err)
+	}
+	return numeros
+}
+
+// verifierSuite vérifie que les numéros forment la suite <prefixe>-<année>-0001… sans doublon ni trou
+func verifierSuite(t *testing.T, prefixe string, annee int, numeros []string, premier, nombre int) {
+	t.Helper()
+	sort.Strings(numeros)
+	if len(numeros) != nombre {
+		t.Fatalf("%s %d : %d numéros attribués, attendu %d (%v)", prefixe, annee, len(numeros), nombre, numeros)
+	}
+	for i, numero := range numeros {
+		if attendu := fmt.Sprintf("%s-%d-%04d", prefixe, annee, premier+i); numero != attendu {
+			t.Fatalf("%s %d : %s à la place de %s, attendu une suite sans doublon ni trou à partir de %s-%d-%04d (%v)",
+				prefixe, annee, numero, attendu, prefixe, annee, premier, numeros)
+		}
+	}
+}
+
+func TestProchainNumeroConcurrent(t *testing.T) {
+	basetest.Exiger(t)
+
+	entreprise := models.Entreprise{Nom: "Numérotation", Email: "numerotation@exemple.test"}
+	if err := config.DB.Create(&entreprise).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Les transactions se croisent de part et d'autre du changement d'année, alors qu'aucune séquence n'existe encore
+	const n = 300
+	fin2025 := time.Date(2025, 12, 31, 23, 59, 0, 0, time.Local)
+	debut2026 := time.Date(2026, 1, 1, 0, 1, 0, 0, time.Local)
+	dates := make([]time.Time, n)
+	annulees := map[int]bool{}
+	for i := range dates {
+		dates[i] = fin2025
+		if i%2 == 1 {
+			dates[i] = debut2026
+		}
+		// Une transaction sur six est annulée : son numéro est réattribué
+		if i%6 == 0 {
+			annulees[i] = true
+		}
+	}
+
+	numeros := attribuerNumeros(t, entreprise.ID, dates, annulees)
+	valides := n - len(annulees)
+	if len(numeros[2025])+len(numeros[2026]) != valides {
+		t.Fatalf("%d numéros attribués, attendu %d", len(numeros[2025])+len(numeros[2026]), valides)
+	}
+	// Les transactions annulées portent toutes sur 2025 (indices pairs)
+	verifierSuite(t, "FAC", 2025, numeros[2025], 1, n/2-len(annulees))
+	verifierSuite(t, "FAC", 2026, numeros[2026], 1, n/2)
+
+	// La séquence 2025 reprend là où elle s'était arrêtée, indépendamment de 2026
+	suite := attribuerNumeros(t, entreprise.ID, []time.Time{fin2025, fin2025, fin2025}, nil)
+	verifierSuite(t, "FAC", 2025, suite[2025], n/2-len(annulees)+1, 3)
+}
+
+// brouillonsNumerotation crée n factures brouillon d'une ligne pour un client de l'entreprise
+func brouillonsNumerotation(t *testing.T, entrepriseID, clientID uint, n int) []uint {
+	t.Helper()
+	factures := make([]models.Facture, n)
+	for i := range factures {
+		factures[i] = models.Facture{
+			EntrepriseID: entrepriseID, ClientID: clientID, DateCreation: time.Now(), DateEcheance: time.Now().AddDate(0, 0, 30),
+			TypeFacture: models.TypeFactureClassique, Statut: models.StatutFactureBrouillon,
+			SousTotalHT: models.MontantEuros(100), TotalTVA: models.MontantEuros(20), TotalTTC: models.MontantEuros(120), ResteAPayer: models.MontantEuros(120),
+			Lignes: []models.LigneFacture{{
+				Description: fmt.Sprintf("Prestation %d", i+1), Unite: models.UniteForfait, Quantite: 1, PrixUnitaire: models.MontantEuros(100), TauxTVA: 20,
+				MontantHT: models.MontantEuros(100), TotalLigne: models.MontantEuros(100), MontantTTC: models.MontantEuros(120),
+			}},
+		}
+	}
+	if err := config.DB.CreateInBatches(&factures, 100).Error; err != nil {
+		t.Fatalf("création des brouillons : %v", err)
+	}
+	ids := make([]uint, n)
+	for i, f := range factures {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+// appelerEnParallele appelle le handler pour chaque document, en même temps, et retourne les codes HTTP inattendus
+func appelerEnParallele(handler http.HandlerFunc, entrepriseID uint, corps string, codeAttendu int, ids []uint) []string {
+	var (
+		mu      sync.Mutex
+		erreurs []string
+	)
+	enParallele(len(ids), func(i int) {
+		rec := httptest.NewRecorder()
+		handler(rec, requeteEntreprise(entrepriseID, "POST", corps, "id", fmt.Sprint(ids[i])))
+		if rec.Code != codeAttendu {
+			mu.Lock()
+			erreurs = append(erreurs, fmt.Sprintf("document %d : %d %s", ids[i], rec.Code, rec.Body.String()))
+			mu.Unlock()
+		}
+	})
+	return erreurs
+}
+
+// referencesEnBase retourne les références non vides de la table pour l'entreprise
+func referencesEnBase(t *testing.T, modele interface{}, entrepriseID uint) []string {
+	t.Helper()
+	var references []string
+	if err := config.DB.Model(modele).Where("entreprise_id = ? AND reference <> ''", entrepriseID).
+		Pluck("reference", &references).Error; err != nil {
+		t.Fatal(err)
+	}
+	return references
+}
+
+func TestNumerotationConcurrenteEmissionsEtAvoirs(t *testing.T) {
+	basetest.Exiger(t)
+
+	entreprise := models.Entreprise{Nom: "Numérotation HTTP", Email: "numerotation-http@exemple.test"}
+	if err := config.DB.Create(&entreprise).Error; err != nil {
+		t.Fatal(err)
+	}
+	nom := "Client numérotation"
+	client := models.Client{TypeClient: "professionnel", NomOrganisme: &nom, Email: "client-numerotation@exemple.test", EntrepriseID: entreprise.ID}
+	if err := config.DB.Create(&client).Error; err != nil {
+		t.Fatal(err)
+	}
+	annee := time.Now().Year()
+
+	// Émission simultanée de plusieurs centaines de brouillons par POST /factures/{id}/emettre
+	const emissions, avoirs, emissionsSuivantes = 300, 200, 100
+	premieres := brouillonsNumerotation(t, entreprise.ID, client.ID, emissions)
+	for _, erreur := range appelerEnParallele(EmettreFacture, entreprise.ID, "", http.StatusOK, premieres) {
+		t.Errorf("émission : %s", erreur)
+	}
+	verifierSuite(t, "FAC", annee, referencesEnBase(t, &models.Facture{}, entreprise.ID), 1, emissions)
+
+	// Avoirs totaux par POST /factures/{id}/avoirs, pendant que d'autres brouillons sont émis
+	suivantes := brouillonsNumerotation(t, entreprise.ID, client.ID, emissionsSuivantes)
+	corpsAvoir, _ := json.Marshal(CreateAvoirRequest{TypeAvoir: models.TypeAvoirTotal, Motif: "Annulation"})
+	var wg sync.WaitGroup
+	var erreursAvoirs, erreursEmissions []string
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		erreursAvoirs = appelerEnParallele(CreateAvoir, entreprise.ID, string(corpsAvoir), http.StatusCreated, premieres[:avoirs])
+	}()
+	go func() {
+		defer wg.Done()
+		erreursEmissions = appelerEnParallele(EmettreFacture, entreprise.ID, "", http.StatusOK, suivantes)
+	}()
+	wg.Wait()
+	for _, erreur := range erreursAvoirs {
+		t.Errorf("avoir : %s", erreur)
+	}
+	for _, erreur := range erreursEmissions {
+		t.Errorf("émission : %s", erreur)
+	}
+
+	// Colonnes reference des deux tables : une suite par série, sans doublon ni trou
+	verifierSuite(t, "FAC", annee, referencesEnBase(t, &models.Facture{}, entreprise.ID), 1, emissions+emissionsSuivantes)
+	verifierSuite(t, "AV", annee, referencesEnBase(t, &models.Avoir{}, entreprise.ID), 1, avoirs)
+}
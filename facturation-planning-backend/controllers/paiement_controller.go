@@ -0,0 +1,329 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errPaiementIntrouvable = errors.New("Paiement introuvable")
+	errPaiementDejaAnnule  = errors.New("Le paiement a déjà été contre-passé")
+	errFactureSoldee       = errors.New("La facture est déjà entièrement payée")
+)
+
+// CreatePaiementRequest représente l'enregistrement d'un paiement sur une facture
+type CreatePaiementRequest struct {
+	DatePaiement      *time.Time     `json:"datePaiement" example:"2025-03-20T00:00:00Z"` // Optionnel, aujourd'hui par défaut
+	Montant           models.Montant `json:"montant" example:"600.00"`
+	Mode              string         `json:"mode" example:"virement"`
+	ReferenceBancaire string         `json:"referenceBancaire" example:"VIR-20250320-0042"`
+	Commentaire       string         `json:"commentaire" example:"Premier versement"`
+}
+
+// AnnulerPaiementRequest représente la contre-passation d'un paiement
+type AnnulerPaiementRequest struct {
+	Motif string `json:"motif" example:"Chèque impayé"`
+}
+
+// PaiementResponse retourne le paiement et la facture mise à jour
+type PaiementResponse struct {
+	Paiement models.Paiement `json:"paiement"`
+	Facture  models.Facture  `json:"facture"`
+}
+
+// GetPaiementsByFacture godoc
+// @Summary Lister les paiements d'une facture
+// @Description Retourne tous les paiements d'une facture, contre-passations comprises
+// @Tags Paiements
+// @Produce json
+// @Param id path string true "ID de la facture"
+// @Success 200 {array} models.Paiement
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 500 {string} string "Erreur lors de la récupération des paiements"
+// @Router /factures/{id}/paiements [get]
+func GetPaiementsByFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	var paiements []models.Paiement
+	if err := tenantDB(entrepriseID).Where("facture_id = ?", facture.ID).
+		Order("date_paiement ASC, id ASC").Find(&paiements).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des paiements", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paiements)
+}
+
+// GetAllPaiements godoc
+// @Summary Lister les paiements
+// @Description Retourne les paiements de l'entreprise connectée, éventuellement filtrés par période
+// @Tags Paiements
+// @Produce json
+// @Param date_debut query string false "Date de début (YYYY-MM-DD)"
+// @Param date_fin query string false "Date de fin (YYYY-MM-DD)"
+// @Success 200 {array} models.Paiement
+// @Failure 400 {string} string "Date invalide"
+// @Failure 500 {string} string "Erreur lors de la récupération des paiements"
+// @Router /paiements [get]
+func GetAllPaiements(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	query := tenantDB(entrepriseID)
+
+	if dateDebut := r.URL.Query().Get("date_debut"); dateDebut != "" {
+		debut, err := time.Parse("2006-01-02", dateDebut)
+		if err != nil {
+			http.Error(w, "Date de début invalide (format attendu : YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("date_paiement >= ?", debut)
+	}
+	if dateFin := r.URL.Query().Get("date_fin"); dateFin != "" {
+		fin, err := time.Parse("2006-01-02", dateFin)
+		if err != nil {
+			http.Error(w, "Date de fin invalide (format attendu : YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("date_paiement < ?", fin.AddDate(0, 0, 1))
+	}
+
+	var paiements []models.Paiement
+	if err := query.Order("date_paiement DESC, id DESC").Find(&paiements).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des paiements", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(paiements)
+}
+
+// CreatePaiement godoc
+// @Summary Enregistrer un paiement
+// @Description Enregistre un paiement total ou partiel sur une facture émise ; le reste à payer et le statut sont recalculés
+// @Tags Paiements
+// @Accept json
+// @Produce json
+// @Param id path string true "ID de la facture"
+// @Param paiement body CreatePaiementRequest true "Paiement reçu"
+// @Success 201 {object} PaiementResponse
+// @Failure 400 {string} string "Erreur de validation des données"
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 409 {string} string "Facture brouillon, annulée ou déjà payée"
+// @Failure 500 {string} string "Erreur lors de l'enregistrement du paiement"
+// @Router /factures/{id}/paiements [post]
+func CreatePaiement(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var req CreatePaiementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validatePaiementRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var response PaiementResponse
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var facture models.Facture
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("entreprise_id = ?", entrepriseID).First(&facture, id).Error; err != nil {
+			return errFactureIntrouvable
+		}
+
+		switch facture.Statut {
+		case models.StatutFactureBrouillon:
+			return errFactureBrouillon
+		case models.StatutFactureAnnulee:
+			return errFactureAnnulee
+		}
+
+		if facture.ResteAPayer <= 0 {
+			return errFactureSoldee
+		}
+		if req.Montant > facture.ResteAPayer {
+			return fmt.Errorf("le montant du paiement (%s €) dépasse le reste à payer (%s €)", req.Montant, facture.ResteAPayer)
+		}
+
+		paiement := models.Paiement{
+			EntrepriseID:      entrepriseID,
+			FactureID:         facture.ID,
+			DatePaiement:      *req.DatePaiement,
+			Montant:           req.Montant,
+			Mode:              req.Mode,
+			ReferenceBancaire: req.ReferenceBancaire,
+			Commentaire:       req.Commentaire,
+		}
+		if err := tx.Create(&paiement).Error; err != nil {
+			return err
+		}
+
+		if err := recalculerSoldeFacture(tx, facture.ID, acteurEntreprise(r, entrepriseID), fmt.Sprintf("Paiement de %s €", paiement.Montant)); err != nil {
+			return err
+		}
+
+		response.Paiement = paiement
+		return tx.First(&response.Facture, facture.ID).Error
+	})
+
+	switch {
+	case err == errFactureIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errFactureBrouillon || err == errFactureAnnulee || err == errFactureSoldee:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// AnnulerPaiement godoc
+// @Summary Contre-passer un paiement
+// @Description Annule un paiement (chèque impayé, erreur de saisie…) sans le supprimer ; le reste à payer et le statut sont recalculés
+// @Tags Paiements
+// @Accept json
+// @Produce json
+// @Param id path string true "ID du paiement"
+// @Param annulation body AnnulerPaiementRequest true "Motif de la contre-passation"
+// @Success 200 {object} PaiementResponse
+// @Failure 400 {string} string "Motif obligatoire"
+// @Failure 404 {string} string "Paiement introuvable"
+// @Failure 409 {string} string "Paiement déjà contre-passé"
+// @Failure 500 {string} string "Erreur lors de la contre-passation"
+// @Router /paiements/{id}/annulation [post]
+func AnnulerPaiement(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var req AnnulerPaiementRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Motif = strings.TrimSpace(req.Motif)
+	if req.Motif == "" {
+		http.Error(w, "Le motif de la contre-passation est obligatoire", http.StatusBadRequest)
+		return
+	}
+
+	var response PaiementResponse
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var paiement models.Paiement
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("entreprise_id = ?", entrepriseID).First(&paiement, id).Error; err != nil {
+			return errPaiementIntrouvable
+		}
+
+		if paiement.Annule {
+			return errPaiementDejaAnnule
+		}
+
+		now := time.Now()
+		paiement.Annule = true
+		paiement.AnnuleLe = &now
+		paiement.MotifAnnulation = req.Motif
+
+		err := tx.Model(&models.Paiement{}).Where("id = ?", paiement.ID).Updates(map[string]interface{}{
+			"annule":           true,
+			"annule_le":        now,
+			"motif_annulation": req.Motif,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := recalculerSoldeFacture(tx, paiement.FactureID, acteurEntreprise(r, entrepriseID), fmt.Sprintf("Contre-passation du paiement de %s € : %s", paiement.Montant, req.Motif)); err != nil {
+			return err
+		}
+
+		response.Paiement = paiement
+		return tx.First(&response.Facture, paiement.FactureID).Error
+	})
+
+	switch {
+	case err == errPaiementIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errPaiementDejaAnnule:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Erreur lors de la contre-passation du paiement", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// validatePaiementRequest valide les données d'un paiement et complète la date par défaut
+func validatePaiementRequest(req *CreatePaiementRequest) error {
+	if req.Montant <= 0 {
+		return fmt.Errorf("Le montant du paiement doit être positif")
+	}
+
+	modeValide := false
+	for _, mode := range models.ModesPaiement() {
+		if mode == req.Mode {
+			modeValide = true
+			break
+		}
+	}
+	if !modeValide {
+		return fmt.Errorf("Mode de paiement invalide (valeurs acceptées : %s)", strings.Join(models.ModesPaiement(), ", "))
+	}
+
+	if req.DatePaiement == nil || req.DatePaiement.IsZero() {
+		now := time.Now()
+		req.DatePaiement = &now
+	}
+	if req.DatePaiement.After(time.Now().Add(24 * time.Hour)) {
+		return fmt.Errorf("La date du paiement ne peut pas être dans le futur")
+	}
+
+	return nil
+}
@@ -8,70 +8,155 @@ import (
 	"net/http"
 
 	"log"
-	"time"
 
 	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // @Summary Récupérer tous les plannings
-// @Description Retourne la liste complète des plannings enregistrés
+// @Description Retourne les plannings de la période, occurrences des plannings récurrents comprises (une occurrence calculée a un id à 0, son serie_id et sa date_occurrence). Sans période : tous les plannings enregistrés et les occurrences jusqu'à un an.
 // @Tags Planning
 // @Produce json
+// @Param date_debut query string false "Début de la période (AAAA-MM-JJ)"
+// @Param date_fin query string false "Fin de la période (AAAA-MM-JJ)"
 // @Success 200 {array} models.Planning
+// @Failure 400 {string} string "Période invalide"
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/plannings [get]
 func GetPlannings(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	debut, fin, err := lirePeriodePlannings(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := tenantDB(entrepriseID).Preload("Salarie")
+	if r.URL.Query().Get("date_debut") != "" {
+		query = query.Where("date >= ? AND date <= ?", debut, fin)
+	}
+
 	var plannings []models.Planning
-	config.DB.Preload("Salarie").Find(&plannings)
+	if err := query.Find(&plannings).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des plannings", http.StatusInternalServerError)
+		return
+	}
+
+	occurrences, err := occurrencesVirtuelles(config.DB, entrepriseID, debut, fin)
+	if err != nil {
+		http.Error(w, "Erreur lors du calcul des plannings récurrents", http.StatusInternalServerError)
+		return
+	}
+	plannings = append(plannings, occurrences...)
+	trierPlannings(plannings)
+
 	json.NewEncoder(w).Encode(plannings)
 }
 
 // @Summary Créer un planning
-// @Description Ajoute un nouveau planning en base de données
+// @Description Ajoute un nouveau planning. Un planning qui chevauche un autre planning du salarié est refusé sauf avec forcer=true ; une intervention facturable pendant une absence du salarié est toujours refusée. Avec nb_repetitions > 1, crée un planning récurrent hebdomadaire (toutes les periodicite semaines) et retourne ses occurrences ; utilisez /plannings/series pour les autres récurrences.
 // @Tags Planning
 // @Accept json
 // @Produce json
 // @Param planning body models.Planning true "Détails du planning"
+// @Param forcer query bool false "Enregistrer malgré les chevauchements (les conflits acceptés sont comptés dans l'en-tête X-Conflits-Planning)"
 // @Success 201 {object} models.Planning
 // @Failure 400 {string} string "Requête invalide"
+// @Failure 409 {object} map[string]interface{} "Conflits avec les autres plannings ou les absences du salarié"
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/plannings [post]
 func CreatePlanning(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var planning models.Planning
 	if err := json.NewDecoder(r.Body).Decode(&planning); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if planning.NbRepetitions <= 0 {
-		planning.NbRepetitions = 1
-	}
-
-	date, err := time.Parse("2006-01-02", planning.Date)
-	if err != nil {
-		http.Error(w, "Date invalide", http.StatusBadRequest)
+	planning.ID = 0
+	planning.EntrepriseID = entrepriseID
+	// Un planning n'est rattaché à une facture que par la facturation, et à une série que par sa création
+	planning.LigneFactureID = nil
+	planning.SerieID = nil
+	planning.DateOccurrence = ""
+	planning.UIDImport = ""
+	if err := validerPlanning(&planning); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	conflits := nouveauControleConflits(r)
 
-	var createdPlannings []models.Planning
+	if planning.NbRepetitions <= 1 {
+		planning.NbRepetitions = 1
+		if err := conflits.verifier(config.DB, entrepriseID, []models.Planning{planning}, nil); err != nil {
+			repondreConflits(w, err)
+			return
+		}
+		if err := config.DB.Omit(clause.Associations).Create(&planning).Error; err != nil {
+			log.Println("❌ Erreur lors de la création du planning :", err)
+			http.Error(w, "Erreur lors de la création du planning", http.StatusInternalServerError)
+			return
+		}
 
-	for i := 0; i < planning.NbRepetitions; i++ {
-		newDate := date.AddDate(0, 0, i*7)
-		copyPlanning := planning
-		copyPlanning.ID = 0
-		copyPlanning.Date = newDate.Format("2006-01-02")
+		conflits.signaler(w)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode([]models.Planning{planning})
+		return
+	}
 
-		if err := config.DB.Create(&copyPlanning).Error; err != nil {
-			log.Println("❌ Erreur lors de la création répétée :", err)
-			continue
-		}
+	// Les répétitions forment une série hebdomadaire, modifiable occurrence par occurrence ou en bloc
+	intervalle := planning.Periodicite
+	if intervalle < 1 {
+		intervalle = 1
+	}
+	serie := models.SeriePlanning{
+		EntrepriseID:  entrepriseID,
+		RRule:         fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d;COUNT=%d", intervalle, planning.NbRepetitions),
+		DateDebut:     planning.Date,
+		HeureDebut:    planning.HeureDebut,
+		HeureFin:      planning.HeureFin,
+		TypeEvenement: planning.TypeEvenement,
+		SalarieID:     planning.SalarieID,
+		ClientID:      planning.ClientID,
+		Objet:         planning.Objet,
+		Prestation:    planning.Prestation,
+		Facturation:   planning.Facturation,
+		TauxHoraire:   planning.TauxHoraire,
+		ForfaitHT:     planning.ForfaitHT,
+	}
+	if err := validerSerie(&serie); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := conflits.verifierSerie(config.DB, &serie, nil); err != nil {
+		repondreConflits(w, err)
+		return
+	}
+	if err := config.DB.Omit("Salarie", "Client").Create(&serie).Error; err != nil {
+		log.Println("❌ Erreur lors de la création répétée :", err)
+		http.Error(w, "Erreur lors de la création du planning récurrent", http.StatusInternalServerError)
+		return
+	}
 
-		createdPlannings = append(createdPlannings, copyPlanning)
+	occurrences, err := occurrencesVirtuelles(config.DB, entrepriseID, serie.DateDebut, serie.DateFin)
+	if err != nil {
+		http.Error(w, "Erreur lors du calcul des occurrences", http.StatusInternalServerError)
+		return
 	}
 
-	// Renvoie tous les plannings créés
+	// Renvoie toutes les occurrences créées
+	conflits.signaler(w)
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdPlannings)
+	json.NewEncoder(w).Encode(occurrences)
 }
 
 // @Summary Modifier un planning
@@ -80,23 +165,57 @@ func CreatePlanning(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param id path int true "ID du planning"
 // @Param planning body models.Planning true "Nouvelles informations du planning"
+// @Param forcer query bool false "Enregistrer malgré les chevauchements (les conflits acceptés sont comptés dans l'en-tête X-Conflits-Planning)"
 // @Success 200 {object} models.Planning
 // @Failure 400 {string} string "Requête invalide"
 // @Failure 404 {string} string "Planning non trouvé"
+// @Failure 409 {string} string "Planning facturé ou en conflit avec les autres plannings du salarié"
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /plannings/{id} [put]
 func UpdatePlanning(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 	var planning models.Planning
-	config.DB.First(&planning, id)
+	tenantDB(entrepriseID).First(&planning, id)
 
 	if planning.ID == 0 {
 		http.Error(w, "Planning non trouvé", http.StatusNotFound)
 		return
 	}
 
+	// Un planning facturé est figé tant que sa facture n'est pas annulée ou créditée
+	if planningFacture(planning) {
+		http.Error(w, errPlanningFacture.Error(), http.StatusConflict)
+		return
+	}
+
+	planningID := planning.ID
+	serieID, dateOccurrence, uidImport := planning.SerieID, planning.DateOccurrence, planning.UIDImport
 	json.NewDecoder(r.Body).Decode(&planning)
-	config.DB.Save(&planning)
+
+	// L'identifiant, l'entreprise, la série et la facture ne peuvent pas être modifiés par le corps de la requête
+	planning.ID = planningID
+	planning.EntrepriseID = entrepriseID
+	planning.SerieID = serieID
+	planning.DateOccurrence = dateOccurrence
+	planning.UIDImport = uidImport
+	planning.LigneFactureID = nil
+	if err := validerPlanning(&planning); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conflits := nouveauControleConflits(r)
+	if err := conflits.verifier(config.DB, entrepriseID, []models.Planning{planning}, nil); err != nil {
+		repondreConflits(w, err)
+		return
+	}
+
+	config.DB.Omit(clause.Associations).Save(&planning)
+	conflits.signaler(w)
 	json.NewEncoder(w).Encode(planning)
 }
 
@@ -105,14 +224,42 @@ func UpdatePlanning(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "ID du planning"
 // @Success 204 "No Content"
 // @Failure 404 {string} string "Planning non trouvé"
+// @Failure 409 {string} string "Planning facturé"
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /plannings/{id} [delete]
 func DeletePlanning(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	id := chi.URLParam(r, "id")
 	fmt.Println("🧨 Suppression demandée pour l'ID :", id)
 
-	// Suppression définitive sans passer par DeletedAt
-	if err := config.DB.Unscoped().Delete(&models.Planning{}, id).Error; err != nil {
+	// Un planning facturé ne peut pas être supprimé
+	var planning models.Planning
+	tenantDB(entrepriseID).Limit(1).Find(&planning, id)
+	if planningFacture(planning) {
+		http.Error(w, errPlanningFacture.Error(), http.StatusConflict)
+		return
+	}
+
+	if planning.ID == 0 {
+		http.Error(w, "Planning non trouvé", http.StatusNotFound)
+		return
+	}
+
+	// Suppression définitive sans passer par DeletedAt ; une occurrence de série est aussi exclue de la série
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&models.Planning{}, planning.ID).Error; err != nil {
+			return err
+		}
+		if planning.SerieID != nil {
+			return ajouterExceptionSerie(tx, *planning.SerieID, planning.DateOccurrence)
+		}
+		return nil
+	})
+	if err != nil {
 		fmt.Println("❌ Erreur de suppression :", err)
 		http.Error(w, "Erreur lors de la suppression", http.StatusInternalServerError)
 		return
@@ -133,3 +280,16 @@ func GetTypesEvenements(w http.ResponseWriter, r *http.Request) {
 	types := models.GetTypesEvenements()
 	json.NewEncoder(w).Encode(types)
 }
+
+// validatePlanningRelations vérifie que le salarié et le client référencés appartiennent à l'entreprise du planning
+func validatePlanningRelations(planning *models.Planning) error {
+	if planning.SalarieID != 0 && !salarieAppartientA(planning.EntrepriseID, planning.SalarieID) {
+		return fmt.Errorf("salarié introuvable")
+	}
+
+	if planning.ClientID != 0 && !clientAppartientA(planning.EntrepriseID, planning.ClientID) {
+		return fmt.Errorf("client introuvable")
+	}
+
+	return nil
+}
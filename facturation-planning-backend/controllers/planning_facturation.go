@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"gorm.io/gorm"
+)
+
+// errPlanningDejaFacture signale qu'un planning a été facturé par une autre facture
+var errPlanningDejaFacture = errors.New("Le planning a déjà été facturé")
+
+// errPlanningFacture signale une modification interdite d'un planning déjà facturé
+var errPlanningFacture = errors.New("Le planning a été facturé : annulez ou créditez la facture pour le modifier")
+
+// HeuresNonFactureesClient représente les prestations facturables non encore facturées d'un client
+type HeuresNonFactureesClient struct {
+	ClientID        uint           `json:"client_id" example:"1"`
+	ClientNom       string         `json:"client_nom" example:"Dupont Jean"`
+	NbPlannings     int            `json:"nb_plannings" example:"6"`
+	NbHeures        float64        `json:"nb_heures" example:"14.5"`
+	NbForfaits      int            `json:"nb_forfaits" example:"1"`
+	NbSansTarif     int            `json:"nb_sans_tarif" example:"0"`
+	MontantHTEstime models.Montant `json:"montant_ht_estime" example:"652.50"`
+	PremiereDate    string         `json:"premiere_date" example:"2025-06-02"`
+	DerniereDate    string         `json:"derniere_date" example:"2025-06-27"`
+}
+
+// HeuresNonFactureesResponse représente le rapport des heures facturables non facturées
+type HeuresNonFactureesResponse struct {
+	DateDebut       string                     `json:"date_debut,omitempty" example:"2025-06-01"`
+	DateFin         string                     `json:"date_fin" example:"2025-06-30"`
+	NbHeures        float64                    `json:"nb_heures" example:"14.5"`
+	MontantHTEstime models.Montant             `json:"montant_ht_estime" example:"652.50"`
+	Clients         []HeuresNonFactureesClient `json:"clients"`
+}
+
+// GetHeuresNonFacturees godoc
+// @Summary Heures facturables non facturées par client
+// @Description Regroupe par client les plannings facturables (intervention, formation, divers) encore à l'état "À facturer", jusqu'à la date de fin (aujourd'hui par défaut)
+// @Tags Planning
+// @Produce json
+// @Param date_debut query string false "Date de début (AAAA-MM-JJ)"
+// @Param date_fin query string false "Date de fin (AAAA-MM-JJ), aujourd'hui par défaut"
+// @Param client_id query int false "Limiter le rapport à un client"
+// @Success 200 {object} HeuresNonFactureesResponse
+// @Failure 400 {string} string "Paramètres invalides"
+// @Failure 500 {string} string "Erreur base de données"
+// @Router /plannings/non-factures [get]
+func GetHeuresNonFacturees(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	dateDebut := r.URL.Query().Get("date_debut")
+	dateFin := r.URL.Query().Get("date_fin")
+	if dateFin == "" {
+		dateFin = time.Now().Format("2006-01-02")
+	}
+	for _, date := range []string{dateDebut, dateFin} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, "Date invalide (format attendu : AAAA-MM-JJ)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	query := tenantDB(entrepriseID).Preload("Client").
+		Where("facturation = ?", models.FacturationAFacturer).
+		Where("type_evenement IN ?", typesEvenementsFacturables).
+		Where("date <= ?", dateFin)
+	if dateDebut != "" {
+		query = query.Where("date >= ?", dateDebut)
+	}
+	var clientIDs []uint
+	if clientID := r.URL.Query().Get("client_id"); clientID != "" {
+		id, err := strconv.ParseUint(clientID, 10, 64)
+		if err != nil {
+			http.Error(w, "Client invalide", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("client_id = ?", id)
+		clientIDs = []uint{uint(id)}
+	}
+
+	var plannings []models.Planning
+	if err := query.Order("date, heure_debut, id").Find(&plannings).Error; err != nil {
+		http.Error(w, "Erreur base de données", http.StatusInternalServerError)
+		return
+	}
+
+	// Occurrences des plannings récurrents à facturer, y compris celles encore sans tarif
+	borneDebut := dateDebut
+	if borneDebut == "" {
+		borneDebut = "0001-01-01"
+	}
+	occurrences, err := occurrencesVirtuelles(config.DB, entrepriseID, borneDebut, dateFin)
+	if err != nil {
+		http.Error(w, "Erreur lors du calcul des plannings récurrents", http.StatusInternalServerError)
+		return
+	}
+	for i := range occurrences {
+		if estAFacturer(&occurrences[i], clientIDs) {
+			plannings = append(plannings, occurrences[i])
+		}
+	}
+	trierPlannings(plannings)
+
+	response := HeuresNonFactureesResponse{
+		DateDebut: dateDebut,
+		DateFin:   dateFin,
+		Clients:   []HeuresNonFactureesClient{},
+	}
+
+	clientsMap := make(map[uint]*HeuresNonFactureesClient)
+	var ordreClients []uint
+
+	for i := range plannings {
+		planning := &plannings[i]
+
+		client := clientsMap[planning.ClientID]
+		if client == nil {
+			client = &HeuresNonFactureesClient{
+				ClientID:     planning.ClientID,
+				ClientNom:    planning.Client.GetDisplayName(),
+				PremiereDate: planning.Date,
+			}
+			clientsMap[planning.ClientID] = client
+			ordreClients = append(ordreClients, planning.ClientID)
+		}
+		client.NbPlannings++
+		client.DerniereDate = planning.Date
+
+		// Les heures sont comptées même sans tarif : elles restent à facturer
+		if duree, err := planning.Duree(); err == nil {
+			client.NbHeures += duree
+			response.NbHeures += duree
+		}
+
+		prestation, ok := prestationFacturable(planning, 0)
+		switch {
+		case !ok:
+			client.NbSansTarif++
+		case prestation.TypeFacturation == "forfait":
+			client.NbForfaits++
+		}
+		client.MontantHTEstime += prestation.MontantHT
+		response.MontantHTEstime += prestation.MontantHT
+	}
+
+	for _, clientID := range ordreClients {
+		client := clientsMap[clientID]
+		client.NbHeures = math.Round(client.NbHeures*100) / 100
+		response.Clients = append(response.Clients, *client)
+	}
+	sort.SliceStable(response.Clients, func(i, j int) bool {
+		return response.Clients[i].ClientNom < response.Clients[j].ClientNom
+	})
+	response.NbHeures = math.Round(response.NbHeures*100) / 100
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// estAFacturer indique si un planning facturable est à l'état "À facturer", pour l'un des clients donnés (tous si vide)
+func estAFacturer(planning *models.Planning, clientIDs []uint) bool {
+	if planning.Facturation != models.FacturationAFacturer {
+		return false
+	}
+
+	if !typeFacturable(planning.TypeEvenement) {
+		return false
+	}
+
+	if len(clientIDs) == 0 {
+		return true
+	}
+	for _, clientID := range clientIDs {
+		if planning.ClientID == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// typeFacturable indique si un type d'événement donne lieu à facturation
+func typeFacturable(typeEvenement string) bool {
+	for _, t := range typesEvenementsFacturables {
+		if typeEvenement == t {
+			return true
+		}
+	}
+	return false
+}
+
+// validerEtatFacturation vérifie l'état de facturation demandé pour un planning non facturé.
+// L'état "Facturé" n'est jamais saisi : il est attribué par la facturation.
+func validerEtatFacturation(etat *string) error {
+	switch *etat {
+	case "":
+		*etat = models.FacturationAFacturer
+	case models.FacturationAFacturer, models.FacturationNonFacturable, models.FacturationOffert:
+	case models.FacturationFacture:
+		return errors.New("L'état \"Facturé\" est attribué automatiquement à la facturation du planning")
+	default:
+		return errors.New("État de facturation invalide")
+	}
+	return nil
+}
+
+// marquerPlanningFacture rattache un planning "À facturer" à la ligne de facture qui le facture.
+// Elle doit être appelée dans la transaction qui crée la ligne.
+func marquerPlanningFacture(tx *gorm.DB, entrepriseID, planningID, ligneFactureID uint) error {
+	result := tx.Model(&models.Planning{}).
+		Where("id = ? AND entreprise_id = ? AND facturation = ? AND ligne_facture_id IS NULL",
+			planningID, entrepriseID, models.FacturationAFacturer).
+		Updates(map[string]interface{}{
+			"facturation":      models.FacturationFacture,
+			"ligne_facture_id": ligneFactureID,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errPlanningDejaFacture
+	}
+	return nil
+}
+
+// libererPlanningsLignes repasse "À facturer" les plannings facturés par les lignes de facture données
+func libererPlanningsLignes(tx *gorm.DB, lignesFactureIDs []uint) error {
+	if len(lignesFactureIDs) == 0 {
+		return nil
+	}
+	return tx.Model(&models.Planning{}).
+		Where("ligne_facture_id IN ?", lignesFactureIDs).
+		Updates(map[string]interface{}{
+			"facturation":      models.FacturationAFacturer,
+			"ligne_facture_id": nil,
+		}).Error
+}
+
+// libererPlanningsCredites repasse "À facturer" les plannings d'une facture annulée,
+// ou ceux dont la ligne de facture a été entièrement créditée par des avoirs.
+// Elle doit être appelée après recalculerSoldeFacture, dans la même transaction.
+func libererPlanningsCredites(tx *gorm.DB, factureID uint) error {
+	var facture models.Facture
+	if err := tx.Preload("Lignes").First(&facture, factureID).Error; err != nil {
+		return err
+	}
+
+	if facture.Statut == models.StatutFactureAnnulee {
+		ids := make([]uint, 0, len(facture.Lignes))
+		for _, ligne := range facture.Lignes {
+			ids = append(ids, ligne.ID)
+		}
+		return libererPlanningsLignes(tx, ids)
+	}
+
+	var credits []struct {
+		LigneFactureID uint
+		Quantite       float64
+	}
+	err := tx.Model(&models.LigneAvoir{}).
+		Select("ligne_avoirs.ligne_facture_id, SUM(ligne_avoirs.quantite) AS quantite").
+		Joins("JOIN avoirs ON avoirs.id = ligne_avoirs.avoir_id").
+		Where("avoirs.facture_id = ? AND ligne_avoirs.ligne_facture_id IS NOT NULL", factureID).
+		Group("ligne_avoirs.ligne_facture_id").
+		Scan(&credits).Error
+	if err != nil {
+		return err
+	}
+
+	credite := make(map[uint]float64, len(credits))
+	for _, c := range credits {
+		credite[c.LigneFactureID] = c.Quantite
+	}
+
+	var ids []uint
+	for _, ligne := range facture.Lignes {
+		if ligne.Quantite > 0 && credite[ligne.ID] >= ligne.Quantite-1e-9 {
+			ids = append(ids, ligne.ID)
+		}
+	}
+	return libererPlanningsLignes(tx, ids)
+}
+
+// lignesFactureIDs retourne les identifiants des lignes d'une facture
+func lignesFactureIDs(tx *gorm.DB, factureID uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Model(&models.LigneFacture{}).Where("facture_id = ?", factureID).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// planningFacture indique si un planning est rattaché à une facture
+func planningFacture(planning models.Planning) bool {
+	return planning.Facturation == models.FacturationFacture || planning.LigneFactureID != nil
+}
@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+// templatesRelance associe chaque niveau de relance à son modèle de lettre
+var templatesRelance = map[int]string{
+	models.NiveauPremiereRelance: "relance_1.html",
+	models.NiveauDeuxiemeRelance: "relance_2.html",
+	models.NiveauMiseEnDemeure:   "mise_en_demeure.html",
+}
+
+// FactureEnRetard représente une facture impayée après son échéance, avec les sommes exigibles à ce jour
+type FactureEnRetard struct {
+	Facture              models.Facture `json:"facture"`
+	JoursRetard          int            `json:"joursRetard" example:"12"`
+	Penalites            models.Montant `json:"penalites" example:"4.79"`
+	IndemniteForfaitaire models.Montant `json:"indemniteForfaitaire" example:"40.00"`
+	TotalReclame         models.Montant `json:"totalReclame" example:"1244.79"`
+	DernierNiveau        int            `json:"dernierNiveau" example:"1"`
+	DerniereRelance      *time.Time     `json:"derniereRelance,omitempty" example:"2025-04-24T08:00:00Z"`
+	ProchainNiveau       int            `json:"prochainNiveau,omitempty" example:"2"`
+}
+
+// RelanceLettreData structure pour les données des modèles de lettre de relance
+type RelanceLettreData struct {
+	Libelle              string
+	DateRelance          string
+	Reference            string
+	DateFacture          string
+	DateEcheance         string
+	ClientNom            string
+	ClientAdresse        string
+	Professionnel        bool
+	TotalTTC             models.Montant
+	DejaRegle            models.Montant
+	MontantDu            models.Montant
+	JoursRetard          int
+	TauxPenalites        float64
+	Penalites            models.Montant
+	IndemniteForfaitaire models.Montant
+	TotalReclame         models.Montant
+	DateLimite           string
+	Company              config.CompanyInfo
+}
+
+// GetFacturesEnRetard godoc
+// @Summary Lister les factures en retard
+// @Description Retourne les factures émises non soldées dont l'échéance est dépassée, avec pénalités et indemnité forfaitaire calculées à ce jour
+// @Tags Relances
+// @Produce json
+// @Success 200 {array} FactureEnRetard
+// @Failure 500 {string} string "Erreur lors de la récupération des factures en retard"
+// @Router /relances/retards [get]
+func GetFacturesEnRetard(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	retards, err := facturesEnRetard(config.DB, entrepriseID, time.Now())
+	if err != nil {
+		http.Error(w, "Erreur lors de la récupération des factures en retard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(retards)
+}
+
+// GetAllRelances godoc
+// @Summary Historique des relances
+// @Description Retourne toutes les relances envoyées par l'entreprise connectée, de la plus récente à la plus ancienne
+// @Tags Relances
+// @Produce json
+// @Success 200 {array} models.Relance
+// @Failure 500 {string} string "Erreur lors de la récupération des relances"
+// @Router /relances [get]
+func GetAllRelances(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var relances []models.Relance
+	if err := tenantDB(entrepriseID).Order("date_relance DESC, id DESC").Find(&relances).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des relances", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(relances)
+}
+
+// GetRelancesByFacture godoc
+// @Summary Relances d'une facture
+// @Description Retourne l'historique des relances d'une facture
+// @Tags Relances
+// @Produce json
+// @Param id path string true "ID de la facture"
+// @Success 200 {array} models.Relance
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 500 {string} string "Erreur lors de la récupération des relances"
+// @Router /factures/{id}/relances [get]
+func GetRelancesByFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).First(&facture, id).Error; err != nil {
+		http.Error(w, "Facture introuvable", http.StatusNotFound)
+		return
+	}
+
+	var relances []models.Relance
+	if err := tenantDB(entrepriseID).Where("facture_id = ?", facture.ID).Order("niveau ASC").Find(&relances).Error; err != nil {
+		http.Error(w, "Erreur lors de la récupération des relances", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(relances)
+}
+
+// GetLettreRelance godoc
+// @Summary Lettre de relance
+// @Description Retourne la lettre HTML générée pour une relance
+// @Tags Relances
+// @Produce html
+// @Param id path string true "ID de la relance"
+// @Success 200 {string} string "Lettre HTML"
+// @Failure 404 {string} string "Relance introuvable"
+// @Router /relances/{id}/lettre [get]
+func GetLettreRelance(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var relance models.Relance
+	if err := tenantDB(entrepriseID).First(&relance, id).Error; err != nil {
+		http.Error(w, "Relance introuvable", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(relance.Contenu))
+}
+
+// ExecuterRelances godoc
+// @Summary Lancer les relances
+// @Description Génère immédiatement les relances dues pour l'entreprise connectée (le planificateur le fait aussi périodiquement)
+// @Tags Relances
+// @Produce json
+// @Success 200 {array} models.Relance "Relances générées"
+// @Failure 500 {string} string "Erreur lors de la génération des relances"
+// @Router /relances/executer [post]
+func ExecuterRelances(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	relances, err := genererRelances(entrepriseID, time.Now())
+	if err != nil {
+		http.Error(w, "Erreur lors de la génération des relances : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(relances)
+}
+
+// LancerPlanificateurRelances démarre en arrière-plan la génération des relances :
+// une première exécution au démarrage, puis une à chaque intervalle
+func LancerPlanificateurRelances(intervalle time.Duration) {
+	go func() {
+		executerToutesLesRelances()
+
+		ticker := time.NewTicker(intervalle)
+		defer ticker.Stop()
+		for range ticker.C {
+			executerToutesLesRelances()
+		}
+	}()
+}
+
+// executerToutesLesRelances génère les relances dues pour toutes les entreprises
+func executerToutesLesRelances() {
+	var entrepriseIDs []uint
+	if err := config.DB.Model(&models.Entreprise{}).Pluck("id", &entrepriseIDs).Error; err != nil {
+		log.Printf("❌ Relances : erreur lors de la lecture des entreprises : %v", err)
+		return
+	}
+
+	total := 0
+	for _, entrepriseID := range entrepriseIDs {
+		relances, err := genererRelances(entrepriseID, time.Now())
+		if err != nil {
+			log.Printf("❌ Relances : erreur pour l'entreprise %d : %v", entrepriseID, err)
+			continue
+		}
+		total += len(relances)
+	}
+
+	if total > 0 {
+		log.Printf("📨 Relances : %d relance(s) générée(s)", total)
+	}
+}
+
+// genererRelances génère, pour chaque facture en retard de l'entreprise, la relance du niveau suivant si elle est due
+func genererRelances(entrepriseID uint, date time.Time) ([]models.Relance, error) {
+	retards, err := facturesEnRetard(config.DB, entrepriseID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	relances := []models.Relance{}
+	for _, retard := range retards {
+		if retard.ProchainNiveau == 0 {
+			continue
+		}
+
+		relance := models.Relance{
+			EntrepriseID:         entrepriseID,
+			FactureID:            retard.Facture.ID,
+			Niveau:               retard.ProchainNiveau,
+			Libelle:              models.LibelleNiveauRelance(retard.ProchainNiveau),
+			DateRelance:          date,
+			JoursRetard:          retard.JoursRetard,
+			MontantDu:            retard.Facture.ResteAPayer,
+			Penalites:            retard.Penalites,
+			IndemniteForfaitaire: retard.IndemniteForfaitaire,
+			TotalReclame:         retard.TotalReclame,
+		}
+
+		contenu, err := genererLettreRelance(relance, retard.Facture)
+		if err != nil {
+			return relances, fmt.Errorf("lettre de relance de la facture %s : %v", retard.Facture.Reference, err)
+		}
+		relance.Contenu = contenu
+
+		// L'index unique (facture, niveau) empêche les doublons si deux exécutions se chevauchent
+		if err := config.DB.Create(&relance).Error; err != nil {
+			log.Printf("⚠️  Relance %d de la facture %s non enregistrée : %v", relance.Niveau, retard.Facture.Reference, err)
+			continue
+		}
+
+		relances = append(relances, relance)
+	}
+
+	return relances, nil
+}
+
+// facturesEnRetard retourne les factures émises non soldées de l'entreprise dont l'échéance est dépassée
+func facturesEnRetard(db *gorm.DB, entrepriseID uint, date time.Time) ([]FactureEnRetard, error) {
+	var factures []models.Facture
+	err := db.Preload("Client").
+		Where("entreprise_id = ? AND statut IN ? AND reste_a_payer > 0 AND date_echeance < ?",
+			entrepriseID,
+			[]string{models.StatutFactureEmise, models.StatutFacturePartiellementPayee},
+			debutDeJournee(date)).
+		Order("date_echeance ASC").
+		Find(&factures).Error
+	if err != nil {
+		return nil, err
+	}
+
+	retards := make([]FactureEnRetard, 0, len(factures))
+	for _, facture := range factures {
+		var derniere models.Relance
+		db.Where("facture_id = ?", facture.ID).Order("niveau DESC").Limit(1).Find(&derniere)
+
+		retard := calculerRetard(facture, date)
+		if derniere.ID != 0 {
+			retard.DernierNiveau = derniere.Niveau
+			retard.DerniereRelance = &derniere.DateRelance
+		}
+		retard.ProchainNiveau = prochainNiveauRelance(retard, date)
+
+		retards = append(retards, retard)
+	}
+
+	return retards, nil
+}
+
+// calculerRetard calcule le nombre de jours de retard, les pénalités et l'indemnité forfaitaire d'une facture.
+// Les pénalités (taux annuel appliqué au reste à payer, au prorata des jours de retard) et l'indemnité
+// forfaitaire de recouvrement ne s'appliquent qu'entre professionnels (art. L441-10 du Code de commerce).
+func calculerRetard(facture models.Facture, date time.Time) FactureEnRetard {
+	cfg := config.GetRelanceConfig()
+
+	jours := joursCalendaires(facture.DateEcheance, date)
+	if jours < 0 {
+		jours = 0
+	}
+
+	retard := FactureEnRetard{
+		Facture:     facture,
+		JoursRetard: jours,
+	}
+
+	if facture.Client.TypeClient == "professionnel" && jours > 0 {
+		retard.Penalites = facture.ResteAPayer.Pourcentage(cfg.TauxPenalites * float64(jours) / 365)
+		retard.IndemniteForfaitaire = models.MontantEuros(cfg.IndemniteForfaitaire)
+	}
+	retard.TotalReclame = facture.ResteAPayer + retard.Penalites + retard.IndemniteForfaitaire
+
+	return retard
+}
+
+// prochainNiveauRelance retourne le niveau de relance à envoyer à cette date, ou 0 si aucune relance n'est due.
+// Les niveaux s'enchaînent un par un, en respectant un écart minimum entre deux relances.
+func prochainNiveauRelance(retard FactureEnRetard, date time.Time) int {
+	cfg := config.GetRelanceConfig()
+
+	niveau := retard.DernierNiveau + 1
+	if niveau > models.NiveauMiseEnDemeure {
+		return 0
+	}
+	if retard.JoursRetard < cfg.DelaisJours[niveau-1] {
+		return 0
+	}
+	if retard.DerniereRelance != nil {
+		ecart := joursCalendaires(*retard.DerniereRelance, date)
+		if ecart < cfg.EcartMinimumJours {
+			return 0
+		}
+	}
+	return niveau
+}
+
+// genererLettreRelance produit la lettre HTML d'une relance à partir du modèle de son niveau
+func genererLettreRelance(relance models.Relance, facture models.Facture) (string, error) {
+	tmpl, err := modeleLettreRelance(relance.Niveau)
+	if err != nil {
+		return "", err
+	}
+
+	// Délai de règlement accordé : 8 jours pour une mise en demeure, 15 jours sinon
+	delai := 15
+	if relance.Niveau == models.NiveauMiseEnDemeure {
+		delai = 8
+	}
+
+	data := RelanceLettreData{
+		Libelle:              relance.Libelle,
+		DateRelance:          relance.DateRelance.Format("02/01/2006"),
+		Reference:            facture.Reference,
+		DateFacture:          facture.DateEmission.Format("02/01/2006"),
+		DateEcheance:         facture.DateEcheance.Format("02/01/2006"),
+		ClientNom:            facture.ClientNom,
+		ClientAdresse:        facture.ClientAdresse,
+		Professionnel:        facture.Client.TypeClient == "professionnel",
+		TotalTTC:             facture.TotalTTC,
+		DejaRegle:            facture.TotalTTC - facture.TotalAvoirs - facture.ResteAPayer,
+		MontantDu:            relance.MontantDu,
+		JoursRetard:          relance.JoursRetard,
+		TauxPenalites:        config.GetRelanceConfig().TauxPenalites,
+		Penalites:            relance.Penalites,
+		IndemniteForfaitaire: relance.IndemniteForfaitaire,
+		TotalReclame:         relance.TotalReclame,
+		DateLimite:           relance.DateRelance.AddDate(0, 0, delai).Format("02/01/2006"),
+		Company:              companyInfo(entrepriseDocument(facture.EntrepriseID)),
+	}
+
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// modeleLettreRelance charge le modèle de lettre d'un niveau de relance, avec la mise en page commune
+// de templates/relance_base.html
+func modeleLettreRelance(niveau int) (*template.Template, error) {
+	templateName, ok := templatesRelance[niveau]
+	if !ok {
+		return nil, fmt.Errorf("niveau de relance inconnu : %d", niveau)
+	}
+
+	funcMap := template.FuncMap{
+		"formatPrice": func(price models.Montant) string {
+			return price.String() + " €"
+		},
+		"formatPercent": func(percent float64) string {
+			return fmt.Sprintf("%.2f%%", percent)
+		},
+	}
+
+	return template.New(templateName).Funcs(funcMap).ParseFiles("templates/relance_base.html", "templates/"+templateName)
+}
+
+// debutDeJournee retourne minuit du jour de la date donnée
+func debutDeJournee(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+}
+
+// joursCalendaires retourne le nombre de jours civils entre deux dates, chacune prise dans son fuseau.
+// Les dates sont ramenées à minuit UTC : un changement d'heure ne fait ni gagner ni perdre de jour.
+func joursCalendaires(debut, fin time.Time) int {
+	d := time.Date(debut.Year(), debut.Month(), debut.Day(), 0, 0, 0, 0, time.UTC)
+	f := time.Date(fin.Year(), fin.Month(), fin.Day(), 0, 0, 0, 0, time.UTC)
+	return int(f.Sub(d).Hours() / 24)
+}
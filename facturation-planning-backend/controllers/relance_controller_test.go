@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+)
+
+// parisRelance charge le fuseau Europe/Paris, dont les changements d'heure faussaient le décompte des jours
+func parisRelance(t *testing.T) *time.Location {
+	t.Helper()
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Taux et délais par défaut, quel que soit l'environnement
+	t.Setenv("TAUX_PENALITES_RETARD", "")
+	return paris
+}
+
+func TestCalculerRetard(t *testing.T) {
+	paris := parisRelance(t)
+	le := func(jour, heure int, mois time.Month) time.Time {
+		return time.Date(2025, mois, jour, heure, 0, 0, 0, paris)
+	}
+
+	cas := []struct {
+		nom          string
+		typeClient   string
+		reste        models.Montant
+		echeance     time.Time
+		date         time.Time
+		jours        int
+		penalites    string
+		indemnite    string
+		totalReclame string
+	}{
+		// 1 200,00 × 12,15 % × 30 / 365 = 11,98
+		{"professionnel, 30 jours", "professionnel", models.MontantEuros(1200), le(1, 10, time.April), le(1, 9, time.May), 30, "11.98", "40.00", "1251.98"},
+		{"particulier, 30 jours : ni pénalités ni indemnité", "particulier", models.MontantEuros(1200), le(1, 10, time.April), le(1, 9, time.May), 30, "0.00", "0.00", "1200.00"},
+		{"professionnel, échéance du jour", "professionnel", models.MontantEuros(1200), le(1, 10, time.April), le(1, 23, time.April), 0, "0.00", "0.00", "1200.00"},
+		{"échéance à venir", "professionnel", models.MontantEuros(1200), le(10, 0, time.April), le(1, 9, time.April), 0, "0.00", "0.00", "1200.00"},
+		// Passage à l'heure d'été le 30 mars : 47 h séparent les deux minuits, mais deux jours civils
+		// 1 000,00 × 12,15 % × 2 / 365 = 0,67
+		{"professionnel, changement d'heure de mars", "professionnel", models.MontantEuros(1000), le(29, 12, time.March), le(31, 8, time.March), 2, "0.67", "40.00", "1040.67"},
+		{"professionnel, changement d'heure d'octobre", "professionnel", models.MontantEuros(1000), le(25, 12, time.October), le(27, 8, time.October), 2, "0.67", "40.00", "1040.67"},
+		{"échéance enregistrée en UTC", "professionnel", models.MontantEuros(1000), time.Date(2025, time.March, 29, 0, 0, 0, 0, time.UTC), le(31, 8, time.March), 2, "0.67", "40.00", "1040.67"},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			facture := models.Facture{ResteAPayer: c.reste, DateEcheance: c.echeance, Client: models.Client{TypeClient: c.typeClient}}
+			retard := calculerRetard(facture, c.date)
+			if retard.JoursRetard != c.jours {
+				t.Errorf("%d jours de retard, attendu %d", retard.JoursRetard, c.jours)
+			}
+			if retard.Penalites.String() != c.penalites || retard.IndemniteForfaitaire.String() != c.indemnite || retard.TotalReclame.String() != c.totalReclame {
+				t.Errorf("pénalités %s, indemnité %s, total %s, attendu %s / %s / %s",
+					retard.Penalites, retard.IndemniteForfaitaire, retard.TotalReclame, c.penalites, c.indemnite, c.totalReclame)
+			}
+		})
+	}
+}
+
+func TestProchainNiveauRelance(t *testing.T) {
+	paris := parisRelance(t)
+	date := time.Date(2025, time.March, 31, 8, 0, 0, 0, paris)
+	il := func(jours int) *time.Time {
+		d := date.AddDate(0, 0, -jours)
+		return &d
+	}
+	// Relance du 21 mars : 10 jours civils avant le 31, mais 239 h à cause du passage à l'heure d'été
+	relance21Mars := time.Date(2025, time.March, 21, 9, 0, 0, 0, paris)
+
+	// Délais par défaut : 7, 21 et 45 jours après l'échéance, 10 jours au moins entre deux relances
+	cas := []struct {
+		nom             string
+		joursRetard     int
+		dernierNiveau   int
+		derniereRelance *time.Time
+		attendu         int
+	}{
+		{"avant le délai de la 1re relance", 6, 0, nil, 0},
+		{"1re relance", 7, 0, nil, models.NiveauPremiereRelance},
+		{"un seul niveau à la fois", 60, 0, nil, models.NiveauPremiereRelance},
+		{"avant le délai de la 2e relance", 20, 1, il(15), 0},
+		{"écart minimum non atteint", 21, 1, il(9), 0},
+		{"2e relance", 21, 1, il(10), models.NiveauDeuxiemeRelance},
+		{"avant le délai de la mise en demeure", 44, 2, il(20), 0},
+		{"mise en demeure", 45, 2, il(10), models.NiveauMiseEnDemeure},
+		{"rien après la mise en demeure", 200, 3, il(100), 0},
+		{"écart à travers le changement d'heure", 30, 1, &relance21Mars, models.NiveauDeuxiemeRelance},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			retard := FactureEnRetard{JoursRetard: c.joursRetard, DernierNiveau: c.dernierNiveau, DerniereRelance: c.derniereRelance}
+			if niveau := prochainNiveauRelance(retard, date); niveau != c.attendu {
+				t.Errorf("niveau %d, attendu %d", niveau, c.attendu)
+			}
+		})
+	}
+}
+
+func TestModeleLettreRelance(t *testing.T) {
+	data := RelanceLettreData{
+		Reference: "FAC-2025-0042", DateFacture: "01/03/2025", DateEcheance: "31/03/2025", DateRelance: "30/04/2025", DateLimite: "08/05/2025",
+		ClientNom: "Boulangerie Durand", Professionnel: true, TotalTTC: models.MontantEuros(1200), MontantDu: models.MontantEuros(1200),
+		JoursRetard: 30, TauxPenalites: 12.15, Penalites: models.MontantEuros(11.98), IndemniteForfaitaire: models.MontantEuros(40),
+		TotalReclame: models.MontantEuros(1251.98), Company: config.CompanyInfo{Name: "Atelier Martin", City: "Lyon"},
+	}
+
+	// Chaque niveau garde la mise en page commune et n'en change que le titre, l'objet et le texte
+	cas := []struct {
+		niveau  int
+		titre   string
+		objet   string
+		absents []string
+	}{
+		{models.NiveauPremiereRelance, "Relance", "Objet : rappel de paiement - facture FAC-2025-0042", []string{"mise en demeure", "1344"}},
+		{models.NiveauDeuxiemeRelance, "Deuxième relance", "Objet : deuxième relance - facture FAC-2025-0042 impayée", []string{"1344"}},
+		{models.NiveauMiseEnDemeure, "Mise en demeure", "Objet : mise en demeure de payer - facture FAC-2025-0042", []string{"ne pas tenir compte"}},
+	}
+	for _, c := range cas {
+		t.Run(c.titre, func(t *testing.T) {
+			tmpl, err := modeleLettreRelance(c.niveau)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buffer bytes.Buffer
+			if err := tmpl.Execute(&buffer, data); err != nil {
+				t.Fatal(err)
+			}
+			lettre := buffer.String()
+
+			if !strings.HasPrefix(lettre, "<!DOCTYPE html>") {
+				t.Errorf("la lettre commence par %q", lettre[:min(len(lettre), 40)])
+			}
+			attendus := []string{
+				"<title>" + c.titre + " - Facture FAC-2025-0042</title>",
+				`<div class="document-title">` + c.titre + "</div>",
+				c.objet,
+				"Lyon, le 30/04/2025",
+				"L441-10",
+				"1251.98 €",
+				"Nous vous prions d'agréer",
+			}
+			for _, attendu := range attendus {
+				if !strings.Contains(lettre, attendu) {
+					t.Errorf("%q absent de la lettre", attendu)
+				}
+			}
+			for _, absent := range c.absents {
+				if strings.Contains(lettre, absent) {
+					t.Errorf("%q ne doit pas figurer dans la lettre", absent)
+				}
+			}
+		})
+	}
+
+	if _, err := modeleLettreRelance(4); err == nil {
+		t.Error("niveau 4 accepté")
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"fmt"
+
+	"facturation-planning/models"
+)
+
+// verifierRemisesFacture normalise l'unité de chaque ligne d'une facture et contrôle les remises
+// des lignes (sur quantité × prix unitaire) et la remise globale (sur le total HT des lignes)
+func verifierRemisesFacture(facture *models.Facture) error {
+	for i := range facture.Lignes {
+		ligne := &facture.Lignes[i]
+		unite, err := models.NormaliserUnite(ligne.Unite)
+		if err != nil {
+			return fmt.Errorf("ligne %d : %w", i+1, err)
+		}
+		ligne.Unite = unite
+		if err := models.VerifierRemise(ligne.PrixUnitaire.Multiplier(ligne.Quantite), ligne.RemisePourcentage, ligne.RemiseMontant); err != nil {
+			return fmt.Errorf("ligne %d : %w", i+1, err)
+		}
+	}
+	if err := models.VerifierRemise(facture.TotalLignesHT(), facture.RemisePourcentage, facture.RemiseMontant); err != nil {
+		return fmt.Errorf("remise globale : %w", err)
+	}
+	return nil
+}
+
+// verifierRemisesDevis normalise l'unité de chaque ligne d'un devis et contrôle ses remises.
+// La remise globale n'est comparée au total des lignes que si les lignes sont transmises.
+func verifierRemisesDevis(devis *models.Devis) error {
+	for i := range devis.Lignes {
+		ligne := &devis.Lignes[i]
+		unite, err := models.NormaliserUnite(ligne.Unite)
+		if err != nil {
+			return fmt.Errorf("ligne %d : %w", i+1, err)
+		}
+		ligne.Unite = unite
+		if ligne.Quantite <= 0 {
+			return fmt.Errorf("ligne %d : la quantité doit être positive", i+1)
+		}
+		if err := models.VerifierRemise(ligne.PrixUnitaire.Multiplier(ligne.Quantite), ligne.RemisePourcentage, ligne.RemiseMontant); err != nil {
+			return fmt.Errorf("ligne %d : %w", i+1, err)
+		}
+	}
+	// Sans lignes transmises, seule la forme de la remise globale est contrôlée
+	totalHT := devis.TotalLignesHT()
+	if devis.Lignes == nil {
+		totalHT = max(totalHT, devis.RemiseMontant)
+	}
+	if err := models.VerifierRemise(totalHT, devis.RemisePourcentage, devis.RemiseMontant); err != nil {
+		return fmt.Errorf("remise globale : %w", err)
+	}
+	return nil
+}
@@ -17,8 +17,13 @@ import (
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/salaries [get]
 func GetSalaries(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var salaries []models.Salarie
-	config.DB.Find(&salaries)
+	tenantDB(entrepriseID).Find(&salaries)
 	json.NewEncoder(w).Encode(salaries)
 }
 
@@ -33,6 +38,11 @@ func GetSalaries(w http.ResponseWriter, r *http.Request) {
 // @Failure 500 {string} string "Erreur serveur"
 // @Router /api/salaries [post]
 func CreateSalarie(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
 	var salarie models.Salarie
 
 	// Décoder la requête JSON
@@ -49,6 +59,10 @@ func CreateSalarie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Le salarié est toujours rattaché à l'entreprise connectée
+	salarie.ID = 0
+	salarie.EntrepriseID = entrepriseID
+
 	// Insérer en base
 	result := config.DB.Create(&salarie)
 	if result.Error != nil {
@@ -0,0 +1,765 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/teambition/rrule-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxOccurrencesSerie limite le nombre d'occurrences d'une série bornée par COUNT
+const maxOccurrencesSerie = 1000
+
+// maxJoursPeriodePlannings limite la période développée par une lecture des plannings
+const maxJoursPeriodePlannings = 731
+
+var (
+	errSerieIntrouvable      = errors.New("Série introuvable")
+	errOccurrenceIntrouvable = errors.New("Cette date n'est pas une occurrence de la série")
+)
+
+// CreateSeriePlanning godoc
+// @Summary Créer un planning récurrent
+// @Description Crée une série décrite par une règle RFC 5545 (FREQ=DAILY, WEEKLY avec BYDAY, MONTHLY avec BYMONTHDAY ou BYDAY=2TU, YEARLY ; fin par COUNT ou UNTIL) et des dates exclues. Les occurrences sont calculées à la lecture. Les occurrences des deux premières années sont contrôlées comme un planning unique (chevauchements, absences du salarié).
+// @Tags Planning
+// @Accept json
+// @Produce json
+// @Param serie body models.SeriePlanning true "Règle, date de début, modèle des occurrences et exceptions"
+// @Param forcer query bool false "Enregistrer malgré les chevauchements"
+// @Success 201 {object} models.SeriePlanning
+// @Failure 400 {string} string "Règle ou données invalides"
+// @Failure 409 {object} map[string]interface{} "Conflits avec les autres plannings ou les absences du salarié"
+// @Failure 500 {string} string "Erreur lors de l'enregistrement"
+// @Router /plannings/series [post]
+func CreateSeriePlanning(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var serie models.SeriePlanning
+	if err := json.NewDecoder(r.Body).Decode(&serie); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serie.ID = 0
+	serie.EntrepriseID = entrepriseID
+	serie.UIDImport = ""
+	for i := range serie.Exceptions {
+		serie.Exceptions[i].ID = 0
+	}
+
+	if err := validerSerie(&serie); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conflits := nouveauControleConflits(r)
+	if err := conflits.verifierSerie(config.DB, &serie, nil); err != nil {
+		repondreConflits(w, err)
+		return
+	}
+
+	if err := config.DB.Omit("Salarie", "Client").Create(&serie).Error; err != nil {
+		http.Error(w, "Erreur lors de l'enregistrement de la série", http.StatusInternalServerError)
+		return
+	}
+
+	conflits.signaler(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(serie)
+}
+
+// GetSeriePlanning godoc
+// @Summary Récupérer un planning récurrent
+// @Description Retourne la règle, le modèle et les exceptions d'une série
+// @Tags Planning
+// @Produce json
+// @Param id path int true "ID de la série"
+// @Success 200 {object} models.SeriePlanning
+// @Failure 404 {string} string "Série introuvable"
+// @Router /plannings/series/{id} [get]
+func GetSeriePlanning(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var serie models.SeriePlanning
+	if err := tenantDB(entrepriseID).Preload("Exceptions").Preload("Salarie").Preload("Client").
+		First(&serie, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, errSerieIntrouvable.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(serie)
+}
+
+// UpdateOccurrenceSerie godoc
+// @Summary Modifier une occurrence d'un planning récurrent
+// @Description Portée "occurrence" : seule l'occurrence est modifiée (elle est enregistrée comme planning et retournée). Portée "suivantes" : la série est arrêtée la veille et une nouvelle série démarre à cette date (la nouvelle série est retournée). Portée "serie" : le modèle et la règle de toute la série sont modifiés (la série est retournée). Les occurrences modifiées individuellement et non facturées reprennent le modèle de la série pour les portées "suivantes" et "serie".
+// @Tags Planning
+// @Accept json
+// @Produce json
+// @Param id path int true "ID de la série"
+// @Param date path string true "Date d'origine de l'occurrence (AAAA-MM-JJ)"
+// @Param portee query string false "Portée de la modification" Enums(occurrence, suivantes, serie)
+// @Param modification body models.SeriePlanning true "Champs à modifier (date pour déplacer une occurrence, rrule pour les portées suivantes et serie)"
+// @Param forcer query bool false "Enregistrer malgré les chevauchements"
+// @Success 200 {object} models.Planning
+// @Failure 400 {string} string "Données invalides"
+// @Failure 404 {string} string "Série ou occurrence introuvable"
+// @Failure 409 {string} string "Occurrence facturée ou en conflit avec les autres plannings du salarié"
+// @Router /plannings/series/{id}/occurrences/{date} [put]
+func UpdateOccurrenceSerie(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	date := chi.URLParam(r, "date")
+	portee, ok := lirePortee(w, r)
+	if !ok {
+		return
+	}
+
+	corps, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conflits := nouveauControleConflits(r)
+	var resultat interface{}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		serie, err := chargerSerie(tx, entrepriseID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		if portee == models.PorteeSuivantes && date == serie.DateDebut {
+			portee = models.PorteeSerie
+		}
+
+		switch portee {
+		case models.PorteeOccurrence:
+			planning, err := modifierOccurrence(tx, serie, date, corps, conflits)
+			resultat = planning
+			return err
+		case models.PorteeSuivantes:
+			nouvelle, err := modifierOccurrencesSuivantes(tx, serie, date, corps, conflits)
+			resultat = nouvelle
+			return err
+		default:
+			if err := modifierSerie(tx, serie, corps, conflits); err != nil {
+				return err
+			}
+			resultat = serie
+			return nil
+		}
+	})
+
+	switch {
+	case err == nil:
+	case err == errSerieIntrouvable || err == errOccurrenceIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errPlanningFacture:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case estConflit(err):
+		repondreConflits(w, err)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conflits.signaler(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resultat)
+}
+
+// DeleteOccurrenceSerie godoc
+// @Summary Supprimer une occurrence d'un planning récurrent
+// @Description Portée "occurrence" : la date est ajoutée aux exceptions de la série. Portée "suivantes" : la série s'arrête la veille. Portée "serie" : la série est supprimée. Les occurrences déjà facturées sont conservées comme plannings indépendants.
+// @Tags Planning
+// @Param id path int true "ID de la série"
+// @Param date path string true "Date d'origine de l'occurrence (AAAA-MM-JJ)"
+// @Param portee query string false "Portée de la suppression" Enums(occurrence, suivantes, serie)
+// @Success 204 "No Content"
+// @Failure 400 {string} string "Portée invalide"
+// @Failure 404 {string} string "Série ou occurrence introuvable"
+// @Failure 409 {string} string "Occurrence facturée"
+// @Router /plannings/series/{id}/occurrences/{date} [delete]
+func DeleteOccurrenceSerie(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	date := chi.URLParam(r, "date")
+	portee, ok := lirePortee(w, r)
+	if !ok {
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		serie, err := chargerSerie(tx, entrepriseID, chi.URLParam(r, "id"))
+		if err != nil {
+			return err
+		}
+
+		if portee == models.PorteeSuivantes && date == serie.DateDebut {
+			portee = models.PorteeSerie
+		}
+
+		switch portee {
+		case models.PorteeOccurrence:
+			return supprimerOccurrence(tx, serie, date)
+		case models.PorteeSuivantes:
+			if !occurrenceExiste(tx, serie, date) {
+				return errOccurrenceIntrouvable
+			}
+			if err := reprendreOccurrencesEnregistrees(tx, serie.ID, date, nil); err != nil {
+				return err
+			}
+			return tronquerSerie(tx, serie, date)
+		default:
+			if err := reprendreOccurrencesEnregistrees(tx, serie.ID, "", nil); err != nil {
+				return err
+			}
+			if err := tx.Where("serie_id = ?", serie.ID).Delete(&models.ExceptionSeriePlanning{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&models.SeriePlanning{}, serie.ID).Error
+		}
+	})
+
+	switch {
+	case err == nil:
+	case err == errSerieIntrouvable || err == errOccurrenceIntrouvable:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == errPlanningFacture:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Erreur lors de la suppression : "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lirePortee lit la portée d'une modification de série (occurrence par défaut)
+func lirePortee(w http.ResponseWriter, r *http.Request) (string, bool) {
+	portee := r.URL.Query().Get("portee")
+	switch portee {
+	case "":
+		return models.PorteeOccurrence, true
+	case models.PorteeOccurrence, models.PorteeSuivantes, models.PorteeSerie:
+		return portee, true
+	}
+	http.Error(w, "Portée invalide : occurrence, suivantes ou serie", http.StatusBadRequest)
+	return "", false
+}
+
+// chargerSerie charge et verrouille une série de l'entreprise avec ses exceptions
+func chargerSerie(tx *gorm.DB, entrepriseID uint, id string) (*models.SeriePlanning, error) {
+	var serie models.SeriePlanning
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("entreprise_id = ?", entrepriseID).First(&serie, id).Error; err != nil {
+		return nil, errSerieIntrouvable
+	}
+	if err := tx.Where("serie_id = ?", serie.ID).Order("date").Find(&serie.Exceptions).Error; err != nil {
+		return nil, err
+	}
+	return &serie, nil
+}
+
+// occurrenceEnregistree retourne le planning enregistré d'une occurrence, s'il existe
+func occurrenceEnregistree(tx *gorm.DB, serieID uint, date string) (models.Planning, bool) {
+	var planning models.Planning
+	tx.Where("serie_id = ? AND date_occurrence = ?", serieID, date).Limit(1).Find(&planning)
+	return planning, planning.ID != 0
+}
+
+// occurrenceExiste indique si la date est une occurrence calculée ou enregistrée de la série
+func occurrenceExiste(tx *gorm.DB, serie *models.SeriePlanning, date string) bool {
+	if serie.EstOccurrence(date) {
+		return true
+	}
+	_, existe := occurrenceEnregistree(tx, serie.ID, date)
+	return existe
+}
+
+// modifierOccurrence enregistre la modification d'une seule occurrence
+func modifierOccurrence(tx *gorm.DB, serie *models.SeriePlanning, date string, corps []byte, conflits *controleConflits) (*models.Planning, error) {
+	planning, existe := occurrenceEnregistree(tx, serie.ID, date)
+	if !existe {
+		if !serie.EstOccurrence(date) {
+			return nil, errOccurrenceIntrouvable
+		}
+		planning = serie.Occurrence(date)
+	}
+	if planningFacture(planning) {
+		return nil, errPlanningFacture
+	}
+
+	planningID, uidImport := planning.ID, planning.UIDImport
+	if err := json.Unmarshal(corps, &planning); err != nil {
+		return nil, err
+	}
+
+	// La série, l'entreprise et la facture ne peuvent pas être modifiées par le corps de la requête
+	planning.ID = planningID
+	planning.EntrepriseID = serie.EntrepriseID
+	planning.SerieID = &serie.ID
+	planning.DateOccurrence = date
+	planning.UIDImport = uidImport
+	planning.LigneFactureID = nil
+	planning.NbRepetitions = 1
+	if err := validerPlanning(&planning); err != nil {
+		return nil, err
+	}
+	if err := conflits.verifier(tx, serie.EntrepriseID, []models.Planning{planning}, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Omit(clause.Associations).Save(&planning).Error; err != nil {
+		return nil, err
+	}
+	return &planning, nil
+}
+
+// modifierOccurrencesSuivantes arrête la série la veille de date (ou la supprime si date est sa première occurrence)
+// et crée une nouvelle série à partir de date
+func modifierOccurrencesSuivantes(tx *gorm.DB, serie *models.SeriePlanning, date string, corps []byte, conflits *controleConflits) (*models.SeriePlanning, error) {
+	if !occurrenceExiste(tx, serie, date) {
+		return nil, errOccurrenceIntrouvable
+	}
+
+	nouvelle, err := scinderSerie(serie, date)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(corps, &nouvelle); err != nil {
+		return nil, err
+	}
+	nouvelle.ID = 0
+	nouvelle.EntrepriseID = serie.EntrepriseID
+	nouvelle.DateDebut = date
+	nouvelle.UIDImport = ""
+	if err := validerSerie(&nouvelle); err != nil {
+		return nil, err
+	}
+
+	// Les occurrences remplacées par la nouvelle série ne sont pas comparées à ses occurrences
+	remplacee := func(p *models.Planning) bool {
+		return p.SerieID != nil && *p.SerieID == serie.ID && p.DateOccurrence >= date
+	}
+	if err := conflits.verifierSerie(tx, &nouvelle, remplacee); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Omit("Salarie", "Client").Create(&nouvelle).Error; err != nil {
+		return nil, err
+	}
+	if err := reprendreOccurrencesEnregistrees(tx, serie.ID, date, &nouvelle.ID); err != nil {
+		return nil, err
+	}
+	if err := tronquerSerie(tx, serie, date); err != nil {
+		return nil, err
+	}
+	return &nouvelle, nil
+}
+
+// scinderSerie prépare la série qui reprend serie à partir de date : même modèle, exceptions à partir de date
+// et, si la série est bornée par COUNT, nombre d'occurrences restant à partir de date
+func scinderSerie(serie *models.SeriePlanning, date string) (models.SeriePlanning, error) {
+	regle, err := serie.Regle()
+	if err != nil {
+		return models.SeriePlanning{}, err
+	}
+	jour, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return models.SeriePlanning{}, errOccurrenceIntrouvable
+	}
+
+	nouvelle := *serie
+	nouvelle.ID = 0
+	nouvelle.CreatedAt = time.Time{}
+	nouvelle.UpdatedAt = time.Time{}
+	nouvelle.DateDebut = date
+	nouvelle.Exceptions = nil
+	for _, exception := range serie.Exceptions {
+		if exception.Date >= date {
+			nouvelle.Exceptions = append(nouvelle.Exceptions, models.ExceptionSeriePlanning{Date: exception.Date})
+		}
+	}
+
+	// Les occurrences exclues avant date comptent dans COUNT, comme en RFC 5545
+	if regle.OrigOptions.Count > 0 {
+		option := regle.OrigOptions
+		option.Dtstart = time.Time{}
+		option.Count -= len(regle.Between(regle.GetDTStart(), jour.AddDate(0, 0, -1), true))
+		if option.Count <= 0 {
+			return models.SeriePlanning{}, errOccurrenceIntrouvable
+		}
+		nouvelle.RRule = option.RRuleString()
+	}
+	return nouvelle, nil
+}
+
+// modifierSerie applique une modification à toute la série
+func modifierSerie(tx *gorm.DB, serie *models.SeriePlanning, corps []byte, conflits *controleConflits) error {
+	serieID := serie.ID
+	entrepriseID := serie.EntrepriseID
+	exceptions := serie.Exceptions
+	uidImport := serie.UIDImport
+
+	if err := json.Unmarshal(corps, serie); err != nil {
+		return err
+	}
+	serie.ID = serieID
+	serie.EntrepriseID = entrepriseID
+	serie.Exceptions = exceptions
+	serie.UIDImport = uidImport
+	if err := validerSerie(serie); err != nil {
+		return err
+	}
+
+	remplacee := func(p *models.Planning) bool {
+		return p.SerieID != nil && *p.SerieID == serieID
+	}
+	if err := conflits.verifierSerie(tx, serie, remplacee); err != nil {
+		return err
+	}
+
+	if err := reprendreOccurrencesEnregistrees(tx, serie.ID, "", &serie.ID); err != nil {
+		return err
+	}
+	return tx.Omit(clause.Associations).Save(serie).Error
+}
+
+// supprimerOccurrence exclut une date de la série et supprime son planning enregistré
+func supprimerOccurrence(tx *gorm.DB, serie *models.SeriePlanning, date string) error {
+	planning, existe := occurrenceEnregistree(tx, serie.ID, date)
+	if !existe && !serie.EstOccurrence(date) {
+		return errOccurrenceIntrouvable
+	}
+	if existe {
+		if planningFacture(planning) {
+			return errPlanningFacture
+		}
+		if err := tx.Delete(&models.Planning{}, planning.ID).Error; err != nil {
+			return err
+		}
+	}
+	return ajouterExceptionSerie(tx, serie.ID, date)
+}
+
+// ajouterExceptionSerie exclut une date d'une série
+func ajouterExceptionSerie(tx *gorm.DB, serieID uint, date string) error {
+	exception := models.ExceptionSeriePlanning{SerieID: serieID, Date: date}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&exception).Error
+}
+
+// tronquerSerie arrête une série la veille de date, ou la supprime si aucune occurrence ne précède date
+func tronquerSerie(tx *gorm.DB, serie *models.SeriePlanning, date string) error {
+	regle, dateFin, reste, err := regleTronquee(serie, date)
+	if err != nil {
+		return err
+	}
+
+	if !reste {
+		if err := tx.Where("serie_id = ?", serie.ID).Delete(&models.ExceptionSeriePlanning{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.SeriePlanning{}, serie.ID).Error
+	}
+
+	serie.RRule = regle
+	serie.DateFin = dateFin
+	if err := tx.Where("serie_id = ? AND date >= ?", serie.ID, date).Delete(&models.ExceptionSeriePlanning{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.SeriePlanning{}).Where("id = ?", serie.ID).
+		Updates(map[string]interface{}{"rrule": serie.RRule, "date_fin": serie.DateFin}).Error
+}
+
+// regleTronquee retourne la règle de la série arrêtée la veille de date et sa nouvelle date de fin,
+// et false si la série n'a aucune occurrence avant date
+func regleTronquee(serie *models.SeriePlanning, date string) (string, string, bool, error) {
+	regle, err := serie.Regle()
+	if err != nil {
+		return "", "", false, err
+	}
+	jour, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", "", false, errOccurrenceIntrouvable
+	}
+	veille := jour.AddDate(0, 0, -1)
+	if date <= serie.DateDebut || len(regle.Between(regle.GetDTStart(), veille, true)) == 0 {
+		return "", "", false, nil
+	}
+
+	option := regle.OrigOptions
+	option.Dtstart = time.Time{}
+	option.Count = 0
+	option.Until = veille
+	return option.RRuleString(), veille.Format("2006-01-02"), true, nil
+}
+
+// reprendreOccurrencesEnregistrees traite les occurrences enregistrées d'une série à partir de depuis (toutes si vide) :
+// les occurrences facturées sont rattachées à la série cible, ou détachées de toute série si elle est nil,
+// les autres sont supprimées et reprennent le modèle de la série.
+func reprendreOccurrencesEnregistrees(tx *gorm.DB, serieID uint, depuis string, cible *uint) error {
+	facturees := tx.Model(&models.Planning{}).
+		Where("serie_id = ? AND date_occurrence >= ?", serieID, depuis).
+		Where("facturation = ? OR ligne_facture_id IS NOT NULL", models.FacturationFacture)
+
+	var err error
+	if cible != nil {
+		err = facturees.Update("serie_id", *cible).Error
+	} else {
+		err = facturees.Updates(map[string]interface{}{"serie_id": nil, "date_occurrence": ""}).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Where("serie_id = ? AND date_occurrence >= ?", serieID, depuis).
+		Where("facturation <> ? AND ligne_facture_id IS NULL", models.FacturationFacture).
+		Delete(&models.Planning{}).Error
+}
+
+// normaliserRegleRecurrence valide une règle RFC 5545 et la retourne sous forme canonique
+func normaliserRegleRecurrence(regle string) (string, error) {
+	regle = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(regle)), "RRULE:")
+	if regle == "" {
+		return "", fmt.Errorf("La règle de récurrence (rrule) est obligatoire")
+	}
+
+	option, err := rrule.StrToROption(regle)
+	if err != nil {
+		return "", fmt.Errorf("Règle de récurrence invalide : %v", err)
+	}
+
+	switch option.Freq {
+	case rrule.DAILY, rrule.WEEKLY, rrule.MONTHLY, rrule.YEARLY:
+	default:
+		return "", fmt.Errorf("Fréquence non prise en charge : utilisez DAILY, WEEKLY, MONTHLY ou YEARLY")
+	}
+	if !option.Dtstart.IsZero() {
+		return "", fmt.Errorf("La première occurrence se renseigne dans date_debut, pas dans la règle")
+	}
+	if len(option.Byhour) > 0 || len(option.Byminute) > 0 || len(option.Bysecond) > 0 {
+		return "", fmt.Errorf("Les horaires se renseignent dans heure_debut et heure_fin, pas dans la règle")
+	}
+	if option.Count > 0 && !option.Until.IsZero() {
+		return "", fmt.Errorf("COUNT et UNTIL ne peuvent pas être utilisés ensemble")
+	}
+	if option.Count < 0 || option.Count > maxOccurrencesSerie {
+		return "", fmt.Errorf("COUNT doit être compris entre 1 et %d", maxOccurrencesSerie)
+	}
+	if option.Interval < 0 {
+		return "", fmt.Errorf("INTERVAL doit être positif")
+	}
+
+	return option.RRuleString(), nil
+}
+
+// validerSerie normalise la règle d'une série, vérifie son modèle et calcule sa date de fin
+func validerSerie(serie *models.SeriePlanning) error {
+	regle, err := normaliserRegleRecurrence(serie.RRule)
+	if err != nil {
+		return err
+	}
+	serie.RRule = regle
+
+	if _, err := time.Parse("2006-01-02", serie.DateDebut); err != nil {
+		return fmt.Errorf("Date de début invalide (format attendu : AAAA-MM-JJ)")
+	}
+	for _, exception := range serie.Exceptions {
+		if _, err := time.Parse("2006-01-02", exception.Date); err != nil {
+			return fmt.Errorf("Date d'exception invalide : %s", exception.Date)
+		}
+	}
+
+	// Le modèle est validé comme une occurrence
+	modele := serie.Occurrence(serie.DateDebut)
+	if err := validerPlanning(&modele); err != nil {
+		return err
+	}
+	serie.Facturation = modele.Facturation
+
+	rr, err := serie.Regle()
+	if err != nil {
+		return err
+	}
+	premiere := rr.After(rr.GetDTStart(), true)
+	if premiere.IsZero() {
+		return fmt.Errorf("La règle de récurrence ne produit aucune occurrence")
+	}
+
+	switch {
+	case rr.OrigOptions.Count > 0:
+		occurrences := rr.All()
+		serie.DateFin = occurrences[len(occurrences)-1].Format("2006-01-02")
+	case !rr.OrigOptions.Until.IsZero():
+		serie.DateFin = rr.OrigOptions.Until.Format("2006-01-02")
+	default:
+		serie.DateFin = ""
+	}
+	return nil
+}
+
+// validerPlanning vérifie la date, les horaires, l'état de facturation et les relations d'un planning
+func validerPlanning(planning *models.Planning) error {
+	if _, err := time.Parse("2006-01-02", planning.Date); err != nil {
+		return fmt.Errorf("Date invalide")
+	}
+	// Les horaires sont facultatifs (événement sur la journée), mais doivent être valides s'ils sont renseignés
+	if planning.HeureDebut != "" || planning.HeureFin != "" {
+		if _, err := planning.Duree(); err != nil {
+			return err
+		}
+	}
+	if err := validerEtatFacturation(&planning.Facturation); err != nil {
+		return err
+	}
+	return validatePlanningRelations(planning)
+}
+
+// lirePeriodePlannings lit la période date_debut / date_fin d'une requête de lecture des plannings.
+// Sans période, les séries sont développées jusqu'à un an à partir d'aujourd'hui.
+func lirePeriodePlannings(r *http.Request) (string, string, error) {
+	debut := r.URL.Query().Get("date_debut")
+	fin := r.URL.Query().Get("date_fin")
+
+	if debut == "" && fin == "" {
+		return "0001-01-01", time.Now().AddDate(1, 0, 0).Format("2006-01-02"), nil
+	}
+
+	debutT, err := time.Parse("2006-01-02", debut)
+	if err != nil {
+		return "", "", fmt.Errorf("date_debut invalide (format attendu : AAAA-MM-JJ)")
+	}
+	finT, err := time.Parse("2006-01-02", fin)
+	if err != nil {
+		return "", "", fmt.Errorf("date_fin invalide (format attendu : AAAA-MM-JJ)")
+	}
+	if finT.Before(debutT) {
+		return "", "", fmt.Errorf("date_fin doit être postérieure à date_debut")
+	}
+	if finT.Sub(debutT) > maxJoursPeriodePlannings*24*time.Hour {
+		return "", "", fmt.Errorf("La période ne doit pas dépasser %d jours", maxJoursPeriodePlannings)
+	}
+	return debut, fin, nil
+}
+
+// occurrencesVirtuelles calcule les occurrences des séries de l'entreprise entre debut et fin (AAAA-MM-JJ inclus),
+// hors exceptions et hors occurrences déjà enregistrées comme plannings
+func occurrencesVirtuelles(db *gorm.DB, entrepriseID uint, debut, fin string) ([]models.Planning, error) {
+	var series []models.SeriePlanning
+	err := db.Preload("Exceptions").Preload("Salarie").Preload("Client").
+		Where("entreprise_id = ? AND date_debut <= ?", entrepriseID, fin).
+		Where("date_fin = '' OR date_fin IS NULL OR date_fin >= ?", debut).
+		Order("id").Find(&series).Error
+	if err != nil || len(series) == 0 {
+		return nil, err
+	}
+
+	serieIDs := make([]uint, 0, len(series))
+	for _, serie := range series {
+		serieIDs = append(serieIDs, serie.ID)
+	}
+
+	var enregistrees []struct {
+		SerieID        uint
+		DateOccurrence string
+	}
+	if err := db.Model(&models.Planning{}).Select("serie_id, date_occurrence").
+		Where("serie_id IN ? AND date_occurrence >= ? AND date_occurrence <= ?", serieIDs, debut, fin).
+		Scan(&enregistrees).Error; err != nil {
+		return nil, err
+	}
+	dejaEnregistree := make(map[string]bool, len(enregistrees))
+	for _, e := range enregistrees {
+		dejaEnregistree[fmt.Sprintf("%d/%s", e.SerieID, e.DateOccurrence)] = true
+	}
+
+	debutT, err := time.Parse("2006-01-02", debut)
+	if err != nil {
+		return nil, err
+	}
+	finT, err := time.Parse("2006-01-02", fin)
+	if err != nil {
+		return nil, err
+	}
+
+	var occurrences []models.Planning
+	for i := range series {
+		dates, err := series[i].Occurrences(debutT, finT)
+		if err != nil {
+			return nil, err
+		}
+		for _, date := range dates {
+			if !dejaEnregistree[fmt.Sprintf("%d/%s", series[i].ID, date)] {
+				occurrences = append(occurrences, series[i].Occurrence(date))
+			}
+		}
+	}
+	return occurrences, nil
+}
+
+// materialiserOccurrences enregistre comme plannings les occurrences calculées retenues par garder,
+// pour qu'elles puissent être verrouillées et facturées. Elle doit être appelée dans une transaction.
+func materialiserOccurrences(tx *gorm.DB, entrepriseID uint, debut, fin string, garder func(*models.Planning) bool) error {
+	occurrences, err := occurrencesVirtuelles(tx, entrepriseID, debut, fin)
+	if err != nil {
+		return err
+	}
+	for i := range occurrences {
+		if !garder(&occurrences[i]) {
+			continue
+		}
+		if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&occurrences[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trierPlannings trie des plannings par date, heure de début puis identifiant
+func trierPlannings(plannings []models.Planning) {
+	sort.SliceStable(plannings, func(i, j int) bool {
+		a, b := plannings[i], plannings[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.HeureDebut != b.HeureDebut {
+			return a.HeureDebut < b.HeureDebut
+		}
+		return a.ID < b.ID
+	})
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"facturation-planning/models"
+)
+
+// occurrencesSerie retourne les occurrences de la série sur 2025 et 2026
+func occurrencesSerie(t *testing.T, serie models.SeriePlanning) []string {
+	t.Helper()
+	dates, err := serie.Occurrences(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dates
+}
+
+// seriePlanningTest construit une série avec les exceptions données
+func seriePlanningTest(rrule, dateDebut string, exceptions ...string) models.SeriePlanning {
+	serie := models.SeriePlanning{ID: 7, EntrepriseID: 5, RRule: rrule, DateDebut: dateDebut, HeureDebut: "09:00", HeureFin: "12:00"}
+	for _, date := range exceptions {
+		serie.Exceptions = append(serie.Exceptions, models.ExceptionSeriePlanning{SerieID: serie.ID, Date: date})
+	}
+	return serie
+}
+
+func TestScinderSerie(t *testing.T) {
+	cas := []struct {
+		nom        string
+		serie      models.SeriePlanning
+		date       string
+		rrule      string
+		exceptions []string
+	}{
+		// 16, 19 (exclu) et 23 juin sont passés : il reste 3 des 6 occurrences, dont le 26 et le 30 exclus
+		{"COUNT et exceptions", seriePlanningTest("FREQ=WEEKLY;BYDAY=MO,TH;COUNT=6", "2025-06-16", "2025-06-19", "2025-06-26", "2025-06-30"),
+			"2025-06-26", "FREQ=WEEKLY;COUNT=3;BYDAY=MO,TH", []string{"2025-06-26", "2025-06-30"}},
+		{"2e mardi, COUNT", seriePlanningTest("FREQ=MONTHLY;BYDAY=2TU;COUNT=5", "2025-01-01", "2025-02-11"),
+			"2025-03-11", "FREQ=MONTHLY;COUNT=3;BYDAY=+2TU", nil},
+		{"UNTIL", seriePlanningTest("FREQ=MONTHLY;BYMONTHDAY=31;UNTIL=20251231T000000Z", "2025-01-01", "2025-08-31"),
+			"2025-05-31", "FREQ=MONTHLY;BYMONTHDAY=31;UNTIL=20251231T000000Z", []string{"2025-08-31"}},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			nouvelle, err := scinderSerie(&c.serie, c.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nouvelle.ID != 0 || nouvelle.DateDebut != c.date || nouvelle.HeureDebut != c.serie.HeureDebut {
+				t.Errorf("nouvelle série : id %d, début %s, heure %s", nouvelle.ID, nouvelle.DateDebut, nouvelle.HeureDebut)
+			}
+			if nouvelle.RRule != c.rrule {
+				t.Errorf("règle %q, attendu %q", nouvelle.RRule, c.rrule)
+			}
+			var exceptions []string
+			for _, exception := range nouvelle.Exceptions {
+				if exception.SerieID != 0 {
+					t.Errorf("exception du %s encore rattachée à la série %d", exception.Date, exception.SerieID)
+				}
+				exceptions = append(exceptions, exception.Date)
+			}
+			if !reflect.DeepEqual(exceptions, c.exceptions) {
+				t.Errorf("exceptions %v, attendu %v", exceptions, c.exceptions)
+			}
+
+			// La nouvelle série reprend exactement les occurrences de l'ancienne à partir de date
+			var suivantes []string
+			for _, date := range occurrencesSerie(t, c.serie) {
+				if date >= c.date {
+					suivantes = append(suivantes, date)
+				}
+			}
+			if got := occurrencesSerie(t, nouvelle); !reflect.DeepEqual(got, suivantes) {
+				t.Errorf("occurrences de la nouvelle série %v, attendu %v", got, suivantes)
+			}
+		})
+	}
+
+	serie := seriePlanningTest("FREQ=WEEKLY;BYDAY=MO;COUNT=2", "2025-06-16")
+	if _, err := scinderSerie(&serie, "2025-06-30"); err != errOccurrenceIntrouvable {
+		t.Errorf("au-delà de COUNT : %v, attendu %v", err, errOccurrenceIntrouvable)
+	}
+}
+
+func TestRegleTronquee(t *testing.T) {
+	cas := []struct {
+		nom     string
+		serie   models.SeriePlanning
+		date    string
+		reste   bool
+		dateFin string
+	}{
+		{"première occurrence à la date de début", seriePlanningTest("FREQ=WEEKLY;BYDAY=MO,TH;COUNT=6", "2025-06-16"), "2025-06-16", false, ""},
+		// La série commence le 1er mais sa première occurrence est le 14
+		{"première occurrence après la date de début", seriePlanningTest("FREQ=MONTHLY;BYDAY=2TU", "2025-01-01"), "2025-01-14", false, ""},
+		{"occurrences précédentes toutes exclues", seriePlanningTest("FREQ=MONTHLY;BYDAY=2TU", "2025-01-01", "2025-01-14"), "2025-02-11", true, "2025-02-10"},
+		{"COUNT remplacé par UNTIL", seriePlanningTest("FREQ=WEEKLY;BYDAY=MO,TH;COUNT=6", "2025-06-16", "2025-06-19"), "2025-06-26", true, "2025-06-25"},
+		{"31 du mois", seriePlanningTest("FREQ=MONTHLY;BYMONTHDAY=31", "2025-01-01"), "2025-05-31", true, "2025-05-30"},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			regle, dateFin, reste, err := regleTronquee(&c.serie, c.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reste != c.reste || dateFin != c.dateFin {
+				t.Fatalf("reste %v, fin %q, attendu %v, %q", reste, dateFin, c.reste, c.dateFin)
+			}
+			if !reste {
+				return
+			}
+			if strings.Contains(regle, "COUNT") {
+				t.Errorf("règle %q : COUNT conservé", regle)
+			}
+
+			// La série tronquée garde exactement les occurrences antérieures à date
+			var precedentes []string
+			for _, date := range occurrencesSerie(t, c.serie) {
+				if date < c.date {
+					precedentes = append(precedentes, date)
+				}
+			}
+			tronquee := c.serie
+			tronquee.RRule = regle
+			if got := occurrencesSerie(t, tronquee); !reflect.DeepEqual(got, precedentes) {
+				t.Errorf("occurrences de la série tronquée %v, attendu %v", got, precedentes)
+			}
+		})
+	}
+}
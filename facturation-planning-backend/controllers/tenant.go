@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"gorm.io/gorm"
+)
+
+// getEntrepriseID retourne l'ID de l'entreprise connectée, placé dans le contexte par JWTMiddleware
+func getEntrepriseID(r *http.Request) (uint, bool) {
+	switch v := r.Context().Value("entrepriseID").(type) {
+	case float64:
+		if v > 0 {
+			return uint(v), true
+		}
+	case uint:
+		if v > 0 {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// requireEntrepriseID renvoie une erreur 401 si aucune entreprise n'est associée à la requête
+func requireEntrepriseID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	entrepriseID, ok := getEntrepriseID(r)
+	if !ok {
+		http.Error(w, "Erreur d'authentification", http.StatusUnauthorized)
+		return 0, false
+	}
+	return entrepriseID, true
+}
+
+// tenantDB limite une requête aux lignes appartenant à l'entreprise connectée
+func tenantDB(entrepriseID uint) *gorm.DB {
+	return config.DB.Where("entreprise_id = ?", entrepriseID)
+}
+
+// clientAppartientA vérifie qu'un client existe et appartient à l'entreprise
+func clientAppartientA(entrepriseID, clientID uint) bool {
+	var count int64
+	config.DB.Model(&models.Client{}).Where("id = ? AND entreprise_id = ?", clientID, entrepriseID).Count(&count)
+	return count > 0
+}
+
+// salarieAppartientA vérifie qu'un salarié existe et appartient à l'entreprise
+func salarieAppartientA(entrepriseID, salarieID uint) bool {
+	var count int64
+	config.DB.Model(&models.Salarie{}).Where("id = ? AND entreprise_id = ?", salarieID, entrepriseID).Count(&count)
+	return count > 0
+}
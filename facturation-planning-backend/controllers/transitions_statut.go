@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+var errTransitionStatutInterdite = errors.New("Transition de statut interdite")
+
+// acteurTransition identifie l'auteur d'un changement de statut
+type acteurTransition struct {
+	Type      string
+	Nom       string
+	AdresseIP string
+}
+
+// acteurEntreprise retourne l'utilisateur connecté de l'entreprise, identifié par son email de connexion
+func acteurEntreprise(r *http.Request, entrepriseID uint) acteurTransition {
+	var entreprise models.Entreprise
+	config.DB.Select("id", "email").Limit(1).Find(&entreprise, entrepriseID)
+	return acteurTransition{Type: models.ActeurEntreprise, Nom: entreprise.Email, AdresseIP: adresseIPClient(r)}
+}
+
+// acteurClient retourne le client qui répond au devis par le lien d'acceptation en ligne
+func acteurClient(r *http.Request, nom string) acteurTransition {
+	return acteurTransition{Type: models.ActeurClient, Nom: nom, AdresseIP: adresseIPClient(r)}
+}
+
+// acteurSysteme retourne un traitement automatique
+func acteurSysteme(nom string) acteurTransition {
+	return acteurTransition{Type: models.ActeurSysteme, Nom: nom}
+}
+
+// messageTransitionInterdite explique le refus d'un changement de statut
+func messageTransitionInterdite(de, vers string) string {
+	return fmt.Sprintf("Transition de statut interdite : %s → %s", de, vers)
+}
+
+// changerStatutDevis fait passer un devis au statut vers dans une transaction, en reportant le statut sur ses révisions
+// et en traçant la transition. Les champs complémentaires sont mis à jour avec le statut. Sans effet si le statut est inchangé.
+func changerStatutDevis(tx *gorm.DB, devis models.Devis, vers string, acteur acteurTransition, motif string, champs map[string]interface{}) error {
+	if devis.Statut == vers {
+		return nil
+	}
+	if !models.TransitionDevisAutorisee(devis.Statut, vers) {
+		return errTransitionStatutInterdite
+	}
+
+	updates := map[string]interface{}{"statut": vers}
+	for champ, valeur := range champs {
+		updates[champ] = valeur
+	}
+	if err := tx.Model(&models.Devis{}).Where("id = ?", devis.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+	if err := appliquerStatutRevision(tx, devis, vers); err != nil {
+		return err
+	}
+	return enregistrerTransition(tx, models.TypeDocumentDevis, devis.EntrepriseID, devis.ID, devis.Statut, vers, acteur, motif)
+}
+
+// enregistrerTransition trace le changement de statut d'un document
+func enregistrerTransition(tx *gorm.DB, typeDocument string, entrepriseID, documentID uint, de, vers string, acteur acteurTransition, motif string) error {
+	return tx.Create(&models.TransitionStatut{
+		EntrepriseID: entrepriseID,
+		TypeDocument: typeDocument,
+		DocumentID:   documentID,
+		De:           de,
+		Vers:         vers,
+		Acteur:       acteur.Type,
+		ActeurNom:    acteur.Nom,
+		AdresseIP:    acteur.AdresseIP,
+		Motif:        motif,
+	}).Error
+}
+
+// historiqueStatuts retourne les changements de statut d'un document, du plus ancien au plus récent
+func historiqueStatuts(typeDocument string, documentID uint) ([]models.TransitionStatut, error) {
+	transitions := []models.TransitionStatut{}
+	err := config.DB.Where("type_document = ? AND document_id = ?", typeDocument, documentID).
+		Order("created_at, id").Find(&transitions).Error
+	return transitions, err
+}
+
+// GetHistoriqueDevis godoc
+// @Summary Historique des statuts d'un devis
+// @Description Retourne les changements de statut du devis avec leur auteur (entreprise, client ou système) et leur date, du plus ancien au plus récent
+// @Tags Devis
+// @Produce json
+// @Param id path string true "ID du devis"
+// @Success 200 {array} models.TransitionStatut
+// @Failure 404 {string} string "Devis introuvable"
+// @Failure 500 {string} string "Erreur lors de la récupération de l'historique"
+// @Router /devis/{id}/historique [get]
+func GetHistoriqueDevis(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var devis models.Devis
+	if err := tenantDB(entrepriseID).First(&devis, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Devis introuvable", http.StatusNotFound)
+		return
+	}
+
+	transitions, err := historiqueStatuts(models.TypeDocumentDevis, devis.ID)
+	if err != nil {
+		http.Error(w, "Erreur lors de la récupération de l'historique", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transitions)
+}
+
+// GetHistoriqueFacture godoc
+// @Summary Historique des statuts d'une facture
+// @Description Retourne les changements de statut de la facture (émission, paiements, avoirs) avec leur auteur et leur date, du plus ancien au plus récent
+// @Tags Factures
+// @Produce json
+// @Param id path int true "ID de la facture"
+// @Success 200 {array} models.TransitionStatut
+// @Failure 404 {string} string "Facture introuvable"
+// @Failure 500 {string} string "Erreur lors de la récupération de l'historique"
+// @Router /factures/{id}/historique [get]
+func GetHistoriqueFacture(w http.ResponseWriter, r *http.Request) {
+	entrepriseID, ok := requireEntrepriseID(w, r)
+	if !ok {
+		return
+	}
+
+	var facture models.Facture
+	if err := tenantDB(entrepriseID).Select("id").First(&facture, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, errFactureIntrouvable.Error(), http.StatusNotFound)
+		return
+	}
+
+	transitions, err := historiqueStatuts(models.TypeDocumentFacture, facture.ID)
+	if err != nil {
+		http.Error(w, "Erreur lors de la récupération de l'historique", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transitions)
+}
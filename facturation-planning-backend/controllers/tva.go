@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"facturation-planning/config"
+	"facturation-planning/models"
+	"facturation-planning/utils"
+)
+
+// toleranceMontant est l'écart accepté entre un montant transmis et le montant recalculé (un centime)
+const toleranceMontant = models.Montant(1)
+
+// verifierTVA contrôle les taux de TVA des lignes d'un document et son motif d'exonération :
+// taux en vigueur uniquement, et aucune ligne soumise à TVA sur un document exonéré
+func verifierTVA(taux []float64, motif string) error {
+	if !models.MotifExonerationTVAValide(motif) {
+		return fmt.Errorf("motif d'exonération de TVA invalide : %s (franchise, autoliquidation ou intracommunautaire)", motif)
+	}
+	for i, t := range taux {
+		if !models.TauxTVAApplicable(t) {
+			return fmt.Errorf("ligne %d : taux de TVA %s non applicable (%s)", i+1, utils.FormatTauxPDF(t), tauxTVAApplicables())
+		}
+		if motif != "" && t != 0 {
+			return fmt.Errorf("ligne %d : un document exonéré de TVA (%s) ne peut pas comporter de ligne à %s", i+1, motif, utils.FormatTauxPDF(t))
+		}
+	}
+	return nil
+}
+
+// errNumeroTVAClientManquant signale une autoliquidation intracommunautaire sans numéro de TVA du client,
+// mention obligatoire de la facture
+var errNumeroTVAClientManquant = errors.New("le numéro de TVA intracommunautaire du client est obligatoire pour l'autoliquidation intracommunautaire")
+
+// tauxTVAApplicables liste les taux en vigueur pour les messages d'erreur (20 %, 10 %, 5,5 %...)
+func tauxTVAApplicables() string {
+	libelles := make([]string, 0, len(models.TauxTVAApplicables))
+	for _, t := range models.TauxTVAApplicables {
+		libelles = append(libelles, utils.FormatTauxPDF(t))
+	}
+	return strings.Join(libelles, ", ")
+}
+
+// errFactureSansLigne signale une facture sans ligne : ses montants sont calculés à partir des lignes
+var errFactureSansLigne = errors.New("la facture doit comporter au moins une ligne")
+
+// calculerTotauxFacture calcule les montants des lignes et les totaux de la facture (TVA par taux),
+// après avoir vérifié que les montants transmis par le client correspondent au calcul
+func calculerTotauxFacture(facture *models.Facture) error {
+	if len(facture.Lignes) == 0 {
+		return errFactureSansLigne
+	}
+	if err := verifierRemisesFacture(facture); err != nil {
+		return err
+	}
+	if err := verifierTotauxFacture(facture); err != nil {
+		return err
+	}
+	for i := range facture.Lignes {
+		calculerMontantsLigne(&facture.Lignes[i])
+	}
+	totaliserFacture(facture)
+	return nil
+}
+
+// calculerMontantsLigne calcule le montant HT (quantité × prix unitaire, arrondi au centime, remise déduite) et le montant TTC d'une ligne.
+// Les lignes de facturation d'un devis (situation, solde, déduction d'acompte) conservent leurs montants, vérifiés au centime près :
+// ils découlent des avancements cumulés et des acomptes, pour que les factures successives totalisent exactement le devis.
+func calculerMontantsLigne(ligne *models.LigneFacture) {
+	ligneDevis := ligne.PositionDevis != 0 || ligne.FactureAcompteID != nil
+
+	montantHT := ligne.MontantHTCalcule()
+	if ligneDevis {
+		montantHT = ligne.MontantHTLigne()
+	}
+	montantTTC := montantHT + montantHT.Pourcentage(ligne.TauxTVA)
+	if ligneDevis && ligne.MontantTTC != 0 {
+		montantTTC = ligne.MontantTTC
+	}
+	ligne.MontantHT, ligne.TotalLigne, ligne.MontantTTC = montantHT, montantHT, montantTTC
+}
+
+// totaliserFacture calcule les totaux d'une facture à partir de ses lignes, la TVA étant calculée par taux ;
+// le taux de TVA de la facture n'est renseigné que si toutes les lignes ont le même
+func totaliserFacture(facture *models.Facture) {
+	for i, ligne := range facture.Lignes {
+		if i == 0 {
+			facture.TauxTVA = ligne.TauxTVA
+		} else if ligne.TauxTVA != facture.TauxTVA {
+			facture.TauxTVA = 0
+		}
+	}
+	facture.CompleterTVA()
+	facture.SousTotalHT, facture.TotalTVA, facture.TotalTTC = models.TotauxVentilation(facture.VentilationTVA)
+	facture.MontantTVA = facture.TotalTVA
+	facture.ResteAPayer = facture.TotalTTC
+}
+
+// verifierTotauxFacture vérifie que les montants transmis pour chaque ligne correspondent à sa quantité,
+// à son prix unitaire, à sa remise et à son taux de TVA, et que les totaux transmis sont la somme des lignes
+// remise globale déduite (TVA calculée par taux).
+// Les montants non transmis (nuls) sont calculés par le serveur.
+func verifierTotauxFacture(facture *models.Facture) error {
+	for i, ligne := range facture.Lignes {
+		montantHT := ligne.MontantHTLigne()
+		if attendu := ligne.MontantHTCalcule(); !montantsEgaux(montantHT, attendu) {
+			return fmt.Errorf("ligne %d : le montant HT (%s) ne correspond pas à la quantité × prix unitaire, remise déduite (%s)",
+				i+1, montantHT, attendu)
+		}
+		if attendu := montantHT + montantHT.Pourcentage(ligne.TauxTVA); ligne.MontantTTC != 0 && !montantsEgaux(ligne.MontantTTC, attendu) {
+			return fmt.Errorf("ligne %d : le montant TTC (%s) ne correspond pas au montant HT et au taux de TVA (%s)",
+				i+1, ligne.MontantTTC, attendu)
+		}
+	}
+	return verifierTotauxTransmis(facture.SousTotalHT, facture.TotalTVA, facture.TotalTTC, facture.Ventilation(), len(facture.Lignes))
+}
+
+// verifierTotauxTransmis vérifie que les totaux transmis par le client correspondent aux totaux calculés à partir
+// de la ventilation des lignes ; un total non transmis (nul) n'est pas contrôlé
+func verifierTotauxTransmis(sousTotalHT, totalTVA, totalTTC models.Montant, ventilation []models.VentilationTVA, nbLignes int) error {
+	calculeHT, calculeTVA, calculeTTC := models.TotauxVentilation(ventilation)
+
+	// La TVA arrondie ligne par ligne peut s'écarter d'un centime par ligne de la TVA calculée par taux
+	toleranceTVA := toleranceMontant * models.Montant(max(1, nbLignes))
+	switch {
+	case sousTotalHT != 0 && !montantsEgaux(sousTotalHT, calculeHT):
+		return fmt.Errorf("le sous-total HT (%s) ne correspond pas à la somme des lignes, remise globale déduite (%s)", sousTotalHT, calculeHT)
+	case totalTVA != 0 && (totalTVA-calculeTVA).Abs() > toleranceTVA:
+		return fmt.Errorf("le total de TVA (%s) ne correspond pas à la TVA des lignes par taux (%s)", totalTVA, calculeTVA)
+	case totalTTC != 0 && (totalTTC-calculeTTC).Abs() > toleranceTVA:
+		return fmt.Errorf("le total TTC (%s) ne correspond pas au sous-total HT augmenté de la TVA (%s)", totalTTC, calculeTTC)
+	}
+	return nil
+}
+
+// montantsEgaux compare deux montants au centime près
+func montantsEgaux(a, b models.Montant) bool {
+	return (a - b).Abs() <= toleranceMontant
+}
+
+// motifExonerationParDefaut retourne l'exonération par défaut de l'entreprise pour un document
+// dont toutes les lignes sont à 0 % (vide si le document porte de la TVA)
+func motifExonerationParDefaut(cfg config.DevisConfig, taux []float64) string {
+	if cfg.MotifExonerationTVA == "" || len(taux) == 0 {
+		return ""
+	}
+	for _, t := range taux {
+		if t != 0 {
+			return ""
+		}
+	}
+	return cfg.MotifExonerationTVA
+}
+
+// tauxLignesFacture retourne le taux de TVA de chaque ligne d'une facture
+func tauxLignesFacture(lignes []models.LigneFacture) []float64 {
+	taux := make([]float64, 0, len(lignes))
+	for _, ligne := range lignes {
+		taux = append(taux, ligne.TauxTVA)
+	}
+	return taux
+}
+
+// tauxLignesDevis retourne le taux de TVA de chaque ligne d'un devis
+func tauxLignesDevis(lignes []models.LigneDevis) []float64 {
+	taux := make([]float64, 0, len(lignes))
+	for _, ligne := range lignes {
+		taux = append(taux, ligne.TVA)
+	}
+	return taux
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"facturation-planning/models"
+)
+
+// factureTVATest construit une facture de deux lignes à 20 % et 5,5 %, montants et totaux transmis comme par le client :
+// 3 × 33,33 = 99,99 HT, 119,99 TTC ; 1 × 10,10 HT, 10,66 TTC
+func factureTVATest() models.Facture {
+	return models.Facture{
+		SousTotalHT: models.MontantEuros(110.09), TotalTVA: models.MontantEuros(20.56), TotalTTC: models.MontantEuros(130.65),
+		Lignes: []models.LigneFacture{
+			{Description: "Entretien", Unite: models.UniteHeure, Quantite: 3, PrixUnitaire: models.MontantEuros(33.33), TauxTVA: 20,
+				MontantHT: models.MontantEuros(99.99), MontantTTC: models.MontantEuros(119.99)},
+			{Description: "Fournitures", Unite: models.UniteUnite, Quantite: 1, PrixUnitaire: models.MontantEuros(10.10), TauxTVA: 5.5,
+				MontantHT: models.MontantEuros(10.10), MontantTTC: models.MontantEuros(10.66)},
+		},
+	}
+}
+
+func TestCalculerTotauxFacture(t *testing.T) {
+	centimes := func(n int64) models.Montant { return models.Montant(n) }
+
+	// La tolérance de la TVA et du TTC est d'un centime par ligne : les cas sur ces totaux n'ont qu'une ligne
+	uneLigne := func(f *models.Facture) {
+		f.Lignes = f.Lignes[:1]
+		f.SousTotalHT, f.TotalTVA, f.TotalTTC = models.MontantEuros(99.99), models.MontantEuros(20), models.MontantEuros(119.99)
+	}
+
+	cas := []struct {
+		nom      string
+		modifier func(*models.Facture)
+		erreur   string
+	}{
+		{"montants exacts", func(*models.Facture) {}, ""},
+		{"montants non transmis", func(f *models.Facture) {
+			f.SousTotalHT, f.TotalTVA, f.TotalTTC = 0, 0, 0
+			for i := range f.Lignes {
+				f.Lignes[i].MontantHT, f.Lignes[i].MontantTTC = 0, 0
+			}
+		}, ""},
+		{"HT de ligne à 1 centime", func(f *models.Facture) { f.Lignes[0].MontantHT += centimes(1) }, ""},
+		{"HT de ligne à 2 centimes", func(f *models.Facture) { f.Lignes[0].MontantHT += centimes(2) }, "ligne 1 : le montant HT"},
+		{"TTC de ligne à 1 centime", func(f *models.Facture) { f.Lignes[1].MontantTTC -= centimes(1) }, ""},
+		{"TTC de ligne à 2 centimes", func(f *models.Facture) { f.Lignes[1].MontantTTC -= centimes(2) }, "ligne 2 : le montant TTC"},
+		{"sous-total HT à 1 centime", func(f *models.Facture) { f.SousTotalHT += centimes(1) }, ""},
+		{"sous-total HT à 2 centimes", func(f *models.Facture) { f.SousTotalHT += centimes(2) }, "le sous-total HT"},
+		{"TVA à 1 centime", func(f *models.Facture) { uneLigne(f); f.TotalTVA -= centimes(1) }, ""},
+		{"TVA à 2 centimes", func(f *models.Facture) { uneLigne(f); f.TotalTVA -= centimes(2) }, "le total de TVA"},
+		{"TTC à 1 centime", func(f *models.Facture) { uneLigne(f); f.TotalTTC += centimes(1) }, ""},
+		{"TTC à 2 centimes", func(f *models.Facture) { uneLigne(f); f.TotalTTC += centimes(2) }, "le total TTC"},
+		{"sans ligne", func(f *models.Facture) { f.Lignes = nil }, errFactureSansLigne.Error()},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			facture := factureTVATest()
+			c.modifier(&facture)
+			err := calculerTotauxFacture(&facture)
+			if c.erreur != "" {
+				if err == nil || !strings.Contains(err.Error(), c.erreur) {
+					t.Fatalf("erreur %v, attendu %q", err, c.erreur)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Les montants retenus sont ceux du calcul, pas ceux transmis
+			attendu := factureTVATest()
+			if len(facture.Lignes) == 1 {
+				uneLigne(&attendu)
+			}
+			for i, ligne := range facture.Lignes {
+				if ligne.MontantHT != attendu.Lignes[i].MontantHT || ligne.MontantTTC != attendu.Lignes[i].MontantTTC {
+					t.Errorf("ligne %d : %s HT, %s TTC, attendu %s, %s", i+1, ligne.MontantHT, ligne.MontantTTC, attendu.Lignes[i].MontantHT, attendu.Lignes[i].MontantTTC)
+				}
+			}
+			if facture.SousTotalHT != attendu.SousTotalHT || facture.TotalTVA != attendu.TotalTVA || facture.TotalTTC != attendu.TotalTTC {
+				t.Errorf("totaux %s / %s / %s, attendu %s / %s / %s",
+					facture.SousTotalHT, facture.TotalTVA, facture.TotalTTC, attendu.SousTotalHT, attendu.TotalTVA, attendu.TotalTTC)
+			}
+			if facture.ResteAPayer != facture.TotalTTC || facture.MontantTVA != facture.TotalTVA {
+				t.Errorf("reste à payer %s, TVA %s, attendu %s, %s", facture.ResteAPayer, facture.MontantTVA, facture.TotalTTC, facture.TotalTVA)
+			}
+		})
+	}
+}
+
+func TestSuiviDevisForgeParLeClient(t *testing.T) {
+	// Une ligne transmise comme ligne de devis garde ses montants : le client pourrait y glisser un centime par ligne
+	forger := func(f *models.Facture, ecart models.Montant) {
+		acompteID := uint(3)
+		for i := range f.Lignes {
+			f.Lignes[i].PositionDevis = i + 1
+			f.Lignes[i].AvancementCumule = 100
+			f.Lignes[i].FactureAcompteID = &acompteID
+			f.Lignes[i].MontantHT += ecart
+			f.Lignes[i].MontantTTC += ecart
+		}
+		f.SousTotalHT, f.TotalTVA, f.TotalTTC = 0, 0, 0
+	}
+
+	t.Run("champs de suivi effacés", func(t *testing.T) {
+		facture := factureTVATest()
+		forger(&facture, models.Montant(1))
+		reinitialiserSuiviDevis(facture.Lignes)
+		for i, ligne := range facture.Lignes {
+			if ligne.PositionDevis != 0 || ligne.AvancementCumule != 0 || ligne.FactureAcompteID != nil {
+				t.Fatalf("ligne %d : suivi du devis conservé (position %d, avancement %v, acompte %v)",
+					i+1, ligne.PositionDevis, ligne.AvancementCumule, ligne.FactureAcompteID)
+			}
+		}
+		if err := calculerTotauxFacture(&facture); err != nil {
+			t.Fatal(err)
+		}
+		attendu := factureTVATest()
+		for i, ligne := range facture.Lignes {
+			if ligne.MontantHT != attendu.Lignes[i].MontantHT || ligne.MontantTTC != attendu.Lignes[i].MontantTTC {
+				t.Errorf("ligne %d : %s HT, %s TTC, attendu les montants recalculés %s, %s",
+					i+1, ligne.MontantHT, ligne.MontantTTC, attendu.Lignes[i].MontantHT, attendu.Lignes[i].MontantTTC)
+			}
+		}
+		if facture.TotalTTC != attendu.TotalTTC {
+			t.Errorf("total TTC %s, attendu %s", facture.TotalTTC, attendu.TotalTTC)
+		}
+	})
+
+	t.Run("contrôle maintenu sans réinitialisation", func(t *testing.T) {
+		facture := factureTVATest()
+		forger(&facture, models.Montant(2))
+		err := calculerTotauxFacture(&facture)
+		if err == nil || !strings.Contains(err.Error(), "ligne 1 : le montant HT") {
+			t.Fatalf("erreur %v, attendu le refus du montant HT forgé", err)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"facturation-planning/config"
 	"facturation-planning/models"
 	"fmt"
+	"os"
 )
 
 func MigrateDB() {
@@ -31,6 +32,9 @@ func MigrateDB() {
 	fmt.Println("🔄 Migration des tables avec dépendances...")
 	err = config.DB.AutoMigrate(
 		&models.Planning{},
+		&models.SeriePlanning{},
+		&models.ExceptionSeriePlanning{},
+		&models.AbonnementCalendrier{},
 	)
 
 	if err != nil {
@@ -40,9 +44,34 @@ func MigrateDB() {
 
 	// Étape 3 : Migrer les tables avec dépendances complexes
 	fmt.Println("🔄 Migration des tables avec dépendances complexes...")
+	initSolde := config.DB.Migrator().HasTable(&models.Facture{}) &&
+		!config.DB.Migrator().HasColumn(&models.Facture{}, "reste_a_payer")
+	initScellement := config.DB.Migrator().HasTable(&models.Facture{}) &&
+		!config.DB.Migrator().HasColumn(&models.Facture{}, "hash_contenu")
+	initPaiements := config.DB.Migrator().HasTable(&models.Facture{}) &&
+		!config.DB.Migrator().HasTable(&models.Paiement{})
+	initDevisFactures := config.DB.Migrator().HasTable(&models.Facture{}) &&
+		!config.DB.Migrator().HasColumn(&models.Facture{}, "devis_id")
+
+	// La référence n'est plus unique globalement : elle est attribuée à l'émission, par entreprise
+	if config.DB.Migrator().HasConstraint(&models.Facture{}, "uni_factures_reference") {
+		if err := config.DB.Migrator().DropConstraint(&models.Facture{}, "uni_factures_reference"); err != nil {
+			fmt.Println("❌ Erreur lors de la suppression de la contrainte uni_factures_reference :", err)
+		}
+	}
+	if config.DB.Migrator().HasIndex(&models.Facture{}, "idx_factures_reference") {
+		if err := config.DB.Migrator().DropIndex(&models.Facture{}, "idx_factures_reference"); err != nil {
+			fmt.Println("❌ Erreur lors de la suppression de l'index idx_factures_reference :", err)
+		}
+	}
+
 	err = config.DB.AutoMigrate(
 		&models.Facture{},
 		&models.LigneFacture{},
+		&models.Avoir{},
+		&models.LigneAvoir{},
+		&models.Paiement{},
+		&models.Relance{},
 	)
 
 	if err != nil {
@@ -50,11 +79,45 @@ func MigrateDB() {
 		return
 	}
 
+	// Initialiser le reste à payer des factures existantes (une seule fois, à l'ajout de la colonne)
+	if initSolde {
+		if err := config.DB.Exec("UPDATE factures SET reste_a_payer = total_ttc").Error; err != nil {
+			fmt.Println("❌ Erreur lors de l'initialisation du reste à payer :", err)
+		}
+	}
+
+	// Sceller les factures existantes (une seule fois, à l'ajout de la colonne hash_contenu)
+	if initScellement {
+		if err := migrateFacturesScellement(); err != nil {
+			fmt.Println("❌ Erreur lors du scellement des factures existantes :", err)
+		}
+	}
+
+	// Reprendre les factures déjà marquées payées (une seule fois, à la création de la table des paiements)
+	if initPaiements {
+		if err := migrateFacturesPayees(); err != nil {
+			fmt.Println("❌ Erreur lors de la reprise des factures payées :", err)
+		}
+	}
+
+	// Ramener l'état de facturation des plannings aux valeurs connues et le rattacher aux factures existantes
+	if err := migratePlanningsFacturation(); err != nil {
+		fmt.Println("❌ Erreur lors de la reprise de l'état de facturation des plannings :", err)
+	}
+
 	// Étape 4 : Migrer les tables devis (SANS suppression des données existantes)
 	fmt.Println("🔄 Migration des tables devis...")
+	initRevisions := config.DB.Migrator().HasTable(&models.Devis{}) &&
+		!config.DB.Migrator().HasTable(&models.RevisionDevis{})
+	initTotauxDevis := config.DB.Migrator().HasTable(&models.Devis{}) &&
+		!config.DB.Migrator().HasColumn(&models.Devis{}, "total_ttc")
+
 	err = config.DB.AutoMigrate(
 		&models.Devis{},
 		&models.LigneDevis{},
+		&models.RevisionDevis{},
+		&models.LigneRevisionDevis{},
+		&models.AcceptationDevis{},
 	)
 
 	if err != nil {
@@ -62,6 +125,82 @@ func MigrateDB() {
 		return
 	}
 
+	// Étape 5 : Migrer les tables de numérotation
+	fmt.Println("🔄 Migration des tables de numérotation...")
+	initSequences := !config.DB.Migrator().HasTable(&models.SequenceNumerotation{})
+
+	err = config.DB.AutoMigrate(
+		&models.FormatNumerotation{},
+		&models.SequenceNumerotation{},
+	)
+
+	if err != nil {
+		fmt.Println("❌ Erreur de migration de la numérotation :", err)
+		return
+	}
+
+	// Reprendre les numéros déjà attribués (une seule fois, à la création de la table des séquences)
+	if initSequences {
+		if err := migrateSequencesNumerotation(); err != nil {
+			fmt.Println("❌ Erreur lors de l'initialisation des séquences de numérotation :", err)
+		}
+	}
+
+	// Rattacher les factures existantes à leur devis d'origine (une seule fois, à l'ajout de la colonne devis_id)
+	if initDevisFactures {
+		if err := config.DB.Exec(`UPDATE factures f SET devis_id = d.id FROM devis d
+			WHERE f.devis_id IS NULL AND f.devis_reference <> '' AND f.entreprise_id = d.entreprise_id
+			AND (f.devis_reference = d.reference OR f.devis_reference LIKE d.reference || '-v%')`).Error; err != nil {
+			fmt.Println("❌ Erreur lors du rattachement des factures à leur devis :", err)
+		}
+	}
+
+	// Figer le contenu des devis existants dans leur première révision (une seule fois, à la création de la table des révisions)
+	if initRevisions {
+		if err := migrateRevisionsDevis(); err != nil {
+			fmt.Println("❌ Erreur lors de la création des révisions des devis existants :", err)
+		}
+	}
+
+	// Enregistrer les totaux des devis existants (une seule fois, à l'ajout des colonnes de totaux)
+	if initTotauxDevis {
+		if err := migrateTotauxDevis(); err != nil {
+			fmt.Println("❌ Erreur lors du calcul des totaux des devis existants :", err)
+		}
+	}
+
+	// Étape 6 : Migrer les tables d'envoi d'emails
+	fmt.Println("🔄 Migration des tables d'envoi d'emails...")
+	err = config.DB.AutoMigrate(
+		&models.ParametresEmail{},
+		&models.EnvoiEmail{},
+	)
+
+	if err != nil {
+		fmt.Println("❌ Erreur de migration des emails :", err)
+		return
+	}
+
+	// Étape 7 : Migrer les tables des factures fournisseurs importées
+	fmt.Println("🔄 Migration des tables des factures fournisseurs...")
+	err = config.DB.AutoMigrate(
+		&models.FactureFournisseur{},
+		&models.LigneFactureFournisseur{},
+		&models.TVAFactureFournisseur{},
+	)
+
+	if err != nil {
+		fmt.Println("❌ Erreur de migration des factures fournisseurs :", err)
+		return
+	}
+
+	// Étape 8 : Migrer l'historique des statuts des devis et factures
+	fmt.Println("🔄 Migration de l'historique des statuts...")
+	if err := config.DB.AutoMigrate(&models.TransitionStatut{}); err != nil {
+		fmt.Println("❌ Erreur de migration de l'historique des statuts :", err)
+		return
+	}
+
 	fmt.Println("✅ Migration réussie !")
 }
 
@@ -91,6 +230,267 @@ func migrateClientsData() error {
 	return nil
 }
 
+// migrateFacturesScellement convertit les anciens statuts et calcule l'empreinte des factures déjà émises
+func migrateFacturesScellement() error {
+	fmt.Println("🔄 Scellement des factures existantes...")
+
+	statuts := map[string][]string{
+		models.StatutFactureEmise:   {"en attente", "en_attente", "rejetée"},
+		models.StatutFacturePayee:   {"payee"},
+		models.StatutFactureAnnulee: {"annulee"},
+	}
+	for nouveau, anciens := range statuts {
+		if err := config.DB.Model(&models.Facture{}).Where("statut IN ?", anciens).Update("statut", nouveau).Error; err != nil {
+			return fmt.Errorf("erreur lors de la conversion des statuts : %v", err)
+		}
+	}
+
+	var factures []models.Facture
+	if err := config.DB.Preload("Lignes").
+		Where("statut <> ? AND (hash_contenu IS NULL OR hash_contenu = '')", models.StatutFactureBrouillon).
+		Find(&factures).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des factures : %v", err)
+	}
+
+	for _, facture := range factures {
+		updates := map[string]interface{}{
+			"hash_contenu": facture.CalculerHashContenu(),
+			"emise_le":     facture.DateEmission,
+		}
+		if err := config.DB.Model(&models.Facture{}).Where("id = ?", facture.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("erreur lors du scellement de la facture %d : %v", facture.ID, err)
+		}
+	}
+
+	if len(factures) > 0 {
+		fmt.Printf("✅ %d factures existantes scellées\n", len(factures))
+	}
+
+	return nil
+}
+
+// migrateFacturesPayees enregistre un paiement de reprise pour les factures marquées payées
+// avant l'existence des paiements, afin que le recalcul du solde conserve leur statut
+func migrateFacturesPayees() error {
+	var factures []models.Facture
+	if err := config.DB.Where("statut = ? AND reste_a_payer > 0", models.StatutFacturePayee).Find(&factures).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des factures payées : %v", err)
+	}
+
+	for _, facture := range factures {
+		paiement := models.Paiement{
+			EntrepriseID: facture.EntrepriseID,
+			FactureID:    facture.ID,
+			DatePaiement: facture.UpdatedAt,
+			Montant:      facture.ResteAPayer,
+			Mode:         models.ModePaiementVirement,
+			Commentaire:  "Reprise : facture marquée payée avant l'enregistrement des paiements",
+		}
+		if err := config.DB.Create(&paiement).Error; err != nil {
+			return fmt.Errorf("erreur lors de la reprise de la facture %d : %v", facture.ID, err)
+		}
+
+		updates := map[string]interface{}{"total_paye": paiement.Montant, "reste_a_payer": 0}
+		if err := config.DB.Model(&models.Facture{}).Where("id = ?", facture.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du solde de la facture %d : %v", facture.ID, err)
+		}
+	}
+
+	if len(factures) > 0 {
+		fmt.Printf("✅ %d factures payées reprises\n", len(factures))
+	}
+
+	return nil
+}
+
+// migratePlanningsFacturation marque "Facturé" les plannings rattachés à une facture émise
+// et remplace les états de facturation saisis librement par "À facturer". Elle peut être relancée sans effet de bord.
+func migratePlanningsFacturation() error {
+	// Les plannings d'une facture émise (ancien lien factures.planning_id) sont facturés par sa première ligne
+	err := config.DB.Exec(`
+		UPDATE plannings SET ligne_facture_id = (
+			SELECT MIN(ligne_factures.id) FROM ligne_factures WHERE ligne_factures.facture_id = factures.id
+		)
+		FROM factures
+		WHERE factures.planning_id = plannings.id
+		  AND factures.entreprise_id = plannings.entreprise_id
+		  AND factures.statut NOT IN (?, ?)
+		  AND factures.deleted_at IS NULL
+		  AND plannings.ligne_facture_id IS NULL
+		  AND EXISTS (SELECT 1 FROM ligne_factures WHERE ligne_factures.facture_id = factures.id)`,
+		models.StatutFactureBrouillon, models.StatutFactureAnnulee).Error
+	if err != nil {
+		return fmt.Errorf("erreur lors du rattachement des plannings aux factures : %v", err)
+	}
+
+	if err := config.DB.Exec("UPDATE plannings SET facturation = ? WHERE ligne_facture_id IS NOT NULL AND facturation <> ?",
+		models.FacturationFacture, models.FacturationFacture).Error; err != nil {
+		return fmt.Errorf("erreur lors du marquage des plannings facturés : %v", err)
+	}
+
+	// Un planning déjà marqué "Facturé" sans facture rattachée reste facturé : il a été facturé hors de l'application
+	result := config.DB.Exec("UPDATE plannings SET facturation = ? WHERE facturation IS NULL OR facturation NOT IN (?, ?, ?, ?)",
+		models.FacturationAFacturer, models.FacturationAFacturer, models.FacturationFacture, models.FacturationNonFacturable, models.FacturationOffert)
+	if result.Error != nil {
+		return fmt.Errorf("erreur lors de la reprise des états de facturation : %v", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		fmt.Printf("✅ %d plannings repassés à l'état \"%s\"\n", result.RowsAffected, models.FacturationAFacturer)
+	}
+
+	return nil
+}
+
+// migrateSequencesNumerotation initialise les séquences à partir des références existantes,
+// pour que les prochains numéros ne réutilisent pas ceux déjà attribués
+func migrateSequencesNumerotation() error {
+	fmt.Println("🔄 Initialisation des séquences de numérotation...")
+
+	type cle struct {
+		entrepriseID uint
+		typeDocument string
+		annee        int
+	}
+	derniers := map[cle]int{}
+
+	// Factures et avoirs au format PREFIXE-AAAA-NNNN
+	reprendre := func(references []struct {
+		EntrepriseID uint
+		Reference    string
+	}, prefixe, typeDocument string) {
+		for _, ref := range references {
+			var annee, numero int
+			if _, err := fmt.Sscanf(ref.Reference, prefixe+"-%d-%d", &annee, &numero); err != nil {
+				continue
+			}
+			// Ignorer les anciens formats (ex. FAC-2025-06-003 de la facturation mensuelle)
+			if fmt.Sprintf("%s-%d-%04d", prefixe, annee, numero) != ref.Reference {
+				continue
+			}
+			k := cle{ref.EntrepriseID, typeDocument, annee}
+			if numero > derniers[k] {
+				derniers[k] = numero
+			}
+		}
+	}
+
+	var references []struct {
+		EntrepriseID uint
+		Reference    string
+	}
+	if err := config.DB.Model(&models.Facture{}).Select("entreprise_id, reference").
+		Where("reference LIKE 'FAC-%'").Scan(&references).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des factures : %v", err)
+	}
+	reprendre(references, "FAC", models.TypeDocumentFacture)
+
+	references = nil
+	if err := config.DB.Model(&models.Avoir{}).Select("entreprise_id, reference").
+		Where("reference LIKE 'AV-%'").Scan(&references).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des avoirs : %v", err)
+	}
+	reprendre(references, "AV", models.TypeDocumentAvoir)
+
+	// Les devis existants étaient numérotés d'après leur ID : on fige cette référence
+	if err := config.DB.Exec("UPDATE devis SET reference = 'DEV' || LPAD(id::text, 4, '0') WHERE reference = '' OR reference IS NULL").Error; err != nil {
+		return fmt.Errorf("erreur lors de la numérotation des devis existants : %v", err)
+	}
+
+	var devis []struct {
+		EntrepriseID uint
+		MaxID        int
+	}
+	if err := config.DB.Unscoped().Model(&models.Devis{}).Select("entreprise_id, MAX(id) AS max_id").
+		Group("entreprise_id").Scan(&devis).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des devis : %v", err)
+	}
+	for _, d := range devis {
+		derniers[cle{d.EntrepriseID, models.TypeDocumentDevis, 0}] = d.MaxID
+	}
+
+	for k, dernier := range derniers {
+		sequence := models.SequenceNumerotation{
+			EntrepriseID:  k.entrepriseID,
+			TypeDocument:  k.typeDocument,
+			Annee:         k.annee,
+			DernierNumero: dernier,
+		}
+		if err := config.DB.Create(&sequence).Error; err != nil {
+			return fmt.Errorf("erreur lors de la création de la séquence %s %d : %v", k.typeDocument, k.annee, err)
+		}
+	}
+
+	fmt.Printf("✅ %d séquences de numérotation initialisées\n", len(derniers))
+	return nil
+}
+
+// migrateRevisionsDevis crée la révision v1 des devis existants. Un devis déjà proposé au client a sa révision figée,
+// un devis accepté est facturable à partir de cette révision.
+func migrateRevisionsDevis() error {
+	fmt.Println("🔄 Création des révisions des devis existants...")
+
+	var devis []models.Devis
+	if err := config.DB.Preload("Lignes").Find(&devis).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des devis : %v", err)
+	}
+
+	for _, d := range devis {
+		reference := d.Reference
+		if reference == "" {
+			reference = fmt.Sprintf("DEV%04d", d.ID)
+		}
+		revision := d.NouvelleRevision(1, reference)
+		if d.Statut != "" && d.Statut != "brouillon" {
+			envoyee := d.UpdatedAt
+			revision.EnvoyeeLe = &envoyee
+		}
+		revisionAcceptee := 0
+		if d.Statut == "accepté" {
+			revision.AccepteeLe = revision.EnvoyeeLe
+			revisionAcceptee = 1
+		}
+
+		if err := config.DB.Create(&revision).Error; err != nil {
+			return fmt.Errorf("erreur lors de la création de la révision du devis %d : %v", d.ID, err)
+		}
+		if err := config.DB.Model(&models.Devis{}).Where("id = ?", d.ID).
+			Updates(map[string]interface{}{"revision": 1, "revision_acceptee": revisionAcceptee}).Error; err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du devis %d : %v", d.ID, err)
+		}
+	}
+
+	if len(devis) > 0 {
+		fmt.Printf("✅ %d devis existants repris en révision v1\n", len(devis))
+	}
+	return nil
+}
+
+// migrateTotauxDevis calcule et enregistre les totaux des devis existants à partir de leurs lignes
+func migrateTotauxDevis() error {
+	fmt.Println("🔄 Calcul des totaux des devis existants...")
+
+	var devis []models.Devis
+	if err := config.DB.Preload("Lignes").Find(&devis).Error; err != nil {
+		return fmt.Errorf("erreur lors de la lecture des devis : %v", err)
+	}
+
+	for _, d := range devis {
+		d.CalculerTotaux()
+		if err := config.DB.Model(&models.Devis{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+			"sous_total_ht": d.SousTotalHT,
+			"total_tva":     d.TotalTVA,
+			"total_ttc":     d.TotalTTC,
+		}).Error; err != nil {
+			return fmt.Errorf("erreur lors de la mise à jour du devis %d : %v", d.ID, err)
+		}
+	}
+
+	if len(devis) > 0 {
+		fmt.Printf("✅ Totaux de %d devis existants enregistrés\n", len(devis))
+	}
+	return nil
+}
+
 // CleanDevisData nettoie les données et tables devis - ATTENTION: Supprime toutes les données devis !
 // Cette fonction doit être appelée manuellement uniquement si vous voulez remettre à zéro les devis
 func CleanDevisData() error {
@@ -98,6 +498,18 @@ func CleanDevisData() error {
 
 	// Supprimer les tables si elles existent avec l'ancien schéma
 	// L'ordre est important : d'abord les tables dépendantes, puis les tables principales
+	if err := config.DB.Exec("DROP TABLE IF EXISTS acceptation_devis CASCADE").Error; err != nil {
+		return fmt.Errorf("erreur lors de la suppression de la table acceptation_devis : %v", err)
+	}
+
+	if err := config.DB.Exec("DROP TABLE IF EXISTS ligne_revision_devis CASCADE").Error; err != nil {
+		return fmt.Errorf("erreur lors de la suppression de la table ligne_revision_devis : %v", err)
+	}
+
+	if err := config.DB.Exec("DROP TABLE IF EXISTS revision_devis CASCADE").Error; err != nil {
+		return fmt.Errorf("erreur lors de la suppression de la table revision_devis : %v", err)
+	}
+
 	if err := config.DB.Exec("DROP TABLE IF EXISTS ligne_devis CASCADE").Error; err != nil {
 		return fmt.Errorf("erreur lors de la suppression de la table ligne_devis : %v", err)
 	}
@@ -139,6 +551,15 @@ func createTestData() error {
 			SIRET:       "83377432600023",
 			TVA:         "FR92833774326",
 			Responsable: "Émeric",
+			CodePostal:  "85170",
+			Ville:       "DOMPIERRE SUR YON",
+			CodeAPE:     "8121Z",
+			RCS:         "833774326",
+			Capital:     "500€",
+		}
+		if logo, err := os.ReadFile("assets/logo.jpg"); err == nil {
+			entreprise.Logo = logo
+			entreprise.LogoType = "image/jpeg"
 		}
 
 		if err := config.DB.Create(&entreprise).Error; err != nil {
@@ -191,14 +612,14 @@ func createTestData() error {
 				DevisID:      devis.ID,
 				Description:  "Développement frontend React (5 jours)",
 				Quantite:     1,
-				PrixUnitaire: 2000,
+				PrixUnitaire: models.MontantEuros(2000),
 				TVA:          20,
 			},
 			{
 				DevisID:      devis.ID,
 				Description:  "Développement backend Go (3 jours)",
 				Quantite:     1,
-				PrixUnitaire: 1350,
+				PrixUnitaire: models.MontantEuros(1350),
 				TVA:          20,
 			},
 		}
@@ -209,6 +630,16 @@ func createTestData() error {
 			}
 		}
 
+		devis.Lignes = lignes
+		devis.CalculerTotaux()
+		if err := config.DB.Model(&models.Devis{}).Where("id = ?", devis.ID).Updates(map[string]interface{}{
+			"sous_total_ht": devis.SousTotalHT,
+			"total_tva":     devis.TotalTVA,
+			"total_ttc":     devis.TotalTTC,
+		}).Error; err != nil {
+			return fmt.Errorf("erreur lors de l'enregistrement des totaux du devis de test : %v", err)
+		}
+
 		fmt.Printf("✅ Devis de test créé - Devis ID: %d avec %d lignes\n", devis.ID, len(lignes))
 	} else {
 		fmt.Println("✅ Données existantes détectées - Pas de création de données de test")
@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"facturation-planning/config"
+	"facturation-planning/controllers"
 	"facturation-planning/database"
 	"facturation-planning/middlewares"
 	"facturation-planning/models"
@@ -40,6 +41,12 @@ func main() {
 	config.ConnectDB()
 	database.RunMigrations()
 
+	// 📨 Planificateur des relances de factures impayées
+	controllers.LancerPlanificateurRelances(config.GetRelanceConfig().Intervalle)
+
+	// ⌛ Planificateur de l'expiration des devis envoyés échus
+	controllers.LancerPlanificateurExpirationDevis(config.GetDevisConfig().ExpirationInterval)
+
 	// 🔥 Créer un nouveau routeur Chi
 	r := chi.NewRouter()
 
@@ -122,8 +129,27 @@ func migrateFresh() error {
 func rollback() error {
 	// Supprimer toutes les tables dans l'ordre inverse des dépendances
 	tables := []string{
+		"transition_statuts",
+		"abonnement_calendriers",
+		"exception_serie_plannings",
+		"serie_plannings",
+		"tva_facture_fournisseurs",
+		"ligne_facture_fournisseurs",
+		"facture_fournisseurs",
+		"envoi_emails",
+		"parametres_emails",
+		"sequence_numerotations",
+		"format_numerotations",
+		"acceptation_devis",
+		"ligne_revision_devis",
+		"revision_devis",
 		"ligne_devis",
 		"devis",
+		"relances",
+		"paiements",
+		"ligne_avoirs",
+		"avoirs",
+		"ligne_factures",
 		"factures",
 		"plannings",
 		"clients",
@@ -47,6 +47,7 @@ func GetCORSOptions() cors.Options {
 			ExposedHeaders: []string{
 				"Content-Length",
 				"Content-Type",
+				"X-Conflits-Planning",
 			},
 			AllowCredentials: true,
 			MaxAge:           86400, // 24 heures
@@ -67,6 +68,7 @@ func GetCORSOptions() cors.Options {
 			"Content-Length",
 			"Content-Type",
 			"X-Total-Count",
+			"X-Conflits-Planning",
 		},
 		AllowCredentials: true,
 		MaxAge:           300,  // 5 minutes en développement pour faciliter les tests
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Décisions du client sur un devis en ligne
+const (
+	DecisionDevisAccepte = StatutDevisAccepte
+	DecisionDevisRefuse  = StatutDevisRefuse
+)
+
+// AcceptationDevis conserve la preuve de la décision du client sur une révision de devis, recueillie
+// par le lien d'acceptation en ligne : identité et signature du signataire, horodatage, adresse IP,
+// navigateur et empreinte du document présenté.
+type AcceptationDevis struct {
+	ID        uint      `json:"id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-06-15T14:30:00Z"`
+
+	EntrepriseID uint   `json:"entreprise_id" gorm:"not null;index" example:"1"`
+	DevisID      uint   `json:"devis_id" gorm:"not null;index" example:"12"`
+	Revision     int    `json:"revision" example:"2"`
+	Reference    string `json:"reference" example:"DEV0012-v2"`
+	Decision     string `json:"decision" example:"accepté"` // accepté, refusé
+	Motif        string `json:"motif,omitempty" gorm:"type:text" example:"Budget dépassé"`
+
+	NomSignataire string `json:"nom_signataire" example:"Jean Dupont"`
+	Lieu          string `json:"lieu,omitempty" example:"Paris"`
+	Signature     []byte `json:"-"`                                            // Image de la signature manuscrite (PNG ou JPEG)
+	SignatureType string `json:"signature_type,omitempty" example:"image/png"` // Vide si aucune signature
+
+	DecideLe          time.Time `json:"decide_le" example:"2025-06-15T14:30:00Z"`
+	AdresseIP         string    `json:"adresse_ip" example:"203.0.113.42"`
+	UserAgent         string    `json:"user_agent" example:"Mozilla/5.0 (Windows NT 10.0; Win64; x64)"`
+	EmpreinteDocument string    `json:"empreinte_document" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"` // SHA-256 du PDF présenté au client
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Types d'avoir
+const (
+	TypeAvoirTotal   = "total"
+	TypeAvoirPartiel = "partiel"
+)
+
+// LigneAvoir représente une ligne créditée dans un avoir
+type LigneAvoir struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty" gorm:"index"`
+	AvoirID        uint       `json:"avoirID"`
+	LigneFactureID *uint      `json:"ligneFactureID,omitempty" example:"12"`
+	Description    string     `json:"description" example:"Développement site web"`
+	Unite          string     `json:"unite" example:"jour"`
+	Quantite       float64    `json:"quantite" example:"2"`
+	PrixUnitaire   Montant    `json:"prixUnitaire" example:"500.00"`
+	TauxTVA        float64    `json:"tauxTVA" example:"20"`
+	MontantHT      Montant    `json:"montantHT" example:"1000.00"` // Remise de la ligne déduite
+	MontantTTC     Montant    `json:"montantTTC" example:"1200.00"`
+
+	// Remise de la ligne de facture créditée (pourcentage, ou montant au prorata de la quantité créditée)
+	RemisePourcentage float64 `json:"remisePourcentage,omitempty" gorm:"not null;default:0" example:"10"`
+	RemiseMontant     Montant `json:"remiseMontant,omitempty" gorm:"not null;default:0" example:"0.00"`
+}
+
+// Avoir représente une facture d'avoir qui corrige tout ou partie d'une facture émise
+// @Description Structure d'un avoir
+type Avoir struct {
+	ID        uint       `json:"id" gorm:"primaryKey" example:"1"`
+	CreatedAt time.Time  `json:"created_at" example:"2025-03-17T14:09:30.706109+01:00"`
+	UpdatedAt time.Time  `json:"updated_at" example:"2025-03-17T14:09:30.706109+01:00"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index"`
+
+	// Référence unique de l'avoir (numérotation propre : AV-2025-0001)
+	Reference    string `json:"reference" gorm:"not null;uniqueIndex:idx_avoirs_entreprise_reference" example:"AV-2025-0001"`
+	EntrepriseID uint   `json:"entrepriseID" gorm:"not null;uniqueIndex:idx_avoirs_entreprise_reference" example:"5"`
+
+	// Facture d'origine
+	FactureID uint     `json:"factureID" gorm:"not null;index" example:"1"`
+	Facture   *Facture `json:"facture,omitempty" gorm:"foreignKey:FactureID"`
+
+	// Informations client (copiées depuis la facture d'origine)
+	ClientID      uint   `json:"clientID" example:"1"`
+	ClientNom     string `json:"clientNom" example:"Entreprise ABC"`
+	ClientAdresse string `json:"clientAdresse" example:"123 Rue de la Paix, 75001 Paris"`
+
+	DateEmission time.Time `json:"dateEmission" example:"2025-03-20T00:00:00Z"`
+	TypeAvoir    string    `json:"typeAvoir" example:"partiel"` // "total" ou "partiel"
+	Motif        string    `json:"motif" example:"Remise commerciale sur prestation"`
+
+	// Montants crédités (positifs, à déduire de la facture d'origine)
+	SousTotalHT Montant `json:"sousTotalHT" example:"1000.00"`
+	TotalTVA    Montant `json:"totalTVA" example:"200.00"`
+	TotalTTC    Montant `json:"totalTTC" example:"1200.00"`
+
+	// Part de la remise globale de la facture sur les lignes créditées, déduite du sous-total HT
+	RemiseMontant Montant `json:"remiseMontant,omitempty" gorm:"not null;default:0" example:"0.00"`
+
+	// Champ calculé (ne pas stocker en base) : récapitulatif par taux de TVA
+	VentilationTVA []VentilationTVA `json:"ventilationTVA,omitempty" gorm:"-"`
+
+	Lignes []LigneAvoir `json:"lignes" gorm:"foreignKey:AvoirID"`
+}
+
+// Ventilation retourne le récapitulatif de TVA par taux des lignes créditées, remise globale déduite
+func (a *Avoir) Ventilation() []VentilationTVA {
+	bases := BasesTVA{}
+	for _, ligne := range a.Lignes {
+		bases.Ajouter(ligne.TauxTVA, ligne.MontantHT)
+	}
+	return bases.VentilationRemisee(a.RemiseMontant)
+}
+
+// TotalLignesHT retourne la somme des montants HT des lignes créditées, avant remise globale
+func (a *Avoir) TotalLignesHT() Montant {
+	var total Montant
+	for _, ligne := range a.Lignes {
+		total += ligne.MontantHT
+	}
+	return total
+}
+
+// AfterFind complète le récapitulatif de TVA à chaque lecture (lignes préchargées comprises)
+func (a *Avoir) AfterFind(tx *gorm.DB) error {
+	a.VentilationTVA = a.Ventilation()
+	return nil
+}
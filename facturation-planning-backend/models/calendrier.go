@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// AbonnementCalendrier donne accès sans authentification au flux iCalendar des plannings d'une entreprise
+// ou d'un salarié. Seule l'empreinte SHA-256 du jeton est enregistrée : le jeton n'est communiqué qu'à sa création.
+type AbonnementCalendrier struct {
+	ID           uint      `json:"id" example:"1"`
+	CreatedAt    time.Time `json:"created_at" example:"2025-06-10T10:00:00Z"`
+	EntrepriseID uint      `json:"entreprise_id" gorm:"not null;index" example:"1"`
+	SalarieID    *uint     `json:"salarie_id,omitempty" gorm:"index" example:"2"` // nil : tous les plannings de l'entreprise
+	JetonHash    string    `json:"-" gorm:"not null;uniqueIndex"`
+}
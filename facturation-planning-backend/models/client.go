@@ -19,6 +19,7 @@ type Client struct {
 
 	// Champs pour clients professionnels
 	NomOrganisme *string `json:"nom_organisme,omitempty" example:"ACME Corporation"`
+	NumeroTVA    string  `json:"numero_tva,omitempty" example:"DE123456789"` // Obligatoire pour l'autoliquidation intracommunautaire
 
 	// Champs communs
 	Adresse           string `json:"adresse" example:"123 rue de la Paix"`
@@ -1,11 +1,35 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Cycle de vie d'un devis : brouillon → envoyé → accepté / refusé / expiré.
+// Un devis envoyé, refusé ou expiré qui est modifié redevient brouillon sur une nouvelle révision ; un devis accepté est définitif.
+const (
+	StatutDevisBrouillon = "brouillon"
+	StatutDevisEnvoye    = "envoyé"
+	StatutDevisAccepte   = "accepté"
+	StatutDevisRefuse    = "refusé"
+	StatutDevisExpire    = "expiré"
+)
+
+// transitionsDevis associe à chaque statut de devis les statuts qui peuvent lui succéder
+var transitionsDevis = map[string][]string{
+	StatutDevisBrouillon: {StatutDevisEnvoye},
+	StatutDevisEnvoye:    {StatutDevisAccepte, StatutDevisRefuse, StatutDevisExpire, StatutDevisBrouillon},
+	StatutDevisRefuse:    {StatutDevisBrouillon},
+	StatutDevisExpire:    {StatutDevisBrouillon},
+}
+
+// TransitionDevisAutorisee indique si un devis peut passer du statut de au statut vers
+func TransitionDevisAutorisee(de, vers string) bool {
+	return contientStatut(transitionsDevis[de], vers)
+}
+
 // Devis représente un devis complet
 type Devis struct {
 	// Champs GORM
@@ -14,25 +38,74 @@ type Devis struct {
 	UpdatedAt time.Time       `json:"updated_at" example:"2025-06-10T10:00:00Z"`
 	DeletedAt *gorm.DeletedAt `json:"deleted_at,omitempty" swaggerignore:"true" gorm:"index"`
 
-	EntrepriseID   uint         `json:"entreprise_id"`
+	EntrepriseID   uint         `json:"entreprise_id" gorm:"index:idx_devis_entreprise_reference,unique,priority:1"`
+	Reference      string       `json:"reference" example:"DEV0012" gorm:"not null;default:'';index:idx_devis_entreprise_reference,unique,where:reference <> ''"`
 	ClientID       uint         `json:"client_id"`
 	DateDevis      time.Time    `json:"date_devis" example:"2025-06-10T00:00:00Z"`
 	DateExpiration time.Time    `json:"date_expiration" example:"2025-07-10T00:00:00Z"`
 	Conditions     string       `json:"conditions" example:"Paiement sous 30 jours"`
 	Objet          string       `json:"objet" example:"Développement application web"`
-	Statut         string       `json:"statut" example:"brouillon" gorm:"default:brouillon"` // brouillon, envoyé, accepté, refusé
+	Statut         string       `json:"statut" example:"brouillon" gorm:"default:brouillon"` // brouillon, envoyé, accepté, refusé, expiré
 	LieuSignature  string       `json:"lieu_signature" example:"Paris"`
 	DateSignature  string       `json:"date_signature" example:"10/06/2025"`
 	Lignes         []LigneDevis `json:"lignes"`
 
+	// Remise globale, en pourcentage ou en montant HT, appliquée au total HT des lignes
+	RemisePourcentage float64 `json:"remise_pourcentage,omitempty" gorm:"not null;default:0" example:"5"`
+	RemiseMontant     Montant `json:"remise_montant,omitempty" gorm:"not null;default:0" example:"0"`
+
+	// Exonération de TVA ("franchise", "autoliquidation" ou "intracommunautaire") : toutes les lignes sont à 0 %
+	MotifExonerationTVA string `json:"motif_exoneration_tva,omitempty" example:"franchise"`
+
+	// Révisions : le devis porte le contenu de sa dernière révision, les précédentes sont figées dans RevisionDevis
+	Revision         int `json:"revision" example:"2" gorm:"not null;default:1"`
+	RevisionAcceptee int `json:"revision_acceptee,omitempty" example:"2"` // Numéro de la révision acceptée par le client (0 si aucune)
+
+	// Empreinte SHA-256 du jeton du lien d'acceptation en ligne (vide si aucun lien actif)
+	JetonAcceptationHash string `json:"-" gorm:"index"`
+
 	// Relations
 	Entreprise Entreprise `json:"entreprise" gorm:"foreignKey:EntrepriseID"`
 	Client     Client     `json:"client" gorm:"foreignKey:ClientID"`
 
+	// Totaux calculés par le serveur à partir des lignes et enregistrés avec le devis
+	SousTotalHT Montant `json:"sous_total_ht" gorm:"not null;default:0"`
+	TotalTVA    Montant `json:"total_tva" gorm:"not null;default:0"`
+	TotalTTC    Montant `json:"total_ttc" gorm:"not null;default:0"`
+
 	// Champs calculés (ne pas stocker en base)
-	SousTotalHT float64 `json:"sous_total_ht" gorm:"-"`
-	TotalTVA    float64 `json:"total_tva" gorm:"-"`
-	TotalTTC    float64 `json:"total_ttc" gorm:"-"`
+	VentilationTVA []VentilationTVA `json:"ventilation_tva,omitempty" gorm:"-"`
+	MentionTVA     string           `json:"mention_tva,omitempty" gorm:"-" example:"TVA non applicable, art. 293 B du CGI"`
+}
+
+// Ventilation retourne le récapitulatif de TVA par taux des lignes du devis, remise globale déduite
+func (d *Devis) Ventilation() []VentilationTVA {
+	bases := BasesTVA{}
+	for _, ligne := range d.Lignes {
+		bases.Ajouter(ligne.TVA, ligne.MontantHT())
+	}
+	return bases.VentilationRemisee(d.RemiseGlobale())
+}
+
+// TotalLignesHT retourne la somme des montants HT des lignes, avant remise globale
+func (d *Devis) TotalLignesHT() Montant {
+	var total Montant
+	for _, ligne := range d.Lignes {
+		total += ligne.MontantHT()
+	}
+	return total
+}
+
+// RemiseGlobale retourne le montant HT de la remise globale du devis
+func (d *Devis) RemiseGlobale() Montant {
+	return MontantRemise(d.TotalLignesHT(), d.RemisePourcentage, d.RemiseMontant)
+}
+
+// CalculerTotaux calcule les totaux du devis à partir de ses lignes (TVA par taux) ainsi que sa mention d'exonération
+func (d *Devis) CalculerTotaux() {
+	d.VentilationTVA = d.Ventilation()
+	d.SousTotalHT, d.TotalTVA, d.TotalTTC = TotauxVentilation(d.VentilationTVA)
+	d.MentionTVA = MentionExonerationTVA(d.MotifExonerationTVA)
 }
 
 // LigneDevis représente une ligne de produit ou service dans un devis
@@ -45,7 +118,130 @@ type LigneDevis struct {
 
 	DevisID      uint    `json:"-"`
 	Description  string  `json:"description" example:"Développement site web"`
-	Quantite     int     `json:"quantite" example:"1"`
-	PrixUnitaire float64 `json:"prix_unitaire" example:"1500"`
+	Quantite     float64 `json:"quantite" example:"1.5"`
+	Unite        string  `json:"unite" gorm:"not null;default:'U'" example:"h"` // h, jour, forfait, m² ou U
+	PrixUnitaire Montant `json:"prix_unitaire" example:"1500"`
 	TVA          float64 `json:"tva" example:"20"`
+
+	// Remise de la ligne, en pourcentage ou en montant HT
+	RemisePourcentage float64 `json:"remise_pourcentage,omitempty" gorm:"not null;default:0" example:"10"`
+	RemiseMontant     Montant `json:"remise_montant,omitempty" gorm:"not null;default:0" example:"0"`
+}
+
+// MontantHT retourne le montant HT de la ligne (quantité × prix unitaire arrondi au centime, remise déduite)
+func (l LigneDevis) MontantHT() Montant {
+	return MontantHTRemise(l.PrixUnitaire, l.Quantite, l.RemisePourcentage, l.RemiseMontant)
+}
+
+// RevisionDevis est l'état figé d'un devis tel qu'il a été proposé au client (DEV0012-v1, DEV0012-v2…).
+// Une révision envoyée, acceptée ou refusée n'est plus modifiée : toute modification du devis crée la révision suivante.
+type RevisionDevis struct {
+	ID        uint      `json:"id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-06-10T10:00:00Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2025-06-10T10:00:00Z"`
+
+	DevisID        uint                 `json:"devis_id" example:"12" gorm:"not null;uniqueIndex:idx_revision_devis_numero,priority:1"`
+	EntrepriseID   uint                 `json:"entreprise_id" example:"5" gorm:"index"`
+	Numero         int                  `json:"numero" example:"2" gorm:"not null;uniqueIndex:idx_revision_devis_numero,priority:2"`
+	Reference      string               `json:"reference" example:"DEV0012-v2"`
+	ClientID       uint                 `json:"client_id" example:"3"`
+	DateDevis      time.Time            `json:"date_devis" example:"2025-06-10T00:00:00Z"`
+	DateExpiration time.Time            `json:"date_expiration" example:"2025-07-10T00:00:00Z"`
+	Objet          string               `json:"objet" example:"Développement application web"`
+	Conditions     string               `json:"conditions" example:"Paiement sous 30 jours"`
+	Lignes         []LigneRevisionDevis `json:"lignes" gorm:"foreignKey:RevisionDevisID"`
+
+	MotifExonerationTVA string `json:"motif_exoneration_tva,omitempty" example:"franchise"`
+
+	RemisePourcentage float64 `json:"remise_pourcentage,omitempty" gorm:"not null;default:0" example:"5"`
+	RemiseMontant     Montant `json:"remise_montant,omitempty" gorm:"not null;default:0" example:"0"`
+
+	SousTotalHT Montant `json:"sous_total_ht" example:"1500"`
+	TotalTVA    Montant `json:"total_tva" example:"300"`
+	TotalTTC    Montant `json:"total_ttc" example:"1800"`
+
+	EnvoyeeLe  *time.Time `json:"envoyee_le,omitempty" example:"2025-06-11T09:00:00Z"`  // Proposée au client : la révision est figée
+	AccepteeLe *time.Time `json:"acceptee_le,omitempty" example:"2025-06-15T14:30:00Z"` // Acceptée par le client
+}
+
+// LigneRevisionDevis est une ligne figée d'une révision de devis
+type LigneRevisionDevis struct {
+	ID              uint    `json:"id" example:"1"`
+	RevisionDevisID uint    `json:"-" gorm:"index"`
+	Position        int     `json:"position" example:"1"`
+	Description     string  `json:"description" example:"Développement site web"`
+	Quantite        float64 `json:"quantite" example:"1.5"`
+	Unite           string  `json:"unite" gorm:"not null;default:'U'" example:"h"`
+	PrixUnitaire    Montant `json:"prix_unitaire" example:"1500"`
+	TVA             float64 `json:"tva" example:"20"`
+	MontantHT       Montant `json:"montant_ht" example:"1500"` // Remise de la ligne déduite
+
+	RemisePourcentage float64 `json:"remise_pourcentage,omitempty" gorm:"not null;default:0" example:"10"`
+	RemiseMontant     Montant `json:"remise_montant,omitempty" gorm:"not null;default:0" example:"0"`
+}
+
+// ReferenceRevision retourne la référence d'une révision (DEV0012-v2) à partir de la référence du devis
+func ReferenceRevision(reference string, numero int) string {
+	return fmt.Sprintf("%s-v%d", reference, numero)
+}
+
+// NouvelleRevision fige le contenu actuel du devis (lignes comprises) dans une révision numérotée
+func (d *Devis) NouvelleRevision(numero int, reference string) RevisionDevis {
+	revision := RevisionDevis{
+		DevisID:        d.ID,
+		EntrepriseID:   d.EntrepriseID,
+		Numero:         numero,
+		Reference:      ReferenceRevision(reference, numero),
+		ClientID:       d.ClientID,
+		DateDevis:      d.DateDevis,
+		DateExpiration: d.DateExpiration,
+		Objet:          d.Objet,
+		Conditions:     d.Conditions,
+		Lignes:         make([]LigneRevisionDevis, 0, len(d.Lignes)),
+
+		MotifExonerationTVA: d.MotifExonerationTVA,
+		RemisePourcentage:   d.RemisePourcentage,
+		RemiseMontant:       d.RemiseMontant,
+	}
+
+	for i, ligne := range d.Lignes {
+		revision.Lignes = append(revision.Lignes, LigneRevisionDevis{
+			Position:     i + 1,
+			Description:  ligne.Description,
+			Quantite:     ligne.Quantite,
+			Unite:        ligne.Unite,
+			PrixUnitaire: ligne.PrixUnitaire,
+			TVA:          ligne.TVA,
+			MontantHT:    ligne.MontantHT(),
+
+			RemisePourcentage: ligne.RemisePourcentage,
+			RemiseMontant:     ligne.RemiseMontant,
+		})
+	}
+	revision.SousTotalHT, revision.TotalTVA, revision.TotalTTC = TotauxVentilation(revision.Ventilation())
+
+	return revision
+}
+
+// Ventilation retourne le récapitulatif de TVA par taux des lignes de la révision, remise globale déduite
+func (r *RevisionDevis) Ventilation() []VentilationTVA {
+	bases := BasesTVA{}
+	for _, ligne := range r.Lignes {
+		bases.Ajouter(ligne.TVA, ligne.MontantHT)
+	}
+	return bases.VentilationRemisee(r.RemiseGlobale())
+}
+
+// TotalLignesHT retourne la somme des montants HT des lignes de la révision, avant remise globale
+func (r *RevisionDevis) TotalLignesHT() Montant {
+	var total Montant
+	for _, ligne := range r.Lignes {
+		total += ligne.MontantHT
+	}
+	return total
+}
+
+// RemiseGlobale retourne le montant HT de la remise globale de la révision
+func (r *RevisionDevis) RemiseGlobale() Montant {
+	return MontantRemise(r.TotalLignesHT(), r.RemisePourcentage, r.RemiseMontant)
 }
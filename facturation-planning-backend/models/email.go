@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+)
+
+// Statuts d'un envoi d'email
+const (
+	StatutEnvoiEnvoye = "envoyé"
+	StatutEnvoiEchec  = "échec"
+)
+
+// ParametresEmail contient les paramètres d'envoi d'emails d'une entreprise :
+// serveur SMTP, expéditeur et modèles de sujet/corps des messages
+// @Description Paramètres d'envoi d'emails d'une entreprise
+type ParametresEmail struct {
+	ID        uint      `json:"id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-06-10T10:00:00Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2025-06-10T10:00:00Z"`
+
+	EntrepriseID uint `json:"entreprise_id" gorm:"not null;uniqueIndex"`
+
+	// Serveur SMTP (les valeurs vides reprennent la configuration SMTP_* du serveur)
+	SMTPHost        string `json:"smtp_host" example:"smtp.example.com"`
+	SMTPPort        int    `json:"smtp_port" example:"587"`
+	SMTPUser        string `json:"smtp_user" example:"factures@maboite.com"`
+	SMTPPassword    string `json:"smtp_password,omitempty" example:"motdepasse"` // Jamais renvoyé par l'API
+	SMTPChiffrement string `json:"smtp_chiffrement" example:"starttls"`          // "aucun", "starttls" ou "tls"
+
+	// Expéditeur
+	ExpediteurEmail string `json:"expediteur_email" example:"factures@maboite.com"`
+	ExpediteurNom   string `json:"expediteur_nom" example:"MaBoite - Facturation"`
+	CopieA          string `json:"copie_a" example:"compta@maboite.com"` // Copie systématique (optionnelle)
+
+	// Modèles (text/template : {{.Reference}}, {{.ClientNom}}, {{.Montant}}, {{.DateEcheance}}, {{.DateExpiration}}, {{.Entreprise}})
+	SujetFacture string `json:"sujet_facture" gorm:"type:text" example:"Facture {{.Reference}}"`
+	CorpsFacture string `json:"corps_facture" gorm:"type:text"`
+	SujetDevis   string `json:"sujet_devis" gorm:"type:text" example:"Devis {{.Reference}}"`
+	CorpsDevis   string `json:"corps_devis" gorm:"type:text"`
+}
+
+// EnvoiEmail trace chaque envoi (réussi ou non) d'un document par email
+// @Description Journal d'envoi d'un document par email
+type EnvoiEmail struct {
+	ID        uint      `json:"id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-06-10T10:00:00Z"`
+
+	EntrepriseID uint   `json:"entreprise_id" gorm:"not null;index"`
+	TypeDocument string `json:"type_document" gorm:"not null;index:idx_envois_email_document" example:"facture"` // "facture" ou "devis"
+	DocumentID   uint   `json:"document_id" gorm:"not null;index:idx_envois_email_document" example:"1"`
+	Reference    string `json:"reference" example:"FAC-2025-0001"`
+
+	Destinataires string `json:"destinataires" example:"client@example.com"`
+	Copies        string `json:"copies,omitempty" example:"compta@maboite.com"`
+	Sujet         string `json:"sujet" example:"Facture FAC-2025-0001"`
+	PieceJointe   string `json:"piece_jointe" example:"facture_FAC-2025-0001.pdf"`
+
+	Statut string `json:"statut" example:"envoyé"` // "envoyé" ou "échec"
+	Erreur string `json:"erreur,omitempty"`
+}
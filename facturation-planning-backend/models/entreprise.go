@@ -6,19 +6,44 @@ import (
 
 // Entreprise représente une entreprise avec un compte
 type Entreprise struct {
-	ID           uint       `json:"id" example:"1"`
-	CreatedAt    time.Time  `json:"created_at" example:"2025-03-17T14:09:30.706109+01:00"`
-	UpdatedAt    time.Time  `json:"updated_at" example:"2025-03-17T14:09:30.706109+01:00"`
-	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
-	Nom          string     `json:"nom" example:"MaBoite"`
-	Adresse      string     `json:"adresse" example:"123 rue du Code"`
-	Email        string     `json:"email" gorm:"unique" example:"contact@maboite.com"`
-	Telephone    string     `json:"telephone" example:"+33123456789"`
-	SIRET        string     `json:"siret" example:"12345678901234"`
-	TVA          string     `json:"tva" example:"FR12345678901"`
-	IBAN         string     `json:"iban" example:"FR7612345987650123456789014"`
-	BIC          string     `json:"bic" example:"BIC12345"`
-	Responsable  string     `json:"responsable" example:"Jean Dupont"`
+	ID          uint       `json:"id" example:"1"`
+	CreatedAt   time.Time  `json:"created_at" example:"2025-03-17T14:09:30.706109+01:00"`
+	UpdatedAt   time.Time  `json:"updated_at" example:"2025-03-17T14:09:30.706109+01:00"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Nom         string     `json:"nom" example:"MaBoite"`
+	Adresse     string     `json:"adresse" example:"123 rue du Code"`
+	Email       string     `json:"email" gorm:"unique" example:"contact@maboite.com"`
+	Telephone   string     `json:"telephone" example:"+33123456789"`
+	SIRET       string     `json:"siret" example:"12345678901234"`
+	TVA         string     `json:"tva" example:"FR12345678901"`
+	IBAN        string     `json:"iban" example:"FR7612345987650123456789014"`
+	BIC         string     `json:"bic" example:"BIC12345"`
+	Responsable string     `json:"responsable" example:"Jean Dupont"`
+
+	// Profil affiché sur les documents (devis, factures, avoirs, relances)
+	CodePostal      string `json:"code_postal" example:"75001"`
+	Ville           string `json:"ville" example:"Paris"`
+	SiteWeb         string `json:"site_web" example:"https://www.maboite.com"`
+	FormeJuridique  string `json:"forme_juridique" example:"SARL"`
+	Capital         string `json:"capital" example:"10 000 €"`
+	CodeAPE         string `json:"code_ape" example:"6201Z"`
+	RCS             string `json:"rcs" example:"RCS Paris 123 456 789"`
+	MentionsLegales string `json:"mentions_legales" gorm:"type:text" example:"Pénalités de retard : 3 fois le taux d'intérêt légal"`
+	Logo            []byte `json:"-"`                             // Image téléversée (PNG ou JPEG)
+	LogoType        string `json:"logo_type" example:"image/png"` // Vide si aucun logo
+
+	// Valeurs par défaut des devis (zéro ou vide : valeur par défaut du serveur)
+	ConditionsDevis    string   `json:"conditions_devis" gorm:"type:text" example:"Paiement à réception de facture."`
+	ValiditeDevisJours int      `json:"validite_devis_jours" example:"30"`
+	TauxTVADefaut      *float64 `json:"taux_tva_defaut" example:"20"`
+	PrefixeDevis       string   `json:"prefixe_devis" example:"DEV"`
+
+	// Exonération de TVA appliquée par défaut aux devis et factures sans TVA (vide : entreprise soumise à TVA)
+	MotifExonerationTVA string `json:"motif_exoneration_tva" example:"franchise"`
+
+	// Feuilles de temps des salariés (zéro : valeur par défaut du serveur)
+	SeuilHeuresSupplementaires float64 `json:"seuil_heures_supplementaires" example:"35"`
+	HeuresJournee              float64 `json:"heures_journee" example:"7"`
 
 	// Champs pour l'authentification
 	Password string `json:"-"` // Ne pas exposer le mot de passe
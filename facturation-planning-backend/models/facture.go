@@ -1,9 +1,47 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cycle de vie d'une facture : brouillon → émise → partiellement payée / payée / annulée
+const (
+	StatutFactureBrouillon          = "brouillon"
+	StatutFactureEmise              = "émise"
+	StatutFacturePartiellementPayee = "partiellement payée"
+	StatutFacturePayee              = "payée"
+	StatutFactureAnnulee            = "annulée"
+)
+
+// Types de facture ; les factures d'acompte, de situation et de solde sont rattachées à un devis accepté
+const (
+	TypeFactureClassique = "classique"
+	TypeFactureAcompte   = "acompte"
+	TypeFactureSituation = "avancement" // Facture de situation : avancement des lignes du devis
+	TypeFactureSolde     = "solde"      // Dernière facture du devis, déduction faite des acomptes
 )
 
+// transitionsFacture associe à chaque statut de facture les statuts qui peuvent lui succéder.
+// Le statut d'une facture émise suit ses paiements (qui peuvent être annulés) et ses avoirs ; une facture annulée est définitive.
+var transitionsFacture = map[string][]string{
+	StatutFactureBrouillon:          {StatutFactureEmise},
+	StatutFactureEmise:              {StatutFacturePartiellementPayee, StatutFacturePayee, StatutFactureAnnulee},
+	StatutFacturePartiellementPayee: {StatutFactureEmise, StatutFacturePayee, StatutFactureAnnulee},
+	StatutFacturePayee:              {StatutFactureEmise, StatutFacturePartiellementPayee, StatutFactureAnnulee},
+}
+
+// TransitionFactureAutorisee indique si une facture peut passer du statut de au statut vers
+func TransitionFactureAutorisee(de, vers string) bool {
+	return contientStatut(transitionsFacture[de], vers)
+}
+
 // LigneFacture représente une ligne dans une facture
 type LigneFacture struct {
 	ID           uint       `json:"id" gorm:"primaryKey"`
@@ -12,13 +50,23 @@ type LigneFacture struct {
 	DeletedAt    *time.Time `json:"deletedAt,omitempty" gorm:"index"`
 	FactureID    uint       `json:"factureID"`
 	Description  string     `json:"description" example:"Développement site web"`
-	Unite        string     `json:"unite" example:"jour"`
+	Unite        string     `json:"unite" example:"jour"` // h, jour, forfait, m² ou U
 	Quantite     float64    `json:"quantite" example:"10"`
-	PrixUnitaire float64    `json:"prixUnitaire" example:"500.00"`
-	TotalLigne   float64    `json:"totalLigne" example:"5000.00"`
+	PrixUnitaire Montant    `json:"prixUnitaire" example:"500.00"`
+	TotalLigne   Montant    `json:"totalLigne" example:"5000.00"`
 	TauxTVA      float64    `json:"tauxTVA" example:"20"`
-	MontantHT    float64    `json:"montantHT" example:"5000.00"`
-	MontantTTC   float64    `json:"montantTTC" example:"6000.00"`
+	MontantHT    Montant    `json:"montantHT" example:"5000.00"` // Remise de la ligne déduite
+	MontantTTC   Montant    `json:"montantTTC" example:"6000.00"`
+
+	// Remise de la ligne, en pourcentage ou en montant HT
+	RemisePourcentage float64 `json:"remisePourcentage,omitempty" gorm:"not null;default:0" example:"10"`
+	RemiseMontant     Montant `json:"remiseMontant,omitempty" gorm:"not null;default:0" example:"0.00"`
+
+	// Facturation d'un devis : ligne du devis facturée et avancement cumulé atteint (100 pour une facture de solde),
+	// ou facture d'acompte dont la ligne est la déduction
+	PositionDevis    int     `json:"positionDevis,omitempty" example:"1"`
+	AvancementCumule float64 `json:"avancementCumule,omitempty" example:"60"`
+	FactureAcompteID *uint   `json:"factureAcompteID,omitempty" gorm:"index" example:"4"`
 }
 
 // Facture représente une facture générée
@@ -29,8 +77,8 @@ type Facture struct {
 	UpdatedAt time.Time  `json:"updated_at" example:"2025-03-17T14:09:30.706109+01:00"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index"`
 
-	// Référence unique de la facture
-	Reference string `json:"reference" gorm:"unique;not null" example:"FAC-2025-001"`
+	// Référence unique de la facture, attribuée uniquement à l'émission (vide pour un brouillon)
+	Reference string `json:"reference" gorm:"not null;default:'';index:idx_factures_entreprise_reference,unique,where:reference <> ''" example:"FAC-2025-001"`
 
 	// Informations client
 	ClientID        uint   `json:"clientID" gorm:"not null" example:"1"`
@@ -47,30 +95,216 @@ type Facture struct {
 
 	// Contenu
 	Description string `json:"description" example:"Développement site web"`
-	TypeFacture string `json:"typeFacture" example:"classique"` // "classique" ou "acompte"
+	TypeFacture string `json:"typeFacture" example:"classique"` // "classique", "acompte", "avancement" (situation) ou "solde"
 
 	// Montants
-	SousTotalHT float64 `json:"sousTotalHT" example:"1000.00"`
-	TotalTVA    float64 `json:"totalTVA" example:"200.00"`
-	TotalTTC    float64 `json:"totalTTC" example:"1200.00"`
+	SousTotalHT Montant `json:"sousTotalHT" example:"1000.00"`
+	TotalTVA    Montant `json:"totalTVA" example:"200.00"`
+	TotalTTC    Montant `json:"totalTTC" example:"1200.00"`
 	TauxTVA     float64 `json:"tauxTVA" example:"20.0"`
-	MontantTVA  float64 `json:"montantTVA" example:"200.00"`
+	MontantTVA  Montant `json:"montantTVA" example:"200.00"`
+
+	// Remise globale, en pourcentage ou en montant HT, appliquée au total HT des lignes ; le sous-total HT est net de remise
+	RemisePourcentage float64 `json:"remisePourcentage,omitempty" gorm:"not null;default:0" example:"5"`
+	RemiseMontant     Montant `json:"remiseMontant,omitempty" gorm:"not null;default:0" example:"0.00"`
+
+	// Exonération de TVA ("franchise", "autoliquidation" ou "intracommunautaire") : toutes les lignes sont à 0 %
+	// et la mention légale correspondante est reproduite sur la facture
+	MotifExonerationTVA string `json:"motifExonerationTVA,omitempty" example:"franchise"`
+
+	// Champs calculés (ne pas stocker en base) : récapitulatif par taux et mention d'exonération
+	VentilationTVA []VentilationTVA `json:"ventilationTVA,omitempty" gorm:"-"`
+	MentionTVA     string           `json:"mentionTVA,omitempty" gorm:"-" example:"TVA non applicable, art. 293 B du CGI"`
 
 	// Statut et workflow
-	Statut string `json:"statut" example:"en_attente"` // "en_attente", "payee", "rejetee"
+	Statut string `json:"statut" gorm:"default:brouillon" example:"brouillon"` // "brouillon", "émise", "partiellement payée", "payée", "annulée"
+
+	// Scellement à l'émission : empreinte SHA-256 du contenu pour détecter toute altération
+	HashContenu string     `json:"hashContenu,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	EmiseLe     *time.Time `json:"emiseLe,omitempty" example:"2025-03-17T14:09:30Z"`
+
+	// Solde après déduction des avoirs et des paiements
+	TotalAvoirs Montant `json:"totalAvoirs" gorm:"not null;default:0" example:"0.00"`
+	TotalPaye   Montant `json:"totalPaye" gorm:"not null;default:0" example:"600.00"`
+	ResteAPayer Montant `json:"resteAPayer" gorm:"not null;default:0" example:"600.00"`
 
 	// Informations de signature
 	LieuSignature string `json:"lieuSignature" example:"Paris"`
 	DateSignature string `json:"dateSignature" example:"17/03/2025"`
 
-	// Champs optionnels pour les acomptes
-	DevisReference     string `json:"devisReference,omitempty" example:"DEV-2025-001"`
-	PourcentageAcompte int    `json:"pourcentageAcompte,omitempty" example:"50"`
+	// Devis facturé (révision acceptée) et pourcentage du devis pour une facture d'acompte
+	DevisID            *uint   `json:"devisID,omitempty" gorm:"index" example:"12"`
+	DevisReference     string  `json:"devisReference,omitempty" example:"DEV0012-v2"`
+	PourcentageAcompte float64 `json:"pourcentageAcompte,omitempty" example:"30"`
 
 	// Legacy fields (pour compatibilité)
-	EntrepriseID uint  `json:"entrepriseID,omitempty" example:"5"`
+	EntrepriseID uint  `json:"entrepriseID,omitempty" gorm:"index:idx_factures_entreprise_reference,unique,priority:1" example:"5"`
 	PlanningID   *uint `json:"planningID,omitempty" example:"3"`
 
 	// Relations
-	Lignes []LigneFacture `json:"lignes" gorm:"foreignKey:FactureID"`
+	Lignes    []LigneFacture `json:"lignes" gorm:"foreignKey:FactureID"`
+	Avoirs    []Avoir        `json:"avoirs,omitempty" gorm:"foreignKey:FactureID"`
+	Paiements []Paiement     `json:"paiements,omitempty" gorm:"foreignKey:FactureID"`
+}
+
+// EstScellee indique si la facture a été émise et ne peut donc plus être modifiée
+func (f *Facture) EstScellee() bool {
+	return f.Statut != "" && f.Statut != StatutFactureBrouillon
+}
+
+// MontantHTLigne retourne le montant HT d'une ligne, reconstitué pour les anciennes lignes qui ne le portent pas
+func (l LigneFacture) MontantHTLigne() Montant {
+	if l.MontantHT != 0 {
+		return l.MontantHT
+	}
+	if l.TotalLigne != 0 {
+		return l.TotalLigne
+	}
+	return l.MontantHTCalcule()
+}
+
+// MontantHTCalcule retourne le montant HT calculé de la ligne : quantité × prix unitaire arrondi au centime, remise déduite
+func (l LigneFacture) MontantHTCalcule() Montant {
+	return MontantHTRemise(l.PrixUnitaire, l.Quantite, l.RemisePourcentage, l.RemiseMontant)
+}
+
+// Ventilation retourne le récapitulatif de TVA par taux des lignes, remise globale déduite. Sans lignes (ancienne facture, ou lignes
+// non chargées), la facture n'est ventilée que si son taux unique explique sa TVA.
+func (f *Facture) Ventilation() []VentilationTVA {
+	bases := BasesTVA{}
+	for _, ligne := range f.Lignes {
+		bases.Ajouter(ligne.TauxTVA, ligne.MontantHTLigne())
+	}
+	if len(f.Lignes) == 0 && f.SousTotalHT != 0 && f.SousTotalHT.Pourcentage(f.TauxTVA) == f.TotalTVA {
+		bases.Ajouter(f.TauxTVA, f.SousTotalHT)
+		return bases.Ventilation()
+	}
+	return bases.VentilationRemisee(f.RemiseGlobale())
+}
+
+// TotalLignesHT retourne la somme des montants HT des lignes, avant remise globale
+func (f *Facture) TotalLignesHT() Montant {
+	var total Montant
+	for _, ligne := range f.Lignes {
+		total += ligne.MontantHTLigne()
+	}
+	return total
+}
+
+// RemiseGlobale retourne le montant HT de la remise globale de la facture
+func (f *Facture) RemiseGlobale() Montant {
+	return MontantRemise(f.TotalLignesHT(), f.RemisePourcentage, f.RemiseMontant)
+}
+
+// CompleterTVA renseigne les champs calculés de TVA (ventilation et mention d'exonération)
+func (f *Facture) CompleterTVA() {
+	f.VentilationTVA = f.Ventilation()
+	f.MentionTVA = MentionExonerationTVA(f.MotifExonerationTVA)
+}
+
+// AfterFind complète les champs calculés de TVA à chaque lecture (lignes préchargées comprises)
+func (f *Facture) AfterFind(tx *gorm.DB) error {
+	f.CompleterTVA()
+	return nil
+}
+
+// CalculerHashContenu calcule l'empreinte SHA-256 du contenu légal de la facture
+// (référence, client, dates, lignes et montants)
+func (f *Facture) CalculerHashContenu() string {
+	type ligneScellee struct {
+		Description  string `json:"description"`
+		Unite        string `json:"unite"`
+		Quantite     string `json:"quantite"`
+		PrixUnitaire string `json:"prixUnitaire"`
+		TauxTVA      string `json:"tauxTVA"`
+		MontantHT    string `json:"montantHT"`
+		MontantTTC   string `json:"montantTTC"`
+
+		// Absentes des lignes sans remise, pour que l'empreinte des factures antérieures reste inchangée
+		RemisePourcentage string `json:"remisePourcentage,omitempty"`
+		RemiseMontant     string `json:"remiseMontant,omitempty"`
+	}
+
+	formatDate := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+	}
+	formatTaux := func(v float64) string {
+		return fmt.Sprintf("%.2f", v)
+	}
+	formatRemise := func(pourcentage float64, montant Montant) (string, string) {
+		var p, m string
+		if pourcentage != 0 {
+			p = formatTaux(pourcentage)
+		}
+		if montant != 0 {
+			m = montant.String()
+		}
+		return p, m
+	}
+
+	contenu := struct {
+		Reference       string         `json:"reference"`
+		EntrepriseID    uint           `json:"entrepriseID"`
+		ClientID        uint           `json:"clientID"`
+		ClientNom       string         `json:"clientNom"`
+		ClientAdresse   string         `json:"clientAdresse"`
+		ClientEmail     string         `json:"clientEmail"`
+		ClientTelephone string         `json:"clientTelephone"`
+		DateEmission    string         `json:"dateEmission"`
+		DateEcheance    string         `json:"dateEcheance"`
+		Description     string         `json:"description"`
+		TypeFacture     string         `json:"typeFacture"`
+		SousTotalHT     string         `json:"sousTotalHT"`
+		TotalTVA        string         `json:"totalTVA"`
+		TotalTTC        string         `json:"totalTTC"`
+		TauxTVA         string         `json:"tauxTVA"`
+		Exoneration     string         `json:"motifExonerationTVA,omitempty"`
+		RemisePct       string         `json:"remisePourcentage,omitempty"`
+		RemiseMontant   string         `json:"remiseMontant,omitempty"`
+		Lignes          []ligneScellee `json:"lignes"`
+	}{
+		Reference:       f.Reference,
+		EntrepriseID:    f.EntrepriseID,
+		ClientID:        f.ClientID,
+		ClientNom:       f.ClientNom,
+		ClientAdresse:   f.ClientAdresse,
+		ClientEmail:     f.ClientEmail,
+		ClientTelephone: f.ClientTelephone,
+		DateEmission:    formatDate(f.DateEmission),
+		DateEcheance:    formatDate(f.DateEcheance),
+		Description:     f.Description,
+		TypeFacture:     f.TypeFacture,
+		SousTotalHT:     f.SousTotalHT.String(),
+		TotalTVA:        f.TotalTVA.String(),
+		TotalTTC:        f.TotalTTC.String(),
+		TauxTVA:         formatTaux(f.TauxTVA),
+		Exoneration:     f.MotifExonerationTVA,
+		Lignes:          []ligneScellee{},
+	}
+	contenu.RemisePct, contenu.RemiseMontant = formatRemise(f.RemisePourcentage, f.RemiseMontant)
+
+	// Les lignes sont triées par ID pour que l'empreinte ne dépende pas de l'ordre de chargement
+	lignes := append([]LigneFacture(nil), f.Lignes...)
+	sort.Slice(lignes, func(i, j int) bool { return lignes[i].ID < lignes[j].ID })
+
+	for _, l := range lignes {
+		ligne := ligneScellee{
+			Description:  l.Description,
+			Unite:        l.Unite,
+			Quantite:     fmt.Sprintf("%g", l.Quantite),
+			PrixUnitaire: l.PrixUnitaire.String(),
+			TauxTVA:      formatTaux(l.TauxTVA),
+			MontantHT:    l.MontantHT.String(),
+			MontantTTC:   l.MontantTTC.String(),
+		}
+		ligne.RemisePourcentage, ligne.RemiseMontant = formatRemise(l.RemisePourcentage, l.RemiseMontant)
+		contenu.Lignes = append(contenu.Lignes, ligne)
+	}
+
+	data, _ := json.Marshal(contenu)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+)
+
+// LigneFactureFournisseur représente une ligne d'une facture reçue d'un fournisseur
+type LigneFactureFournisseur struct {
+	ID                   uint    `json:"id" gorm:"primaryKey"`
+	FactureFournisseurID uint    `json:"factureFournisseurID" gorm:"not null;index"`
+	Description          string  `json:"description" example:"Fournitures de bureau"`
+	Unite                string  `json:"unite" example:"C62"` // Code UN/ECE recommandation 20
+	Quantite             float64 `json:"quantite" example:"10"`
+	PrixUnitaire         float64 `json:"prixUnitaire" example:"12.50"` // Tel que déclaré (EN 16931 admet plus de deux décimales)
+	MontantHT            Montant `json:"montantHT" example:"125.00"`
+	TauxTVA              float64 `json:"tauxTVA" example:"20"`
+	CategorieTVA         string  `json:"categorieTVA" example:"S"` // Catégorie UNTDID 5305 (S, E, Z, AE...)
+	MotifExoneration     string  `json:"motifExoneration,omitempty"`
+}
+
+// TVAFactureFournisseur représente la ventilation de TVA déclarée par le fournisseur pour un taux
+type TVAFactureFournisseur struct {
+	ID                   uint    `json:"id" gorm:"primaryKey"`
+	FactureFournisseurID uint    `json:"factureFournisseurID" gorm:"not null;index"`
+	CategorieTVA         string  `json:"categorieTVA" example:"S"`
+	TauxTVA              float64 `json:"tauxTVA" example:"20"`
+	BaseHT               Montant `json:"baseHT" example:"125.00"`
+	MontantTVA           Montant `json:"montantTVA" example:"25.00"`
+	MotifExoneration     string  `json:"motifExoneration,omitempty"`
+}
+
+// FactureFournisseur représente une facture (ou un avoir) reçue d'un fournisseur et importée
+// depuis un XML UBL 2.1 ou CII. Les montants sont ceux déclarés par le fournisseur.
+// @Description Facture reçue d'un fournisseur
+type FactureFournisseur struct {
+	ID        uint      `json:"id" gorm:"primaryKey" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-03-17T14:09:30.706109+01:00"`
+	UpdatedAt time.Time `json:"updated_at" example:"2025-03-17T14:09:30.706109+01:00"`
+
+	EntrepriseID uint `json:"entrepriseID" gorm:"not null;uniqueIndex:idx_factures_fournisseurs_cle" example:"5"`
+
+	// Numéro attribué par le fournisseur
+	Reference    string `json:"reference" gorm:"not null;uniqueIndex:idx_factures_fournisseurs_cle" example:"F2025-0458"`
+	TypeDocument string `json:"typeDocument" gorm:"uniqueIndex:idx_factures_fournisseurs_cle" example:"380"` // Code UNTDID 1001 : 380 facture, 381 avoir, 386 acompte
+	Format       string `json:"format" example:"ubl"`                                                        // "ubl" ou "cii"
+
+	// Fournisseur
+	FournisseurNom     string `json:"fournisseurNom" gorm:"not null;uniqueIndex:idx_factures_fournisseurs_cle" example:"Papeterie Martin"`
+	FournisseurSIRET   string `json:"fournisseurSIRET,omitempty" example:"12345678900012"`
+	FournisseurTVA     string `json:"fournisseurTVA,omitempty" example:"FR12123456789"`
+	FournisseurAdresse string `json:"fournisseurAdresse,omitempty" example:"4 rue des Lilas, 44000 Nantes"`
+	FournisseurIBAN    string `json:"fournisseurIBAN,omitempty" example:"FR7612345987650123456789014"`
+
+	DateEmission   time.Time  `json:"dateEmission" example:"2025-03-17T00:00:00Z"`
+	DateEcheance   *time.Time `json:"dateEcheance,omitempty" example:"2025-04-17T00:00:00Z"`
+	Devise         string     `json:"devise" example:"EUR"`
+	Note           string     `json:"note,omitempty"`
+	FactureOrigine string     `json:"factureOrigine,omitempty" example:"F2025-0401"` // Facture corrigée par un avoir
+
+	// Montants déclarés
+	SousTotalHT    Montant `json:"sousTotalHT" example:"125.00"`
+	TotalTVA       Montant `json:"totalTVA" example:"25.00"`
+	TotalTTC       Montant `json:"totalTTC" example:"150.00"`
+	MontantPrepaye Montant `json:"montantPrepaye" example:"0.00"`
+	NetAPayer      Montant `json:"netAPayer" example:"150.00"`
+
+	// Document d'origine conservé tel que reçu
+	XMLSource string `json:"-" gorm:"type:text"`
+
+	Lignes []LigneFactureFournisseur `json:"lignes" gorm:"foreignKey:FactureFournisseurID"`
+	TVA    []TVAFactureFournisseur   `json:"tva" gorm:"foreignKey:FactureFournisseurID"`
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Montant est un montant en euros, stocké en centimes entiers.
+//
+// Les additions et soustractions de montants sont exactes (0,10 € + 0,20 € = 0,30 €, là où
+// 0.1 + 0.2 vaut 0.30000000000000004 en flottant) et les comparaisons se font au centime près.
+// Les multiplications par une quantité, un taux ou un pourcentage sont calculées en décimal exact
+// puis arrondies au centime, au plus proche, les demi-centimes étant arrondis à l'écart de zéro
+// (2,345 € → 2,35 € ; -2,345 € → -2,35 €).
+//
+// Règles d'arrondi des documents :
+//   - le montant HT d'une ligne est arrondi au centime (quantité × prix unitaire) ;
+//   - la TVA est calculée et arrondie sur la base HT de chaque taux, et non ligne par ligne
+//     (3 lignes de 0,07 € HT à 20 % : base 0,21 €, TVA 0,04 €, et non 3 × 0,01 €) ;
+//   - les totaux HT, TVA et TTC sont des sommes exactes des montants arrondis.
+//
+// En JSON comme en base (numeric(14,2)), un montant est un nombre décimal à deux chiffres après la virgule.
+type Montant int64
+
+// montantMaximum est le plus grand montant représentable par la colonne numeric(14,2)
+const montantMaximum = Montant(99999999999999)
+
+// cent convertit les euros en centimes
+var cent = decimal.NewFromInt(100)
+
+// MontantCentimes retourne le montant correspondant à un nombre de centimes
+func MontantCentimes(centimes int64) Montant {
+	return Montant(centimes)
+}
+
+// MontantEuros convertit un montant flottant en euros, arrondi au centime
+// (paramètres de configuration, anciennes valeurs stockées en flottant)
+func MontantEuros(euros float64) Montant {
+	return montantDecimal(decimal.NewFromFloat(euros).Mul(cent))
+}
+
+// ParseMontant lit un montant décimal (« 1234.5 », « -0.30 ») sans passer par un flottant ;
+// au-delà de deux décimales, il est arrondi au centime
+func ParseMontant(s string) (Montant, error) {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return 0, fmt.Errorf("montant invalide : %s", s)
+	}
+	if d.Abs().GreaterThan(montantMaximum.decimal()) {
+		return 0, fmt.Errorf("montant hors limites : %s", s)
+	}
+	return montantDecimal(d.Mul(cent)), nil
+}
+
+// montantDecimal arrondit un nombre décimal de centimes au centime le plus proche (demi-centime à l'écart de zéro)
+func montantDecimal(centimes decimal.Decimal) Montant {
+	return Montant(centimes.Round(0).IntPart())
+}
+
+// decimal retourne le montant en euros sous forme décimale exacte
+func (m Montant) decimal() decimal.Decimal {
+	return decimal.New(int64(m), -2)
+}
+
+// Centimes retourne le montant en centimes
+func (m Montant) Centimes() int64 {
+	return int64(m)
+}
+
+// Euros retourne le montant en euros sous forme flottante, pour l'affichage et les ratios
+// (jamais pour des calculs de montants)
+func (m Montant) Euros() float64 {
+	return m.decimal().InexactFloat64()
+}
+
+// String formate le montant avec deux décimales et un point (« 1234.50 »)
+func (m Montant) String() string {
+	return m.decimal().StringFixed(2)
+}
+
+// Abs retourne la valeur absolue du montant
+func (m Montant) Abs() Montant {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Multiplier retourne le montant multiplié par un facteur (quantité, avancement...), arrondi au centime
+func (m Montant) Multiplier(facteur float64) Montant {
+	return montantDecimal(decimal.NewFromInt(int64(m)).Mul(decimal.NewFromFloat(facteur)))
+}
+
+// Pourcentage retourne le pourcentage du montant (taux de TVA, acompte...), arrondi au centime :
+// 20 % de 0,30 € donnent 0,06 €, 5,5 % de 10,10 € donnent 0,56 € (0,5555 arrondi)
+func (m Montant) Pourcentage(taux float64) Montant {
+	return montantDecimal(decimal.NewFromInt(int64(m)).Mul(decimal.NewFromFloat(taux)).Div(cent))
+}
+
+// Proportion retourne la part du montant correspondant à partie / total, arrondie au centime
+// (montant nul si le total est nul)
+func (m Montant) Proportion(partie, total Montant) Montant {
+	if total == 0 {
+		return 0
+	}
+	return montantDecimal(decimal.NewFromInt(int64(m)).Mul(decimal.NewFromInt(int64(partie))).Div(decimal.NewFromInt(int64(total))))
+}
+
+// Ratio retourne le rapport du montant à un total (0 si le total est nul)
+func (m Montant) Ratio(total Montant) float64 {
+	if total == 0 {
+		return 0
+	}
+	return decimal.NewFromInt(int64(m)).Div(decimal.NewFromInt(int64(total))).InexactFloat64()
+}
+
+// MarshalJSON écrit le montant comme un nombre JSON à deux décimales
+func (m Montant) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON lit un nombre JSON (ou une chaîne numérique) sans passer par un flottant
+func (m *Montant) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	valeur, err := ParseMontant(string(bytes.Trim(data, `"`)))
+	if err != nil {
+		return err
+	}
+	*m = valeur
+	return nil
+}
+
+// Value enregistre le montant en base sous forme décimale exacte
+func (m Montant) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan lit un montant depuis une colonne numeric (ou une ancienne colonne flottante)
+func (m *Montant) Scan(valeur interface{}) error {
+	switch v := valeur.(type) {
+	case nil:
+		*m = 0
+	case []byte:
+		return m.scanChaine(string(v))
+	case string:
+		return m.scanChaine(v)
+	case float64:
+		*m = MontantEuros(v)
+	case int64:
+		*m = Montant(v * 100)
+	default:
+		return fmt.Errorf("montant : type %T non pris en charge", valeur)
+	}
+	return nil
+}
+
+func (m *Montant) scanChaine(s string) error {
+	valeur, err := ParseMontant(s)
+	if err != nil {
+		return err
+	}
+	*m = valeur
+	return nil
+}
+
+// GormDataType déclare le type des colonnes de montants. Les anciennes colonnes double precision
+// sont converties par AutoMigrate, les valeurs étant arrondies au centime.
+func (Montant) GormDataType() string {
+	return "numeric(14,2)"
+}
+
+// MontantOuZero retourne la valeur d'un montant optionnel, ou zéro
+func MontantOuZero(m *Montant) Montant {
+	if m == nil {
+		return 0
+	}
+	return *m
+}
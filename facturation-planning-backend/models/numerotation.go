@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// Types de documents numérotés
+const (
+	TypeDocumentFacture = "facture"
+	TypeDocumentAcompte = "acompte"
+	TypeDocumentAvoir   = "avoir"
+	TypeDocumentDevis   = "devis"
+)
+
+// PatternsNumerotationParDefaut associe à chaque type de document son format de numérotation par défaut.
+// Jetons disponibles : {YYYY} année sur 4 chiffres, {YY} année sur 2 chiffres, {MM} mois,
+// {N…} compteur complété de zéros sur autant de chiffres que de N (ex. {NNNN} → 0042).
+var PatternsNumerotationParDefaut = map[string]string{
+	TypeDocumentFacture: "FAC-{YYYY}-{NNNN}",
+	TypeDocumentAcompte: "AC-{YYYY}-{NNNN}",
+	TypeDocumentAvoir:   "AV-{YYYY}-{NNNN}",
+	TypeDocumentDevis:   "DEV{NNNN}",
+}
+
+// FormatNumerotation représente le format de numérotation choisi par une entreprise pour un type de document
+type FormatNumerotation struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	EntrepriseID uint      `json:"entreprise_id" gorm:"not null;uniqueIndex:idx_formats_numerotation_entreprise_type"`
+	TypeDocument string    `json:"type_document" example:"facture" gorm:"not null;uniqueIndex:idx_formats_numerotation_entreprise_type"`
+	Pattern      string    `json:"pattern" example:"FAC-{YYYY}-{NNNN}" gorm:"not null"`
+}
+
+// SequenceNumerotation conserve le dernier numéro attribué par entreprise, type de document et année.
+// L'année vaut 0 lorsque le format ne contient pas d'année (compteur continu).
+type SequenceNumerotation struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	EntrepriseID  uint      `json:"entreprise_id" gorm:"not null;uniqueIndex:idx_sequences_numerotation_cle"`
+	TypeDocument  string    `json:"type_document" gorm:"not null;uniqueIndex:idx_sequences_numerotation_cle"`
+	Annee         int       `json:"annee" gorm:"not null;uniqueIndex:idx_sequences_numerotation_cle"`
+	DernierNumero int       `json:"dernier_numero" gorm:"not null;default:0"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+)
+
+// Modes de paiement acceptés
+const (
+	ModePaiementVirement    = "virement"
+	ModePaiementCheque      = "chèque"
+	ModePaiementCB          = "CB"
+	ModePaiementEspeces     = "espèces"
+	ModePaiementPrelevement = "prélèvement"
+)
+
+// ModesPaiement retourne la liste des modes de paiement acceptés
+func ModesPaiement() []string {
+	return []string{
+		ModePaiementVirement,
+		ModePaiementCheque,
+		ModePaiementCB,
+		ModePaiementEspeces,
+		ModePaiementPrelevement,
+	}
+}
+
+// Paiement représente un règlement (total ou partiel) reçu pour une facture émise.
+// Un paiement n'est jamais supprimé : une erreur de saisie se corrige par une contre-passation.
+// @Description Structure d'un paiement
+type Paiement struct {
+	ID        uint      `json:"id" gorm:"primaryKey" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-03-20T10:00:00Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2025-03-20T10:00:00Z"`
+
+	EntrepriseID uint `json:"entrepriseID" gorm:"not null;index" example:"5"`
+	FactureID    uint `json:"factureID" gorm:"not null;index" example:"1"`
+
+	DatePaiement      time.Time `json:"datePaiement" example:"2025-03-20T00:00:00Z"`
+	Montant           Montant   `json:"montant" gorm:"not null" example:"600.00"`
+	Mode              string    `json:"mode" gorm:"not null" example:"virement"` // "virement", "chèque", "CB", "espèces", "prélèvement"
+	ReferenceBancaire string    `json:"referenceBancaire,omitempty" example:"VIR-20250320-0042"`
+	Commentaire       string    `json:"commentaire,omitempty" example:"Premier versement"`
+
+	// Contre-passation
+	Annule          bool       `json:"annule" gorm:"not null;default:false" example:"false"`
+	AnnuleLe        *time.Time `json:"annuleLe,omitempty" example:"2025-03-21T09:00:00Z"`
+	MotifAnnulation string     `json:"motifAnnulation,omitempty" example:"Chèque impayé"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -20,6 +21,34 @@ const (
 	TypeVisiteMedicale     = "Visite médicale"
 )
 
+// EstAbsence indique si un type d'événement rend le salarié indisponible toute la journée
+func EstAbsence(typeEvenement string) bool {
+	switch typeEvenement {
+	case TypeAbsence, TypeConge, TypeMaladie, TypeRTT:
+		return true
+	}
+	return false
+}
+
+// États de facturation d'un planning : seul un planning "À facturer" peut être facturé,
+// "Facturé" est attribué par la facturation et annulé par l'annulation ou l'avoir de la facture
+const (
+	FacturationAFacturer     = "À facturer"
+	FacturationFacture       = "Facturé"
+	FacturationNonFacturable = "Non facturable"
+	FacturationOffert        = "Offert"
+)
+
+// GetEtatsFacturation retourne la liste des états de facturation d'un planning
+func GetEtatsFacturation() []string {
+	return []string{
+		FacturationAFacturer,
+		FacturationFacture,
+		FacturationNonFacturable,
+		FacturationOffert,
+	}
+}
+
 // GetTypesEvenements retourne la liste des types d'événements disponibles
 func GetTypesEvenements() []string {
 	return []string{
@@ -57,12 +86,67 @@ type Planning struct {
 
 	Objet         *string  `json:"objet,omitempty" example:"Maintenance système"`
 	Prestation    *string  `json:"prestation,omitempty" example:"Support technique"`
-	Facturation   string   `json:"facturation" example:"À facturer"`
-	TauxHoraire   *float64 `json:"taux_horaire,omitempty" example:"45.50"`
-	ForfaitHT     *float64 `json:"forfait_ht,omitempty" example:"0"`
+	Facturation   string   `json:"facturation" gorm:"not null;default:'À facturer';index" example:"À facturer" enums:"À facturer,Facturé,Non facturable,Offert"`
+	TauxHoraire   *Montant `json:"taux_horaire,omitempty" example:"45.50"`
+	ForfaitHT     *Montant `json:"forfait_ht,omitempty" example:"0"`
 	EntrepriseID  uint     `json:"entreprise_id" example:"1"`
-	Periodicite   int      `json:"periodicite" example:"0"`
-	NbRepetitions int      `json:"nb_repetitions"` // combien de fois on le répète
+	Periodicite   int      `json:"periodicite" example:"0"` // intervalle en semaines entre deux répétitions
+	NbRepetitions int      `json:"nb_repetitions"`          // combien de fois on le répète (crée une SeriePlanning)
+
+	// Série récurrente dont ce planning est une occurrence enregistrée (modifiée ou facturée),
+	// et date d'origine de l'occurrence dans la série (RECURRENCE-ID)
+	SerieID        *uint  `json:"serie_id,omitempty" gorm:"uniqueIndex:idx_plannings_serie_occurrence" example:"3"`
+	DateOccurrence string `json:"date_occurrence,omitempty" gorm:"uniqueIndex:idx_plannings_serie_occurrence" example:"2025-06-16"`
+
+	// Identifiant (UID) de l'événement iCalendar dont le planning a été importé
+	UIDImport string `json:"uid_import,omitempty" gorm:"index" example:"040000008200E00074C5B7101A82E008@outlook.com"`
+
+	// Ligne de facture qui a facturé ce planning (nil s'il n'a pas été facturé par l'application)
+	LigneFactureID *uint `json:"ligne_facture_id,omitempty" gorm:"index" example:"12"`
 
 	Facture *Facture `json:"facture,omitempty" gorm:"foreignKey:PlanningID"`
 }
+
+// Intervalle retourne le début et la fin du planning. Sans horaires, le planning occupe toute la journée.
+func (p *Planning) Intervalle() (time.Time, time.Time, error) {
+	jour, err := time.Parse("2006-01-02", p.Date)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("date invalide : %s", p.Date)
+	}
+	if p.HeureDebut == "" && p.HeureFin == "" {
+		return jour, jour.AddDate(0, 0, 1), nil
+	}
+
+	debut, fin, err := p.horaires()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	depart := jour.Add(debut.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)))
+	return depart, depart.Add(fin.Sub(debut)), nil
+}
+
+// Duree retourne la durée du planning en heures, calculée à partir de HeureDebut et HeureFin.
+// Une heure de fin antérieure à l'heure de début correspond à une intervention qui passe minuit.
+func (p *Planning) Duree() (float64, error) {
+	debut, fin, err := p.horaires()
+	if err != nil {
+		return 0, err
+	}
+	return fin.Sub(debut).Hours(), nil
+}
+
+// horaires retourne les heures de début et de fin du planning, la fin étant reportée au lendemain si elle précède le début
+func (p *Planning) horaires() (time.Time, time.Time, error) {
+	debut, err := time.Parse("15:04", p.HeureDebut)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("heure de début invalide : %s", p.HeureDebut)
+	}
+	fin, err := time.Parse("15:04", p.HeureFin)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("heure de fin invalide : %s", p.HeureFin)
+	}
+	if fin.Before(debut) {
+		fin = fin.Add(24 * time.Hour)
+	}
+	return debut, fin, nil
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+)
+
+// Niveaux de relance, par ordre d'escalade
+const (
+	NiveauPremiereRelance = 1
+	NiveauDeuxiemeRelance = 2
+	NiveauMiseEnDemeure   = 3
+)
+
+// LibelleNiveauRelance retourne le libellé d'un niveau de relance
+func LibelleNiveauRelance(niveau int) string {
+	switch niveau {
+	case NiveauPremiereRelance:
+		return "1re relance"
+	case NiveauDeuxiemeRelance:
+		return "2e relance"
+	case NiveauMiseEnDemeure:
+		return "Mise en demeure"
+	}
+	return ""
+}
+
+// Relance représente une lettre de relance générée pour une facture impayée après son échéance
+// @Description Structure d'une relance
+type Relance struct {
+	ID        uint      `json:"id" gorm:"primaryKey" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-04-24T08:00:00Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2025-04-24T08:00:00Z"`
+
+	EntrepriseID uint     `json:"entrepriseID" gorm:"not null;index" example:"5"`
+	FactureID    uint     `json:"factureID" gorm:"not null;uniqueIndex:idx_relances_facture_niveau" example:"1"`
+	Facture      *Facture `json:"facture,omitempty" gorm:"foreignKey:FactureID"`
+
+	Niveau      int       `json:"niveau" gorm:"not null;uniqueIndex:idx_relances_facture_niveau" example:"1"` // 1, 2 ou 3 (mise en demeure)
+	Libelle     string    `json:"libelle" example:"1re relance"`
+	DateRelance time.Time `json:"dateRelance" example:"2025-04-24T08:00:00Z"`
+	JoursRetard int       `json:"joursRetard" example:"7"`
+
+	// Montants réclamés à la date de la relance
+	MontantDu            Montant `json:"montantDu" example:"1200.00"`
+	Penalites            Montant `json:"penalites" example:"2.80"`
+	IndemniteForfaitaire Montant `json:"indemniteForfaitaire" example:"40.00"`
+	TotalReclame         Montant `json:"totalReclame" example:"1242.80"`
+
+	// Lettre HTML générée
+	Contenu string `json:"-" gorm:"type:text"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Unités standard des lignes de devis, de facture et d'avoir
+const (
+	UniteHeure      = "h"
+	UniteJour       = "jour"
+	UniteForfait    = "forfait"
+	UniteMetreCarre = "m²"
+	UniteUnite      = "U"
+)
+
+// UnitesStandard liste les unités proposées pour les lignes, dans l'ordre d'affichage
+var UnitesStandard = []string{UniteHeure, UniteJour, UniteForfait, UniteMetreCarre, UniteUnite}
+
+// synonymesUnites associe les saisies courantes et les unités des anciennes lignes à leur unité standard
+var synonymesUnites = map[string]string{
+	"heure":  UniteHeure,
+	"heures": UniteHeure,
+	"j":      UniteJour,
+	"jours":  UniteJour,
+	"m2":     UniteMetreCarre,
+	"unité":  UniteUnite,
+	"unités": UniteUnite,
+	"":       UniteUnite,
+}
+
+// NormaliserUnite retourne l'unité standard correspondant à une saisie (U par défaut),
+// ou une erreur si elle ne fait pas partie des unités standard
+func NormaliserUnite(unite string) (string, error) {
+	saisie := strings.TrimSpace(unite)
+	for _, standard := range UnitesStandard {
+		if strings.EqualFold(saisie, standard) {
+			return standard, nil
+		}
+	}
+	if standard, ok := synonymesUnites[strings.ToLower(saisie)]; ok {
+		return standard, nil
+	}
+	return "", fmt.Errorf("unité %q inconnue (%s)", unite, strings.Join(UnitesStandard, ", "))
+}
+
+// MontantRemise retourne le montant HT d'une remise appliquée à un montant brut.
+// Une remise, sur une ligne ou sur l'ensemble d'un document, est exprimée soit en pourcentage (arrondie au centime),
+// soit en montant HT. La remise globale d'un document s'applique au total HT des lignes, remises de lignes déduites,
+// et est répartie entre les taux de TVA au prorata de leur base (voir BasesTVA.VentilationRemisee).
+func MontantRemise(brut Montant, pourcentage float64, montant Montant) Montant {
+	if pourcentage != 0 {
+		return brut.Pourcentage(pourcentage)
+	}
+	return montant
+}
+
+// MontantHTRemise retourne le montant HT d'une ligne : quantité × prix unitaire arrondi au centime, remise déduite
+func MontantHTRemise(prixUnitaire Montant, quantite, pourcentage float64, montant Montant) Montant {
+	brut := prixUnitaire.Multiplier(quantite)
+	return brut - MontantRemise(brut, pourcentage, montant)
+}
+
+// errRemiseDouble signale une remise exprimée à la fois en pourcentage et en montant
+var errRemiseDouble = errors.New("une remise est exprimée soit en pourcentage, soit en montant")
+
+// VerifierRemise contrôle une remise : pourcentage compris entre 0 et 100, ou montant positif
+// ne dépassant pas le montant brut auquel elle s'applique
+func VerifierRemise(brut Montant, pourcentage float64, montant Montant) error {
+	switch {
+	case pourcentage != 0 && montant != 0:
+		return errRemiseDouble
+	case pourcentage < 0 || pourcentage > 100:
+		return fmt.Errorf("le pourcentage de remise (%g) doit être compris entre 0 et 100", pourcentage)
+	case montant < 0:
+		return fmt.Errorf("le montant de la remise (%s) ne peut pas être négatif", montant)
+	case montant > brut:
+		return fmt.Errorf("la remise (%s) dépasse le montant auquel elle s'applique (%s)", montant, brut)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// Portée d'une modification ou d'une suppression dans une série de plannings
+const (
+	PorteeOccurrence = "occurrence" // cette occurrence uniquement
+	PorteeSuivantes  = "suivantes"  // cette occurrence et les suivantes
+	PorteeSerie      = "serie"      // toute la série
+)
+
+// SeriePlanning représente un planning récurrent décrit par une règle RFC 5545 (RRULE).
+// Les occurrences ne sont pas enregistrées : elles sont calculées à la lecture. Une occurrence
+// modifiée ou facturée est enregistrée comme un Planning rattaché à la série (SerieID, DateOccurrence).
+type SeriePlanning struct {
+	ID        uint      `json:"id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-06-10T10:00:00Z"`
+	UpdatedAt time.Time `json:"updated_at" example:"2025-06-10T10:00:00Z"`
+
+	EntrepriseID uint `json:"entreprise_id" gorm:"not null;index" example:"1"`
+
+	// Règle de récurrence sans DTSTART, ex. FREQ=WEEKLY;BYDAY=MO,TH;UNTIL=20251231T000000Z
+	RRule string `json:"rrule" gorm:"column:rrule;not null" example:"FREQ=WEEKLY;BYDAY=MO,TH;COUNT=10"`
+	// Date de la première occurrence (DTSTART)
+	DateDebut string `json:"date_debut" gorm:"not null;index" example:"2025-06-16"`
+	// Date au-delà de laquelle la série n'a plus d'occurrence, vide si la série est illimitée
+	DateFin string `json:"date_fin,omitempty" gorm:"index" example:"2025-07-17"`
+
+	// Modèle des occurrences
+	HeureDebut    string   `json:"heure_debut" example:"09:00"`
+	HeureFin      string   `json:"heure_fin" example:"12:00"`
+	TypeEvenement string   `json:"type_evenement" example:"Intervention"`
+	SalarieID     uint     `json:"salarie_id" example:"1"`
+	Salarie       Salarie  `json:"salarie" gorm:"foreignKey:SalarieID"`
+	ClientID      uint     `json:"client_id" example:"1"`
+	Client        Client   `json:"client" gorm:"foreignKey:ClientID"`
+	Objet         *string  `json:"objet,omitempty" example:"Entretien des bureaux"`
+	Prestation    *string  `json:"prestation,omitempty" example:"Ménage"`
+	Facturation   string   `json:"facturation" gorm:"not null;default:'À facturer'" example:"À facturer"`
+	TauxHoraire   *Montant `json:"taux_horaire,omitempty" example:"25"`
+	ForfaitHT     *Montant `json:"forfait_ht,omitempty"`
+
+	// Identifiant (UID) de l'événement iCalendar dont la série a été importée
+	UIDImport string `json:"uid_import,omitempty" gorm:"index"`
+
+	// Dates supprimées de la série (EXDATE)
+	Exceptions []ExceptionSeriePlanning `json:"exceptions" gorm:"foreignKey:SerieID"`
+}
+
+// ExceptionSeriePlanning représente une occurrence supprimée d'une série
+type ExceptionSeriePlanning struct {
+	ID      uint   `json:"id" example:"1"`
+	SerieID uint   `json:"serie_id" gorm:"not null;uniqueIndex:idx_exceptions_serie_date" example:"1"`
+	Date    string `json:"date" gorm:"not null;uniqueIndex:idx_exceptions_serie_date" example:"2025-07-14"`
+}
+
+// Regle retourne la règle de récurrence de la série, ancrée sur sa date de début
+func (s *SeriePlanning) Regle() (*rrule.RRule, error) {
+	option, err := rrule.StrToROption(s.RRule)
+	if err != nil {
+		return nil, fmt.Errorf("règle de récurrence invalide : %v", err)
+	}
+	debut, err := time.Parse("2006-01-02", s.DateDebut)
+	if err != nil {
+		return nil, fmt.Errorf("date de début invalide : %s", s.DateDebut)
+	}
+	option.Dtstart = debut
+	return rrule.NewRRule(*option)
+}
+
+// Occurrences retourne les dates (AAAA-MM-JJ) des occurrences comprises entre debut et fin inclus,
+// hors exceptions
+func (s *SeriePlanning) Occurrences(debut, fin time.Time) ([]string, error) {
+	regle, err := s.Regle()
+	if err != nil {
+		return nil, err
+	}
+
+	exclues := make(map[string]bool, len(s.Exceptions))
+	for _, exception := range s.Exceptions {
+		exclues[exception.Date] = true
+	}
+
+	var dates []string
+	for _, occurrence := range regle.Between(debut, fin, true) {
+		date := occurrence.Format("2006-01-02")
+		if !exclues[date] {
+			dates = append(dates, date)
+		}
+	}
+	return dates, nil
+}
+
+// EstOccurrence indique si la date (AAAA-MM-JJ) est une occurrence de la série, hors exceptions
+func (s *SeriePlanning) EstOccurrence(date string) bool {
+	jour, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false
+	}
+	dates, err := s.Occurrences(jour, jour)
+	return err == nil && len(dates) == 1
+}
+
+// Occurrence construit le planning d'une occurrence de la série à partir de son modèle
+func (s *SeriePlanning) Occurrence(date string) Planning {
+	serieID := s.ID
+	return Planning{
+		Date:           date,
+		HeureDebut:     s.HeureDebut,
+		HeureFin:       s.HeureFin,
+		TypeEvenement:  s.TypeEvenement,
+		SalarieID:      s.SalarieID,
+		Salarie:        s.Salarie,
+		ClientID:       s.ClientID,
+		Client:         s.Client,
+		Objet:          s.Objet,
+		Prestation:     s.Prestation,
+		Facturation:    s.Facturation,
+		TauxHoraire:    s.TauxHoraire,
+		ForfaitHT:      s.ForfaitHT,
+		EntrepriseID:   s.EntrepriseID,
+		NbRepetitions:  1,
+		SerieID:        &serieID,
+		DateOccurrence: date,
+	}
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeriePlanningOccurrences(t *testing.T) {
+	jour := func(date string) time.Time {
+		d, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	cas := []struct {
+		nom        string
+		rrule      string
+		dateDebut  string
+		exceptions []string
+		debut, fin string
+		attendu    []string
+	}{
+		{"2e mardi du mois", "FREQ=MONTHLY;BYDAY=2TU", "2025-01-01", nil, "2025-01-01", "2025-04-30",
+			[]string{"2025-01-14", "2025-02-11", "2025-03-11", "2025-04-08"}},
+		{"le 31 : les mois plus courts sont sautés", "FREQ=MONTHLY;BYMONTHDAY=31", "2025-01-01", nil, "2025-01-01", "2025-06-30",
+			[]string{"2025-01-31", "2025-03-31", "2025-05-31"}},
+		{"dernier jour du mois", "FREQ=MONTHLY;BYMONTHDAY=-1", "2024-01-01", nil, "2024-01-01", "2024-03-31",
+			[]string{"2024-01-31", "2024-02-29", "2024-03-31"}},
+		{"exceptions", "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=6", "2025-06-16", []string{"2025-06-19", "2025-06-30"}, "2025-06-01", "2025-07-31",
+			[]string{"2025-06-16", "2025-06-23", "2025-06-26", "2025-07-03"}},
+		{"période partielle", "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=6", "2025-06-16", nil, "2025-06-24", "2025-06-30",
+			[]string{"2025-06-26", "2025-06-30"}},
+		{"UNTIL inclus", "FREQ=DAILY;INTERVAL=2;UNTIL=20250707T000000Z", "2025-07-01", nil, "2025-06-01", "2025-07-31",
+			[]string{"2025-07-01", "2025-07-03", "2025-07-05", "2025-07-07"}},
+		{"avant le début de la série", "FREQ=MONTHLY;BYDAY=2TU", "2025-03-01", nil, "2025-01-01", "2025-02-28", nil},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			serie := SeriePlanning{RRule: c.rrule, DateDebut: c.dateDebut}
+			for _, date := range c.exceptions {
+				serie.Exceptions = append(serie.Exceptions, ExceptionSeriePlanning{Date: date})
+			}
+			dates, err := serie.Occurrences(jour(c.debut), jour(c.fin))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dates, c.attendu) {
+				t.Errorf("occurrences %v, attendu %v", dates, c.attendu)
+			}
+		})
+	}
+}
+
+func TestSeriePlanningEstOccurrence(t *testing.T) {
+	deuxiemeMardi := SeriePlanning{RRule: "FREQ=MONTHLY;BYDAY=2TU", DateDebut: "2025-01-01",
+		Exceptions: []ExceptionSeriePlanning{{Date: "2025-03-11"}}}
+	finDeMois := SeriePlanning{RRule: "FREQ=MONTHLY;BYMONTHDAY=31;COUNT=3", DateDebut: "2025-01-01"}
+
+	cas := []struct {
+		nom     string
+		serie   SeriePlanning
+		date    string
+		attendu bool
+	}{
+		{"2e mardi", deuxiemeMardi, "2025-02-11", true},
+		{"3e mardi", deuxiemeMardi, "2025-02-18", false},
+		{"2e mardi exclu", deuxiemeMardi, "2025-03-11", false},
+		{"avant le début", deuxiemeMardi, "2024-12-10", false},
+		{"date invalide", deuxiemeMardi, "11/02/2025", false},
+		{"31 mai", finDeMois, "2025-05-31", true},
+		{"30 avril", finDeMois, "2025-04-30", false},
+		{"au-delà de COUNT", finDeMois, "2025-07-31", false},
+		{"règle invalide", SeriePlanning{RRule: "FREQ=SOUVENT", DateDebut: "2025-01-01"}, "2025-01-01", false},
+	}
+	for _, c := range cas {
+		t.Run(c.nom, func(t *testing.T) {
+			if got := c.serie.EstOccurrence(c.date); got != c.attendu {
+				t.Errorf("EstOccurrence(%s) = %v, attendu %v", c.date, got, c.attendu)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Auteurs d'un changement de statut
+const (
+	ActeurEntreprise = "entreprise" // Utilisateur connecté de l'entreprise
+	ActeurClient     = "client"     // Client, par le lien d'acceptation en ligne
+	ActeurSysteme    = "système"    // Traitement automatique (expiration des devis)
+)
+
+// TransitionStatut trace un changement de statut d'un devis ou d'une facture : statuts de départ et d'arrivée,
+// auteur et date du changement
+type TransitionStatut struct {
+	ID        uint      `json:"id" example:"1"`
+	CreatedAt time.Time `json:"created_at" example:"2025-06-15T14:30:00Z"` // Date du changement
+
+	EntrepriseID uint   `json:"entreprise_id" gorm:"not null;index" example:"1"`
+	TypeDocument string `json:"type_document" gorm:"not null;index:idx_transitions_statut_document,priority:1" example:"devis"` // devis, facture
+	DocumentID   uint   `json:"document_id" gorm:"not null;index:idx_transitions_statut_document,priority:2" example:"12"`
+	De           string `json:"de" example:"envoyé"`
+	Vers         string `json:"vers" example:"accepté"`
+
+	Acteur    string `json:"acteur" example:"client"`          // entreprise, client, système
+	ActeurNom string `json:"acteur_nom" example:"Jean Dupont"` // Email de l'entreprise, nom du signataire ou traitement automatique
+	AdresseIP string `json:"adresse_ip,omitempty" example:"203.0.113.42"`
+	Motif     string `json:"motif,omitempty" gorm:"type:text" example:"Budget dépassé"`
+}
+
+// contientStatut indique si un statut figure dans une liste de statuts
+func contientStatut(statuts []string, statut string) bool {
+	for _, s := range statuts {
+		if s == statut {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package models
+
+import "sort"
+
+// TauxTVAApplicables liste les taux de TVA en vigueur : normal, intermédiaire, réduit, particulier et nul
+var TauxTVAApplicables = []float64{20, 10, 5.5, 2.1, 0}
+
+// TauxTVAApplicable indique si un taux de TVA fait partie des taux en vigueur
+func TauxTVAApplicable(taux float64) bool {
+	for _, t := range TauxTVAApplicables {
+		if t == taux {
+			return true
+		}
+	}
+	return false
+}
+
+// Motifs d'exonération de TVA. Un document exonéré ne porte que des lignes à 0 % et doit
+// reproduire la mention légale correspondante.
+const (
+	ExonerationTVAFranchise          = "franchise"          // Franchise en base (micro-entrepreneur)
+	ExonerationTVAAutoliquidation    = "autoliquidation"    // Sous-traitance du BTP : la TVA est due par le donneur d'ordre
+	ExonerationTVAIntracommunautaire = "intracommunautaire" // Prestation à un assujetti d'un autre État membre
+)
+
+// mentionsExonerationTVA associe à chaque motif d'exonération la mention obligatoire sur les documents
+var mentionsExonerationTVA = map[string]string{
+	ExonerationTVAFranchise:          "TVA non applicable, art. 293 B du CGI",
+	ExonerationTVAAutoliquidation:    "Autoliquidation : TVA due par le preneur, art. 283-2 nonies du CGI",
+	ExonerationTVAIntracommunautaire: "Autoliquidation : TVA due par le preneur, art. 283-2 du CGI et art. 196 de la directive 2006/112/CE",
+}
+
+// MotifExonerationTVAValide indique si le motif est vide (document soumis à TVA) ou un motif connu
+func MotifExonerationTVAValide(motif string) bool {
+	_, ok := mentionsExonerationTVA[motif]
+	return motif == "" || ok
+}
+
+// MentionExonerationTVA retourne la mention légale d'un motif d'exonération (vide si aucun)
+func MentionExonerationTVA(motif string) string {
+	return mentionsExonerationTVA[motif]
+}
+
+// VentilationTVA est le récapitulatif d'un taux de TVA sur un document : base HT, TVA et TTC
+type VentilationTVA struct {
+	Taux   float64 `json:"taux" example:"20"`
+	Base   Montant `json:"base" example:"1000.00"` // Remise globale déduite
+	TVA    Montant `json:"tva" example:"200.00"`
+	TTC    Montant `json:"ttc" example:"1200.00"`
+	Remise Montant `json:"remise,omitempty" example:"50.00"` // Part de la remise globale du document sur ce taux
+}
+
+// BasesTVA cumule les montants HT d'un document par taux de TVA
+type BasesTVA map[float64]Montant
+
+// Ajouter ajoute le montant HT d'une ligne à la base de son taux
+func (b BasesTVA) Ajouter(taux float64, montantHT Montant) {
+	b[taux] += montantHT
+}
+
+// Ventilation retourne le récapitulatif par taux, du plus élevé au plus faible.
+// La TVA est calculée et arrondie au centime sur la base de chaque taux, et non ligne par ligne.
+func (b BasesTVA) Ventilation() []VentilationTVA {
+	taux := make([]float64, 0, len(b))
+	for t := range b {
+		taux = append(taux, t)
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(taux)))
+
+	ventilation := make([]VentilationTVA, 0, len(taux))
+	for _, t := range taux {
+		base := b[t]
+		tva := base.Pourcentage(t)
+		ventilation = append(ventilation, VentilationTVA{Taux: t, Base: base, TVA: tva, TTC: base + tva})
+	}
+	return ventilation
+}
+
+// VentilationRemisee retourne le récapitulatif par taux après déduction de la remise globale d'un document,
+// répartie entre les taux au prorata de leur base HT ; le dernier taux reçoit le reste pour que la somme soit exacte
+func (b BasesTVA) VentilationRemisee(remise Montant) []VentilationTVA {
+	ventilation := b.Ventilation()
+	if remise == 0 || len(ventilation) == 0 {
+		return ventilation
+	}
+
+	var total Montant
+	for _, v := range ventilation {
+		total += v.Base
+	}
+	nettes, parts := BasesTVA{}, map[float64]Montant{}
+	reste := remise
+	for i, v := range ventilation {
+		part := reste
+		if i < len(ventilation)-1 {
+			part = remise.Proportion(v.Base, total)
+		}
+		reste -= part
+		nettes[v.Taux], parts[v.Taux] = v.Base-part, part
+	}
+
+	ventilation = nettes.Ventilation()
+	for i := range ventilation {
+		ventilation[i].Remise = parts[ventilation[i].Taux]
+	}
+	return ventilation
+}
+
+// TotalRemises retourne le montant de la remise globale répartie dans une ventilation
+func TotalRemises(ventilation []VentilationTVA) Montant {
+	var total Montant
+	for _, v := range ventilation {
+		total += v.Remise
+	}
+	return total
+}
+
+// TotauxVentilation retourne le total HT, le total de TVA et le total TTC d'une ventilation
+func TotauxVentilation(ventilation []VentilationTVA) (totalHT, totalTVA, totalTTC Montant) {
+	for _, v := range ventilation {
+		totalHT += v.Base
+		totalTVA += v.TVA
+	}
+	return totalHT, totalTVA, totalHT + totalTVA
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"facturation-planning/controllers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func AvoirRoutes(r chi.Router) {
+	// Création et consultation (un avoir n'est jamais modifié ni supprimé)
+	r.Post("/factures/{id}/avoirs", controllers.CreateAvoir)
+	r.Get("/factures/{id}/avoirs", controllers.GetAvoirsByFacture)
+	r.Get("/avoirs", controllers.GetAllAvoirs)
+	r.Get("/avoirs/{id}", controllers.GetAvoirByID)
+
+	// PDF generation
+	r.Get("/avoirs/{id}/pdf", controllers.GenerateAvoirPDF)
+	r.Get("/avoirs/{id}/download", controllers.DownloadAvoirPDF)
+
+	// Structured XML export (UBL 2.1 / CII)
+	r.Get("/avoirs/{id}/xml", controllers.ExportAvoirXML)
+}
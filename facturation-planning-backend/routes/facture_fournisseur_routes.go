@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"facturation-planning/controllers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func FactureFournisseurRoutes(r chi.Router) {
+	// Import des factures reçues au format UBL 2.1 ou CII
+	r.Post("/factures-fournisseurs/import", controllers.ImportFactureFournisseur)
+	r.Get("/factures-fournisseurs", controllers.GetFacturesFournisseurs)
+	r.Get("/factures-fournisseurs/{id}", controllers.GetFactureFournisseur)
+	r.Get("/factures-fournisseurs/{id}/xml", controllers.GetFactureFournisseurXML)
+}
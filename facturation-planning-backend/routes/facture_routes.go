@@ -10,6 +10,8 @@ func FactureRoutes(r chi.Router) {
 	// CRUD operations
 	r.Post("/factures", controllers.CreateFacture)
 	r.Post("/factures/from-devis/{devisId}", controllers.CreateFactureFromDevis)
+	r.Post("/factures/from-devis/{devisId}/acompte", controllers.CreateFactureAcompteDevis)
+	r.Post("/factures/from-devis/{devisId}/situation", controllers.CreateFactureSituationDevis)
 	r.Get("/factures", controllers.GetAllFactures)
 	r.Get("/factures/{id}", controllers.GetFactureByID)
 	r.Put("/factures/{id}", controllers.UpdateFacture)
@@ -18,9 +20,16 @@ func FactureRoutes(r chi.Router) {
 	// PDF generation
 	r.Get("/factures/{id}/pdf", controllers.GenerateFacturePDF)
 	r.Get("/factures/{id}/download", controllers.DownloadFacturePDF)
+	r.Get("/factures/{id}/facturx", controllers.ExportFacturX)
 
-	// Status management
+	// Structured XML export (UBL 2.1 / CII)
+	r.Get("/factures/{id}/xml", controllers.ExportFactureXML)
+
+	// Lifecycle and status management
+	r.Post("/factures/{id}/emettre", controllers.EmettreFacture)
 	r.Put("/factures/{id}/statut", controllers.UpdateFactureStatut)
+	r.Get("/factures/{id}/verification", controllers.VerifierFacture)
+	r.Get("/factures/{id}/historique", controllers.GetHistoriqueFacture)
 
 	// Search and filters
 	r.Get("/factures/search", controllers.SearchFactures)
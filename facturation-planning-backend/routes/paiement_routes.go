@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"facturation-planning/controllers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func PaiementRoutes(r chi.Router) {
+	// Paiements d'une facture (un paiement n'est jamais supprimé, il est contre-passé)
+	r.Get("/factures/{id}/paiements", controllers.GetPaiementsByFacture)
+	r.Post("/factures/{id}/paiements", controllers.CreatePaiement)
+	r.Get("/paiements", controllers.GetAllPaiements)
+	r.Post("/paiements/{id}/annulation", controllers.AnnulerPaiement)
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"facturation-planning/controllers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func RelanceRoutes(r chi.Router) {
+	// Factures en retard et historique des relances
+	r.Get("/relances/retards", controllers.GetFacturesEnRetard)
+	r.Get("/relances", controllers.GetAllRelances)
+	r.Get("/relances/{id}/lettre", controllers.GetLettreRelance)
+	r.Get("/factures/{id}/relances", controllers.GetRelancesByFacture)
+
+	// Exécution manuelle (le planificateur tourne aussi en arrière-plan)
+	r.Post("/relances/executer", controllers.ExecuterRelances)
+}
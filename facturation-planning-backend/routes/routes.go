@@ -22,48 +22,111 @@ func SetupRoutes() *chi.Mux {
 
 	// Auth
 	AuthRoutes(r)
-	FactureRoutes(r)
 
-	// Autres routes (protégées après connexion)
-	r.With(middlewares.JWTMiddleware).Get("/profile", controllers.GetProfile)
-
-	// Entreprises
-	r.Get("/entreprises", controllers.GetEntreprises)
-	r.Post("/entreprises", controllers.CreateEntreprise)
+	// Types d'événements (endpoint public)
+	r.Get("/plannings/types-evenements", controllers.GetTypesEvenements)
 
-	// Salariés
-	r.Get("/salaries", controllers.GetSalaries)
-	r.Post("/salaries", controllers.CreateSalarie)
+	// Flux iCalendar des plannings (endpoint public, protégé par le jeton du lien d'abonnement)
+	r.Get("/calendrier/{jeton}", controllers.GetFluxCalendrier)
 
-	r.Route("/clients", func(r chi.Router) {
-		r.Get("/", controllers.GetClients)
-		r.Post("/", controllers.CreateClient)
+	// Acceptation des devis en ligne (endpoints publics, protégés par le jeton du lien envoyé au client)
+	r.Route("/devis-en-ligne/{jeton}", func(r chi.Router) {
+		r.Get("/", controllers.GetDevisEnLigne)
+		r.Get("/pdf", controllers.GetDevisEnLignePDF)
+		r.Post("/accepter", controllers.AccepterDevisEnLigne)
+		r.Post("/refuser", controllers.RefuserDevisEnLigne)
 	})
 
-	r.Get("/devis", controllers.GetAllDevis)
-	r.Get("/devis/test", controllers.TestDevisJSON) // Route de test
-	r.Post("/devis", controllers.CreateDevis)
-	r.Get("/devis/{id}", controllers.GetDevis)
-	r.Put("/devis/{id}", controllers.UpdateDevis)
-	r.Delete("/devis/{id}", controllers.DeleteDevis)
-	r.Get("/devis/{id}/pdf", controllers.GenerateDevisPDF)
-	r.Get("/devis/{id}/download", controllers.DownloadDevisPDF)
-	r.Patch("/devis/{id}/statut", controllers.UpdateDevisStatut)
+	// Toutes les autres routes sont protégées et limitées à l'entreprise du token
+	r.Group(func(r chi.Router) {
+		r.Use(middlewares.JWTMiddleware)
+
+		FactureRoutes(r)
+		AvoirRoutes(r)
+		PaiementRoutes(r)
+		RelanceRoutes(r)
+		FactureFournisseurRoutes(r)
 
-	// Devis par entreprise et client
-	r.Get("/entreprises/{id}/devis", controllers.GetDevisByEntreprise)
-	r.Get("/clients/{id}/devis", controllers.GetDevisByClient)
+		r.Get("/profile", controllers.GetProfile)
 
-	// Types d'événements (endpoint public)
-	r.Get("/plannings/types-evenements", controllers.GetTypesEvenements)
+		// Envoi des documents par email
+		r.Post("/factures/{id}/send", controllers.SendFacture)
+		r.Post("/devis/{id}/send", controllers.SendDevis)
+		r.Get("/emails/envois", controllers.GetEnvoisEmail)
+		r.Get("/parametres/email", controllers.GetParametresEmail)
+		r.Put("/parametres/email", controllers.UpdateParametresEmail)
 
-	// Plannings (endpoints protégés)
-	r.Route("/plannings", func(r chi.Router) {
-		r.Use(middlewares.JWTMiddleware)
-		r.Get("/", controllers.GetPlannings)
-		r.Post("/", controllers.CreatePlanning)
-		r.Put("/{id}", controllers.UpdatePlanning)
-		r.Delete("/{id}", controllers.DeletePlanning)
+		// Profil de l'entreprise (identité, mentions légales, logo, valeurs par défaut des devis)
+		r.Get("/parametres/entreprise", controllers.GetProfilEntreprise)
+		r.Put("/parametres/entreprise", controllers.UpdateProfilEntreprise)
+		r.Get("/parametres/entreprise/logo", controllers.GetLogoEntreprise)
+		r.Put("/parametres/entreprise/logo", controllers.UploadLogoEntreprise)
+		r.Delete("/parametres/entreprise/logo", controllers.DeleteLogoEntreprise)
+
+		// Numérotation des documents
+		r.Get("/numerotation", controllers.GetFormatsNumerotation)
+		r.Put("/numerotation/{type}", controllers.UpdateFormatNumerotation)
+
+		// Facturation mensuelle des plannings
+		r.Post("/facturation-mensuelle/preview", controllers.GetFacturationMensuellePreview)
+		r.Post("/facturation-mensuelle", controllers.CreateFacturationMensuelle)
+
+		// Entreprises
+		r.Get("/entreprises", controllers.GetEntreprises)
+		r.Post("/entreprises", controllers.CreateEntreprise)
+
+		// Salariés
+		r.Get("/salaries", controllers.GetSalaries)
+		r.Post("/salaries", controllers.CreateSalarie)
+
+		r.Route("/clients", func(r chi.Router) {
+			r.Get("/", controllers.GetClients)
+			r.Post("/", controllers.CreateClient)
+		})
+
+		r.Get("/devis", controllers.GetAllDevis)
+		r.Get("/devis/test", controllers.TestDevisJSON) // Route de test
+		r.Post("/devis", controllers.CreateDevis)
+		r.Get("/devis/{id}", controllers.GetDevis)
+		r.Put("/devis/{id}", controllers.UpdateDevis)
+		r.Delete("/devis/{id}", controllers.DeleteDevis)
+		r.Get("/devis/{id}/pdf", controllers.GenerateDevisPDF)
+		r.Get("/devis/{id}/download", controllers.DownloadDevisPDF)
+		r.Patch("/devis/{id}/statut", controllers.UpdateDevisStatut)
+		r.Get("/devis/{id}/revisions", controllers.GetRevisionsDevis)
+		r.Get("/devis/{id}/revisions/diff", controllers.GetDiffRevisionsDevis)
+		r.Get("/devis/{id}/revisions/{numero}", controllers.GetRevisionDevis)
+		r.Get("/devis/{id}/revisions/{numero}/pdf", controllers.GenerateRevisionDevisPDF)
+		r.Post("/devis/{id}/lien-acceptation", controllers.CreateLienAcceptationDevis)
+		r.Delete("/devis/{id}/lien-acceptation", controllers.DeleteLienAcceptationDevis)
+		r.Get("/devis/{id}/acceptations", controllers.GetAcceptationsDevis)
+		r.Get("/devis/{id}/historique", controllers.GetHistoriqueDevis)
+		r.Get("/devis/{id}/facturation", controllers.GetFacturationDevis)
+		r.Post("/devis/expirer", controllers.ExpirerDevis)
+
+		// Devis par entreprise et client
+		r.Get("/entreprises/{id}/devis", controllers.GetDevisByEntreprise)
+		r.Get("/clients/{id}/devis", controllers.GetDevisByClient)
+
+		// Plannings
+		r.Route("/plannings", func(r chi.Router) {
+			r.Get("/", controllers.GetPlannings)
+			r.Post("/", controllers.CreatePlanning)
+			r.Get("/non-factures", controllers.GetHeuresNonFacturees)
+			r.Get("/conflits", controllers.GetConflitsPlannings)
+			r.Get("/feuilles-temps", controllers.GetFeuillesTemps)
+			r.Get("/feuilles-temps/csv", controllers.ExportFeuillesTempsCSV)
+			r.Get("/feuilles-temps/pdf", controllers.ExportFeuillesTempsPDF)
+			r.Post("/calendrier", controllers.CreateAbonnementCalendrier)
+			r.Delete("/calendrier", controllers.DeleteAbonnementCalendrier)
+			r.Post("/import-ics", controllers.ImportPlanningsICS)
+			r.Post("/series", controllers.CreateSeriePlanning)
+			r.Get("/series/{id}", controllers.GetSeriePlanning)
+			r.Put("/series/{id}/occurrences/{date}", controllers.UpdateOccurrenceSerie)
+			r.Delete("/series/{id}/occurrences/{date}", controllers.DeleteOccurrenceSerie)
+			r.Put("/{id}", controllers.UpdatePlanning)
+			r.Delete("/{id}", controllers.DeletePlanning)
+		})
 	})
 
 	return r
@@ -0,0 +1,282 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"facturation-planning/auth"
+	"facturation-planning/config"
+	"facturation-planning/database/basetest"
+	"facturation-planning/models"
+)
+
+func TestMain(m *testing.M) {
+	auth.JwtSecret = []byte("secret-de-test")
+	os.Exit(basetest.Executer(m))
+}
+
+// entrepriseSeedee regroupe les documents d'une entreprise de test ; la marque figure dans
+// chacun d'eux pour repérer une fuite dans les réponses d'une autre entreprise
+type entrepriseSeedee struct {
+	marque     string
+	jeton      string
+	entreprise models.Entreprise
+	client     models.Client
+	salarie    models.Salarie
+	planning   models.Planning
+	devis      models.Devis
+	facture    models.Facture
+	avoir      models.Avoir
+	paiement   models.Paiement
+}
+
+func seederEntreprise(t *testing.T, marque string) entrepriseSeedee {
+	t.Helper()
+	db := config.DB
+	s := entrepriseSeedee{marque: marque}
+	nom := "Société " + marque
+	objet := "Intervention " + marque
+	creer := func(valeur interface{}) {
+		t.Helper()
+		if err := db.Create(valeur).Error; err != nil {
+			t.Fatalf("création %T : %v", valeur, err)
+		}
+	}
+
+	s.entreprise = models.Entreprise{Nom: "Entreprise " + marque, Email: strings.ToLower(marque) + "@exemple.test"}
+	creer(&s.entreprise)
+	id := s.entreprise.ID
+
+	s.client = models.Client{TypeClient: "professionnel", NomOrganisme: &nom, Email: "client-" + strings.ToLower(marque) + "@exemple.test", EntrepriseID: id}
+	creer(&s.client)
+	s.salarie = models.Salarie{Nom: "Salarié " + marque, EntrepriseID: id}
+	creer(&s.salarie)
+
+	taux := models.MontantEuros(50)
+	s.planning = models.Planning{
+		Date: "2025-06-16", HeureDebut: "09:00", HeureFin: "12:00", TypeEvenement: "Intervention",
+		SalarieID: s.salarie.ID, ClientID: s.client.ID, Objet: &objet, TauxHoraire: &taux,
+		Facturation: models.FacturationAFacturer, EntrepriseID: id,
+	}
+	creer(&s.planning)
+
+	s.devis = models.Devis{
+		EntrepriseID: id, Reference: "DEV-" + marque, ClientID: s.client.ID, Objet: objet,
+		Statut: models.StatutDevisBrouillon, DateDevis: time.Now(), DateExpiration: time.Now().AddDate(0, 1, 0),
+		Lignes: []models.LigneDevis{{Description: objet, Quantite: 1, PrixUnitaire: models.MontantEuros(100), TVA: 20}},
+	}
+	creer(&s.devis)
+
+	emiseLe := time.Now()
+	s.facture = models.Facture{
+		EntrepriseID: id, Reference: "FAC-" + marque, ClientID: s.client.ID, ClientNom: nom, ClientEmail: s.client.Email,
+		DateCreation: emiseLe, DateEmission: emiseLe, DateEcheance: emiseLe.AddDate(0, 0, 30),
+		Description: objet, TypeFacture: models.TypeFactureClassique, Statut: models.StatutFactureEmise, EmiseLe: &emiseLe,
+		SousTotalHT: models.MontantEuros(100), TotalTVA: models.MontantEuros(20), TotalTTC: models.MontantEuros(120),
+		Lignes: []models.LigneFacture{{
+			Description: objet, Unite: models.UniteForfait, Quantite: 1, PrixUnitaire: models.MontantEuros(100), TauxTVA: 20,
+			MontantHT: models.MontantEuros(100), TotalLigne: models.MontantEuros(100), MontantTTC: models.MontantEuros(120),
+		}},
+	}
+	creer(&s.facture)
+
+	s.avoir = models.Avoir{
+		EntrepriseID: id, Reference: "AV-" + marque, FactureID: s.facture.ID, ClientID: s.client.ID, ClientNom: nom,
+		DateEmission: emiseLe, TypeAvoir: models.TypeAvoirPartiel, Motif: "Geste " + marque,
+		SousTotalHT: models.MontantEuros(10), TotalTVA: models.MontantEuros(2), TotalTTC: models.MontantEuros(12),
+	}
+	creer(&s.avoir)
+	s.paiement = models.Paiement{
+		EntrepriseID: id, FactureID: s.facture.ID, DatePaiement: emiseLe, Montant: models.MontantEuros(50),
+		Mode: "virement", ReferenceBancaire: "VIR-" + marque,
+	}
+	creer(&s.paiement)
+	if err := db.Model(&s.facture).Updates(map[string]interface{}{
+		"total_avoirs": models.MontantEuros(12), "total_paye": models.MontantEuros(50), "reste_a_payer": models.MontantEuros(58),
+		"statut": models.StatutFacturePartiellementPayee,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	creer(&models.EnvoiEmail{
+		EntrepriseID: id, TypeDocument: models.TypeDocumentFacture, DocumentID: s.facture.ID, Reference: s.facture.Reference,
+		Destinataires: s.client.Email, Sujet: "Facture " + s.facture.Reference, Statut: models.StatutEnvoiEnvoye,
+	})
+
+	jeton, err := auth.GenerateJWT(id, s.entreprise.Nom, s.entreprise.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.jeton = jeton
+	return s
+}
+
+func requete(t *testing.T, routeur http.Handler, jeton, methode, chemin, corps string) *httptest.ResponseRecorder {
+	t.Helper()
+	var lecteur io.Reader
+	if corps != "" {
+		lecteur = strings.NewReader(corps)
+	}
+	req := httptest.NewRequest(methode, chemin, lecteur)
+	req.Header.Set("Authorization", "Bearer "+jeton)
+	if corps != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	routeur.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIsolationEntreprises(t *testing.T) {
+	basetest.Exiger(t)
+
+	alpha := seederEntreprise(t, "ALPHA7341")
+	zeta := seederEntreprise(t, "ZETA9562")
+	routeur := SetupRoutes()
+
+	// L'entreprise Alpha vise chaque document de Zeta par son identifiant : tous sont introuvables
+	t.Run("documents d'une autre entreprise", func(t *testing.T) {
+		client := fmt.Sprint(zeta.client.ID)
+		devis := fmt.Sprint(zeta.devis.ID)
+		facture := fmt.Sprint(zeta.facture.ID)
+		avoir := fmt.Sprint(zeta.avoir.ID)
+		paiement := fmt.Sprint(zeta.paiement.ID)
+		planning := fmt.Sprint(zeta.planning.ID)
+
+		cas := []struct{ methode, chemin, corps string }{
+			// Clients
+			{"GET", "/clients/" + client + "/devis", ""},
+			{"GET", "/factures/client/" + client, ""},
+
+			// Devis
+			{"GET", "/devis/" + devis, ""},
+			{"PUT", "/devis/" + devis, `{"objet":"modifié"}`},
+			{"DELETE", "/devis/" + devis, ""},
+			{"GET", "/devis/" + devis + "/pdf", ""},
+			{"GET", "/devis/" + devis + "/download", ""},
+			{"PATCH", "/devis/" + devis + "/statut", `{"statut":"refusé"}`},
+			{"GET", "/devis/" + devis + "/revisions", ""},
+			{"POST", "/devis/" + devis + "/lien-acceptation", ""},
+			{"DELETE", "/devis/" + devis + "/lien-acceptation", ""},
+			{"GET", "/devis/" + devis + "/acceptations", ""},
+			{"GET", "/devis/" + devis + "/historique", ""},
+			{"GET", "/devis/" + devis + "/facturation", ""},
+			{"POST", "/devis/" + devis + "/send", ""},
+			{"POST", "/factures/from-devis/" + devis, ""},
+			{"POST", "/factures/from-devis/" + devis + "/acompte", `{"pourcentage":30}`},
+			{"POST", "/factures/from-devis/" + devis + "/situation", `{"avancement":50}`},
+
+			// Factures
+			{"GET", "/factures/" + facture, ""},
+			{"PUT", "/factures/" + facture, `{"description":"modifiée"}`},
+			{"DELETE", "/factures/" + facture, ""},
+			{"GET", "/factures/" + facture + "/pdf", ""},
+			{"GET", "/factures/" + facture + "/download", ""},
+			{"GET", "/factures/" + facture + "/facturx", ""},
+			{"GET", "/factures/" + facture + "/xml", ""},
+			{"POST", "/factures/" + facture + "/emettre", ""},
+			{"PUT", "/factures/" + facture + "/statut", `{"statut":"émise"}`},
+			{"GET", "/factures/" + facture + "/verification", ""},
+			{"GET", "/factures/" + facture + "/historique", ""},
+			{"POST", "/factures/" + facture + "/send", ""},
+
+			// Avoirs
+			{"GET", "/factures/" + facture + "/avoirs", ""},
+			{"POST", "/factures/" + facture + "/avoirs", `{"typeAvoir":"total","motif":"fuite"}`},
+			{"GET", "/avoirs/" + avoir, ""},
+			{"GET", "/avoirs/" + avoir + "/pdf", ""},
+			{"GET", "/avoirs/" + avoir + "/download", ""},
+			{"GET", "/avoirs/" + avoir + "/xml", ""},
+
+			// Paiements
+			{"GET", "/factures/" + facture + "/paiements", ""},
+			{"POST", "/factures/" + facture + "/paiements", `{"montant":10,"mode":"virement"}`},
+			{"POST", "/paiements/" + paiement + "/annulation", `{"motif":"fuite"}`},
+
+			// Plannings
+			{"PUT", "/plannings/" + planning, `{"objet":"modifié"}`},
+			{"DELETE", "/plannings/" + planning, ""},
+		}
+		for _, c := range cas {
+			rec := requete(t, routeur, alpha.jeton, c.methode, c.chemin, c.corps)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s %s : statut %d, attendu 404 (%s)", c.methode, c.chemin, rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+			if strings.Contains(rec.Body.String(), zeta.marque) {
+				t.Errorf("%s %s : la réponse contient des données de l'autre entreprise", c.methode, c.chemin)
+			}
+		}
+	})
+
+	// Les listes ne contiennent que les lignes de l'entreprise connectée
+	t.Run("listes", func(t *testing.T) {
+		listes := []string{
+			"/clients",
+			"/salaries",
+			"/entreprises",
+			"/devis",
+			"/factures",
+			"/factures/search?q=FAC",
+			"/factures/statut/partiellement_payee",
+			"/avoirs",
+			"/paiements",
+			"/plannings",
+			"/plannings/non-factures",
+			"/emails/envois",
+		}
+		for _, s := range []entrepriseSeedee{alpha, zeta} {
+			autre := zeta
+			if s.marque == zeta.marque {
+				autre = alpha
+			}
+			for _, chemin := range listes {
+				rec := requete(t, routeur, s.jeton, "GET", chemin, "")
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s (%s) : statut %d (%s)", chemin, s.marque, rec.Code, strings.TrimSpace(rec.Body.String()))
+					continue
+				}
+				corps := rec.Body.String()
+				if !strings.Contains(corps, s.marque) {
+					t.Errorf("%s (%s) : lignes de l'entreprise absentes", chemin, s.marque)
+				}
+				if strings.Contains(corps, autre.marque) {
+					t.Errorf("%s (%s) : contient des lignes de %s", chemin, s.marque, autre.marque)
+				}
+			}
+		}
+	})
+
+	// Aucune requête d'Alpha n'a modifié les documents de Zeta
+	t.Run("documents intacts", func(t *testing.T) {
+		db := config.DB
+		var devis models.Devis
+		if err := db.First(&devis, zeta.devis.ID).Error; err != nil || devis.Objet != zeta.devis.Objet || devis.Statut != models.StatutDevisBrouillon || devis.JetonAcceptationHash != "" {
+			t.Errorf("devis modifié : %+v (%v)", devis, err)
+		}
+		var facture models.Facture
+		if err := db.First(&facture, zeta.facture.ID).Error; err != nil || facture.Description != zeta.facture.Description || facture.Statut != models.StatutFacturePartiellementPayee {
+			t.Errorf("facture modifiée : %+v (%v)", facture, err)
+		}
+		var planning models.Planning
+		if err := db.First(&planning, zeta.planning.ID).Error; err != nil || *planning.Objet != *zeta.planning.Objet {
+			t.Errorf("planning modifié : %+v (%v)", planning, err)
+		}
+		var paiement models.Paiement
+		if err := db.First(&paiement, zeta.paiement.ID).Error; err != nil || paiement.Annule {
+			t.Errorf("paiement modifié : %+v (%v)", paiement, err)
+		}
+		for table, modele := range map[string]interface{}{"avoirs": &models.Avoir{}, "paiements": &models.Paiement{}, "factures": &models.Facture{}} {
+			var n int64
+			db.Model(modele).Where("entreprise_id = ?", zeta.entreprise.ID).Count(&n)
+			if n != 1 {
+				t.Errorf("%s de l'autre entreprise : %d, attendu 1", table, n)
+			}
+		}
+	})
+}
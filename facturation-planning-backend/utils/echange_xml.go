@@ -0,0 +1,554 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/models"
+)
+
+// Syntaxes XML d'échange de factures
+const (
+	FormatUBL = "ubl"
+	FormatCII = "cii"
+)
+
+// GenererXML sérialise le document dans la syntaxe demandée ("ubl" ou "cii")
+func GenererXML(d DocumentFacturation, format string) ([]byte, error) {
+	switch format {
+	case FormatUBL:
+		return GenererUBL(d)
+	case FormatCII:
+		return GenererCII(d)
+	default:
+		return nil, fmt.Errorf("format XML inconnu : %s (attendu : ubl ou cii)", format)
+	}
+}
+
+// DocumentImporte est un document lu depuis un XML UBL ou CII, avec la ventilation
+// de TVA et les totaux tels que déclarés par l'émetteur
+type DocumentImporte struct {
+	Format       string
+	Document     DocumentFacturation
+	Ventilation  []LigneTVA
+	TotalHT      models.Montant
+	TotalRemises models.Montant // Remises du document (BT-107)
+	TotalFrais   models.Montant // Frais du document (BT-108)
+	TotalTVA     models.Montant
+	TotalTTC     models.Montant
+	NetAPayer    models.Montant
+}
+
+// LireDocumentXML détecte la syntaxe (UBL Invoice/CreditNote ou CII) et lit le document.
+// Les totaux déclarés sont contrôlés par rapport aux lignes et à la ventilation de TVA.
+func LireDocumentXML(donnees []byte) (DocumentImporte, error) {
+	racine, err := elementRacine(donnees)
+	if err != nil {
+		return DocumentImporte{}, err
+	}
+
+	var importe DocumentImporte
+	switch racine {
+	case "CrossIndustryInvoice":
+		importe, err = lireCII(donnees)
+	case "Invoice", "CreditNote":
+		importe, err = lireUBL(donnees)
+	default:
+		return DocumentImporte{}, fmt.Errorf("document XML non reconnu (élément racine %s)", racine)
+	}
+	if err != nil {
+		return DocumentImporte{}, err
+	}
+
+	return importe, importe.controler()
+}
+
+// elementRacine retourne le nom local du premier élément du document
+func elementRacine(donnees []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(donnees))
+	for {
+		jeton, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("XML illisible : %v", err)
+		}
+		if debut, ok := jeton.(xml.StartElement); ok {
+			return debut.Name.Local, nil
+		}
+	}
+}
+
+// controler vérifie les informations indispensables et la cohérence des totaux (BR-CO-10, BR-CO-13, BR-CO-14, BR-CO-15)
+func (i *DocumentImporte) controler() error {
+	d := i.Document
+	var erreurs []string
+
+	if d.Numero == "" {
+		erreurs = append(erreurs, "numéro de facture manquant")
+	}
+	if d.DateEmission.IsZero() {
+		erreurs = append(erreurs, "date d'émission manquante")
+	}
+	if d.Vendeur.Nom == "" {
+		erreurs = append(erreurs, "nom du fournisseur manquant")
+	}
+	if len(d.Lignes) == 0 {
+		erreurs = append(erreurs, "aucune ligne de facture")
+	}
+
+	if len(i.Ventilation) == 0 {
+		i.Ventilation = d.VentilationTVA()
+	}
+
+	var sommeLignes, sommeBases, sommeTVA models.Montant
+	for _, l := range d.Lignes {
+		sommeLignes += l.MontantHT
+	}
+	for _, v := range i.Ventilation {
+		sommeBases += v.BaseHT
+		sommeTVA += v.MontantTVA
+	}
+
+	ecart := func(a, b models.Montant) bool { return (a - b).Abs() > models.MontantCentimes(1) }
+	if net := sommeLignes - i.TotalRemises + i.TotalFrais; ecart(net, i.TotalHT) {
+		erreurs = append(erreurs, fmt.Sprintf("la somme des lignes, remises et frais du document compris (%s), ne correspond pas au total HT (%s)", net, i.TotalHT))
+	}
+	if ecart(sommeBases, i.TotalHT) {
+		erreurs = append(erreurs, fmt.Sprintf("la ventilation de TVA (%s) ne correspond pas au total HT (%s)", sommeBases, i.TotalHT))
+	}
+	if ecart(sommeTVA, i.TotalTVA) {
+		erreurs = append(erreurs, fmt.Sprintf("la somme des TVA par taux (%s) ne correspond pas au total de TVA (%s)", sommeTVA, i.TotalTVA))
+	}
+	if ecart(i.TotalHT+i.TotalTVA, i.TotalTTC) {
+		erreurs = append(erreurs, fmt.Sprintf("le total TTC (%s) ne correspond pas au total HT + TVA (%s)", i.TotalTTC, i.TotalHT+i.TotalTVA))
+	}
+
+	if len(erreurs) > 0 {
+		return fmt.Errorf("document %s incohérent : %s", strings.ToUpper(i.Format), strings.Join(erreurs, " ; "))
+	}
+	return nil
+}
+
+// lecteurNombres convertit les valeurs numériques du XML en mémorisant la première erreur
+type lecteurNombres struct {
+	err error
+}
+
+func (l *lecteurNombres) nombre(valeur, champ string) float64 {
+	valeur = strings.TrimSpace(valeur)
+	if valeur == "" {
+		return 0
+	}
+	v, err := strconv.ParseFloat(valeur, 64)
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("valeur numérique invalide pour %s : %q", champ, valeur)
+	}
+	return v
+}
+
+// montant lit un montant sans passer par un flottant (arrondi au centime au-delà de deux décimales)
+func (l *lecteurNombres) montant(valeur, champ string) models.Montant {
+	valeur = strings.TrimSpace(valeur)
+	if valeur == "" {
+		return 0
+	}
+	m, err := models.ParseMontant(valeur)
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("montant invalide pour %s : %q", champ, valeur)
+	}
+	return m
+}
+
+func (l *lecteurNombres) date(valeur, format, champ string) time.Time {
+	valeur = strings.TrimSpace(valeur)
+	if valeur == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(format, valeur)
+	if err != nil && l.err == nil {
+		l.err = fmt.Errorf("date invalide pour %s : %q", champ, valeur)
+	}
+	return t
+}
+
+// Lecture CII (les balises sont lues par nom local, quel que soit le préfixe utilisé)
+
+type lectureMontant struct {
+	Valeur string `xml:",chardata"`
+	Devise string `xml:"currencyID,attr"`
+}
+
+type lectureIdentifiant struct {
+	Valeur string `xml:",chardata"`
+	Schema string `xml:"schemeID,attr"`
+}
+
+type lectureTaxeCII struct {
+	Montant          string `xml:"CalculatedAmount"`
+	MotifExoneration string `xml:"ExemptionReason"`
+	Base             string `xml:"BasisAmount"`
+	Categorie        string `xml:"CategoryCode"`
+	Taux             string `xml:"RateApplicablePercent"`
+}
+
+type lecturePartieCII struct {
+	Nom            string               `xml:"Name"`
+	Organisation   lectureIdentifiant   `xml:"SpecifiedLegalOrganization>ID"`
+	CodePostal     string               `xml:"PostalTradeAddress>PostcodeCode"`
+	Ligne1         string               `xml:"PostalTradeAddress>LineOne"`
+	Ligne2         string               `xml:"PostalTradeAddress>LineTwo"`
+	Ville          string               `xml:"PostalTradeAddress>CityName"`
+	Pays           string               `xml:"PostalTradeAddress>CountryID"`
+	Email          string               `xml:"URIUniversalCommunication>URIID"`
+	Enregistrement []lectureIdentifiant `xml:"SpecifiedTaxRegistration>ID"`
+}
+
+type lectureCIIDocument struct {
+	Document struct {
+		ID       string   `xml:"ID"`
+		TypeCode string   `xml:"TypeCode"`
+		Date     string   `xml:"IssueDateTime>DateTimeString"`
+		Notes    []string `xml:"IncludedNote>Content"`
+	} `xml:"ExchangedDocument"`
+	Transaction struct {
+		Lignes []struct {
+			Nom      string          `xml:"SpecifiedTradeProduct>Name"`
+			Prix     string          `xml:"SpecifiedLineTradeAgreement>NetPriceProductTradePrice>ChargeAmount"`
+			Base     string          `xml:"SpecifiedLineTradeAgreement>NetPriceProductTradePrice>BasisQuantity"`
+			Quantite lectureQuantite `xml:"SpecifiedLineTradeDelivery>BilledQuantity"`
+			Taxe     lectureTaxeCII  `xml:"SpecifiedLineTradeSettlement>ApplicableTradeTax"`
+			Montant  string          `xml:"SpecifiedLineTradeSettlement>SpecifiedTradeSettlementLineMonetarySummation>LineTotalAmount"`
+		} `xml:"IncludedSupplyChainTradeLineItem"`
+		Vendeur   lecturePartieCII `xml:"ApplicableHeaderTradeAgreement>SellerTradeParty"`
+		Acheteur  lecturePartieCII `xml:"ApplicableHeaderTradeAgreement>BuyerTradeParty"`
+		Reglement struct {
+			Devise   string           `xml:"InvoiceCurrencyCode"`
+			IBAN     []string         `xml:"SpecifiedTradeSettlementPaymentMeans>PayeePartyCreditorFinancialAccount>IBANID"`
+			Taxes    []lectureTaxeCII `xml:"ApplicableTradeTax"`
+			Echeance string           `xml:"SpecifiedTradePaymentTerms>DueDateDateTime>DateTimeString"`
+			Totaux   struct {
+				TotalLignes string           `xml:"LineTotalAmount"`
+				Frais       string           `xml:"ChargeTotalAmount"`
+				Remises     string           `xml:"AllowanceTotalAmount"`
+				BaseTaxable string           `xml:"TaxBasisTotalAmount"`
+				TotalTVA    []lectureMontant `xml:"TaxTotalAmount"`
+				TotalTTC    string           `xml:"GrandTotalAmount"`
+				Prepaye     string           `xml:"TotalPrepaidAmount"`
+				NetAPayer   string           `xml:"DuePayableAmount"`
+			} `xml:"SpecifiedTradeSettlementHeaderMonetarySummation"`
+			FactureOrigine string `xml:"InvoiceReferencedDocument>IssuerAssignedID"`
+		} `xml:"ApplicableHeaderTradeSettlement"`
+	} `xml:"SupplyChainTradeTransaction"`
+}
+
+func lireCII(donnees []byte) (DocumentImporte, error) {
+	var cii lectureCIIDocument
+	if err := xml.Unmarshal(donnees, &cii); err != nil {
+		return DocumentImporte{}, fmt.Errorf("XML CII illisible : %v", err)
+	}
+
+	var n lecteurNombres
+	reglement := cii.Transaction.Reglement
+
+	doc := DocumentFacturation{
+		CodeType:       strings.TrimSpace(cii.Document.TypeCode),
+		Numero:         strings.TrimSpace(cii.Document.ID),
+		DateEmission:   n.date(cii.Document.Date, "20060102", "IssueDateTime"),
+		DateEcheance:   n.date(reglement.Echeance, "20060102", "DueDateDateTime"),
+		Devise:         strings.TrimSpace(reglement.Devise),
+		Note:           strings.Join(cii.Document.Notes, "\n"),
+		FactureOrigine: strings.TrimSpace(reglement.FactureOrigine),
+		Vendeur:        partieCII(cii.Transaction.Vendeur),
+		Acheteur:       partieCII(cii.Transaction.Acheteur),
+		MontantPrepaye: n.montant(reglement.Totaux.Prepaye, "TotalPrepaidAmount"),
+	}
+	if len(reglement.IBAN) > 0 {
+		doc.Vendeur.IBAN = strings.TrimSpace(reglement.IBAN[0])
+	}
+
+	importe := DocumentImporte{Format: FormatCII}
+	motifs := map[string]string{}
+	for _, t := range reglement.Taxes {
+		ligneTVA := LigneTVA{
+			CategorieTVA:     strings.TrimSpace(t.Categorie),
+			TauxTVA:          n.nombre(t.Taux, "RateApplicablePercent"),
+			BaseHT:           n.montant(t.Base, "BasisAmount"),
+			MontantTVA:       n.montant(t.Montant, "CalculatedAmount"),
+			MotifExoneration: strings.TrimSpace(t.MotifExoneration),
+		}
+		motifs[ligneTVA.CategorieTVA] = ligneTVA.MotifExoneration
+		importe.Ventilation = append(importe.Ventilation, ligneTVA)
+	}
+
+	for _, l := range cii.Transaction.Lignes {
+		prix := n.nombre(l.Prix, "ChargeAmount")
+		if base := n.nombre(l.Base, "BasisQuantity"); base > 0 {
+			prix = prix / base
+		}
+		categorie := strings.TrimSpace(l.Taxe.Categorie)
+		motif := strings.TrimSpace(l.Taxe.MotifExoneration)
+		if motif == "" {
+			motif = motifs[categorie]
+		}
+		doc.Lignes = append(doc.Lignes, LigneFacturation{
+			Description:      strings.TrimSpace(l.Nom),
+			CodeUnite:        strings.TrimSpace(l.Quantite.Unite),
+			Quantite:         n.nombre(l.Quantite.Valeur, "BilledQuantity"),
+			PrixUnitaire:     prix,
+			MontantHT:        n.montant(l.Montant, "LineTotalAmount"),
+			TauxTVA:          n.nombre(l.Taxe.Taux, "RateApplicablePercent"),
+			CategorieTVA:     categorie,
+			MotifExoneration: motif,
+		})
+	}
+
+	totaux := reglement.Totaux
+	importe.TotalRemises = n.montant(totaux.Remises, "AllowanceTotalAmount")
+	importe.TotalFrais = n.montant(totaux.Frais, "ChargeTotalAmount")
+	importe.TotalHT = n.montant(totaux.BaseTaxable, "TaxBasisTotalAmount")
+	if strings.TrimSpace(totaux.BaseTaxable) == "" {
+		importe.TotalHT = n.montant(totaux.TotalLignes, "LineTotalAmount") - importe.TotalRemises + importe.TotalFrais
+	}
+	for _, m := range totaux.TotalTVA {
+		// Le total de TVA peut être répété dans la devise de comptabilisation
+		if m.Devise == "" || m.Devise == doc.Devise {
+			importe.TotalTVA = n.montant(m.Valeur, "TaxTotalAmount")
+			break
+		}
+	}
+	importe.TotalTTC = n.montant(totaux.TotalTTC, "GrandTotalAmount")
+	importe.NetAPayer = n.montant(totaux.NetAPayer, "DuePayableAmount")
+
+	if n.err != nil {
+		return DocumentImporte{}, n.err
+	}
+	importe.Document = doc
+	return importe, nil
+}
+
+func partieCII(p lecturePartieCII) PartieFacturation {
+	partie := PartieFacturation{
+		Nom:        strings.TrimSpace(p.Nom),
+		Adresse:    strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(p.Ligne1)+", "+strings.TrimSpace(p.Ligne2), ", ")),
+		CodePostal: strings.TrimSpace(p.CodePostal),
+		Ville:      strings.TrimSpace(p.Ville),
+		Pays:       strings.TrimSpace(p.Pays),
+		Email:      strings.TrimSpace(p.Email),
+	}
+	if p.Organisation.Schema == "" || p.Organisation.Schema == "0009" || p.Organisation.Schema == "0002" {
+		partie.SIRET = strings.TrimSpace(p.Organisation.Valeur)
+	}
+	for _, id := range p.Enregistrement {
+		if id.Schema == "VA" {
+			partie.NumeroTVA = strings.TrimSpace(id.Valeur)
+		}
+	}
+	return partie
+}
+
+// Lecture UBL 2.1 (Invoice et CreditNote partagent la même structure de lecture)
+
+type lectureQuantite struct {
+	Valeur string `xml:",chardata"`
+	Unite  string `xml:"unitCode,attr"`
+}
+
+type lectureCategorieUBL struct {
+	ID               string `xml:"ID"`
+	Taux             string `xml:"Percent"`
+	MotifExoneration string `xml:"TaxExemptionReason"`
+}
+
+type lecturePartieUBL struct {
+	PointAcces     lectureIdentifiant `xml:"EndpointID"`
+	Nom            string             `xml:"PartyName>Name"`
+	Rue            string             `xml:"PostalAddress>StreetName"`
+	Complement     string             `xml:"PostalAddress>AdditionalStreetName"`
+	Ville          string             `xml:"PostalAddress>CityName"`
+	CodePostal     string             `xml:"PostalAddress>PostalZone"`
+	Pays           string             `xml:"PostalAddress>Country>IdentificationCode"`
+	SchemasFiscaux []struct {
+		ID     string `xml:"CompanyID"`
+		Schema string `xml:"TaxScheme>ID"`
+	} `xml:"PartyTaxScheme"`
+	RaisonSociale string             `xml:"PartyLegalEntity>RegistrationName"`
+	IDLegal       lectureIdentifiant `xml:"PartyLegalEntity>CompanyID"`
+	Email         string             `xml:"Contact>ElectronicMail"`
+}
+
+type lectureLigneUBL struct {
+	QuantiteFacturee lectureQuantite     `xml:"InvoicedQuantity"`
+	QuantiteCreditee lectureQuantite     `xml:"CreditedQuantity"`
+	Montant          string              `xml:"LineExtensionAmount"`
+	Nom              string              `xml:"Item>Name"`
+	Description      string              `xml:"Item>Description"`
+	Categorie        lectureCategorieUBL `xml:"Item>ClassifiedTaxCategory"`
+	Prix             string              `xml:"Price>PriceAmount"`
+	QuantiteBase     string              `xml:"Price>BaseQuantity"`
+}
+
+type lectureUBLDocument struct {
+	XMLName         xml.Name
+	ID              string           `xml:"ID"`
+	DateEmission    string           `xml:"IssueDate"`
+	DateEcheance    string           `xml:"DueDate"`
+	CodeTypeFacture string           `xml:"InvoiceTypeCode"`
+	CodeTypeAvoir   string           `xml:"CreditNoteTypeCode"`
+	Notes           []string         `xml:"Note"`
+	Devise          string           `xml:"DocumentCurrencyCode"`
+	FactureOrigine  string           `xml:"BillingReference>InvoiceDocumentReference>ID"`
+	Vendeur         lecturePartieUBL `xml:"AccountingSupplierParty>Party"`
+	Acheteur        lecturePartieUBL `xml:"AccountingCustomerParty>Party"`
+	MoyensPaiement  []struct {
+		Echeance string `xml:"PaymentDueDate"`
+		IBAN     string `xml:"PayeeFinancialAccount>ID"`
+	} `xml:"PaymentMeans"`
+	TotauxTaxes []struct {
+		Montant    lectureMontant `xml:"TaxAmount"`
+		SousTotaux []struct {
+			Base      string              `xml:"TaxableAmount"`
+			Montant   string              `xml:"TaxAmount"`
+			Categorie lectureCategorieUBL `xml:"TaxCategory"`
+		} `xml:"TaxSubtotal"`
+	} `xml:"TaxTotal"`
+	Totaux struct {
+		TotalLignes string `xml:"LineExtensionAmount"`
+		TotalHT     string `xml:"TaxExclusiveAmount"`
+		TotalTTC    string `xml:"TaxInclusiveAmount"`
+		Remises     string `xml:"AllowanceTotalAmount"`
+		Frais       string `xml:"ChargeTotalAmount"`
+		Prepaye     string `xml:"PrepaidAmount"`
+		NetAPayer   string `xml:"PayableAmount"`
+	} `xml:"LegalMonetaryTotal"`
+	LignesFacture []lectureLigneUBL `xml:"InvoiceLine"`
+	LignesAvoir   []lectureLigneUBL `xml:"CreditNoteLine"`
+}
+
+func lireUBL(donnees []byte) (DocumentImporte, error) {
+	var ubl lectureUBLDocument
+	if err := xml.Unmarshal(donnees, &ubl); err != nil {
+		return DocumentImporte{}, fmt.Errorf("XML UBL illisible : %v", err)
+	}
+
+	var n lecteurNombres
+	avoir := ubl.XMLName.Local == "CreditNote"
+
+	doc := DocumentFacturation{
+		CodeType:       strings.TrimSpace(ubl.CodeTypeFacture),
+		Numero:         strings.TrimSpace(ubl.ID),
+		DateEmission:   n.date(ubl.DateEmission, "2006-01-02", "IssueDate"),
+		DateEcheance:   n.date(ubl.DateEcheance, "2006-01-02", "DueDate"),
+		Devise:         strings.TrimSpace(ubl.Devise),
+		Note:           strings.Join(ubl.Notes, "\n"),
+		FactureOrigine: strings.TrimSpace(ubl.FactureOrigine),
+		Vendeur:        partieUBL(ubl.Vendeur),
+		Acheteur:       partieUBL(ubl.Acheteur),
+		MontantPrepaye: n.montant(ubl.Totaux.Prepaye, "PrepaidAmount"),
+	}
+	if avoir {
+		doc.CodeType = strings.TrimSpace(ubl.CodeTypeAvoir)
+		if doc.CodeType == "" {
+			doc.CodeType = CodeDocumentAvoir
+		}
+	}
+	for _, moyen := range ubl.MoyensPaiement {
+		if doc.Vendeur.IBAN == "" {
+			doc.Vendeur.IBAN = strings.TrimSpace(moyen.IBAN)
+		}
+		if doc.DateEcheance.IsZero() {
+			doc.DateEcheance = n.date(moyen.Echeance, "2006-01-02", "PaymentDueDate")
+		}
+	}
+
+	importe := DocumentImporte{Format: FormatUBL}
+	motifs := map[string]string{}
+	for _, total := range ubl.TotauxTaxes {
+		// Le TaxTotal sans sous-totaux exprime la TVA dans la devise de comptabilisation
+		if len(total.SousTotaux) == 0 && total.Montant.Devise != "" && total.Montant.Devise != doc.Devise {
+			continue
+		}
+		importe.TotalTVA = n.montant(total.Montant.Valeur, "TaxAmount")
+		for _, st := range total.SousTotaux {
+			ligneTVA := LigneTVA{
+				CategorieTVA:     strings.TrimSpace(st.Categorie.ID),
+				TauxTVA:          n.nombre(st.Categorie.Taux, "Percent"),
+				BaseHT:           n.montant(st.Base, "TaxableAmount"),
+				MontantTVA:       n.montant(st.Montant, "TaxAmount"),
+				MotifExoneration: strings.TrimSpace(st.Categorie.MotifExoneration),
+			}
+			motifs[ligneTVA.CategorieTVA] = ligneTVA.MotifExoneration
+			importe.Ventilation = append(importe.Ventilation, ligneTVA)
+		}
+		break
+	}
+
+	lignes := ubl.LignesFacture
+	if avoir {
+		lignes = ubl.LignesAvoir
+	}
+	for _, l := range lignes {
+		quantite := l.QuantiteFacturee
+		if avoir {
+			quantite = l.QuantiteCreditee
+		}
+		prix := n.nombre(l.Prix, "PriceAmount")
+		if base := n.nombre(l.QuantiteBase, "BaseQuantity"); base > 0 {
+			prix = prix / base
+		}
+		description := strings.TrimSpace(l.Nom)
+		if description == "" {
+			description = strings.TrimSpace(l.Description)
+		}
+		categorie := strings.TrimSpace(l.Categorie.ID)
+		doc.Lignes = append(doc.Lignes, LigneFacturation{
+			Description:      description,
+			CodeUnite:        strings.TrimSpace(quantite.Unite),
+			Quantite:         n.nombre(quantite.Valeur, "Quantity"),
+			PrixUnitaire:     prix,
+			MontantHT:        n.montant(l.Montant, "LineExtensionAmount"),
+			TauxTVA:          n.nombre(l.Categorie.Taux, "Percent"),
+			CategorieTVA:     categorie,
+			MotifExoneration: motifs[categorie],
+		})
+	}
+
+	importe.TotalHT = n.montant(ubl.Totaux.TotalHT, "TaxExclusiveAmount")
+	importe.TotalRemises = n.montant(ubl.Totaux.Remises, "AllowanceTotalAmount")
+	importe.TotalFrais = n.montant(ubl.Totaux.Frais, "ChargeTotalAmount")
+	importe.TotalTTC = n.montant(ubl.Totaux.TotalTTC, "TaxInclusiveAmount")
+	importe.NetAPayer = n.montant(ubl.Totaux.NetAPayer, "PayableAmount")
+
+	if n.err != nil {
+		return DocumentImporte{}, n.err
+	}
+	importe.Document = doc
+	return importe, nil
+}
+
+func partieUBL(p lecturePartieUBL) PartieFacturation {
+	partie := PartieFacturation{
+		Nom:        strings.TrimSpace(p.RaisonSociale),
+		Adresse:    strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(p.Rue)+", "+strings.TrimSpace(p.Complement), ", ")),
+		CodePostal: strings.TrimSpace(p.CodePostal),
+		Ville:      strings.TrimSpace(p.Ville),
+		Pays:       strings.TrimSpace(p.Pays),
+		Email:      strings.TrimSpace(p.Email),
+	}
+	if partie.Nom == "" {
+		partie.Nom = strings.TrimSpace(p.Nom)
+	}
+	for _, id := range []lectureIdentifiant{p.IDLegal, p.PointAcces} {
+		if partie.SIRET == "" && (id.Schema == "" || id.Schema == "0009" || id.Schema == "0002") {
+			partie.SIRET = strings.TrimSpace(id.Valeur)
+		}
+	}
+	for _, s := range p.SchemasFiscaux {
+		if strings.TrimSpace(s.Schema) == "VAT" {
+			partie.NumeroTVA = strings.TrimSpace(s.ID)
+		}
+	}
+	return partie
+}
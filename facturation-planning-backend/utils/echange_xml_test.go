@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"facturation-planning/models"
+)
+
+// entrepriseTest est un vendeur complet au sens EN 16931 (SIRET, numéro de TVA, adresse structurée)
+func entrepriseTest() models.Entreprise {
+	return models.Entreprise{
+		Nom:        "Atelier Martin",
+		SIRET:      "123 456 789 00012",
+		TVA:        "FR12123456789",
+		Adresse:    "12 rue des Lilas",
+		CodePostal: "69003",
+		Ville:      "Lyon",
+		Email:      "contact@atelier-martin.fr",
+		IBAN:       "FR76 3000 6000 0112 3456 7890 189",
+		BIC:        "AGRIFRPP",
+	}
+}
+
+// factureTest est une facture émise à plusieurs taux de TVA, avec une ligne exonérée,
+// une remise de ligne et une remise globale
+func factureTest() models.Facture {
+	facture := models.Facture{
+		Reference:         "FAC-2025-0042",
+		ClientNom:         "Boulangerie Durand",
+		ClientAdresse:     "3 place du Marché, 38000 Grenoble",
+		ClientEmail:       "compta@durand.fr",
+		DateEmission:      time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC),
+		DateEcheance:      time.Date(2025, 4, 16, 0, 0, 0, 0, time.UTC),
+		Description:       "Travaux de mars",
+		TypeFacture:       "classique",
+		Statut:            models.StatutFactureEmise,
+		RemisePourcentage: 5,
+		Lignes: []models.LigneFacture{
+			{Description: "Pose de cloisons", Unite: models.UniteMetreCarre, Quantite: 12.5, PrixUnitaire: models.MontantEuros(38.90), TauxTVA: 20, RemisePourcentage: 10},
+			{Description: "Main d'œuvre", Unite: models.UniteHeure, Quantite: 7, PrixUnitaire: models.MontantEuros(45), TauxTVA: 20},
+			{Description: "Rénovation énergétique", Unite: models.UniteForfait, Quantite: 1, PrixUnitaire: models.MontantEuros(1250.33), TauxTVA: 5.5},
+			{Description: "Travaux d'amélioration", Unite: models.UniteJour, Quantite: 2, PrixUnitaire: models.MontantEuros(410.10), TauxTVA: 10, RemiseMontant: models.MontantEuros(20)},
+			{Description: "Formation (exonérée)", Unite: models.UniteUnite, Quantite: 3, PrixUnitaire: models.MontantEuros(99.99), TauxTVA: 0},
+		},
+	}
+	for i := range facture.Lignes {
+		ligne := &facture.Lignes[i]
+		ligne.MontantHT = ligne.MontantHTCalcule()
+		ligne.TotalLigne = ligne.MontantHT
+	}
+	facture.CompleterTVA()
+	facture.SousTotalHT, facture.TotalTVA, facture.TotalTTC = models.TotauxVentilation(facture.VentilationTVA)
+	return facture
+}
+
+// avoirTest crédite une partie des lignes de la facture de test, avec sa part de la remise globale
+func avoirTest(facture models.Facture) models.Avoir {
+	avoir := models.Avoir{
+		Reference:    "AV-2025-0007",
+		ClientNom:    facture.ClientNom,
+		DateEmission: time.Date(2025, 3, 24, 0, 0, 0, 0, time.UTC),
+		TypeAvoir:    models.TypeAvoirPartiel,
+		Motif:        "Prestations non réalisées",
+	}
+	for _, i := range []int{0, 2, 4} {
+		ligne := facture.Lignes[i]
+		quantite := ligne.Quantite / 2
+		montantHT := models.MontantHTRemise(ligne.PrixUnitaire, quantite, ligne.RemisePourcentage, 0)
+		avoir.Lignes = append(avoir.Lignes, models.LigneAvoir{
+			Description:       ligne.Description,
+			Unite:             ligne.Unite,
+			Quantite:          quantite,
+			PrixUnitaire:      ligne.PrixUnitaire,
+			TauxTVA:           ligne.TauxTVA,
+			MontantHT:         montantHT,
+			RemisePourcentage: ligne.RemisePourcentage,
+		})
+	}
+	avoir.RemiseMontant = facture.RemiseGlobale().Proportion(avoir.TotalLignesHT(), facture.TotalLignesHT())
+	avoir.VentilationTVA = avoir.Ventilation()
+	avoir.SousTotalHT, avoir.TotalTVA, avoir.TotalTTC = models.TotauxVentilation(avoir.VentilationTVA)
+	return avoir
+}
+
+func TestEchangeXMLAllerRetour(t *testing.T) {
+	entreprise := entrepriseTest()
+	facture := factureTest()
+	avoir := avoirTest(facture)
+
+	documents := []struct {
+		nom         string
+		document    DocumentFacturation
+		ventilation []models.VentilationTVA
+		totalHT     models.Montant
+		totalTVA    models.Montant
+		totalTTC    models.Montant
+	}{
+		{"facture", DocumentDepuisFacture(facture, entreprise), facture.VentilationTVA, facture.SousTotalHT, facture.TotalTVA, facture.TotalTTC},
+		{"avoir", DocumentDepuisAvoir(avoir, facture, entreprise), avoir.VentilationTVA, avoir.SousTotalHT, avoir.TotalTVA, avoir.TotalTTC},
+	}
+
+	for _, d := range documents {
+		// Au moins deux taux soumis à TVA et la ligne exonérée
+		if len(d.ventilation) < 3 {
+			t.Fatalf("%s de test ventilée sur %d taux, attendu au moins 3", d.nom, len(d.ventilation))
+		}
+		for _, format := range []string{FormatUBL, FormatCII} {
+			t.Run(d.nom+"/"+format, func(t *testing.T) {
+				contenu, err := GenererXML(d.document, format)
+				if err != nil {
+					t.Fatalf("export : %v", err)
+				}
+				importe, err := LireDocumentXML(contenu)
+				if err != nil {
+					t.Fatalf("import : %v\n%s", err, contenu)
+				}
+
+				if importe.Format != format {
+					t.Errorf("format détecté %q, attendu %q", importe.Format, format)
+				}
+				if importe.Document.Numero != d.document.Numero || importe.Document.CodeType != d.document.CodeType {
+					t.Errorf("document %s (%s), attendu %s (%s)", importe.Document.Numero, importe.Document.CodeType, d.document.Numero, d.document.CodeType)
+				}
+				if importe.Document.FactureOrigine != d.document.FactureOrigine {
+					t.Errorf("facture d'origine %q, attendu %q", importe.Document.FactureOrigine, d.document.FactureOrigine)
+				}
+				if len(importe.Document.Lignes) != len(d.document.Lignes) {
+					t.Fatalf("%d lignes relues, attendu %d", len(importe.Document.Lignes), len(d.document.Lignes))
+				}
+
+				if importe.TotalHT != d.totalHT || importe.TotalTVA != d.totalTVA || importe.TotalTTC != d.totalTTC {
+					t.Errorf("totaux relus HT %s TVA %s TTC %s, attendu %s / %s / %s",
+						importe.TotalHT, importe.TotalTVA, importe.TotalTTC, d.totalHT, d.totalTVA, d.totalTTC)
+				}
+
+				// Ventilation par taux : base HT, TVA et TTC identiques à celles du document d'origine
+				relue := map[float64]LigneTVA{}
+				for _, v := range importe.Ventilation {
+					relue[v.TauxTVA] = v
+				}
+				if len(relue) != len(d.ventilation) {
+					t.Fatalf("%d taux relus, attendu %d", len(relue), len(d.ventilation))
+				}
+				for _, attendu := range d.ventilation {
+					v, ok := relue[attendu.Taux]
+					if !ok {
+						t.Errorf("taux %v absent du document relu", attendu.Taux)
+						continue
+					}
+					if v.BaseHT != attendu.Base || v.MontantTVA != attendu.TVA || v.BaseHT+v.MontantTVA != attendu.TTC {
+						t.Errorf("taux %v : HT %s TVA %s TTC %s, attendu %s / %s / %s",
+							attendu.Taux, v.BaseHT, v.MontantTVA, v.BaseHT+v.MontantTVA, attendu.Base, attendu.TVA, attendu.TTC)
+					}
+				}
+
+				exoneree, ok := relue[0]
+				if !ok || exoneree.CategorieTVA != CategorieTVAExoneree || exoneree.MotifExoneration == "" {
+					t.Errorf("ligne exonérée relue en catégorie %q, motif %q", exoneree.CategorieTVA, exoneree.MotifExoneration)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,803 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"facturation-planning/models"
+)
+
+// Codes de type de document (UNTDID 1001)
+const (
+	CodeDocumentFacture = "380"
+	CodeDocumentAcompte = "386"
+	CodeDocumentAvoir   = "381"
+)
+
+// Catégories de TVA (UNTDID 5305)
+const (
+	CategorieTVAStandard           = "S"
+	CategorieTVAExoneree           = "E"
+	CategorieTVAAutoliquidation    = "AE"
+	CategorieTVAIntracommunautaire = "K"
+)
+
+// Motif des remises de ligne et de document (UNTDID 5189 : 95 = remise)
+const (
+	CodeMotifRemise = "95"
+	MotifRemise     = "Remise"
+)
+
+// Profil EN 16931 (Factur-X « EN 16931 » / « COMFORT »)
+const ProfilEN16931 = "urn:cen.eu:en16931:2017"
+
+// MotifExonerationParDefaut est la mention portée sur les lignes sans TVA d'une facture sans motif d'exonération
+const MotifExonerationParDefaut = "TVA non applicable, art. 293 B du CGI"
+
+// categoriesExoneration associe à chaque motif d'exonération sa catégorie de TVA et son code VATEX (BT-121)
+var categoriesExoneration = map[string]struct{ categorie, code string }{
+	models.ExonerationTVAFranchise:          {CategorieTVAExoneree, "VATEX-FR-FRANCHISE"},
+	models.ExonerationTVAAutoliquidation:    {CategorieTVAAutoliquidation, "VATEX-EU-AE"},
+	models.ExonerationTVAIntracommunautaire: {CategorieTVAIntracommunautaire, "VATEX-EU-IC"},
+}
+
+// PartieFacturation décrit le vendeur ou l'acheteur d'une facture électronique
+type PartieFacturation struct {
+	Nom        string
+	SIRET      string
+	NumeroTVA  string
+	Adresse    string
+	CodePostal string
+	Ville      string
+	Pays       string // Code ISO 3166-1 alpha-2
+	Email      string
+	IBAN       string
+	BIC        string
+}
+
+// LigneFacturation est une ligne de facture électronique
+type LigneFacturation struct {
+	Description      string
+	CodeUnite        string // Code UN/ECE recommandation 20 (C62, HUR, DAY...)
+	Quantite         float64
+	PrixUnitaire     float64        // Peut comporter plus de deux décimales (BT-146)
+	MontantHT        models.Montant // Remise de la ligne déduite (BT-131)
+	TauxTVA          float64
+	CategorieTVA     string
+	MotifExoneration string
+	CodeExoneration  string // Code VATEX du motif d'exonération
+
+	// Remise de la ligne (BG-27) : montant (BT-136), base (BT-137) et pourcentage (BT-138, facultatif)
+	Remise            models.Montant
+	BaseRemise        models.Montant
+	PourcentageRemise float64
+}
+
+// RemiseDocument est une remise au niveau du document (BG-20), ventilée par catégorie et taux de TVA
+type RemiseDocument struct {
+	Montant      models.Montant // BT-92
+	TauxTVA      float64        // BT-96
+	CategorieTVA string         // BT-95
+}
+
+// LigneTVA est le total d'une catégorie et d'un taux de TVA (ventilation BG-23)
+type LigneTVA struct {
+	CategorieTVA     string
+	TauxTVA          float64
+	BaseHT           models.Montant
+	MontantTVA       models.Montant
+	MotifExoneration string
+	CodeExoneration  string
+}
+
+// DocumentFacturation est la représentation normalisée (EN 16931) d'une facture ou d'un avoir,
+// indépendante de la syntaxe XML utilisée pour l'échange
+type DocumentFacturation struct {
+	CodeType       string
+	Numero         string
+	DateEmission   time.Time
+	DateEcheance   time.Time
+	Devise         string
+	Note           string
+	FactureOrigine string // Référence de la facture corrigée (avoirs)
+	Vendeur        PartieFacturation
+	Acheteur       PartieFacturation
+	Lignes         []LigneFacturation
+	Remises        []RemiseDocument // Remise globale, déduite de la base HT de chaque taux
+	MontantPrepaye models.Montant
+}
+
+// DocumentDepuisFacture construit le document normalisé d'une facture émise.
+// Le client de la facture doit être préchargé pour disposer de l'adresse structurée.
+func DocumentDepuisFacture(facture models.Facture, entreprise models.Entreprise) DocumentFacturation {
+	doc := DocumentFacturation{
+		CodeType:     CodeDocumentFacture,
+		Numero:       facture.Reference,
+		DateEmission: facture.DateEmission,
+		DateEcheance: facture.DateEcheance,
+		Devise:       "EUR",
+		Note:         facture.Description,
+		Vendeur:      partieEntreprise(entreprise),
+		Acheteur:     partieClient(facture.ClientNom, facture.ClientAdresse, facture.ClientEmail, facture.Client),
+	}
+	if facture.TypeFacture == "acompte" {
+		doc.CodeType = CodeDocumentAcompte
+	}
+
+	for _, l := range facture.Lignes {
+		ligne := nouvelleLigneFacturation(l.Description, l.Unite, l.Quantite, l.PrixUnitaire, l.MontantHTLigne(), l.TauxTVA, facture.MotifExonerationTVA)
+		if l.RemisePourcentage != 0 || l.RemiseMontant != 0 {
+			ligne.remiser(l.PrixUnitaire.Multiplier(l.Quantite), l.RemisePourcentage)
+		}
+		doc.Lignes = append(doc.Lignes, ligne)
+	}
+	if len(facture.Lignes) > 0 {
+		doc.Remises = remisesDocument(facture.Ventilation(), facture.MotifExonerationTVA)
+	}
+
+	// Anciennes factures sans lignes : une ligne unique reprenant le total HT
+	if len(doc.Lignes) == 0 {
+		description := facture.Description
+		if description == "" {
+			description = "Prestation"
+		}
+		doc.Lignes = append(doc.Lignes, nouvelleLigneFacturation(description, "", 1, facture.SousTotalHT, facture.SousTotalHT, facture.TauxTVA, facture.MotifExonerationTVA))
+	}
+
+	return doc
+}
+
+// DocumentDepuisAvoir construit le document normalisé d'un avoir (facture rectificative 381).
+// La facture d'origine fournit l'adresse structurée du client lorsqu'elle est préchargée avec son client.
+func DocumentDepuisAvoir(avoir models.Avoir, facture models.Facture, entreprise models.Entreprise) DocumentFacturation {
+	doc := DocumentFacturation{
+		CodeType:       CodeDocumentAvoir,
+		Numero:         avoir.Reference,
+		DateEmission:   avoir.DateEmission,
+		Devise:         "EUR",
+		Note:           avoir.Motif,
+		FactureOrigine: facture.Reference,
+		Vendeur:        partieEntreprise(entreprise),
+		Acheteur:       partieClient(avoir.ClientNom, avoir.ClientAdresse, facture.ClientEmail, facture.Client),
+	}
+
+	for _, l := range avoir.Lignes {
+		ligne := nouvelleLigneFacturation(l.Description, l.Unite, l.Quantite, l.PrixUnitaire, l.MontantHT, l.TauxTVA, facture.MotifExonerationTVA)
+		if l.RemisePourcentage != 0 || l.RemiseMontant != 0 {
+			ligne.remiser(l.PrixUnitaire.Multiplier(l.Quantite), l.RemisePourcentage)
+		}
+		doc.Lignes = append(doc.Lignes, ligne)
+	}
+	if len(avoir.Lignes) > 0 {
+		doc.Remises = remisesDocument(avoir.Ventilation(), facture.MotifExonerationTVA)
+	}
+	if len(doc.Lignes) == 0 {
+		doc.Lignes = append(doc.Lignes, nouvelleLigneFacturation("Avoir sur facture "+facture.Reference, "", 1, avoir.SousTotalHT, avoir.SousTotalHT, facture.TauxTVA, facture.MotifExonerationTVA))
+	}
+
+	return doc
+}
+
+// nouvelleLigneFacturation normalise une ligne (unité, catégorie de TVA selon le motif d'exonération de la facture)
+func nouvelleLigneFacturation(description, unite string, quantite float64, prixUnitaire, montantHT models.Montant, tauxTVA float64, exoneration string) LigneFacturation {
+	ligne := LigneFacturation{
+		Description:  description,
+		CodeUnite:    CodeUnite(unite),
+		Quantite:     quantite,
+		PrixUnitaire: prixUnitaire.Euros(),
+		MontantHT:    montantHT,
+		TauxTVA:      tauxTVA,
+	}
+	ligne.CategorieTVA, ligne.CodeExoneration, ligne.MotifExoneration = categorieTVA(tauxTVA, exoneration)
+	return ligne
+}
+
+// categorieTVA retourne la catégorie de TVA d'un taux, avec le code et la mention d'exonération d'un taux nul
+func categorieTVA(tauxTVA float64, exoneration string) (categorie, code, motif string) {
+	if tauxTVA != 0 {
+		return CategorieTVAStandard, "", ""
+	}
+	if c, ok := categoriesExoneration[exoneration]; ok {
+		return c.categorie, c.code, models.MentionExonerationTVA(exoneration)
+	}
+	return CategorieTVAExoneree, "", MotifExonerationParDefaut
+}
+
+// remiser renseigne la remise de la ligne : écart entre le montant brut (quantité × prix unitaire) et le montant HT,
+// le pourcentage n'étant transmis que pour une remise exprimée en pourcentage
+func (l *LigneFacturation) remiser(brut models.Montant, pourcentage float64) {
+	l.Remise, l.BaseRemise, l.PourcentageRemise = brut-l.MontantHT, brut, pourcentage
+}
+
+// remisesDocument convertit la remise globale répartie par taux d'une ventilation en remises de document
+func remisesDocument(ventilation []models.VentilationTVA, exoneration string) []RemiseDocument {
+	var remises []RemiseDocument
+	for _, v := range ventilation {
+		if v.Remise == 0 {
+			continue
+		}
+		categorie, _, _ := categorieTVA(v.Taux, exoneration)
+		remises = append(remises, RemiseDocument{Montant: v.Remise, TauxTVA: v.Taux, CategorieTVA: categorie})
+	}
+	return remises
+}
+
+// partieEntreprise construit le vendeur à partir de la fiche entreprise
+func partieEntreprise(entreprise models.Entreprise) PartieFacturation {
+	partie := PartieFacturation{
+		Nom:       entreprise.Nom,
+		SIRET:     strings.ReplaceAll(entreprise.SIRET, " ", ""),
+		NumeroTVA: strings.ReplaceAll(entreprise.TVA, " ", ""),
+		Pays:      "FR",
+		Email:     entreprise.Email,
+		IBAN:      strings.ReplaceAll(entreprise.IBAN, " ", ""),
+		BIC:       entreprise.BIC,
+	}
+	if entreprise.CodePostal != "" || entreprise.Ville != "" {
+		partie.Adresse, partie.CodePostal, partie.Ville = entreprise.Adresse, entreprise.CodePostal, entreprise.Ville
+	} else {
+		partie.Adresse, partie.CodePostal, partie.Ville = decouperAdresse(entreprise.Adresse)
+	}
+	return partie
+}
+
+// partieClient construit l'acheteur à partir de la copie figée sur la facture,
+// complétée par l'adresse structurée du client lorsqu'elle est disponible
+func partieClient(nom, adresse, email string, client models.Client) PartieFacturation {
+	partie := PartieFacturation{
+		Nom:       nom,
+		Pays:      "FR",
+		Email:     email,
+		NumeroTVA: strings.ToUpper(strings.ReplaceAll(client.NumeroTVA, " ", "")),
+	}
+	if partie.Nom == "" && client.ID != 0 {
+		partie.Nom = client.GetDisplayName()
+	}
+	if pays := paysNumeroTVA(partie.NumeroTVA); pays != "" {
+		partie.Pays = pays
+	}
+
+	if client.ID != 0 && client.CodePostal != "" {
+		partie.Adresse = client.Adresse
+		if client.ComplementAdresse != "" {
+			partie.Adresse += ", " + client.ComplementAdresse
+		}
+		partie.CodePostal = client.CodePostal
+		partie.Ville = client.Ville
+	} else {
+		partie.Adresse, partie.CodePostal, partie.Ville = decouperAdresse(adresse)
+	}
+	if partie.Email == "" {
+		partie.Email = client.Email
+	}
+	return partie
+}
+
+var regexpNumeroTVA = regexp.MustCompile(`^([A-Z]{2})[0-9A-Z]{2,13}$`)
+
+// paysNumeroTVA retourne le code pays ISO 3166-1 d'un numéro de TVA intracommunautaire (la Grèce utilise le préfixe EL)
+func paysNumeroTVA(numero string) string {
+	m := regexpNumeroTVA.FindStringSubmatch(numero)
+	if m == nil {
+		return ""
+	}
+	if m[1] == "EL" {
+		return "GR"
+	}
+	return m[1]
+}
+
+var regexpCodePostalVille = regexp.MustCompile(`^(.*?),?\s*(\d{5})\s+([^,\d][^,]*)$`)
+
+// decouperAdresse sépare « 12 rue X, 75001 Paris » en rue, code postal et ville
+func decouperAdresse(adresse string) (rue, codePostal, ville string) {
+	adresse = strings.TrimSpace(adresse)
+	if m := regexpCodePostalVille.FindStringSubmatch(adresse); m != nil {
+		return strings.TrimSpace(m[1]), m[2], strings.TrimSpace(m[3])
+	}
+	return adresse, "", ""
+}
+
+// CodeUnite convertit une unité libre en code UN/ECE recommandation 20
+func CodeUnite(unite string) string {
+	switch strings.ToLower(strings.TrimSpace(unite)) {
+	case "h", "heure", "heures":
+		return "HUR"
+	case "j", "jour", "jours":
+		return "DAY"
+	case "semaine", "semaines":
+		return "WEE"
+	case "mois":
+		return "MON"
+	case "forfait":
+		return "LS"
+	case "m2", "m²":
+		return "MTK"
+	case "kg":
+		return "KGM"
+	default:
+		return "C62"
+	}
+}
+
+// VentilationTVA regroupe les lignes par catégorie et taux de TVA, remises du document déduites ;
+// la TVA est arrondie par taux
+func (d DocumentFacturation) VentilationTVA() []LigneTVA {
+	type cle struct {
+		categorie string
+		taux      float64
+	}
+	groupes := map[cle]*LigneTVA{}
+	var ordre []cle
+
+	for _, l := range d.Lignes {
+		k := cle{l.CategorieTVA, l.TauxTVA}
+		groupe, ok := groupes[k]
+		if !ok {
+			groupe = &LigneTVA{CategorieTVA: l.CategorieTVA, TauxTVA: l.TauxTVA, MotifExoneration: l.MotifExoneration, CodeExoneration: l.CodeExoneration}
+			groupes[k] = groupe
+			ordre = append(ordre, k)
+		}
+		groupe.BaseHT += l.MontantHT
+	}
+	for _, remise := range d.Remises {
+		if groupe, ok := groupes[cle{remise.CategorieTVA, remise.TauxTVA}]; ok {
+			groupe.BaseHT -= remise.Montant
+		}
+	}
+
+	sort.Slice(ordre, func(i, j int) bool {
+		if ordre[i].taux != ordre[j].taux {
+			return ordre[i].taux > ordre[j].taux
+		}
+		return ordre[i].categorie < ordre[j].categorie
+	})
+
+	ventilation := make([]LigneTVA, 0, len(ordre))
+	for _, k := range ordre {
+		groupe := groupes[k]
+		groupe.MontantTVA = groupe.BaseHT.Pourcentage(groupe.TauxTVA)
+		ventilation = append(ventilation, *groupe)
+	}
+	return ventilation
+}
+
+// TotalLignes retourne la somme des montants HT des lignes (BT-106)
+func (d DocumentFacturation) TotalLignes() models.Montant {
+	var total models.Montant
+	for _, l := range d.Lignes {
+		total += l.MontantHT
+	}
+	return total
+}
+
+// TotalRemises retourne la somme des remises du document (BT-107)
+func (d DocumentFacturation) TotalRemises() models.Montant {
+	var total models.Montant
+	for _, remise := range d.Remises {
+		total += remise.Montant
+	}
+	return total
+}
+
+// Totaux retourne le total HT (remises du document déduites), le total de TVA et le total TTC du document
+func (d DocumentFacturation) Totaux() (totalHT, totalTVA, totalTTC models.Montant) {
+	for _, groupe := range d.VentilationTVA() {
+		totalHT += groupe.BaseHT
+		totalTVA += groupe.MontantTVA
+	}
+	return totalHT, totalTVA, totalHT + totalTVA
+}
+
+// Valider contrôle les informations obligatoires du profil EN 16931 et des règles françaises
+// (identifiants du vendeur) avant toute génération XML
+func (d DocumentFacturation) Valider() error {
+	var manques []string
+
+	if d.Numero == "" {
+		manques = append(manques, "numéro de facture (BT-1) manquant")
+	}
+	if d.DateEmission.IsZero() {
+		manques = append(manques, "date d'émission (BT-2) manquante")
+	}
+	if d.CodeType == "" {
+		manques = append(manques, "type de document (BT-3) manquant")
+	}
+	if d.Devise == "" {
+		manques = append(manques, "devise (BT-5) manquante")
+	}
+	if d.Vendeur.Nom == "" {
+		manques = append(manques, "nom du vendeur (BT-27) manquant")
+	}
+	if d.Vendeur.Pays == "" {
+		manques = append(manques, "pays du vendeur (BT-40) manquant")
+	}
+	if len(d.Vendeur.SIRET) != 14 {
+		manques = append(manques, "SIRET de l'entreprise (BT-30) manquant ou invalide")
+	}
+	if d.Acheteur.Nom == "" {
+		manques = append(manques, "nom de l'acheteur (BT-44) manquant")
+	}
+	if d.Acheteur.Pays == "" {
+		manques = append(manques, "pays de l'acheteur (BT-55) manquant")
+	}
+	if len(d.Lignes) == 0 {
+		manques = append(manques, "aucune ligne de facture (BR-16)")
+	}
+
+	soumisATVA, autoliquidation := false, false
+	for i, l := range d.Lignes {
+		if l.Description == "" {
+			manques = append(manques, fmt.Sprintf("ligne %d : désignation (BT-153) manquante", i+1))
+		}
+		if l.CategorieTVA == "" {
+			manques = append(manques, fmt.Sprintf("ligne %d : catégorie de TVA (BT-151) manquante", i+1))
+		}
+		if l.CategorieTVA == CategorieTVAStandard {
+			soumisATVA = true
+			if l.TauxTVA <= 0 {
+				manques = append(manques, fmt.Sprintf("ligne %d : taux de TVA (BT-152) manquant", i+1))
+			}
+		}
+		switch l.CategorieTVA {
+		case CategorieTVAExoneree, CategorieTVAAutoliquidation, CategorieTVAIntracommunautaire:
+			if l.TauxTVA != 0 {
+				manques = append(manques, fmt.Sprintf("ligne %d : une ligne exonérée doit avoir un taux nul", i+1))
+			}
+		}
+		if l.CategorieTVA == CategorieTVAAutoliquidation || l.CategorieTVA == CategorieTVAIntracommunautaire {
+			autoliquidation = true
+		}
+	}
+
+	if (soumisATVA || autoliquidation) && d.Vendeur.NumeroTVA == "" {
+		manques = append(manques, "numéro de TVA intracommunautaire de l'entreprise (BT-31) manquant")
+	}
+	if autoliquidation && d.Acheteur.NumeroTVA == "" {
+		manques = append(manques, "numéro de TVA intracommunautaire du client (BT-48) manquant pour l'autoliquidation")
+	}
+	if d.CodeType == CodeDocumentAvoir && d.FactureOrigine == "" {
+		manques = append(manques, "référence de la facture d'origine (BT-25) manquante")
+	}
+
+	if len(manques) > 0 {
+		return fmt.Errorf("document non conforme EN 16931 : %s", strings.Join(manques, " ; "))
+	}
+	return nil
+}
+
+// GenererCII sérialise le document au format UN/CEFACT Cross Industry Invoice D16B (profil EN 16931)
+func GenererCII(d DocumentFacturation) ([]byte, error) {
+	if err := d.Valider(); err != nil {
+		return nil, err
+	}
+
+	totalHT, totalTVA, totalTTC := d.Totaux()
+
+	facture := ciiFacture{
+		XmlnsRsm: "urn:un:unece:uncefact:data:standard:CrossIndustryInvoice:100",
+		XmlnsRam: "urn:un:unece:uncefact:data:standard:ReusableAggregateBusinessInformationEntity:100",
+		XmlnsQdt: "urn:un:unece:uncefact:data:standard:QualifiedDataType:100",
+		XmlnsUdt: "urn:un:unece:uncefact:data:standard:UnqualifiedDataType:100",
+		Contexte: ciiContexte{Profil: ciiID{Valeur: ProfilEN16931}},
+		Document: ciiDocument{
+			ID:       d.Numero,
+			TypeCode: d.CodeType,
+			Date:     ciiDate(d.DateEmission),
+		},
+	}
+	if d.Note != "" {
+		facture.Document.Notes = []ciiNote{{Contenu: d.Note}}
+	}
+
+	transaction := &facture.Transaction
+	for i, l := range d.Lignes {
+		ligne := ciiLigne{
+			Document: ciiLigneDocument{LineID: strconv.Itoa(i + 1)},
+			Produit:  ciiProduit{Nom: l.Description},
+		}
+		ligne.Accord.PrixNet.Montant = formatPrixUnitaire(l.PrixUnitaire)
+		ligne.Livraison.Quantite = ciiQuantite{Valeur: formatQuantite(l.Quantite), Unite: l.CodeUnite}
+		ligne.Reglement.Taxe = ciiTaxe{TypeCode: "VAT", Categorie: l.CategorieTVA, Taux: formatTaux(l.TauxTVA)}
+		if l.Remise != 0 {
+			ligne.Reglement.Remise = ciiRemiseLigne(l)
+		}
+		ligne.Reglement.Totaux.MontantLigne = formatMontant(l.MontantHT)
+		transaction.Lignes = append(transaction.Lignes, ligne)
+	}
+
+	transaction.Accord.Vendeur = ciiPartie(d.Vendeur, true)
+	transaction.Accord.Acheteur = ciiPartie(d.Acheteur, false)
+
+	reglement := &transaction.Reglement
+	reglement.Devise = d.Devise
+	if d.Vendeur.IBAN != "" {
+		reglement.MoyenPaiement = &ciiMoyenPaiement{
+			TypeCode: "58", // Virement SEPA
+			Compte:   &ciiCompte{IBAN: d.Vendeur.IBAN},
+		}
+	}
+	for _, groupe := range d.VentilationTVA() {
+		reglement.Taxes = append(reglement.Taxes, ciiTaxe{
+			Montant:          formatMontant(groupe.MontantTVA),
+			TypeCode:         "VAT",
+			MotifExoneration: groupe.MotifExoneration,
+			Base:             formatMontant(groupe.BaseHT),
+			Categorie:        groupe.CategorieTVA,
+			CodeExoneration:  groupe.CodeExoneration,
+			Taux:             formatTaux(groupe.TauxTVA),
+		})
+	}
+	for _, remise := range d.Remises {
+		reglement.Remises = append(reglement.Remises, ciiRemise{
+			Montant:   formatMontant(remise.Montant),
+			CodeMotif: CodeMotifRemise,
+			Motif:     MotifRemise,
+			Taxe:      &ciiTaxe{TypeCode: "VAT", Categorie: remise.CategorieTVA, Taux: formatTaux(remise.TauxTVA)},
+		})
+	}
+	if d.FactureOrigine == "" && !d.DateEcheance.IsZero() {
+		reglement.Conditions = &ciiConditions{Echeance: ciiDate(d.DateEcheance)}
+	}
+	reglement.Totaux = ciiTotaux{
+		TotalLignes:   formatMontant(d.TotalLignes()),
+		BaseTaxable:   formatMontant(totalHT),
+		TotalTVA:      ciiMontant{Valeur: formatMontant(totalTVA), Devise: d.Devise},
+		TotalTTC:      formatMontant(totalTTC),
+		MontantPrepay: formatMontant(d.MontantPrepaye),
+		NetAPayer:     formatMontant(totalTTC - d.MontantPrepaye),
+	}
+	if remises := d.TotalRemises(); remises != 0 {
+		reglement.Totaux.TotalRemises = formatMontant(remises)
+	}
+	if d.FactureOrigine != "" {
+		reglement.FactureOrigine = &ciiDocumentReference{ID: d.FactureOrigine}
+	}
+
+	contenu, err := xml.MarshalIndent(facture, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("sérialisation CII : %v", err)
+	}
+	return append([]byte(xml.Header), contenu...), nil
+}
+
+// ciiRemiseLigne convertit la remise d'une ligne (BG-27)
+func ciiRemiseLigne(l LigneFacturation) *ciiRemise {
+	remise := &ciiRemise{
+		Base:      formatMontant(l.BaseRemise),
+		Montant:   formatMontant(l.Remise),
+		CodeMotif: CodeMotifRemise,
+		Motif:     MotifRemise,
+	}
+	if l.PourcentageRemise != 0 {
+		remise.Pourcentage = formatTaux(l.PourcentageRemise)
+	}
+	return remise
+}
+
+// ciiPartie convertit une partie ; les identifiants légaux ne sont portés que pour le vendeur
+func ciiPartie(p PartieFacturation, vendeur bool) ciiTradeParty {
+	partie := ciiTradeParty{
+		Nom: p.Nom,
+		Adresse: ciiAdresse{
+			CodePostal: p.CodePostal,
+			Ligne1:     p.Adresse,
+			Ville:      p.Ville,
+			Pays:       p.Pays,
+		},
+	}
+	if p.SIRET != "" {
+		partie.Organisation = &ciiOrganisation{ID: ciiID{Valeur: p.SIRET, Schema: "0009"}}
+	}
+	if vendeur && p.Email != "" {
+		partie.Communication = &ciiCommunication{URI: ciiID{Valeur: p.Email, Schema: "EM"}}
+	}
+	if p.NumeroTVA != "" {
+		partie.EnregistrementsFiscaux = []ciiEnregistrementFiscal{{ID: ciiID{Valeur: p.NumeroTVA, Schema: "VA"}}}
+	}
+	return partie
+}
+
+func ciiDate(t time.Time) ciiDateTime {
+	return ciiDateTime{Valeur: ciiChaineDate{Format: "102", Valeur: t.Format("20060102")}}
+}
+
+func formatMontant(v models.Montant) string {
+	return v.String()
+}
+
+func formatPrixUnitaire(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10000)/10000, 'f', -1, 64)
+}
+
+func formatQuantite(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10000)/10000, 'f', -1, 64)
+}
+
+func formatTaux(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// Structures XML du Cross Industry Invoice (ordre des éléments imposé par le schéma D16B)
+
+type ciiFacture struct {
+	XMLName     xml.Name       `xml:"rsm:CrossIndustryInvoice"`
+	XmlnsRsm    string         `xml:"xmlns:rsm,attr"`
+	XmlnsRam    string         `xml:"xmlns:ram,attr"`
+	XmlnsQdt    string         `xml:"xmlns:qdt,attr"`
+	XmlnsUdt    string         `xml:"xmlns:udt,attr"`
+	Contexte    ciiContexte    `xml:"rsm:ExchangedDocumentContext"`
+	Document    ciiDocument    `xml:"rsm:ExchangedDocument"`
+	Transaction ciiTransaction `xml:"rsm:SupplyChainTradeTransaction"`
+}
+
+type ciiContexte struct {
+	Profil ciiID `xml:"ram:GuidelineSpecifiedDocumentContextParameter>ram:ID"`
+}
+
+type ciiDocument struct {
+	ID       string      `xml:"ram:ID"`
+	TypeCode string      `xml:"ram:TypeCode"`
+	Date     ciiDateTime `xml:"ram:IssueDateTime"`
+	Notes    []ciiNote   `xml:"ram:IncludedNote"`
+}
+
+type ciiNote struct {
+	Contenu string `xml:"ram:Content"`
+}
+
+type ciiID struct {
+	Valeur string `xml:",chardata"`
+	Schema string `xml:"schemeID,attr,omitempty"`
+}
+
+type ciiDateTime struct {
+	Valeur ciiChaineDate `xml:"udt:DateTimeString"`
+}
+
+type ciiChaineDate struct {
+	Valeur string `xml:",chardata"`
+	Format string `xml:"format,attr"`
+}
+
+type ciiMontant struct {
+	Valeur string `xml:",chardata"`
+	Devise string `xml:"currencyID,attr,omitempty"`
+}
+
+type ciiQuantite struct {
+	Valeur string `xml:",chardata"`
+	Unite  string `xml:"unitCode,attr"`
+}
+
+type ciiTransaction struct {
+	Lignes    []ciiLigne         `xml:"ram:IncludedSupplyChainTradeLineItem"`
+	Accord    ciiAccord          `xml:"ram:ApplicableHeaderTradeAgreement"`
+	Livraison struct{}           `xml:"ram:ApplicableHeaderTradeDelivery"`
+	Reglement ciiReglementEnTete `xml:"ram:ApplicableHeaderTradeSettlement"`
+}
+
+type ciiLigne struct {
+	Document ciiLigneDocument `xml:"ram:AssociatedDocumentLineDocument"`
+	Produit  ciiProduit       `xml:"ram:SpecifiedTradeProduct"`
+	Accord   struct {
+		PrixNet struct {
+			Montant string `xml:"ram:ChargeAmount"`
+		} `xml:"ram:NetPriceProductTradePrice"`
+	} `xml:"ram:SpecifiedLineTradeAgreement"`
+	Livraison struct {
+		Quantite ciiQuantite `xml:"ram:BilledQuantity"`
+	} `xml:"ram:SpecifiedLineTradeDelivery"`
+	Reglement struct {
+		Taxe   ciiTaxe    `xml:"ram:ApplicableTradeTax"`
+		Remise *ciiRemise `xml:"ram:SpecifiedTradeAllowanceCharge"`
+		Totaux struct {
+			MontantLigne string `xml:"ram:LineTotalAmount"`
+		} `xml:"ram:SpecifiedTradeSettlementLineMonetarySummation"`
+	} `xml:"ram:SpecifiedLineTradeSettlement"`
+}
+
+type ciiLigneDocument struct {
+	LineID string `xml:"ram:LineID"`
+}
+
+type ciiProduit struct {
+	Nom string `xml:"ram:Name"`
+}
+
+type ciiAccord struct {
+	Vendeur  ciiTradeParty `xml:"ram:SellerTradeParty"`
+	Acheteur ciiTradeParty `xml:"ram:BuyerTradeParty"`
+}
+
+type ciiTradeParty struct {
+	Nom                    string                    `xml:"ram:Name"`
+	Organisation           *ciiOrganisation          `xml:"ram:SpecifiedLegalOrganization"`
+	Adresse                ciiAdresse                `xml:"ram:PostalTradeAddress"`
+	Communication          *ciiCommunication         `xml:"ram:URIUniversalCommunication"`
+	EnregistrementsFiscaux []ciiEnregistrementFiscal `xml:"ram:SpecifiedTaxRegistration"`
+}
+
+type ciiOrganisation struct {
+	ID ciiID `xml:"ram:ID"`
+}
+
+type ciiAdresse struct {
+	CodePostal string `xml:"ram:PostcodeCode,omitempty"`
+	Ligne1     string `xml:"ram:LineOne,omitempty"`
+	Ville      string `xml:"ram:CityName,omitempty"`
+	Pays       string `xml:"ram:CountryID"`
+}
+
+type ciiCommunication struct {
+	URI ciiID `xml:"ram:URIID"`
+}
+
+type ciiEnregistrementFiscal struct {
+	ID ciiID `xml:"ram:ID"`
+}
+
+type ciiReglementEnTete struct {
+	Devise         string                `xml:"ram:InvoiceCurrencyCode"`
+	MoyenPaiement  *ciiMoyenPaiement     `xml:"ram:SpecifiedTradeSettlementPaymentMeans"`
+	Taxes          []ciiTaxe             `xml:"ram:ApplicableTradeTax"`
+	Remises        []ciiRemise           `xml:"ram:SpecifiedTradeAllowanceCharge"`
+	Conditions     *ciiConditions        `xml:"ram:SpecifiedTradePaymentTerms"`
+	Totaux         ciiTotaux             `xml:"ram:SpecifiedTradeSettlementHeaderMonetarySummation"`
+	FactureOrigine *ciiDocumentReference `xml:"ram:InvoiceReferencedDocument"`
+}
+
+type ciiMoyenPaiement struct {
+	TypeCode string     `xml:"ram:TypeCode"`
+	Compte   *ciiCompte `xml:"ram:PayeePartyCreditorFinancialAccount"`
+}
+
+type ciiCompte struct {
+	IBAN string `xml:"ram:IBANID"`
+}
+
+type ciiTaxe struct {
+	Montant          string `xml:"ram:CalculatedAmount,omitempty"`
+	TypeCode         string `xml:"ram:TypeCode"`
+	MotifExoneration string `xml:"ram:ExemptionReason,omitempty"`
+	Base             string `xml:"ram:BasisAmount,omitempty"`
+	Categorie        string `xml:"ram:CategoryCode"`
+	CodeExoneration  string `xml:"ram:ExemptionReasonCode,omitempty"`
+	Taux             string `xml:"ram:RateApplicablePercent"`
+}
+
+// ciiRemise est une remise (indicateur de frais à false), de ligne ou de document ; la catégorie de TVA
+// n'est portée que par les remises de document
+type ciiRemise struct {
+	Indicateur  bool     `xml:"ram:ChargeIndicator>udt:Indicator"`
+	Pourcentage string   `xml:"ram:CalculationPercent,omitempty"`
+	Base        string   `xml:"ram:BasisAmount,omitempty"`
+	Montant     string   `xml:"ram:ActualAmount"`
+	CodeMotif   string   `xml:"ram:ReasonCode,omitempty"`
+	Motif       string   `xml:"ram:Reason,omitempty"`
+	Taxe        *ciiTaxe `xml:"ram:CategoryTradeTax"`
+}
+
+type ciiConditions struct {
+	Echeance ciiDateTime `xml:"ram:DueDateDateTime"`
+}
+
+type ciiTotaux struct {
+	TotalLignes   string     `xml:"ram:LineTotalAmount"`
+	TotalRemises  string     `xml:"ram:AllowanceTotalAmount,omitempty"`
+	BaseTaxable   string     `xml:"ram:TaxBasisTotalAmount"`
+	TotalTVA      ciiMontant `xml:"ram:TaxTotalAmount"`
+	TotalTTC      string     `xml:"ram:GrandTotalAmount"`
+	MontantPrepay string     `xml:"ram:TotalPrepaidAmount"`
+	NetAPayer     string     `xml:"ram:DuePayableAmount"`
+}
+
+type ciiDocumentReference struct {
+	ID string `xml:"ram:IssuerAssignedID"`
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// schemaCII est le schéma Factur-X EN 16931 du Cross Industry Invoice (voir testdata/factur-x/README.md)
+const schemaCII = "testdata/factur-x/FACTUR-X_EN16931.xsd"
+
+func TestFacturXSchemaEtPieceJointe(t *testing.T) {
+	schema, err := filepath.Abs(schemaCII)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dansRacineModule(t) // Police des PDF (assets/)
+
+	facture := factureTest()
+	entreprise := entrepriseTest()
+	document := DocumentDepuisFacture(facture, entreprise)
+
+	xmlCII, err := GenererCII(document)
+	if err != nil {
+		t.Fatalf("génération CII : %v", err)
+	}
+	pdf, err := GenererDocumentPDF(DocumentPDF{
+		Titre:     TitrePDFFacture,
+		Reference: facture.Reference,
+		Emetteur:  []string{entreprise.Nom},
+		Client:    BlocPDF{Titre: "CLIENT", Lignes: []string{facture.ClientNom}},
+	})
+	if err != nil {
+		t.Fatalf("génération PDF : %v", err)
+	}
+	facturX, err := IntegrerFacturX(pdf, xmlCII, MetadonneesFacturX{
+		Titre:  "Facture " + facture.Reference,
+		Auteur: entreprise.Nom,
+		Date:   facture.DateEmission,
+	})
+	if err != nil {
+		t.Fatalf("intégration Factur-X : %v", err)
+	}
+
+	structure, err := lireStructurePDF(facturX)
+	if err != nil {
+		t.Fatalf("PDF Factur-X illisible : %v", err)
+	}
+	catalogue, err := structure.dictionnaireObjet(structure.racine)
+	if err != nil {
+		t.Fatalf("catalogue illisible : %v", err)
+	}
+
+	// Pièce jointe associée au document (/AF) : fichier factur-x.xml, relation /Data, type text/xml
+	af, ok := trouverEntree(catalogue, "/AF")
+	if !ok {
+		t.Fatal("catalogue sans /AF")
+	}
+	spec := dictionnaireReference(t, structure, strings.Trim(af, "[] "))
+	for cle, attendu := range map[string]string{
+		"/Type":           "/Filespec",
+		"/F":              chainePDF(NomFichierFacturX),
+		"/UF":             chainePDF(NomFichierFacturX),
+		"/AFRelationship": "/Data",
+	} {
+		if valeur, _ := trouverEntree(spec, cle); valeur != attendu {
+			t.Errorf("Filespec %s = %q, attendu %q", cle, valeur, attendu)
+		}
+	}
+	ef, _ := trouverEntree(spec, "/EF")
+	fichiers, err := structure.resoudreDictionnaire(ef)
+	if err != nil {
+		t.Fatalf("/EF illisible : %v", err)
+	}
+	refFichier, _ := trouverEntree(fichiers, "/F")
+	fichier := dictionnaireReference(t, structure, refFichier)
+	if sousType, _ := trouverEntree(fichier, "/Subtype"); sousType != "/text#2Fxml" {
+		t.Errorf("fichier embarqué de sous-type %q, attendu /text#2Fxml", sousType)
+	}
+
+	// Le fichier embarqué de /Names /EmbeddedFiles est le même
+	names, _ := trouverEntree(catalogue, "/Names")
+	if dictionnaire, err := structure.resoudreDictionnaire(names); err != nil || !strings.Contains(serialiserDictionnaire(dictionnaire), chainePDF(NomFichierFacturX)) {
+		t.Errorf("/Names sans %s : %v", NomFichierFacturX, err)
+	}
+
+	// Métadonnées XMP PDF/A-3B et Factur-X, profil de sortie
+	metadata, _ := trouverEntree(catalogue, "/Metadata")
+	xmp := string(fluxObjet(t, structure, metadata))
+	for _, attendu := range []string{
+		"<pdfaid:part>3</pdfaid:part>",
+		"<pdfaid:conformance>B</pdfaid:conformance>",
+		"<fx:DocumentType>INVOICE</fx:DocumentType>",
+		"<fx:DocumentFileName>" + NomFichierFacturX + "</fx:DocumentFileName>",
+		"<fx:ConformanceLevel>EN 16931</fx:ConformanceLevel>",
+		"<pdfaSchema:namespaceURI>urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#</pdfaSchema:namespaceURI>",
+	} {
+		if !strings.Contains(xmp, attendu) {
+			t.Errorf("XMP sans %s", attendu)
+		}
+	}
+	if intentions, _ := trouverEntree(catalogue, "/OutputIntents"); !strings.Contains(intentions, "/GTS_PDFA1") {
+		t.Errorf("/OutputIntents = %q, attendu un profil GTS_PDFA1", intentions)
+	}
+
+	// XML extrait : identique à celui généré et conforme au schéma EN 16931
+	extrait := fluxObjet(t, structure, refFichier)
+	if !bytes.Equal(extrait, xmlCII) {
+		t.Fatal("le XML extrait du PDF diffère du XML généré")
+	}
+	if taille, _ := trouverEntree(fichier, "/Params"); !strings.Contains(taille, "/Size "+strconv.Itoa(len(xmlCII))) {
+		t.Errorf("/Params = %q, attendu /Size %d", taille, len(xmlCII))
+	}
+
+	xmllint, err := exec.LookPath("xmllint")
+	if err != nil {
+		t.Fatalf("xmllint introuvable, nécessaire à la validation XSD (paquet libxml2-utils) : %v", err)
+	}
+	chemin := filepath.Join(t.TempDir(), NomFichierFacturX)
+	if err := os.WriteFile(chemin, extrait, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if sortie, err := exec.Command(xmllint, "--noout", "--schema", schema, chemin).CombinedOutput(); err != nil {
+		t.Fatalf("XML non conforme au schéma EN 16931 : %v\n%s", err, sortie)
+	}
+}
+
+// dansRacineModule se place à la racine du module le temps du test (chemins relatifs de assets/)
+func dansRacineModule(t *testing.T) {
+	t.Helper()
+	courant, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(courant) })
+}
+
+// dictionnaireReference lit le dictionnaire d'un objet désigné par une référence « n g R »
+func dictionnaireReference(t *testing.T, s *structurePDF, valeur string) []entreePDF {
+	t.Helper()
+	ref, ok := lireReference(valeur)
+	if !ok {
+		t.Fatalf("référence attendue, obtenu %q", valeur)
+	}
+	dictionnaire, err := s.dictionnaireObjet(ref)
+	if err != nil {
+		t.Fatalf("objet %d illisible : %v", ref.numero, err)
+	}
+	return dictionnaire
+}
+
+// fluxObjet retourne le contenu, décompressé si besoin, du flux d'un objet désigné par une référence
+func fluxObjet(t *testing.T, s *structurePDF, valeur string) []byte {
+	t.Helper()
+	dictionnaire := dictionnaireReference(t, s, valeur)
+	ref, _ := lireReference(valeur)
+	longueur, err := strconv.Atoi(mustEntree(t, dictionnaire, "/Length"))
+	if err != nil {
+		t.Fatalf("objet %d : /Length invalide", ref.numero)
+	}
+
+	debut := bytes.Index(s.donnees[s.objets[ref.numero]:], []byte("stream\n"))
+	if debut < 0 {
+		t.Fatalf("objet %d sans flux", ref.numero)
+	}
+	debut += s.objets[ref.numero] + len("stream\n")
+	flux := s.donnees[debut : debut+longueur]
+
+	if filtre, _ := trouverEntree(dictionnaire, "/Filter"); filtre == "/FlateDecode" {
+		lecteur, err := zlib.NewReader(bytes.NewReader(flux))
+		if err != nil {
+			t.Fatalf("objet %d : flux compressé illisible : %v", ref.numero, err)
+		}
+		if flux, err = io.ReadAll(lecteur); err != nil {
+			t.Fatalf("objet %d : décompression : %v", ref.numero, err)
+		}
+	}
+	return flux
+}
+
+func mustEntree(t *testing.T, entrees []entreePDF, cle string) string {
+	t.Helper()
+	valeur, ok := trouverEntree(entrees, cle)
+	if !ok {
+		t.Fatalf("entrée %s absente", cle)
+	}
+	return valeur
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes de chiffrement de la connexion SMTP
+const (
+	SMTPChiffrementAucun    = "aucun"    // Connexion en clair (serveur de test local)
+	SMTPChiffrementSTARTTLS = "starttls" // STARTTLS obligatoire (port 587)
+	SMTPChiffrementTLS      = "tls"      // TLS implicite (port 465)
+)
+
+// SMTPConfig contient les paramètres de connexion au serveur SMTP
+type SMTPConfig struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	Chiffrement string
+}
+
+// PieceJointe représente un fichier joint à un email
+type PieceJointe struct {
+	Nom         string
+	ContentType string
+	Contenu     []byte
+}
+
+// Email représente un message à envoyer
+type Email struct {
+	ExpediteurEmail string
+	ExpediteurNom   string
+	Destinataires   []string
+	Copies          []string
+	Sujet           string
+	Corps           string
+	PiecesJointes   []PieceJointe
+}
+
+// EnvoyerEmail envoie un email avec ses pièces jointes via le serveur SMTP configuré
+func EnvoyerEmail(cfg SMTPConfig, email Email) error {
+	if cfg.Host == "" || cfg.Port == 0 {
+		return fmt.Errorf("serveur SMTP non configuré")
+	}
+	if len(email.Destinataires) == 0 {
+		return fmt.Errorf("aucun destinataire")
+	}
+
+	message, err := ConstruireMessage(email)
+	if err != nil {
+		return err
+	}
+
+	adresse := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+
+	var conn net.Conn
+	if cfg.Chiffrement == SMTPChiffrementTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", adresse, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", adresse, 15*time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("connexion au serveur SMTP %s : %v", adresse, err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("dialogue SMTP : %v", err)
+	}
+	defer client.Close()
+
+	if cfg.Chiffrement == SMTPChiffrementSTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("le serveur SMTP ne prend pas en charge STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS : %v", err)
+		}
+	}
+
+	if cfg.Username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+				return fmt.Errorf("authentification SMTP : %v", err)
+			}
+		}
+	}
+
+	if err := client.Mail(email.ExpediteurEmail); err != nil {
+		return fmt.Errorf("expéditeur refusé : %v", err)
+	}
+	for _, destinataire := range append(append([]string{}, email.Destinataires...), email.Copies...) {
+		if err := client.Rcpt(destinataire); err != nil {
+			return fmt.Errorf("destinataire %s refusé : %v", destinataire, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("envoi du message : %v", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		writer.Close()
+		return fmt.Errorf("envoi du message : %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("envoi du message : %v", err)
+	}
+
+	return client.Quit()
+}
+
+// ConstruireMessage produit le message MIME (texte + pièces jointes) d'un email
+func ConstruireMessage(email Email) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+
+	expediteur := mail.Address{Name: email.ExpediteurNom, Address: email.ExpediteurEmail}
+
+	headers := []string{
+		"From: " + expediteur.String(),
+		"To: " + strings.Join(email.Destinataires, ", "),
+	}
+	if len(email.Copies) > 0 {
+		headers = append(headers, "Cc: "+strings.Join(email.Copies, ", "))
+	}
+	headers = append(headers,
+		"Subject: "+mime.QEncoding.Encode("utf-8", email.Sujet),
+		"Date: "+time.Now().Format(time.RFC1123Z),
+		"Message-ID: "+genererMessageID(email.ExpediteurEmail),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary="+writer.Boundary(),
+	)
+
+	var message bytes.Buffer
+	message.WriteString(strings.Join(headers, "\r\n"))
+	message.WriteString("\r\n\r\n")
+
+	// Corps du message en texte brut
+	partie, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(partie)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(email.Corps, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	qp.Close()
+
+	// Pièces jointes encodées en base64 (lignes de 76 caractères)
+	for _, pj := range email.PiecesJointes {
+		contentType := pj.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		partie, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": pj.Nom})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": pj.Nom})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encode := base64.StdEncoding.EncodeToString(pj.Contenu)
+		for len(encode) > 76 {
+			partie.Write([]byte(encode[:76] + "\r\n"))
+			encode = encode[76:]
+		}
+		partie.Write([]byte(encode + "\r\n"))
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	message.Write(buffer.Bytes())
+	return message.Bytes(), nil
+}
+
+// genererMessageID génère un identifiant de message unique sur le domaine de l'expéditeur
+func genererMessageID(expediteur string) string {
+	domaine := "localhost"
+	if i := strings.LastIndex(expediteur, "@"); i >= 0 {
+		domaine = expediteur[i+1:]
+	}
+	aleatoire := make([]byte, 12)
+	rand.Read(aleatoire)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(aleatoire), domaine)
+}
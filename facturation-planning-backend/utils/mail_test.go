@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"facturation-planning/utils/smtptest"
+)
+
+// messageRecu est le contenu décodé d'un email reçu par le serveur de test
+type messageRecu struct {
+	sujet         string
+	corps         string
+	piecesJointes map[string][]byte // Contenu par nom de fichier
+	typesContenu  map[string]string // Type MIME par nom de fichier
+}
+
+// lireMessage décode l'en-tête Subject, le corps texte et les pièces jointes base64 d'un message MIME
+func lireMessage(t *testing.T, donnees []byte) messageRecu {
+	t.Helper()
+	message, err := mail.ReadMessage(bytes.NewReader(donnees))
+	if err != nil {
+		t.Fatalf("message illisible : %v", err)
+	}
+	recu := messageRecu{piecesJointes: map[string][]byte{}, typesContenu: map[string]string{}}
+	if recu.sujet, err = new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject")); err != nil {
+		t.Fatalf("sujet illisible : %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type %q, attendu multipart/mixed", message.Header.Get("Content-Type"))
+	}
+	parties := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		partie, err := parties.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("partie illisible : %v", err)
+		}
+		contenu, err := io.ReadAll(partie)
+		if err != nil {
+			t.Fatalf("partie illisible : %v", err)
+		}
+		if partie.FileName() == "" {
+			recu.corps = string(contenu) // quoted-printable décodé par multipart.Reader
+			continue
+		}
+		if encodage := partie.Header.Get("Content-Transfer-Encoding"); encodage != "base64" {
+			t.Fatalf("pièce jointe %s encodée en %q, attendu base64", partie.FileName(), encodage)
+		}
+		for _, ligne := range strings.Split(strings.TrimSpace(string(contenu)), "\n") {
+			if len(strings.TrimRight(ligne, "\r")) > 76 {
+				t.Fatalf("pièce jointe %s : ligne base64 de plus de 76 caractères", partie.FileName())
+			}
+		}
+		decode, err := base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(string(contenu)))
+		if err != nil {
+			t.Fatalf("pièce jointe %s : base64 invalide : %v", partie.FileName(), err)
+		}
+		recu.piecesJointes[partie.FileName()] = decode
+		recu.typesContenu[partie.FileName()], _, _ = mime.ParseMediaType(partie.Header.Get("Content-Type"))
+	}
+	return recu
+}
+
+func TestEnvoyerEmail(t *testing.T) {
+	serveur := smtptest.NouveauServeur(t)
+	pdf := append([]byte("%PDF-1.4\n"), bytes.Repeat([]byte{0x00, 0xff, 0x7f, '\n'}, 100)...)
+
+	err := EnvoyerEmail(SMTPConfig{Host: serveur.Hote, Port: serveur.Port, Chiffrement: SMTPChiffrementAucun}, Email{
+		ExpediteurEmail: "factures@atelier-martin.fr",
+		ExpediteurNom:   "Atelier Martin",
+		Destinataires:   []string{"compta@durand.fr"},
+		Copies:          []string{"archives@atelier-martin.fr"},
+		Sujet:           "Facture FAC-2025-0042 – échéance 16/04/2025",
+		Corps:           "Bonjour,\nVeuillez trouver ci-joint notre facture.",
+		PiecesJointes:   []PieceJointe{{Nom: "facture_FAC-2025-0042.pdf", ContentType: "application/pdf", Contenu: pdf}},
+	})
+	if err != nil {
+		t.Fatalf("envoi : %v", err)
+	}
+
+	messages := serveur.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("%d messages reçus, attendu 1", len(messages))
+	}
+	message := messages[0]
+	if message.Expediteur != "factures@atelier-martin.fr" {
+		t.Errorf("MAIL FROM %q", message.Expediteur)
+	}
+	if strings.Join(message.Destinataires, ",") != "compta@durand.fr,archives@atelier-martin.fr" {
+		t.Errorf("RCPT TO %v, attendu le destinataire puis la copie", message.Destinataires)
+	}
+
+	recu := lireMessage(t, message.Donnees)
+	if recu.sujet != "Facture FAC-2025-0042 – échéance 16/04/2025" {
+		t.Errorf("sujet %q", recu.sujet)
+	}
+	if !strings.Contains(recu.corps, "Veuillez trouver ci-joint notre facture.") {
+		t.Errorf("corps %q", recu.corps)
+	}
+	if !bytes.Equal(recu.piecesJointes["facture_FAC-2025-0042.pdf"], pdf) {
+		t.Errorf("pièce jointe absente ou altérée (%d octets reçus, %d envoyés)", len(recu.piecesJointes["facture_FAC-2025-0042.pdf"]), len(pdf))
+	}
+	if recu.typesContenu["facture_FAC-2025-0042.pdf"] != "application/pdf" {
+		t.Errorf("pièce jointe de type %q, attendu application/pdf", recu.typesContenu["facture_FAC-2025-0042.pdf"])
+	}
+}
+
+func TestEnvoyerEmailDestinataireRefuse(t *testing.T) {
+	serveur := smtptest.NouveauServeur(t)
+	serveur.RefuserDestinataires(true)
+
+	err := EnvoyerEmail(SMTPConfig{Host: serveur.Hote, Port: serveur.Port, Chiffrement: SMTPChiffrementAucun}, Email{
+		ExpediteurEmail: "factures@atelier-martin.fr",
+		Destinataires:   []string{"inconnu@durand.fr"},
+		Sujet:           "Facture",
+		Corps:           "Bonjour",
+	})
+	if err == nil || !strings.Contains(err.Error(), "inconnu@durand.fr") || !strings.Contains(err.Error(), "550") {
+		t.Fatalf("erreur %v, attendu le refus 550 du destinataire", err)
+	}
+	if len(serveur.Messages()) != 0 {
+		t.Fatal("aucun message ne doit être transmis")
+	}
+}
+
+func TestEnvoyerEmailServeurInjoignable(t *testing.T) {
+	serveur := smtptest.NouveauServeur(t)
+	port := serveur.Port
+	serveur.Fermer()
+
+	err := EnvoyerEmail(SMTPConfig{Host: "127.0.0.1", Port: port, Chiffrement: SMTPChiffrementAucun}, Email{
+		ExpediteurEmail: "factures@atelier-martin.fr",
+		Destinataires:   []string{"compta@durand.fr"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "connexion au serveur SMTP") {
+		t.Fatalf("erreur %v, attendu un échec de connexion", err)
+	}
+}
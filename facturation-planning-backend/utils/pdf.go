@@ -3,56 +3,569 @@ package utils
 import (
 	"bytes"
 	"fmt"
-	"html/template"
+	"math"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"facturation-planning/models"
+
+	"github.com/jung-kurt/gofpdf"
 )
 
-// GenerateInvoicePDF génère un fichier PDF à partir d'un template HTML
-// TEMPORAIRE: Retourne le HTML au lieu du PDF car wkhtmltopdf n'est pas disponible
-func GenerateInvoicePDF(facture models.Facture, useFilePath bool) (string, error) {
-	fmt.Println("🚀 GenerateInvoicePDF appelée pour la facture :", facture.ID)
+// PolicePDF est la police TrueType embarquée dans les documents (couverture Unicode complète, €, accents)
+const PolicePDF = "assets/DejaVuSans.ttf"
 
-	// Charger le template HTML
-	tmpl, err := template.ParseFiles("templates/facture.html")
-	if err != nil {
-		return "", fmt.Errorf("Erreur lors du chargement du template : %v", err)
-	}
-
-	// Remplir le template avec les données de la facture
-	var tplBuffer bytes.Buffer
-	err = tmpl.Execute(&tplBuffer, map[string]interface{}{
-		"EntrepriseNom":       fmt.Sprintf("%d", facture.EntrepriseID),
-		"EntrepriseAdresse":   "123 rue du Code", // Ajoute la vraie valeur depuis la struct
-		"EntrepriseEmail":     "contact@maboite.com",
-		"EntrepriseTelephone": "+33 6 12 34 56 78",
-		"EntrepriseSIRET":     "12345678901234",
-		"EntrepriseIBAN":      "FR7612345987650123456789014",
-		"EntrepriseBIC":       "BIC12345",
-		"ClientNom":           "Client XYZ",
-		"Date":                facture.DateEmission,
-		"Numero":              facture.Reference,
-		"Description":         facture.Description,
-		"MontantHT":           fmt.Sprintf("%.2f", facture.SousTotalHT),
-		"TVA":                 fmt.Sprintf("%.2f", facture.TotalTVA),
-		"MontantTTC":          fmt.Sprintf("%.2f", facture.TotalTTC),
-		"UseFilePath":         useFilePath,
-	})
+// Titres des documents commerciaux
+const (
+	TitrePDFFacture   = "FACTURE"
+	TitrePDFAcompte   = "FACTURE D'ACOMPTE"
+	TitrePDFSituation = "FACTURE DE SITUATION"
+	TitrePDFSolde     = "FACTURE DE SOLDE"
+	TitrePDFDevis     = "DEVIS"
+	TitrePDFAvoir     = "AVOIR"
+)
 
-	if err != nil {
-		return "", fmt.Errorf("Erreur lors de l'exécution du template : %v", err)
+// BlocPDF est un encadré titré (client, informations, objet, conditions)
+type BlocPDF struct {
+	Titre  string
+	Lignes []string
+}
+
+// LigneDocumentPDF représente une ligne du tableau de détail
+type LigneDocumentPDF struct {
+	Designation  string
+	Unite        string
+	Quantite     float64
+	PrixUnitaire models.Montant
+	TauxTVA      float64
+	MontantHT    models.Montant // Remise de la ligne déduite
+
+	// Remise de la ligne, en pourcentage ou en montant HT (colonne affichée si une ligne en porte une)
+	RemisePourcentage float64
+	RemiseMontant     models.Montant
+}
+
+// TotalPDF représente une ligne du tableau des totaux
+type TotalPDF struct {
+	Libelle   string
+	Montant   models.Montant
+	Principal bool // Mise en évidence (total TTC, net à payer)
+}
+
+// TVAPDF représente une ligne du récapitulatif de TVA par taux
+type TVAPDF struct {
+	Taux       float64
+	BaseHT     models.Montant
+	MontantTVA models.Montant
+}
+
+// SignaturesPDF décrit le bloc de signatures en bas de document
+type SignaturesPDF struct {
+	Mention string // "Fait à ..., le ..."
+	Gauche  string
+	Droite  string
+
+	// Signature électronique du client, reportée dans le cadre de droite
+	ImageDroite   []byte   // Image JPEG ou PNG de la signature manuscrite
+	LegendeDroite []string // Signataire et horodatage
+}
+
+// DocumentPDF décrit un document commercial (facture, acompte, devis, avoir) à mettre en page
+type DocumentPDF struct {
+	Titre        string
+	Reference    string
+	Mentions     []string // Lignes affichées sous la référence (lieu et date, validité, facture d'origine)
+	Emetteur     []string // Raison sociale puis coordonnées
+	Logo         []byte   // Image JPEG ou PNG, facultative
+	Client       BlocPDF
+	Informations BlocPDF
+	Objet        BlocPDF
+	Lignes       []LigneDocumentPDF
+	Totaux       []TotalPDF
+	TVA          []TVAPDF // Récapitulatif par taux, à gauche des totaux
+	MentionTVA   string   // Mention d'exonération ou d'autoliquidation, sous les totaux
+	Conditions   BlocPDF
+	Signatures   *SignaturesPDF
+	PiedDePage   []string  // Mentions légales répétées sur chaque page
+	DateCreation time.Time // Date inscrite dans les métadonnées (document reproductible)
+}
+
+// Mise en page A4 (millimètres)
+const (
+	pdfMarge         = 15.0
+	pdfLargeurUtile  = 180.0
+	pdfLimiteBas     = 262.0 // Au-delà, le contenu passe à la page suivante
+	pdfHauteurLigne  = 4.5
+	pdfHauteurEntete = 8.0
+	pdfHauteurReport = 7.0
+)
+
+// colonnePDF décrit une colonne du tableau de détail
+type colonnePDF struct {
+	titre   string
+	largeur float64
+	align   string
+}
+
+// Colonnes du tableau de détail : N°, désignation, unité, quantité, P.U. HT, TVA, montant HT
+var colonnesPDF = []colonnePDF{
+	{"N°", 10, "C"},
+	{"DÉSIGNATION", 72, "L"},
+	{"UNITÉ", 16, "C"},
+	{"QUANTITÉ", 18, "R"},
+	{"P.U. HT", 24, "R"},
+	{"TVA %", 16, "R"},
+	{"MONTANT HT", 24, "R"},
+}
+
+// colonneRemisePDF est insérée avant le montant HT, aux dépens de la désignation, si une ligne porte une remise
+var colonneRemisePDF = colonnePDF{"REMISE", 18, "R"}
+
+// colonnesTableau retourne les colonnes du tableau de détail d'un document
+func colonnesTableau(lignes []LigneDocumentPDF) []colonnePDF {
+	for _, ligne := range lignes {
+		if ligne.RemisePourcentage != 0 || ligne.RemiseMontant != 0 {
+			colonnes := append([]colonnePDF(nil), colonnesPDF[:len(colonnesPDF)-1]...)
+			colonnes[1].largeur -= colonneRemisePDF.largeur
+			return append(colonnes, colonneRemisePDF, colonnesPDF[len(colonnesPDF)-1])
+		}
 	}
+	return colonnesPDF
+}
 
-	fmt.Println("✅ HTML généré avec succès !")
+var (
+	couleurTitre = [3]int{44, 62, 80}
+	couleurFond  = [3]int{240, 240, 240}
+	couleurTexte = [3]int{33, 33, 33}
+)
 
-	// Sauvegarde temporaire du HTML
-	tempHTMLFile := "facture_temp.html"
-	err = os.WriteFile(tempHTMLFile, tplBuffer.Bytes(), 0644)
+// GenererDocumentPDF produit le PDF d'un document commercial sans dépendance externe.
+// Le tableau des lignes s'étend sur plusieurs pages : l'en-tête est répété et le cumul HT
+// est reporté d'une page à l'autre. La sortie ne dépend que du document et des fichiers
+// de assets/, elle est donc identique en local et dans l'image Docker.
+func GenererDocumentPDF(doc DocumentPDF) ([]byte, error) {
+	police, err := os.ReadFile(PolicePDF)
 	if err != nil {
-		return "", fmt.Errorf("Erreur lors de l'écriture du fichier HTML temporaire : %v", err)
+		return nil, fmt.Errorf("Erreur chargement police : %v", err)
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMarge, pdfMarge, pdfMarge)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddUTF8FontFromBytes("DejaVu", "", police)
+	pdf.AliasNbPages("{nb}")
+	pdf.SetCatalogSort(true)
+	pdf.SetCreationDate(doc.DateCreation)
+	pdf.SetModificationDate(doc.DateCreation)
+	pdf.SetTitle(strings.TrimSpace(doc.Titre+" "+doc.Reference), true)
+	if len(doc.Emetteur) > 0 {
+		pdf.SetAuthor(doc.Emetteur[0], true)
+		pdf.SetCreator(doc.Emetteur[0], true)
+	}
+	pdf.SetProducer("facturation-planning", true)
+
+	r := &rendu{pdf: pdf, doc: doc, colonnes: colonnesTableau(doc.Lignes)}
+	pdf.SetHeaderFunc(r.entetePage)
+	pdf.SetFooterFunc(r.piedPage)
+
+	pdf.AddPage()
+	r.entete()
+	r.blocsParties()
+	r.bloc(doc.Objet)
+	r.tableau()
+	r.totaux()
+	r.bloc(doc.Conditions)
+	r.signatures()
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("Erreur création PDF : %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// rendu porte l'état de la mise en page d'un document
+type rendu struct {
+	pdf      *gofpdf.Fpdf
+	doc      DocumentPDF
+	colonnes []colonnePDF // Colonnes du tableau de détail, avec la remise si une ligne en porte une
+}
+
+func (r *rendu) police(taille float64, couleur [3]int) {
+	r.pdf.SetFont("DejaVu", "", taille)
+	r.pdf.SetTextColor(couleur[0], couleur[1], couleur[2])
+}
+
+// assurerEspace passe à la page suivante si la hauteur demandée ne tient pas sur la page courante
+func (r *rendu) assurerEspace(hauteur float64) {
+	if r.pdf.GetY()+hauteur > pdfLimiteBas {
+		r.pdf.AddPage()
+	}
+}
+
+// entetePage rappelle l'émetteur et la référence en haut des pages suivantes
+func (r *rendu) entetePage() {
+	if r.pdf.PageNo() == 1 {
+		return
+	}
+	emetteur := ""
+	if len(r.doc.Emetteur) > 0 {
+		emetteur = r.doc.Emetteur[0]
+	}
+	r.police(8, couleurTitre)
+	r.pdf.SetXY(pdfMarge, 10)
+	r.pdf.CellFormat(pdfLargeurUtile/2, 5, emetteur, "", 0, "L", false, 0, "")
+	r.pdf.CellFormat(pdfLargeurUtile/2, 5, strings.TrimSpace(r.doc.Titre+" "+r.doc.Reference), "", 1, "R", false, 0, "")
+	r.pdf.SetDrawColor(couleurTitre[0], couleurTitre[1], couleurTitre[2])
+	r.pdf.Line(pdfMarge, 16, pdfMarge+pdfLargeurUtile, 16)
+	r.pdf.SetY(20)
+}
+
+// piedPage affiche les mentions légales et la pagination
+func (r *rendu) piedPage() {
+	r.pdf.SetDrawColor(200, 200, 200)
+	r.pdf.Line(pdfMarge, 270, pdfMarge+pdfLargeurUtile, 270)
+	r.pdf.SetXY(pdfMarge, 271)
+	r.police(7, couleurTexte)
+	for _, mention := range r.doc.PiedDePage {
+		r.pdf.CellFormat(pdfLargeurUtile, 3.5, mention, "", 1, "C", false, 0, "")
+	}
+	r.pdf.SetXY(pdfMarge, 287)
+	r.pdf.CellFormat(pdfLargeurUtile, 4, fmt.Sprintf("Page %d/{nb}", r.pdf.PageNo()), "", 0, "R", false, 0, "")
+}
+
+// entete dessine le logo, les coordonnées de l'émetteur et le titre du document
+func (r *rendu) entete() {
+	x := pdfMarge
+	if typeImage := typeImagePDF(r.doc.Logo); typeImage != "" {
+		options := gofpdf.ImageOptions{ImageType: typeImage, ReadDpi: true}
+		r.pdf.RegisterImageOptionsReader("logo", options, bytes.NewReader(r.doc.Logo))
+		if r.pdf.Ok() {
+			r.pdf.ImageOptions("logo", pdfMarge, pdfMarge, 25, 0, false, options, 0, "")
+			x += 29
+		} else {
+			// Logo illisible : le document est produit sans logo
+			r.pdf.ClearError()
+		}
+	}
+
+	r.pdf.SetXY(x, pdfMarge)
+	for i, ligne := range r.doc.Emetteur {
+		if i == 0 {
+			r.police(12, couleurTitre)
+			r.pdf.CellFormat(90, 6, ligne, "", 2, "L", false, 0, "")
+			continue
+		}
+		r.police(8.5, couleurTexte)
+		r.pdf.CellFormat(90, 4.2, ligne, "", 2, "L", false, 0, "")
+	}
+	basEmetteur := r.pdf.GetY()
+
+	r.pdf.SetXY(115, pdfMarge)
+	r.police(18, couleurTitre)
+	r.pdf.CellFormat(80, 9, r.doc.Titre, "", 2, "R", false, 0, "")
+	r.police(9, couleurTexte)
+	if r.doc.Reference != "" {
+		r.pdf.CellFormat(80, 5, "Référence : "+r.doc.Reference, "", 2, "R", false, 0, "")
+	}
+	for _, mention := range r.doc.Mentions {
+		r.pdf.CellFormat(80, 5, mention, "", 2, "R", false, 0, "")
+	}
+
+	bas := r.pdf.GetY()
+	if basEmetteur > bas {
+		bas = basEmetteur
+	}
+	if bas < pdfMarge+25 {
+		bas = pdfMarge + 25
 	}
+	r.pdf.SetY(bas + 6)
+}
+
+// blocsParties dessine côte à côte le bloc client et le bloc d'informations
+func (r *rendu) blocsParties() {
+	haut := r.pdf.GetY()
+	largeur := (pdfLargeurUtile - 6) / 2
+	basClient := r.encadre(pdfMarge, haut, largeur, r.doc.Client)
+	basInfos := r.encadre(pdfMarge+largeur+6, haut, largeur, r.doc.Informations)
+	if basInfos > basClient {
+		basClient = basInfos
+	}
+	r.pdf.SetY(basClient + 6)
+}
+
+// encadre dessine un bloc titré à la position donnée et retourne son ordonnée basse
+func (r *rendu) encadre(x, y, largeur float64, bloc BlocPDF) float64 {
+	if bloc.Titre == "" && len(bloc.Lignes) == 0 {
+		return y
+	}
+	r.pdf.SetXY(x, y)
+	r.police(9, couleurTitre)
+	r.pdf.SetFillColor(couleurFond[0], couleurFond[1], couleurFond[2])
+	r.pdf.CellFormat(largeur, 6, strings.ToUpper(bloc.Titre), "", 2, "L", true, 0, "")
+	r.police(9, couleurTexte)
+	r.pdf.SetY(r.pdf.GetY() + 1)
+	for _, ligne := range bloc.Lignes {
+		r.pdf.SetX(x)
+		r.pdf.MultiCell(largeur, pdfHauteurLigne, ligne, "", "L", false)
+	}
+	return r.pdf.GetY()
+}
+
+// bloc dessine un bloc titré pleine largeur (objet, conditions)
+func (r *rendu) bloc(bloc BlocPDF) {
+	if len(bloc.Lignes) == 0 {
+		return
+	}
+	r.police(9, couleurTexte)
+	hauteur := 8.0
+	for _, ligne := range bloc.Lignes {
+		hauteur += float64(len(r.pdf.SplitText(ligne, pdfLargeurUtile))) * pdfHauteurLigne
+	}
+	r.assurerEspace(hauteur)
+	r.encadre(pdfMarge, r.pdf.GetY(), pdfLargeurUtile, bloc)
+	r.pdf.SetY(r.pdf.GetY() + 6)
+}
+
+// enteteTableau dessine la ligne de titres du tableau de détail
+func (r *rendu) enteteTableau() {
+	r.police(8, couleurTitre)
+	r.pdf.SetFillColor(couleurFond[0], couleurFond[1], couleurFond[2])
+	r.pdf.SetDrawColor(200, 200, 200)
+	r.pdf.SetX(pdfMarge)
+	for _, col := range r.colonnes {
+		r.pdf.CellFormat(col.largeur, pdfHauteurEntete, col.titre, "1", 0, "C", true, 0, "")
+	}
+	r.pdf.Ln(-1)
+}
+
+// ligneReport affiche le cumul HT reporté en bas ou en haut de page
+func (r *rendu) ligneReport(libelle string, cumulHT models.Montant) {
+	largeurMontant := r.colonnes[len(r.colonnes)-1].largeur
+	r.police(8, couleurTitre)
+	r.pdf.SetX(pdfMarge)
+	r.pdf.CellFormat(pdfLargeurUtile-largeurMontant, pdfHauteurReport, libelle, "1", 0, "R", false, 0, "")
+	r.pdf.CellFormat(largeurMontant, pdfHauteurReport, FormatMontantPDF(cumulHT), "1", 1, "R", false, 0, "")
+}
+
+// tableau dessine les lignes du document en reportant le cumul HT à chaque saut de page
+func (r *rendu) tableau() {
+	if len(r.doc.Lignes) == 0 {
+		return
+	}
+	r.assurerEspace(pdfHauteurEntete + 3*pdfHauteurLigne + pdfHauteurReport)
+	r.enteteTableau()
+
+	var cumulHT models.Montant
+	for i, ligne := range r.doc.Lignes {
+		r.police(8, couleurTexte)
+		designation := r.pdf.SplitText(ligne.Designation, r.colonnes[1].largeur)
+		if len(designation) == 0 {
+			designation = []string{""}
+		}
+		hauteur := float64(len(designation))*pdfHauteurLigne + 2
+
+		if r.pdf.GetY()+hauteur+pdfHauteurReport > pdfLimiteBas {
+			r.ligneReport("À reporter", cumulHT)
+			r.pdf.AddPage()
+			r.enteteTableau()
+			r.ligneReport("Report", cumulHT)
+			r.police(8, couleurTexte)
+		}
+
+		valeurs := []string{
+			strconv.Itoa(i + 1),
+			"",
+			ligne.Unite,
+			FormatQuantitePDF(ligne.Quantite),
+			FormatMontantPDF(ligne.PrixUnitaire),
+			FormatTauxPDF(ligne.TauxTVA),
+		}
+		if len(r.colonnes) > len(colonnesPDF) {
+			valeurs = append(valeurs, FormatRemisePDF(ligne.RemisePourcentage, ligne.RemiseMontant))
+		}
+		valeurs = append(valeurs, FormatMontantPDF(ligne.MontantHT))
+		y := r.pdf.GetY()
+		x := pdfMarge
+		for c, col := range r.colonnes {
+			r.pdf.Rect(x, y, col.largeur, hauteur, "D")
+			if c == 1 {
+				for l, texte := range designation {
+					r.pdf.SetXY(x, y+1+float64(l)*pdfHauteurLigne)
+					r.pdf.CellFormat(col.largeur, pdfHauteurLigne, texte, "", 0, "L", false, 0, "")
+				}
+			} else {
+				r.pdf.SetXY(x, y+1)
+				r.pdf.CellFormat(col.largeur, pdfHauteurLigne, valeurs[c], "", 0, col.align, false, 0, "")
+			}
+			x += col.largeur
+		}
+		r.pdf.SetXY(pdfMarge, y+hauteur)
+		cumulHT += ligne.MontantHT
+	}
+	r.pdf.SetY(r.pdf.GetY() + 6)
+}
+
+// totaux dessine le récapitulatif de TVA à gauche, le tableau des totaux aligné à droite,
+// puis la mention de TVA
+func (r *rendu) totaux() {
+	if len(r.doc.Totaux) == 0 {
+		return
+	}
+	hauteur := math.Max(float64(len(r.doc.Totaux))*7, float64(len(r.doc.TVA)+1)*6)
+	if r.doc.MentionTVA != "" {
+		hauteur += 8
+	}
+	r.assurerEspace(hauteur + 6)
+	r.pdf.SetDrawColor(200, 200, 200)
+
+	haut := r.pdf.GetY()
+	finTVA := r.recapitulatifTVA()
+	r.pdf.SetY(haut)
+	for _, total := range r.doc.Totaux {
+		r.pdf.SetX(pdfMarge + pdfLargeurUtile - 80)
+		if total.Principal {
+			r.police(10, [3]int{255, 255, 255})
+			r.pdf.SetFillColor(couleurTitre[0], couleurTitre[1], couleurTitre[2])
+		} else {
+			r.police(9, couleurTexte)
+			r.pdf.SetFillColor(couleurFond[0], couleurFond[1], couleurFond[2])
+		}
+		r.pdf.CellFormat(50, 7, total.Libelle, "1", 0, "L", true, 0, "")
+		r.pdf.CellFormat(30, 7, FormatMontantPDF(total.Montant), "1", 1, "R", total.Principal, 0, "")
+	}
+	r.pdf.SetY(math.Max(r.pdf.GetY(), finTVA) + 6)
 
-	fmt.Printf("✅ Fichier HTML créé: %s\n", tempHTMLFile)
-	return tempHTMLFile, nil
+	if r.doc.MentionTVA != "" {
+		r.police(9, couleurTitre)
+		r.pdf.MultiCell(pdfLargeurUtile, pdfHauteurLigne, r.doc.MentionTVA, "", "L", false)
+		r.pdf.SetY(r.pdf.GetY() + 4)
+	}
+}
+
+// recapitulatifTVA dessine le tableau taux / base HT / TVA et retourne l'ordonnée de sa fin
+func (r *rendu) recapitulatifTVA() float64 {
+	if len(r.doc.TVA) == 0 {
+		return r.pdf.GetY()
+	}
+	r.police(8, couleurTexte)
+	r.pdf.SetFillColor(couleurFond[0], couleurFond[1], couleurFond[2])
+	r.pdf.SetX(pdfMarge)
+	r.pdf.CellFormat(22, 6, "TAUX TVA", "1", 0, "C", true, 0, "")
+	r.pdf.CellFormat(30, 6, "BASE HT", "1", 0, "R", true, 0, "")
+	r.pdf.CellFormat(30, 6, "MONTANT TVA", "1", 1, "R", true, 0, "")
+	for _, tva := range r.doc.TVA {
+		r.pdf.SetX(pdfMarge)
+		r.pdf.CellFormat(22, 6, FormatTauxPDF(tva.Taux), "1", 0, "C", false, 0, "")
+		r.pdf.CellFormat(30, 6, FormatMontantPDF(tva.BaseHT), "1", 0, "R", false, 0, "")
+		r.pdf.CellFormat(30, 6, FormatMontantPDF(tva.MontantTVA), "1", 1, "R", false, 0, "")
+	}
+	return r.pdf.GetY()
+}
+
+// signatures dessine la mention de lieu et date et les deux cadres de signature
+func (r *rendu) signatures() {
+	s := r.doc.Signatures
+	if s == nil {
+		return
+	}
+	r.assurerEspace(45)
+	r.police(9, couleurTexte)
+	if s.Mention != "" {
+		r.pdf.SetX(pdfMarge)
+		r.pdf.CellFormat(pdfLargeurUtile, 6, s.Mention, "", 1, "L", false, 0, "")
+		r.pdf.Ln(2)
+	}
+	largeur := (pdfLargeurUtile - 10) / 2
+	y := r.pdf.GetY()
+	r.pdf.SetDrawColor(160, 160, 160)
+	for i, titre := range []string{s.Gauche, s.Droite} {
+		x := pdfMarge + float64(i)*(largeur+10)
+		r.pdf.SetXY(x, y)
+		r.police(9, couleurTitre)
+		r.pdf.CellFormat(largeur, 6, titre, "", 0, "L", false, 0, "")
+		r.pdf.Rect(x, y+7, largeur, 28, "D")
+	}
+	r.signatureElectronique(pdfMarge+largeur+10, y+7, largeur, s)
+	r.pdf.SetY(y + 38)
+}
+
+// signatureElectronique dessine la signature du client et sa légende dans le cadre donné
+func (r *rendu) signatureElectronique(x, y, largeur float64, s *SignaturesPDF) {
+	hauteurLegende := float64(len(s.LegendeDroite)) * 3.5
+	if typeImage := typeImagePDF(s.ImageDroite); typeImage != "" {
+		options := gofpdf.ImageOptions{ImageType: typeImage}
+		info := r.pdf.RegisterImageOptionsReader("signature", options, bytes.NewReader(s.ImageDroite))
+		if r.pdf.Ok() && info != nil && info.Height() > 0 {
+			// Image ajustée au cadre en conservant ses proportions
+			hauteurMax, largeurMax := 26-hauteurLegende, largeur-4
+			l, h := largeurMax, largeurMax*info.Height()/info.Width()
+			if h > hauteurMax {
+				l, h = hauteurMax*info.Width()/info.Height(), hauteurMax
+			}
+			r.pdf.ImageOptions("signature", x+(largeur-l)/2, y+1, l, h, false, options, 0, "")
+		} else {
+			// Signature illisible : seule la légende est reportée
+			r.pdf.ClearError()
+		}
+	}
+
+	r.police(7, couleurTexte)
+	for i, ligne := range s.LegendeDroite {
+		r.pdf.SetXY(x+1, y+27-hauteurLegende+float64(i)*3.5)
+		r.pdf.CellFormat(largeur-2, 3.5, ligne, "", 0, "C", false, 0, "")
+	}
+}
+
+// typeImagePDF retourne le type gofpdf d'une image (JPG ou PNG), ou une chaîne vide si le format n'est pas supporté
+func typeImagePDF(image []byte) string {
+	switch http.DetectContentType(image) {
+	case "image/jpeg":
+		return "JPG"
+	case "image/png":
+		return "PNG"
+	}
+	return ""
+}
+
+// FormatMontantPDF formate un montant en euros (1 234,56 €)
+func FormatMontantPDF(montant models.Montant) string {
+	s := montant.String()
+	signe := ""
+	if strings.HasPrefix(s, "-") {
+		signe, s = "-", s[1:]
+	}
+	entier, decimales := s[:len(s)-3], s[len(s)-2:]
+	var groupes []string
+	for len(entier) > 3 {
+		groupes = append([]string{entier[len(entier)-3:]}, groupes...)
+		entier = entier[:len(entier)-3]
+	}
+	groupes = append([]string{entier}, groupes...)
+	return signe + strings.Join(groupes, " ") + "," + decimales + " €"
+}
+
+// FormatQuantitePDF formate une quantité sans zéros superflus (2 ; 1,5 ; 0,25)
+func FormatQuantitePDF(quantite float64) string {
+	arrondie := math.Round(quantite*10000) / 10000
+	return strings.Replace(strconv.FormatFloat(arrondie, 'f', -1, 64), ".", ",", 1)
+}
+
+// FormatTauxPDF formate un taux de TVA (20 % ; 5,5 %)
+func FormatTauxPDF(taux float64) string {
+	return FormatQuantitePDF(taux) + " %"
+}
+
+// FormatRemisePDF formate la remise d'une ligne : pourcentage (10 %) ou montant HT (50,00 €), vide sans remise
+func FormatRemisePDF(pourcentage float64, montant models.Montant) string {
+	switch {
+	case pourcentage != 0:
+		return FormatTauxPDF(pourcentage)
+	case montant != 0:
+		return FormatMontantPDF(montant)
+	}
+	return ""
 }
@@ -0,0 +1,681 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NomFichierFacturX est le nom imposé du XML embarqué dans un PDF Factur-X
+const NomFichierFacturX = "factur-x.xml"
+
+// MetadonneesFacturX décrit le document PDF/A-3 produit
+type MetadonneesFacturX struct {
+	Titre       string
+	Auteur      string
+	Sujet       string
+	Date        time.Time
+	Profil      string // Niveau de conformité Factur-X : "EN 16931", "BASIC"...
+	TypeFacture string // "INVOICE" (facture, acompte ou avoir)
+}
+
+// IntegrerFacturX ajoute au PDF, par mise à jour incrémentale, le XML CII en pièce jointe associée (/AF),
+// les métadonnées XMP PDF/A-3 et Factur-X, et un profil de sortie sRGB.
+// Le contenu des pages d'origine n'est pas modifié.
+func IntegrerFacturX(pdf []byte, xmlFacture []byte, meta MetadonneesFacturX) ([]byte, error) {
+	structure, err := lireStructurePDF(pdf)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogue, err := structure.dictionnaireObjet(structure.racine)
+	if err != nil {
+		return nil, fmt.Errorf("catalogue du PDF illisible : %v", err)
+	}
+
+	if meta.Date.IsZero() {
+		meta.Date = time.Now()
+	}
+	if meta.Profil == "" {
+		meta.Profil = "EN 16931"
+	}
+	if meta.TypeFacture == "" {
+		meta.TypeFacture = "INVOICE"
+	}
+
+	sortie := bytes.NewBuffer(append([]byte(nil), pdf...))
+	if !bytes.HasSuffix(pdf, []byte("\n")) {
+		sortie.WriteString("\n")
+	}
+
+	prochain := structure.taille
+	offsets := map[int]int{}
+	nouvelObjet := func() int {
+		prochain++
+		return prochain - 1
+	}
+	ecrireObjet := func(numero, generation int, corps string, flux []byte) {
+		offsets[numero] = sortie.Len()
+		fmt.Fprintf(sortie, "%d %d obj\n%s\n", numero, generation, corps)
+		if flux != nil {
+			sortie.WriteString("stream\n")
+			sortie.Write(flux)
+			sortie.WriteString("\nendstream\n")
+		}
+		sortie.WriteString("endobj\n")
+	}
+
+	datePDF := formatDatePDF(meta.Date)
+
+	// Fichier XML embarqué
+	xmlCompresse := compresser(xmlFacture)
+	numFichier := nouvelObjet()
+	ecrireObjet(numFichier, 0, fmt.Sprintf(
+		"<< /Type /EmbeddedFile /Subtype /text#2Fxml /Params << /Size %d /ModDate %s >> /Filter /FlateDecode /Length %d >>",
+		len(xmlFacture), chainePDF(datePDF), len(xmlCompresse)), xmlCompresse)
+
+	numSpec := nouvelObjet()
+	ecrireObjet(numSpec, 0, fmt.Sprintf(
+		"<< /Type /Filespec /F %s /UF %s /Desc %s /AFRelationship /Data /EF << /F %d 0 R /UF %d 0 R >> >>",
+		chainePDF(NomFichierFacturX), chainePDF(NomFichierFacturX), chainePDF("Factur-X"), numFichier, numFichier), nil)
+
+	// Dictionnaire /Names : les entrées existantes (destinations nommées...) sont conservées
+	entreesNames := []entreePDF{}
+	if valeur, ok := trouverEntree(catalogue, "/Names"); ok {
+		if existant, err := structure.resoudreDictionnaire(valeur); err == nil {
+			entreesNames = retirerEntrees(existant, "/EmbeddedFiles")
+		}
+	}
+	entreesNames = append(entreesNames, entreePDF{"/EmbeddedFiles", fmt.Sprintf("<< /Names [%s %d 0 R] >>", chainePDF(NomFichierFacturX), numSpec)})
+	numNames := nouvelObjet()
+	ecrireObjet(numNames, 0, serialiserDictionnaire(entreesNames), nil)
+
+	// Métadonnées XMP (non compressées, exigence PDF/A)
+	xmp := genererXMP(meta)
+	numXMP := nouvelObjet()
+	ecrireObjet(numXMP, 0, fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>", len(xmp)), xmp)
+
+	// Profil de sortie sRGB
+	icc := compresser(ProfilICCsRGB())
+	numICC := nouvelObjet()
+	ecrireObjet(numICC, 0, fmt.Sprintf("<< /N 3 /Filter /FlateDecode /Length %d >>", len(icc)), icc)
+
+	// Dictionnaire d'informations, cohérent avec le XMP
+	numInfo := nouvelObjet()
+	ecrireObjet(numInfo, 0, serialiserDictionnaire([]entreePDF{
+		{"/Title", chainePDF(meta.Titre)},
+		{"/Author", chainePDF(meta.Auteur)},
+		{"/Subject", chainePDF(meta.Sujet)},
+		{"/Creator", chainePDF(producteurPDF)},
+		{"/Producer", chainePDF(producteurPDF)},
+		{"/CreationDate", chainePDF(datePDF)},
+		{"/ModDate", chainePDF(datePDF)},
+	}), nil)
+
+	// Nouvelle version du catalogue
+	catalogue = retirerEntrees(catalogue, "/Names", "/Metadata", "/AF", "/OutputIntents")
+	catalogue = append(catalogue,
+		entreePDF{"/Names", fmt.Sprintf("%d 0 R", numNames)},
+		entreePDF{"/Metadata", fmt.Sprintf("%d 0 R", numXMP)},
+		entreePDF{"/AF", fmt.Sprintf("[%d 0 R]", numSpec)},
+		entreePDF{"/OutputIntents", fmt.Sprintf(
+			"[<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier %s /Info %s /DestOutputProfile %d 0 R >>]",
+			chainePDF("sRGB IEC61966-2.1"), chainePDF("sRGB IEC61966-2.1"), numICC)},
+	)
+	ecrireObjet(structure.racine.numero, structure.racine.generation, serialiserDictionnaire(catalogue), nil)
+
+	// Table de références croisées de la mise à jour
+	identifiant := structure.identifiant
+	if identifiant == "" {
+		somme := md5.Sum(pdf)
+		identifiant = fmt.Sprintf("[<%s> <%s>]", hex.EncodeToString(somme[:]), hex.EncodeToString(somme[:]))
+	}
+
+	debutXref := sortie.Len()
+	sortie.WriteString("xref\n")
+	fmt.Fprintf(sortie, "%d 1\n%010d %05d n\r\n", structure.racine.numero, offsets[structure.racine.numero], structure.racine.generation)
+	fmt.Fprintf(sortie, "%d %d\n", structure.taille, prochain-structure.taille)
+	for numero := structure.taille; numero < prochain; numero++ {
+		fmt.Fprintf(sortie, "%010d 00000 n\r\n", offsets[numero])
+	}
+	fmt.Fprintf(sortie, "trailer\n<< /Size %d /Root %d %d R /Info %d 0 R /ID %s /Prev %d >>\nstartxref\n%d\n%%%%EOF\n",
+		prochain, structure.racine.numero, structure.racine.generation, numInfo, identifiant, structure.xref, debutXref)
+
+	return sortie.Bytes(), nil
+}
+
+const producteurPDF = "facturation-planning"
+
+// genererXMP produit le paquet XMP déclarant la conformité PDF/A-3B et l'extension Factur-X
+func genererXMP(meta MetadonneesFacturX) []byte {
+	dateXMP := meta.Date.Format("2006-01-02T15:04:05-07:00")
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/">
+<pdfaid:part>3</pdfaid:part>
+<pdfaid:conformance>B</pdfaid:conformance>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", echapperXML(meta.Titre))
+	fmt.Fprintf(&b, "<dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", echapperXML(meta.Auteur))
+	fmt.Fprintf(&b, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", echapperXML(meta.Sujet))
+	b.WriteString("</rdf:Description>\n")
+	fmt.Fprintf(&b, "<rdf:Description rdf:about=\"\" xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\">\n<pdf:Producer>%s</pdf:Producer>\n</rdf:Description>\n", producteurPDF)
+	fmt.Fprintf(&b, `<rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+<xmp:CreatorTool>%s</xmp:CreatorTool>
+<xmp:CreateDate>%s</xmp:CreateDate>
+<xmp:ModifyDate>%s</xmp:ModifyDate>
+</rdf:Description>
+`, producteurPDF, dateXMP, dateXMP)
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:pdfaExtension="http://www.aiim.org/pdfa/ns/extension/" xmlns:pdfaSchema="http://www.aiim.org/pdfa/ns/schema#" xmlns:pdfaProperty="http://www.aiim.org/pdfa/ns/property#">
+<pdfaExtension:schemas>
+<rdf:Bag>
+<rdf:li rdf:parseType="Resource">
+<pdfaSchema:schema>Factur-X PDFA Extension Schema</pdfaSchema:schema>
+<pdfaSchema:namespaceURI>urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#</pdfaSchema:namespaceURI>
+<pdfaSchema:prefix>fx</pdfaSchema:prefix>
+<pdfaSchema:property>
+<rdf:Seq>
+`)
+	for _, propriete := range [][2]string{
+		{"DocumentFileName", "The name of the embedded XML document"},
+		{"DocumentType", "The type of the hybrid document in capital letters, e.g. INVOICE or ORDER"},
+		{"Version", "The actual version of the standard applying to the embedded XML document"},
+		{"ConformanceLevel", "The conformance level of the embedded XML document"},
+	} {
+		fmt.Fprintf(&b, `<rdf:li rdf:parseType="Resource">
+<pdfaProperty:name>%s</pdfaProperty:name>
+<pdfaProperty:valueType>Text</pdfaProperty:valueType>
+<pdfaProperty:category>external</pdfaProperty:category>
+<pdfaProperty:description>%s</pdfaProperty:description>
+</rdf:li>
+`, propriete[0], propriete[1])
+	}
+	b.WriteString(`</rdf:Seq>
+</pdfaSchema:property>
+</rdf:li>
+</rdf:Bag>
+</pdfaExtension:schemas>
+</rdf:Description>
+<rdf:Description rdf:about="" xmlns:fx="urn:factur-x:pdfa:CrossIndustryDocument:invoice:1p0#">
+`)
+	fmt.Fprintf(&b, "<fx:DocumentType>%s</fx:DocumentType>\n<fx:DocumentFileName>%s</fx:DocumentFileName>\n<fx:Version>1.0</fx:Version>\n<fx:ConformanceLevel>%s</fx:ConformanceLevel>\n",
+		echapperXML(meta.TypeFacture), NomFichierFacturX, echapperXML(meta.Profil))
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+
+	return []byte(b.String())
+}
+
+func echapperXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// formatDatePDF formate une date au format PDF « D:AAAAMMJJHHmmSS+HH'mm' »
+func formatDatePDF(t time.Time) string {
+	_, decalage := t.Zone()
+	signe := '+'
+	if decalage < 0 {
+		signe = '-'
+		decalage = -decalage
+	}
+	return fmt.Sprintf("D:%s%c%02d'%02d'", t.Format("20060102150405"), signe, decalage/3600, (decalage%3600)/60)
+}
+
+// chainePDF encode une chaîne de texte PDF : littérale si ASCII, UTF-16BE hexadécimale sinon
+func chainePDF(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r > 126 || r < 32 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+		return "(" + r.Replace(s) + ")"
+	}
+
+	unites := utf16.Encode([]rune(s))
+	donnees := make([]byte, 2+2*len(unites))
+	donnees[0], donnees[1] = 0xFE, 0xFF
+	for i, u := range unites {
+		binary.BigEndian.PutUint16(donnees[2+2*i:], u)
+	}
+	return "<" + strings.ToUpper(hex.EncodeToString(donnees)) + ">"
+}
+
+func compresser(donnees []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(donnees)
+	w.Close()
+	return b.Bytes()
+}
+
+// Lecture minimale de la structure d'un PDF (tables xref classiques uniquement)
+
+type referencePDF struct {
+	numero     int
+	generation int
+}
+
+type entreePDF struct {
+	cle    string
+	valeur string
+}
+
+type structurePDF struct {
+	donnees     []byte
+	xref        int
+	taille      int
+	racine      referencePDF
+	identifiant string
+	objets      map[int]int // numéro d'objet → position dans le fichier
+}
+
+// lireStructurePDF lit la dernière table de références croisées, la chaîne /Prev et le trailer
+func lireStructurePDF(pdf []byte) (*structurePDF, error) {
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, fmt.Errorf("le document n'est pas un PDF")
+	}
+
+	i := bytes.LastIndex(pdf, []byte("startxref"))
+	if i < 0 {
+		return nil, fmt.Errorf("PDF invalide : startxref introuvable")
+	}
+	champs := bytes.Fields(pdf[i+len("startxref"):])
+	if len(champs) == 0 {
+		return nil, fmt.Errorf("PDF invalide : startxref vide")
+	}
+	xref, err := strconv.Atoi(string(champs[0]))
+	if err != nil || xref <= 0 || xref >= len(pdf) {
+		return nil, fmt.Errorf("PDF invalide : position de la table xref incorrecte")
+	}
+
+	s := &structurePDF{donnees: pdf, xref: xref, objets: map[int]int{}}
+
+	position := xref
+	premierTrailer := true
+	for visites := 0; visites < 64; visites++ {
+		trailer, err := s.lireTableXref(position)
+		if err != nil {
+			return nil, err
+		}
+
+		if premierTrailer {
+			premierTrailer = false
+			taille, _ := trouverEntree(trailer, "/Size")
+			if s.taille, err = strconv.Atoi(taille); err != nil {
+				return nil, fmt.Errorf("PDF invalide : /Size incorrect")
+			}
+			racine, _ := trouverEntree(trailer, "/Root")
+			ref, ok := lireReference(racine)
+			if !ok {
+				return nil, fmt.Errorf("PDF invalide : /Root introuvable")
+			}
+			s.racine = ref
+			s.identifiant, _ = trouverEntree(trailer, "/ID")
+		}
+
+		prev, ok := trouverEntree(trailer, "/Prev")
+		if !ok {
+			break
+		}
+		if position, err = strconv.Atoi(prev); err != nil || position <= 0 || position >= len(pdf) {
+			return nil, fmt.Errorf("PDF invalide : /Prev incorrect")
+		}
+	}
+
+	return s, nil
+}
+
+// lireTableXref enregistre les objets d'une section xref (sans écraser les versions plus récentes) et retourne son trailer
+func (s *structurePDF) lireTableXref(position int) ([]entreePDF, error) {
+	if !bytes.HasPrefix(s.donnees[position:], []byte("xref")) {
+		return nil, fmt.Errorf("les PDF à flux de références croisées compressés ne sont pas pris en charge")
+	}
+	fin := bytes.Index(s.donnees[position:], []byte("trailer"))
+	if fin < 0 {
+		return nil, fmt.Errorf("PDF invalide : trailer introuvable")
+	}
+
+	champs := bytes.Fields(s.donnees[position+len("xref") : position+fin])
+	for i := 0; i+1 < len(champs); {
+		debut, err1 := strconv.Atoi(string(champs[i]))
+		nombre, err2 := strconv.Atoi(string(champs[i+1]))
+		if err1 != nil || err2 != nil || i+2+3*nombre > len(champs) {
+			return nil, fmt.Errorf("PDF invalide : table xref illisible")
+		}
+		i += 2
+		for n := 0; n < nombre; n++ {
+			offset, _ := strconv.Atoi(string(champs[i]))
+			if string(champs[i+2]) == "n" {
+				if _, existe := s.objets[debut+n]; !existe {
+					s.objets[debut+n] = offset
+				}
+			} else if _, existe := s.objets[debut+n]; !existe {
+				s.objets[debut+n] = -1 // Objet libre dans une version plus récente
+			}
+			i += 3
+		}
+	}
+
+	trailer, _, err := lireDictionnaire(s.donnees, position+fin+len("trailer"))
+	return trailer, err
+}
+
+// dictionnaireObjet retourne les entrées du dictionnaire d'un objet indirect
+func (s *structurePDF) dictionnaireObjet(ref referencePDF) ([]entreePDF, error) {
+	offset, ok := s.objets[ref.numero]
+	if !ok || offset < 0 || offset >= len(s.donnees) {
+		return nil, fmt.Errorf("objet %d introuvable", ref.numero)
+	}
+	debut := bytes.Index(s.donnees[offset:], []byte("obj"))
+	if debut < 0 {
+		return nil, fmt.Errorf("objet %d illisible", ref.numero)
+	}
+	entrees, _, err := lireDictionnaire(s.donnees, offset+debut+len("obj"))
+	return entrees, err
+}
+
+// resoudreDictionnaire lit un dictionnaire direct ou référencé
+func (s *structurePDF) resoudreDictionnaire(valeur string) ([]entreePDF, error) {
+	if ref, ok := lireReference(valeur); ok {
+		return s.dictionnaireObjet(ref)
+	}
+	entrees, _, err := lireDictionnaire([]byte(valeur), 0)
+	return entrees, err
+}
+
+func lireReference(valeur string) (referencePDF, bool) {
+	champs := strings.Fields(valeur)
+	if len(champs) != 3 || champs[2] != "R" {
+		return referencePDF{}, false
+	}
+	numero, err1 := strconv.Atoi(champs[0])
+	generation, err2 := strconv.Atoi(champs[1])
+	return referencePDF{numero, generation}, err1 == nil && err2 == nil
+}
+
+func trouverEntree(entrees []entreePDF, cle string) (string, bool) {
+	for _, e := range entrees {
+		if e.cle == cle {
+			return e.valeur, true
+		}
+	}
+	return "", false
+}
+
+func retirerEntrees(entrees []entreePDF, cles ...string) []entreePDF {
+	resultat := make([]entreePDF, 0, len(entrees))
+	for _, e := range entrees {
+		garder := true
+		for _, cle := range cles {
+			if e.cle == cle {
+				garder = false
+			}
+		}
+		if garder {
+			resultat = append(resultat, e)
+		}
+	}
+	return resultat
+}
+
+func serialiserDictionnaire(entrees []entreePDF) string {
+	var b strings.Builder
+	b.WriteString("<<")
+	for _, e := range entrees {
+		b.WriteString(" " + e.cle + " " + e.valeur)
+	}
+	b.WriteString(" >>")
+	return b.String()
+}
+
+func estEspacePDF(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func estDelimiteurPDF(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// ignorerEspaces saute les blancs et les commentaires
+func ignorerEspaces(d []byte, i int) int {
+	for i < len(d) {
+		if estEspacePDF(d[i]) {
+			i++
+		} else if d[i] == '%' {
+			for i < len(d) && d[i] != '\n' && d[i] != '\r' {
+				i++
+			}
+		} else {
+			break
+		}
+	}
+	return i
+}
+
+// lireDictionnaire lit le dictionnaire commençant à la position donnée
+func lireDictionnaire(d []byte, i int) ([]entreePDF, int, error) {
+	i = ignorerEspaces(d, i)
+	if !bytes.HasPrefix(d[i:], []byte("<<")) {
+		return nil, i, fmt.Errorf("dictionnaire attendu")
+	}
+	i += 2
+
+	var entrees []entreePDF
+	for {
+		i = ignorerEspaces(d, i)
+		if i >= len(d) {
+			return nil, i, fmt.Errorf("dictionnaire non terminé")
+		}
+		if bytes.HasPrefix(d[i:], []byte(">>")) {
+			return entrees, i + 2, nil
+		}
+		if d[i] != '/' {
+			return nil, i, fmt.Errorf("clé de dictionnaire attendue")
+		}
+		finCle := lireJeton(d, i)
+		cle := string(d[i:finCle])
+
+		debutValeur := ignorerEspaces(d, finCle)
+		finValeur, err := lireValeur(d, debutValeur)
+		if err != nil {
+			return nil, finValeur, err
+		}
+		entrees = append(entrees, entreePDF{cle, string(d[debutValeur:finValeur])})
+		i = finValeur
+	}
+}
+
+// lireJeton retourne la fin d'un nom ou d'un mot-clé
+func lireJeton(d []byte, i int) int {
+	j := i + 1
+	for j < len(d) && !estEspacePDF(d[j]) && !estDelimiteurPDF(d[j]) {
+		j++
+	}
+	return j
+}
+
+// lireValeur retourne la fin de l'objet PDF direct commençant à la position donnée
+func lireValeur(d []byte, i int) (int, error) {
+	if i >= len(d) {
+		return i, fmt.Errorf("valeur attendue")
+	}
+
+	switch {
+	case bytes.HasPrefix(d[i:], []byte("<<")):
+		_, fin, err := lireDictionnaire(d, i)
+		return fin, err
+
+	case d[i] == '<':
+		fin := bytes.IndexByte(d[i:], '>')
+		if fin < 0 {
+			return i, fmt.Errorf("chaîne hexadécimale non terminée")
+		}
+		return i + fin + 1, nil
+
+	case d[i] == '[':
+		j := i + 1
+		for {
+			j = ignorerEspaces(d, j)
+			if j >= len(d) {
+				return j, fmt.Errorf("tableau non terminé")
+			}
+			if d[j] == ']' {
+				return j + 1, nil
+			}
+			fin, err := lireValeur(d, j)
+			if err != nil {
+				return fin, err
+			}
+			j = fin
+		}
+
+	case d[i] == '(':
+		profondeur := 0
+		for j := i; j < len(d); j++ {
+			switch d[j] {
+			case '\\':
+				j++
+			case '(':
+				profondeur++
+			case ')':
+				profondeur--
+				if profondeur == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return len(d), fmt.Errorf("chaîne non terminée")
+
+	case d[i] == '/':
+		return lireJeton(d, i), nil
+
+	default:
+		fin := lireJeton(d, i)
+		// Référence indirecte « numéro génération R »
+		if _, err := strconv.Atoi(string(d[i:fin])); err == nil {
+			j := ignorerEspaces(d, fin)
+			if j >= len(d) {
+				return fin, nil
+			}
+			finGeneration := lireJeton(d, j)
+			if _, err := strconv.Atoi(string(d[j:finGeneration])); err == nil {
+				k := ignorerEspaces(d, finGeneration)
+				if k < len(d) && d[k] == 'R' && (k+1 == len(d) || estEspacePDF(d[k+1]) || estDelimiteurPDF(d[k+1])) {
+					return k + 1, nil
+				}
+			}
+		}
+		return fin, nil
+	}
+}
+
+// ProfilICCsRGB construit un profil ICC v2 sRGB IEC61966-2.1 (matrice + courbes de reproduction)
+func ProfilICCsRGB() []byte {
+	s15 := func(v float64) []byte {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(math.Round(v*65536))))
+		return b
+	}
+	xyz := func(x, y, z float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		b = append(b, s15(x)...)
+		b = append(b, s15(y)...)
+		return append(b, s15(z)...)
+	}
+
+	description := "sRGB IEC61966-2.1"
+	desc := []byte("desc\x00\x00\x00\x00")
+	desc = binary.BigEndian.AppendUint32(desc, uint32(len(description)+1))
+	desc = append(desc, description...)
+	desc = append(desc, 0)
+	desc = append(desc, make([]byte, 4+4+2+1+67)...) // Unicode et ScriptCode vides
+
+	cprt := append([]byte("text\x00\x00\x00\x00"), "No copyright, use freely\x00"...)
+
+	// Courbe sRGB échantillonnée sur 1024 points
+	courbe := []byte("curv\x00\x00\x00\x00")
+	courbe = binary.BigEndian.AppendUint32(courbe, 1024)
+	for i := 0; i < 1024; i++ {
+		v := float64(i) / 1023
+		if v <= 0.04045 {
+			v = v / 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		courbe = binary.BigEndian.AppendUint16(courbe, uint16(math.Round(v*65535)))
+	}
+
+	type balise struct {
+		signature string
+		donnees   []byte
+	}
+	balises := []balise{
+		{"desc", desc},
+		{"cprt", cprt},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", courbe},
+	}
+
+	// Table des balises : gTRC et bTRC partagent les données de rTRC
+	taille := 128 + 4 + 12*(len(balises)+2)
+	var table, donnees []byte
+	table = binary.BigEndian.AppendUint32(table, uint32(len(balises)+2))
+	var offsetCourbe, tailleCourbe int
+	for _, b := range balises {
+		for len(donnees)%4 != 0 {
+			donnees = append(donnees, 0)
+		}
+		offset := taille + len(donnees)
+		table = append(table, b.signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(b.donnees)))
+		if b.signature == "rTRC" {
+			offsetCourbe, tailleCourbe = offset, len(b.donnees)
+		}
+		donnees = append(donnees, b.donnees...)
+	}
+	for _, signature := range []string{"gTRC", "bTRC"} {
+		table = append(table, signature...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offsetCourbe))
+		table = binary.BigEndian.AppendUint32(table, uint32(tailleCourbe))
+	}
+
+	entete := make([]byte, 128)
+	binary.BigEndian.PutUint32(entete[0:], uint32(taille+len(donnees)))
+	binary.BigEndian.PutUint32(entete[8:], 0x02100000) // Version 2.1
+	copy(entete[12:], "mntr")
+	copy(entete[16:], "RGB ")
+	copy(entete[20:], "XYZ ")
+	for i, v := range []uint16{2025, 1, 1, 0, 0, 0} {
+		binary.BigEndian.PutUint16(entete[24+2*i:], v)
+	}
+	copy(entete[36:], "acsp")
+	copy(entete[68:], s15(0.9642))
+	copy(entete[72:], s15(1.0))
+	copy(entete[76:], s15(0.8249))
+
+	profil := append(entete, table...)
+	return append(profil, donnees...)
+}
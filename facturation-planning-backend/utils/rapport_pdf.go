@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Titres des rapports
+const (
+	TitrePDFFeuilleTemps = "FEUILLE DE TEMPS"
+)
+
+// ColonneTableauPDF décrit une colonne d'un tableau de rapport
+type ColonneTableauPDF struct {
+	Titre   string
+	Largeur float64 // En millimètres, la somme des largeurs ne doit pas dépasser la largeur utile (180 mm)
+	Align   string  // "L", "C" ou "R"
+}
+
+// TableauPDF est un tableau titré d'un rapport ; la ligne Total est mise en évidence
+type TableauPDF struct {
+	Titre    string
+	Colonnes []ColonneTableauPDF
+	Lignes   [][]string
+	Total    []string
+}
+
+// SectionRapportPDF est une partie d'un rapport commençant sur une nouvelle page (un salarié d'une feuille de temps)
+type SectionRapportPDF struct {
+	Titre    string
+	Mentions []string // Lignes affichées sous le titre (période, paramètres de calcul)
+	Tableaux []TableauPDF
+}
+
+// RapportPDF décrit un rapport interne (feuille de temps) à mettre en page
+type RapportPDF struct {
+	Titre        string
+	Emetteur     []string // Raison sociale puis coordonnées
+	Sections     []SectionRapportPDF
+	PiedDePage   []string  // Mentions répétées sur chaque page
+	DateCreation time.Time // Date inscrite dans les métadonnées
+}
+
+// GenererRapportPDF produit le PDF d'un rapport : chaque section commence sur une nouvelle page,
+// les tableaux s'étendent sur plusieurs pages en répétant leur ligne de titres.
+func GenererRapportPDF(doc RapportPDF) ([]byte, error) {
+	police, err := os.ReadFile(PolicePDF)
+	if err != nil {
+		return nil, fmt.Errorf("Erreur chargement police : %v", err)
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMarge, pdfMarge, pdfMarge)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddUTF8FontFromBytes("DejaVu", "", police)
+	pdf.AliasNbPages("{nb}")
+	pdf.SetCatalogSort(true)
+	pdf.SetCreationDate(doc.DateCreation)
+	pdf.SetModificationDate(doc.DateCreation)
+	pdf.SetTitle(doc.Titre, true)
+	if len(doc.Emetteur) > 0 {
+		pdf.SetAuthor(doc.Emetteur[0], true)
+		pdf.SetCreator(doc.Emetteur[0], true)
+	}
+	pdf.SetProducer("facturation-planning", true)
+
+	r := &renduRapport{rendu: rendu{pdf: pdf, doc: DocumentPDF{Titre: doc.Titre, Emetteur: doc.Emetteur, PiedDePage: doc.PiedDePage}}}
+	pdf.SetHeaderFunc(r.entetePage)
+	pdf.SetFooterFunc(r.piedPage)
+
+	if len(doc.Sections) == 0 {
+		r.nouvelle = true
+		pdf.AddPage()
+		r.entete(SectionRapportPDF{Mentions: []string{"Aucune donnée sur la période"}})
+	}
+	for _, section := range doc.Sections {
+		r.section, r.nouvelle = section.Titre, true
+		pdf.AddPage()
+		r.entete(section)
+		r.nouvelle = false
+		for _, tableau := range section.Tableaux {
+			r.tableau(tableau)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("Erreur création PDF : %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// renduRapport porte l'état de la mise en page d'un rapport
+type renduRapport struct {
+	rendu
+	section  string // Titre de la section en cours, rappelé en haut des pages suivantes
+	nouvelle bool   // La page vient d'être ouverte pour une nouvelle section
+}
+
+// entetePage rappelle l'émetteur, le titre et la section en haut des pages qui poursuivent une section
+func (r *renduRapport) entetePage() {
+	if r.nouvelle {
+		return
+	}
+	emetteur := ""
+	if len(r.doc.Emetteur) > 0 {
+		emetteur = r.doc.Emetteur[0]
+	}
+	r.police(8, couleurTitre)
+	r.pdf.SetXY(pdfMarge, 10)
+	r.pdf.CellFormat(pdfLargeurUtile/2, 5, emetteur, "", 0, "L", false, 0, "")
+	r.pdf.CellFormat(pdfLargeurUtile/2, 5, strings.TrimSpace(r.doc.Titre+" - "+r.section), "", 1, "R", false, 0, "")
+	r.pdf.SetDrawColor(couleurTitre[0], couleurTitre[1], couleurTitre[2])
+	r.pdf.Line(pdfMarge, 16, pdfMarge+pdfLargeurUtile, 16)
+	r.pdf.SetY(20)
+}
+
+// entete dessine l'émetteur, le titre du rapport, le titre de la section et ses mentions
+func (r *renduRapport) entete(section SectionRapportPDF) {
+	r.pdf.SetXY(pdfMarge, pdfMarge)
+	for i, ligne := range r.doc.Emetteur {
+		if i == 0 {
+			r.police(12, couleurTitre)
+			r.pdf.CellFormat(90, 6, ligne, "", 2, "L", false, 0, "")
+			continue
+		}
+		r.police(8.5, couleurTexte)
+		r.pdf.CellFormat(90, 4.2, ligne, "", 2, "L", false, 0, "")
+	}
+	basEmetteur := r.pdf.GetY()
+
+	r.pdf.SetXY(115, pdfMarge)
+	r.police(18, couleurTitre)
+	r.pdf.CellFormat(80, 9, r.doc.Titre, "", 2, "R", false, 0, "")
+	if section.Titre != "" {
+		r.police(11, couleurTitre)
+		r.pdf.CellFormat(80, 6, section.Titre, "", 2, "R", false, 0, "")
+	}
+	r.police(9, couleurTexte)
+	for _, mention := range section.Mentions {
+		r.pdf.CellFormat(80, 5, mention, "", 2, "R", false, 0, "")
+	}
+
+	bas := r.pdf.GetY()
+	if basEmetteur > bas {
+		bas = basEmetteur
+	}
+	r.pdf.SetY(bas + 8)
+}
+
+// enteteTableau dessine le titre et la ligne de titres des colonnes d'un tableau
+func (r *renduRapport) enteteTableau(tableau TableauPDF, titre string) {
+	if titre != "" {
+		r.police(10, couleurTitre)
+		r.pdf.SetX(pdfMarge)
+		r.pdf.CellFormat(pdfLargeurUtile, 7, titre, "", 1, "L", false, 0, "")
+	}
+	r.police(8, couleurTitre)
+	r.pdf.SetFillColor(couleurFond[0], couleurFond[1], couleurFond[2])
+	r.pdf.SetDrawColor(200, 200, 200)
+	r.pdf.SetX(pdfMarge)
+	for _, col := range tableau.Colonnes {
+		r.pdf.CellFormat(col.Largeur, pdfHauteurEntete, col.Titre, "1", 0, "C", true, 0, "")
+	}
+	r.pdf.Ln(-1)
+}
+
+// tableau dessine un tableau en répétant sa ligne de titres à chaque saut de page
+func (r *renduRapport) tableau(tableau TableauPDF) {
+	if len(tableau.Lignes) == 0 && len(tableau.Total) == 0 {
+		return
+	}
+	r.assurerEspace(7 + pdfHauteurEntete + 2*(pdfHauteurLigne+2))
+	r.enteteTableau(tableau, tableau.Titre)
+
+	for _, ligne := range tableau.Lignes {
+		r.police(8, couleurTexte)
+		cellules, hauteur := r.decouperLigne(tableau, ligne)
+		if r.pdf.GetY()+hauteur > pdfLimiteBas {
+			r.pdf.AddPage()
+			r.enteteTableau(tableau, tableau.Titre+" (suite)")
+			r.police(8, couleurTexte)
+		}
+		r.ligne(tableau, cellules, hauteur, false)
+	}
+
+	if len(tableau.Total) > 0 {
+		r.police(8, couleurTitre)
+		cellules, hauteur := r.decouperLigne(tableau, tableau.Total)
+		if r.pdf.GetY()+hauteur > pdfLimiteBas {
+			r.pdf.AddPage()
+			r.enteteTableau(tableau, tableau.Titre+" (suite)")
+			r.police(8, couleurTitre)
+		}
+		r.ligne(tableau, cellules, hauteur, true)
+	}
+	r.pdf.SetY(r.pdf.GetY() + 6)
+}
+
+// decouperLigne découpe le texte des cellules à la largeur des colonnes et retourne la hauteur de la ligne
+func (r *renduRapport) decouperLigne(tableau TableauPDF, valeurs []string) ([][]string, float64) {
+	cellules := make([][]string, len(tableau.Colonnes))
+	nbLignes := 1
+	for c, col := range tableau.Colonnes {
+		texte := ""
+		if c < len(valeurs) {
+			texte = valeurs[c]
+		}
+		cellules[c] = r.pdf.SplitText(texte, col.Largeur-2)
+		if len(cellules[c]) > nbLignes {
+			nbLignes = len(cellules[c])
+		}
+	}
+	return cellules, float64(nbLignes)*pdfHauteurLigne + 2
+}
+
+// ligne dessine une ligne de tableau, sur fond grisé pour la ligne de total
+func (r *renduRapport) ligne(tableau TableauPDF, cellules [][]string, hauteur float64, total bool) {
+	r.pdf.SetFillColor(couleurFond[0], couleurFond[1], couleurFond[2])
+	r.pdf.SetDrawColor(200, 200, 200)
+	style := "D"
+	if total {
+		style = "FD"
+	}
+	y := r.pdf.GetY()
+	x := pdfMarge
+	for c, col := range tableau.Colonnes {
+		r.pdf.Rect(x, y, col.Largeur, hauteur, style)
+		for l, texte := range cellules[c] {
+			r.pdf.SetXY(x+1, y+1+float64(l)*pdfHauteurLigne)
+			r.pdf.CellFormat(col.Largeur-2, pdfHauteurLigne, texte, "", 0, col.Align, false, 0, "")
+		}
+		x += col.Largeur
+	}
+	r.pdf.SetXY(pdfMarge, y+hauteur)
+}
@@ -0,0 +1,139 @@
+// Package smtptest fournit un serveur SMTP local qui enregistre les messages reçus, pour tester les envois d'emails
+// sans serveur réel (sur le modèle de net/http/httptest).
+package smtptest
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Message est un email reçu par le serveur de test
+type Message struct {
+	Expediteur    string   // Adresse de MAIL FROM
+	Destinataires []string // Adresses de RCPT TO, copies comprises
+	Donnees       []byte   // Message MIME transmis par DATA
+}
+
+// Serveur est un serveur SMTP sans chiffrement ni authentification, à l'écoute sur 127.0.0.1
+type Serveur struct {
+	Hote string
+	Port int
+
+	listener  net.Listener
+	mu        sync.Mutex
+	messages  []Message
+	refusRcpt bool
+}
+
+// NouveauServeur démarre un serveur SMTP de test, arrêté à la fin du test
+func NouveauServeur(t testing.TB) *Serveur {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("serveur SMTP de test : %v", err)
+	}
+	adresse := listener.Addr().(*net.TCPAddr)
+	s := &Serveur{Hote: adresse.IP.String(), Port: adresse.Port, listener: listener}
+	t.Cleanup(s.Fermer)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.dialoguer(conn)
+		}
+	}()
+	return s
+}
+
+// Fermer arrête le serveur : les connexions suivantes sont refusées
+func (s *Serveur) Fermer() {
+	s.listener.Close()
+}
+
+// Messages retourne les messages reçus et acceptés
+func (s *Serveur) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// RefuserDestinataires fait répondre 550 à chaque RCPT TO (destinataire refusé par le serveur)
+func (s *Serveur) RefuserDestinataires(refuser bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refusRcpt = refuser
+}
+
+func (s *Serveur) refuser() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refusRcpt
+}
+
+// dialoguer traite une session SMTP : EHLO, MAIL, RCPT, DATA, RSET, NOOP et QUIT
+func (s *Serveur) dialoguer(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 smtptest ESMTP")
+
+	var message Message
+	for {
+		ligne, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		commande, argument, _ := strings.Cut(ligne, " ")
+		switch strings.ToUpper(commande) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-smtptest")
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			message = Message{Expediteur: adresseCommande(argument)}
+			tp.PrintfLine("250 2.1.0 Ok")
+		case "RCPT":
+			if s.refuser() {
+				tp.PrintfLine("550 5.1.1 Destinataire inconnu")
+				continue
+			}
+			message.Destinataires = append(message.Destinataires, adresseCommande(argument))
+			tp.PrintfLine("250 2.1.5 Ok")
+		case "DATA":
+			tp.PrintfLine("354 Fin des données par <CRLF>.<CRLF>")
+			donnees, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Donnees = donnees
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.0.0 Ok")
+		case "RSET":
+			message = Message{}
+			tp.PrintfLine("250 2.0.0 Ok")
+		case "NOOP":
+			tp.PrintfLine("250 2.0.0 Ok")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 Au revoir")
+			return
+		default:
+			tp.PrintfLine("502 5.5.2 Commande non prise en charge")
+		}
+	}
+}
+
+// adresseCommande extrait l'adresse de « FROM:<adresse> BODY=8BITMIME » ou « TO:<adresse> »
+func adresseCommande(argument string) string {
+	debut := strings.Index(argument, "<")
+	fin := strings.Index(argument, ">")
+	if debut < 0 || fin < debut {
+		return ""
+	}
+	return argument[debut+1 : fin]
+}
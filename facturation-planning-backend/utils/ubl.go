@@ -0,0 +1,324 @@
+package utils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"facturation-planning/models"
+)
+
+// GenererUBL sérialise le document au format OASIS UBL 2.1 : Invoice pour une facture ou un acompte,
+// CreditNote pour un avoir (profil EN 16931)
+func GenererUBL(d DocumentFacturation) ([]byte, error) {
+	if err := d.Valider(); err != nil {
+		return nil, err
+	}
+
+	avoir := d.CodeType == CodeDocumentAvoir
+	totalHT, totalTVA, totalTTC := d.Totaux()
+
+	doc := ublDocument{
+		XMLName:         xml.Name{Local: "Invoice"},
+		Xmlns:           "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2",
+		XmlnsCac:        "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2",
+		XmlnsCbc:        "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2",
+		CustomizationID: ProfilEN16931,
+		ID:              d.Numero,
+		DateEmission:    d.DateEmission.Format("2006-01-02"),
+		Devise:          d.Devise,
+		Vendeur:         ublPartie(d.Vendeur),
+		Acheteur:        ublPartie(d.Acheteur),
+	}
+	if avoir {
+		doc.XMLName.Local = "CreditNote"
+		doc.Xmlns = "urn:oasis:names:specification:ubl:schema:xsd:CreditNote-2"
+		doc.CodeTypeAvoir = d.CodeType
+	} else {
+		doc.CodeTypeFacture = d.CodeType
+		if !d.DateEcheance.IsZero() {
+			doc.DateEcheance = d.DateEcheance.Format("2006-01-02")
+		}
+	}
+	if d.Note != "" {
+		doc.Notes = []string{d.Note}
+	}
+	if d.FactureOrigine != "" {
+		doc.FactureOrigine = &ublReferenceFacture{ID: d.FactureOrigine}
+	}
+	if d.Vendeur.IBAN != "" {
+		doc.MoyenPaiement = &ublMoyenPaiement{Code: "58", Compte: &ublCompte{ID: d.Vendeur.IBAN}}
+	}
+
+	montant := func(v models.Montant) ublMontant {
+		return ublMontant{Valeur: formatMontant(v), Devise: d.Devise}
+	}
+
+	for _, remise := range d.Remises {
+		doc.Remises = append(doc.Remises, ublRemise{
+			CodeMotif: CodeMotifRemise,
+			Motif:     MotifRemise,
+			Montant:   montant(remise.Montant),
+			Categorie: &ublCategorieTaxe{
+				ID:     remise.CategorieTVA,
+				Taux:   formatTaux(remise.TauxTVA),
+				Schema: ublSchemaTaxe{ID: "VAT"},
+			},
+		})
+	}
+
+	doc.TotalTaxes = ublTotalTaxes{Montant: montant(totalTVA)}
+	for _, groupe := range d.VentilationTVA() {
+		doc.TotalTaxes.SousTotaux = append(doc.TotalTaxes.SousTotaux, ublSousTotalTaxe{
+			Base:    montant(groupe.BaseHT),
+			Montant: montant(groupe.MontantTVA),
+			Categorie: ublCategorieTaxe{
+				ID:               groupe.CategorieTVA,
+				Taux:             formatTaux(groupe.TauxTVA),
+				CodeExoneration:  groupe.CodeExoneration,
+				MotifExoneration: groupe.MotifExoneration,
+				Schema:           ublSchemaTaxe{ID: "VAT"},
+			},
+		})
+	}
+
+	doc.Totaux = ublTotaux{
+		TotalLignes: montant(d.TotalLignes()),
+		TotalHT:     montant(totalHT),
+		TotalTTC:    montant(totalTTC),
+		Prepaye:     montant(d.MontantPrepaye),
+		NetAPayer:   montant(totalTTC - d.MontantPrepaye),
+	}
+	if remises := d.TotalRemises(); remises != 0 {
+		total := montant(remises)
+		doc.Totaux.TotalRemises = &total
+	}
+
+	for i, l := range d.Lignes {
+		ligne := ublLigne{
+			ID:      strconv.Itoa(i + 1),
+			Montant: montant(l.MontantHT),
+			Article: ublArticle{
+				Nom: l.Description,
+				Categorie: ublCategorieTaxe{
+					ID:     l.CategorieTVA,
+					Taux:   formatTaux(l.TauxTVA),
+					Schema: ublSchemaTaxe{ID: "VAT"},
+				},
+			},
+			Prix: ublPrix{Montant: ublMontant{Valeur: formatPrixUnitaire(l.PrixUnitaire), Devise: d.Devise}},
+		}
+		if l.Remise != 0 {
+			base := montant(l.BaseRemise)
+			ligne.Remise = &ublRemise{
+				CodeMotif: CodeMotifRemise,
+				Motif:     MotifRemise,
+				Montant:   montant(l.Remise),
+				Base:      &base,
+			}
+			if l.PourcentageRemise != 0 {
+				ligne.Remise.Pourcentage = formatTaux(l.PourcentageRemise)
+			}
+		}
+		quantite := &ublQuantite{Valeur: formatQuantite(l.Quantite), Unite: l.CodeUnite}
+		if avoir {
+			ligne.QuantiteCreditee = quantite
+			doc.LignesAvoir = append(doc.LignesAvoir, ligne)
+		} else {
+			ligne.QuantiteFacturee = quantite
+			doc.LignesFacture = append(doc.LignesFacture, ligne)
+		}
+	}
+
+	contenu, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("sérialisation UBL : %v", err)
+	}
+	return append([]byte(xml.Header), contenu...), nil
+}
+
+// ublPartie convertit une partie en AccountingSupplierParty / AccountingCustomerParty
+func ublPartie(p PartieFacturation) ublPartieConteneur {
+	partie := ublParty{
+		Adresse: ublAdresse{
+			Rue:        p.Adresse,
+			Ville:      p.Ville,
+			CodePostal: p.CodePostal,
+			Pays:       ublPays{Code: p.Pays},
+		},
+		EntiteLegale: ublEntiteLegale{RaisonSociale: p.Nom},
+	}
+	if p.SIRET != "" {
+		partie.PointAcces = &ublID{Valeur: p.SIRET, Schema: "0009"}
+		partie.EntiteLegale.ID = &ublID{Valeur: p.SIRET, Schema: "0009"}
+	}
+	if p.Nom != "" {
+		partie.Nom = &ublNomPartie{Nom: p.Nom}
+	}
+	if p.NumeroTVA != "" {
+		partie.SchemasFiscaux = []ublSchemaFiscal{{ID: p.NumeroTVA, Schema: ublSchemaTaxe{ID: "VAT"}}}
+	}
+	if p.Email != "" {
+		partie.Contact = &ublContact{Email: p.Email}
+	}
+	return ublPartieConteneur{Partie: partie}
+}
+
+// Structures XML UBL 2.1 (ordre des éléments imposé par les schémas Invoice-2 et CreditNote-2)
+
+type ublDocument struct {
+	XMLName         xml.Name             `xml:""`
+	Xmlns           string               `xml:"xmlns,attr"`
+	XmlnsCac        string               `xml:"xmlns:cac,attr"`
+	XmlnsCbc        string               `xml:"xmlns:cbc,attr"`
+	CustomizationID string               `xml:"cbc:CustomizationID"`
+	ID              string               `xml:"cbc:ID"`
+	DateEmission    string               `xml:"cbc:IssueDate"`
+	DateEcheance    string               `xml:"cbc:DueDate,omitempty"`
+	CodeTypeFacture string               `xml:"cbc:InvoiceTypeCode,omitempty"`
+	CodeTypeAvoir   string               `xml:"cbc:CreditNoteTypeCode,omitempty"`
+	Notes           []string             `xml:"cbc:Note"`
+	Devise          string               `xml:"cbc:DocumentCurrencyCode"`
+	FactureOrigine  *ublReferenceFacture `xml:"cac:BillingReference>cac:InvoiceDocumentReference"`
+	Vendeur         ublPartieConteneur   `xml:"cac:AccountingSupplierParty"`
+	Acheteur        ublPartieConteneur   `xml:"cac:AccountingCustomerParty"`
+	MoyenPaiement   *ublMoyenPaiement    `xml:"cac:PaymentMeans"`
+	Remises         []ublRemise          `xml:"cac:AllowanceCharge"`
+	TotalTaxes      ublTotalTaxes        `xml:"cac:TaxTotal"`
+	Totaux          ublTotaux            `xml:"cac:LegalMonetaryTotal"`
+	LignesFacture   []ublLigne           `xml:"cac:InvoiceLine"`
+	LignesAvoir     []ublLigne           `xml:"cac:CreditNoteLine"`
+}
+
+type ublID struct {
+	Valeur string `xml:",chardata"`
+	Schema string `xml:"schemeID,attr,omitempty"`
+}
+
+type ublMontant struct {
+	Valeur string `xml:",chardata"`
+	Devise string `xml:"currencyID,attr"`
+}
+
+type ublQuantite struct {
+	Valeur string `xml:",chardata"`
+	Unite  string `xml:"unitCode,attr"`
+}
+
+type ublReferenceFacture struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublPartieConteneur struct {
+	Partie ublParty `xml:"cac:Party"`
+}
+
+type ublParty struct {
+	PointAcces     *ublID            `xml:"cbc:EndpointID"`
+	Nom            *ublNomPartie     `xml:"cac:PartyName"`
+	Adresse        ublAdresse        `xml:"cac:PostalAddress"`
+	SchemasFiscaux []ublSchemaFiscal `xml:"cac:PartyTaxScheme"`
+	EntiteLegale   ublEntiteLegale   `xml:"cac:PartyLegalEntity"`
+	Contact        *ublContact       `xml:"cac:Contact"`
+}
+
+type ublNomPartie struct {
+	Nom string `xml:"cbc:Name"`
+}
+
+type ublAdresse struct {
+	Rue        string  `xml:"cbc:StreetName,omitempty"`
+	Ville      string  `xml:"cbc:CityName,omitempty"`
+	CodePostal string  `xml:"cbc:PostalZone,omitempty"`
+	Pays       ublPays `xml:"cac:Country"`
+}
+
+type ublPays struct {
+	Code string `xml:"cbc:IdentificationCode"`
+}
+
+type ublSchemaFiscal struct {
+	ID     string        `xml:"cbc:CompanyID"`
+	Schema ublSchemaTaxe `xml:"cac:TaxScheme"`
+}
+
+type ublSchemaTaxe struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublEntiteLegale struct {
+	RaisonSociale string `xml:"cbc:RegistrationName"`
+	ID            *ublID `xml:"cbc:CompanyID"`
+}
+
+type ublContact struct {
+	Email string `xml:"cbc:ElectronicMail"`
+}
+
+type ublMoyenPaiement struct {
+	Code   string     `xml:"cbc:PaymentMeansCode"`
+	Compte *ublCompte `xml:"cac:PayeeFinancialAccount"`
+}
+
+type ublCompte struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublTotalTaxes struct {
+	Montant    ublMontant         `xml:"cbc:TaxAmount"`
+	SousTotaux []ublSousTotalTaxe `xml:"cac:TaxSubtotal"`
+}
+
+type ublSousTotalTaxe struct {
+	Base      ublMontant       `xml:"cbc:TaxableAmount"`
+	Montant   ublMontant       `xml:"cbc:TaxAmount"`
+	Categorie ublCategorieTaxe `xml:"cac:TaxCategory"`
+}
+
+type ublCategorieTaxe struct {
+	ID               string        `xml:"cbc:ID"`
+	Taux             string        `xml:"cbc:Percent"`
+	CodeExoneration  string        `xml:"cbc:TaxExemptionReasonCode,omitempty"`
+	MotifExoneration string        `xml:"cbc:TaxExemptionReason,omitempty"`
+	Schema           ublSchemaTaxe `xml:"cac:TaxScheme"`
+}
+
+type ublTotaux struct {
+	TotalLignes  ublMontant  `xml:"cbc:LineExtensionAmount"`
+	TotalHT      ublMontant  `xml:"cbc:TaxExclusiveAmount"`
+	TotalTTC     ublMontant  `xml:"cbc:TaxInclusiveAmount"`
+	TotalRemises *ublMontant `xml:"cbc:AllowanceTotalAmount"`
+	Prepaye      ublMontant  `xml:"cbc:PrepaidAmount"`
+	NetAPayer    ublMontant  `xml:"cbc:PayableAmount"`
+}
+
+// ublRemise est une remise (indicateur de frais à false), de ligne ou de document ; la catégorie de TVA
+// n'est portée que par les remises de document
+type ublRemise struct {
+	Indicateur  bool              `xml:"cbc:ChargeIndicator"`
+	CodeMotif   string            `xml:"cbc:AllowanceChargeReasonCode,omitempty"`
+	Motif       string            `xml:"cbc:AllowanceChargeReason,omitempty"`
+	Pourcentage string            `xml:"cbc:MultiplierFactorNumeric,omitempty"`
+	Montant     ublMontant        `xml:"cbc:Amount"`
+	Base        *ublMontant       `xml:"cbc:BaseAmount"`
+	Categorie   *ublCategorieTaxe `xml:"cac:TaxCategory"`
+}
+
+type ublLigne struct {
+	ID               string       `xml:"cbc:ID"`
+	QuantiteFacturee *ublQuantite `xml:"cbc:InvoicedQuantity"`
+	QuantiteCreditee *ublQuantite `xml:"cbc:CreditedQuantity"`
+	Montant          ublMontant   `xml:"cbc:LineExtensionAmount"`
+	Remise           *ublRemise   `xml:"cac:AllowanceCharge"`
+	Article          ublArticle   `xml:"cac:Item"`
+	Prix             ublPrix      `xml:"cac:Price"`
+}
+
+type ublArticle struct {
+	Nom       string           `xml:"cbc:Name"`
+	Categorie ublCategorieTaxe `xml:"cac:ClassifiedTaxCategory"`
+}
+
+type ublPrix struct {
+	Montant ublMontant `xml:"cbc:PriceAmount"`
+}